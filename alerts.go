@@ -0,0 +1,188 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains implementation of alert manager. Alerts are
+// events raised by various checks performed by the monitor (schema drift
+// etc.). All alerts are written into log and the most recent ones are kept
+// in memory so they can be retrieved via REST API. Listeners can be
+// registered to be notified about every raised alert. Checks that could
+// raise the same alert for many messages use alert throttle to raise it at
+// most once per interval.
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Alert severities
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// maxRecentAlerts is number of alerts kept in memory
+const maxRecentAlerts = 1000
+
+// Alert represents one event raised by the monitor
+type Alert struct {
+	Time     time.Time              `json:"time"`
+	Type     string                 `json:"type"`
+	Severity string                 `json:"severity"`
+	Topic    string                 `json:"topic,omitempty"`
+	Message  string                 `json:"message"`
+	Details  map[string]interface{} `json:"details,omitempty"`
+}
+
+// AlertManager collects all alerts raised by the monitor
+type AlertManager struct {
+	mutex     sync.Mutex
+	recent    []Alert
+	counts    map[string]uint64
+	redactor  *Redactor
+	listeners []func(Alert)
+	clock     Clock
+}
+
+// NewAlertManager constructs new alert manager. Time of alerts that do not
+// have time set is taken from given clock.
+func NewAlertManager(clock Clock) *AlertManager {
+	return &AlertManager{
+		recent: make([]Alert, 0, maxRecentAlerts),
+		counts: make(map[string]uint64),
+		clock:  clockOrDefault(clock),
+	}
+}
+
+// SetRedactor method sets redactor used to redact messages and details of
+// all alerts
+func (manager *AlertManager) SetRedactor(redactor *Redactor) {
+	manager.redactor = redactor
+}
+
+// AddListener method registers function that is called for every alert
+// raised after the registration
+func (manager *AlertManager) AddListener(listener func(Alert)) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	manager.listeners = append(manager.listeners, listener)
+}
+
+// Raise method raises new alert. Alert is always written into log, even
+// when the manager is nil.
+func (manager *AlertManager) Raise(alert Alert) {
+	if manager != nil {
+		if alert.Time.IsZero() {
+			alert.Time = manager.clock.Now()
+		}
+		alert = manager.redactor.RedactAlert(alert)
+	} else if alert.Time.IsZero() {
+		alert.Time = clockOrDefault(nil).Now()
+	}
+
+	log.Warn().
+		Str("alert", alert.Type).
+		Str("severity", alert.Severity).
+		Str(topicKey, alert.Topic).
+		Fields(alert.Details).
+		Msg(alert.Message)
+
+	if manager == nil {
+		return
+	}
+
+	manager.mutex.Lock()
+	if len(manager.recent) == maxRecentAlerts {
+		// drop the oldest alert
+		copy(manager.recent, manager.recent[1:])
+		manager.recent = manager.recent[:maxRecentAlerts-1]
+	}
+	manager.recent = append(manager.recent, alert)
+	manager.counts[alert.Type]++
+	listeners := manager.listeners
+	manager.mutex.Unlock()
+
+	// listeners are called without lock, so they can raise alerts too
+	for _, listener := range listeners {
+		listener(alert)
+	}
+}
+
+// Recent method returns copy of the most recent alerts, the oldest first
+func (manager *AlertManager) Recent() []Alert {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	recent := make([]Alert, len(manager.recent))
+	copy(recent, manager.recent)
+	return recent
+}
+
+// Counts method returns number of alerts raised so far, per alert type
+func (manager *AlertManager) Counts() map[string]uint64 {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	counts := make(map[string]uint64, len(manager.counts))
+	for alertType, count := range manager.counts {
+		counts[alertType] = count
+	}
+	return counts
+}
+
+// alertThrottle limits how often alerts with the same key are raised
+type alertThrottle struct {
+	mutex      sync.Mutex
+	interval   time.Duration
+	clock      Clock
+	last       map[string]time.Time
+	suppressed map[string]uint64
+}
+
+// newAlertThrottle function constructs throttle that allows one alert with
+// given key per interval. All alerts are allowed when interval is zero.
+func newAlertThrottle(interval time.Duration, clock Clock) *alertThrottle {
+	return &alertThrottle{
+		interval:   interval,
+		clock:      clockOrDefault(clock),
+		last:       make(map[string]time.Time),
+		suppressed: make(map[string]uint64),
+	}
+}
+
+// allow method checks if alert with given key can be raised now. When it
+// can, number of alerts suppressed since the last allowed one is returned
+// too, so it can be reported in the alert.
+func (throttle *alertThrottle) allow(key string) (bool, uint64) {
+	throttle.mutex.Lock()
+	defer throttle.mutex.Unlock()
+
+	now := throttle.clock.Now()
+	if last, found := throttle.last[key]; found && now.Sub(last) < throttle.interval {
+		throttle.suppressed[key]++
+		return false, 0
+	}
+
+	suppressed := throttle.suppressed[key]
+	throttle.last[key] = now
+	delete(throttle.suppressed, key)
+	return true, suppressed
+}
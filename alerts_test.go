@@ -0,0 +1,83 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Unit test definitions for functions and methods defined in source file
+// alerts.go
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-kafka-monitor"
+)
+
+// TestAlertManagerRaise checks that raised alerts are stored and counted
+func TestAlertManagerRaise(t *testing.T) {
+	manager := main.NewAlertManager(nil)
+
+	manager.Raise(main.Alert{Type: "type1", Severity: main.SeverityInfo, Message: "first"})
+	manager.Raise(main.Alert{Type: "type2", Severity: main.SeverityWarning, Message: "second"})
+	manager.Raise(main.Alert{Type: "type1", Severity: main.SeverityInfo, Message: "third"})
+
+	recent := manager.Recent()
+	assert.Len(t, recent, 3)
+	assert.Equal(t, "first", recent[0].Message)
+	assert.Equal(t, "third", recent[2].Message)
+	assert.False(t, recent[0].Time.IsZero())
+
+	assert.Equal(t, map[string]uint64{"type1": 2, "type2": 1}, manager.Counts())
+}
+
+// TestAlertManagerBounded checks that only limited number of alerts is kept
+// in memory
+func TestAlertManagerBounded(t *testing.T) {
+	manager := main.NewAlertManager(nil)
+
+	for i := 0; i < 1500; i++ {
+		manager.Raise(main.Alert{Type: "type", Message: fmt.Sprint(i)})
+	}
+
+	recent := manager.Recent()
+	assert.Len(t, recent, 1000)
+	assert.Equal(t, "500", recent[0].Message)
+	assert.Equal(t, "1499", recent[999].Message)
+	assert.Equal(t, uint64(1500), manager.Counts()["type"])
+}
+
+// TestAlertManagerListener checks that registered listeners are notified
+// about raised alerts
+func TestAlertManagerListener(t *testing.T) {
+	manager := main.NewAlertManager(nil)
+	manager.Raise(main.Alert{Type: "type", Message: "before"})
+
+	var notified []string
+	manager.AddListener(func(alert main.Alert) {
+		notified = append(notified, alert.Message)
+	})
+	manager.Raise(main.Alert{Type: "type", Message: "after"})
+
+	assert.Equal(t, []string{"after"}, notified)
+}
+
+// TestNilAlertManager checks that alerts can be raised even without manager
+func TestNilAlertManager(t *testing.T) {
+	var manager *main.AlertManager
+	manager.Raise(main.Alert{Type: "type", Message: "message"})
+}
@@ -0,0 +1,390 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains implementation of message sampling archive.
+// Sample of consumed messages is written into rotating local directory in
+// JSON Lines format together with message metadata (topic, partition,
+// offset, timestamp, key, and headers). Archive files are rotated when they
+// reach configured size and the oldest files are removed when total size or
+// age limit is reached, both on rotation and in regular intervals. It makes
+// possible to retrieve representative
+// payloads from a pod after an incident.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Shopify/sarama"
+	"github.com/rs/zerolog/log"
+)
+
+// Sampling methods supported by archive
+const (
+	SamplingNth    = "nth"
+	SamplingRandom = "random"
+	SamplingErrors = "errors"
+)
+
+// Default limits used by archive
+const (
+	defaultArchiveMaxFileSize  = 10 * 1024 * 1024
+	defaultArchiveMaxTotalSize = 100 * 1024 * 1024
+	defaultArchiveCleanup      = time.Minute
+)
+
+// Archive file naming
+const (
+	archiveFilePrefix     = "archive-"
+	archiveFileSuffix     = ".jsonl"
+	archiveFileTimeFormat = "20060102T150405.000000000"
+)
+
+// Messages used by archive
+const (
+	archiveWriteMessage   = "Unable to write message into archive"
+	archiveRotateMessage  = "Archive file rotated"
+	archiveCleanupMessage = "Archive cleanup"
+)
+
+// MessageHeader represents one header of message stored in JSON Lines file
+type MessageHeader struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// MessageRecord represents message stored in JSON Lines file together with
+// its metadata. Headers are stored in original order including duplicate
+// keys. Payload that is not valid UTF-8 is stored in Base64 encoding.
+type MessageRecord struct {
+	RecordedAt  time.Time       `json:"recorded_at"`
+	Topic       string          `json:"topic"`
+	Partition   int32           `json:"partition"`
+	Offset      int64           `json:"offset"`
+	Timestamp   time.Time       `json:"timestamp"`
+	Key         string          `json:"key,omitempty"`
+	Headers     []MessageHeader `json:"headers,omitempty"`
+	Value       string          `json:"value,omitempty"`
+	ValueBase64 []byte          `json:"value_base64,omitempty"`
+	Error       string          `json:"error,omitempty"`
+}
+
+// NewMessageRecord constructs record for given message recorded at given
+// time. Processing error is stored in record when it is not nil.
+func NewMessageRecord(msg *sarama.ConsumerMessage, processingError error, recordedAt time.Time) MessageRecord {
+	record := MessageRecord{
+		RecordedAt: recordedAt,
+		Topic:      msg.Topic,
+		Partition:  msg.Partition,
+		Offset:     msg.Offset,
+		Timestamp:  msg.Timestamp,
+		Key:        string(msg.Key),
+	}
+
+	for _, header := range msg.Headers {
+		if header != nil {
+			record.Headers = append(record.Headers, MessageHeader{
+				Key:   string(header.Key),
+				Value: string(header.Value),
+			})
+		}
+	}
+
+	if utf8.Valid(msg.Value) {
+		record.Value = string(msg.Value)
+	} else {
+		record.ValueBase64 = msg.Value
+	}
+
+	if processingError != nil {
+		record.Error = processingError.Error()
+	}
+
+	return record
+}
+
+// ConsumerMessage method reconstructs consumed message from the record
+func (record *MessageRecord) ConsumerMessage() *sarama.ConsumerMessage {
+	msg := &sarama.ConsumerMessage{
+		Topic:     record.Topic,
+		Partition: record.Partition,
+		Offset:    record.Offset,
+		Timestamp: record.Timestamp,
+		Value:     []byte(record.Value),
+	}
+	if record.ValueBase64 != nil {
+		msg.Value = record.ValueBase64
+	}
+	if record.Key != "" {
+		msg.Key = []byte(record.Key)
+	}
+	for _, header := range record.Headers {
+		msg.Headers = append(msg.Headers, &sarama.RecordHeader{
+			Key:   []byte(header.Key),
+			Value: []byte(header.Value),
+		})
+	}
+	return msg
+}
+
+// Archive writes sample of consumed messages into rotating set of files
+type Archive struct {
+	mutex       sync.Mutex
+	config      ArchiveConfiguration
+	counter     uint64
+	file        *os.File
+	fileName    string
+	fileSize    int64
+	randomFloat func() float64
+	redactor    *Redactor
+	clock       Clock
+}
+
+// NewArchive constructs new archive. Archive directory is created if it
+// does not exist.
+func NewArchive(config ArchiveConfiguration, clock Clock) (*Archive, error) {
+	switch config.Sampling {
+	case "":
+		config.Sampling = SamplingNth
+	case SamplingNth, SamplingRandom, SamplingErrors:
+	default:
+		return nil, fmt.Errorf("unknown archive sampling '%s'", config.Sampling)
+	}
+
+	if config.EveryNth == 0 {
+		config.EveryNth = 1
+	}
+	if config.MaxFileSize <= 0 {
+		config.MaxFileSize = defaultArchiveMaxFileSize
+	}
+	if config.MaxTotalSize <= 0 {
+		config.MaxTotalSize = defaultArchiveMaxTotalSize
+	}
+	if config.MaxTotalSize < config.MaxFileSize {
+		return nil, fmt.Errorf("archive max total size %d is less than max file size %d",
+			config.MaxTotalSize, config.MaxFileSize)
+	}
+	if config.CleanupInterval <= 0 {
+		config.CleanupInterval = defaultArchiveCleanup
+	}
+
+	err := os.MkdirAll(config.Directory, 0750)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Archive{
+		config:      config,
+		randomFloat: rand.Float64, // #nosec G404
+		clock:       clockOrDefault(clock),
+	}, nil
+}
+
+// SetRedactor method sets redactor used to redact archived messages
+func (archive *Archive) SetRedactor(redactor *Redactor) {
+	archive.redactor = redactor
+}
+
+// sampled method decides whether message is to be archived
+func (archive *Archive) sampled(processingError error) bool {
+	switch archive.config.Sampling {
+	case SamplingErrors:
+		return processingError != nil
+	case SamplingRandom:
+		return archive.randomFloat()*100 < archive.config.Percentage
+	default:
+		archive.counter++
+		return archive.counter%archive.config.EveryNth == 0
+	}
+}
+
+// Record method archives given message when it is selected by sampling
+func (archive *Archive) Record(msg *sarama.ConsumerMessage, processingError error) {
+	archive.mutex.Lock()
+	defer archive.mutex.Unlock()
+
+	if !archive.sampled(processingError) {
+		return
+	}
+
+	line, err := json.Marshal(archive.redactor.RedactRecord(NewMessageRecord(msg, processingError, archive.clock.Now())))
+	if err != nil {
+		log.Error().Err(err).Msg(archiveWriteMessage)
+		return
+	}
+	line = append(line, '\n')
+
+	if archive.file == nil || archive.fileSize+int64(len(line)) > archive.config.MaxFileSize {
+		err = archive.rotate()
+		if err != nil {
+			log.Error().Err(err).Msg(archiveWriteMessage)
+			return
+		}
+	}
+
+	written, err := archive.file.Write(line)
+	archive.fileSize += int64(written)
+	if err != nil {
+		log.Error().Err(err).Str(filenameAttribute, archive.fileName).Msg(archiveWriteMessage)
+	}
+}
+
+// rotate method closes actual archive file, opens new one, and removes
+// archive files that are over limits
+func (archive *Archive) rotate() error {
+	if archive.file != nil {
+		err := archive.file.Close()
+		if err != nil {
+			log.Error().Err(err).Str(filenameAttribute, archive.fileName).Msg(archiveRotateMessage)
+		}
+		archive.file = nil
+	}
+
+	name := archiveFilePrefix + archive.clock.Now().UTC().Format(archiveFileTimeFormat) + archiveFileSuffix
+	fileName := filepath.Join(archive.config.Directory, name)
+
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600) // #nosec G304
+	if err != nil {
+		return err
+	}
+
+	archive.file = file
+	archive.fileName = fileName
+	archive.fileSize = 0
+
+	log.Info().Str(filenameAttribute, fileName).Msg(archiveRotateMessage)
+
+	archive.cleanup()
+	return nil
+}
+
+// ArchiveFiles function returns names of all archive files stored in given
+// directory, the oldest first
+func ArchiveFiles(directory string) ([]string, error) {
+	entries, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && strings.HasPrefix(name, archiveFilePrefix) && strings.HasSuffix(name, archiveFileSuffix) {
+			files = append(files, filepath.Join(directory, name))
+		}
+	}
+
+	// file names contain timestamp, so they can be sorted by name
+	sort.Strings(files)
+	return files, nil
+}
+
+// Cleanup method removes archive files that are over limits. It is called
+// in regular intervals so old files are removed even when no messages are
+// archived.
+func (archive *Archive) Cleanup() {
+	archive.mutex.Lock()
+	defer archive.mutex.Unlock()
+
+	archive.cleanup()
+}
+
+// Run method periodically removes archive files that are over limits. It
+// blocks current thread.
+func (archive *Archive) Run() {
+	ticker := time.NewTicker(archive.config.CleanupInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		archive.Cleanup()
+	}
+}
+
+// cleanup method removes archive files that are older than configured age
+// and the oldest files when total size of archive exceeds configured
+// limit. Actual archive file is never removed.
+func (archive *Archive) cleanup() {
+	files, err := ArchiveFiles(archive.config.Directory)
+	if err != nil {
+		log.Error().Err(err).Msg(archiveCleanupMessage)
+		return
+	}
+
+	type archiveFile struct {
+		name string
+		size int64
+	}
+
+	var kept []archiveFile
+	var totalSize int64
+
+	for _, name := range files {
+		if name == archive.fileName {
+			continue
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			continue
+		}
+		if archive.config.MaxAge > 0 && archive.clock.Now().Sub(info.ModTime()) > archive.config.MaxAge {
+			archive.remove(name)
+			continue
+		}
+		kept = append(kept, archiveFile{name: name, size: info.Size()})
+		totalSize += info.Size()
+	}
+
+	// space for actual file needs to be reserved
+	limit := archive.config.MaxTotalSize - archive.config.MaxFileSize
+	for len(kept) > 0 && totalSize > limit {
+		archive.remove(kept[0].name)
+		totalSize -= kept[0].size
+		kept = kept[1:]
+	}
+}
+
+// remove method removes one archive file
+func (archive *Archive) remove(name string) {
+	err := os.Remove(name)
+	if err != nil {
+		log.Error().Err(err).Str(filenameAttribute, name).Msg(archiveCleanupMessage)
+		return
+	}
+	log.Info().Str(filenameAttribute, name).Msg("Archive file removed")
+}
+
+// Close method closes actual archive file
+func (archive *Archive) Close() error {
+	archive.mutex.Lock()
+	defer archive.mutex.Unlock()
+
+	if archive.file == nil {
+		return nil
+	}
+	err := archive.file.Close()
+	archive.file = nil
+	return err
+}
@@ -0,0 +1,295 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Unit test definitions for functions and methods defined in source file
+// archive.go
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-kafka-monitor"
+)
+
+// archivedRecords function reads all records stored in archive directory
+func archivedRecords(t *testing.T, directory string) []main.MessageRecord {
+	files, err := main.ArchiveFiles(directory)
+	assert.NoError(t, err)
+
+	var records []main.MessageRecord
+	for _, name := range files {
+		file, err := os.Open(name)
+		assert.NoError(t, err)
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var record main.MessageRecord
+			assert.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+			records = append(records, record)
+		}
+		assert.NoError(t, file.Close())
+	}
+	return records
+}
+
+// consumerMessage function constructs message with given offset and value
+func consumerMessage(offset int64, value []byte) *sarama.ConsumerMessage {
+	return &sarama.ConsumerMessage{
+		Topic:     "topic",
+		Partition: 1,
+		Offset:    offset,
+		Timestamp: time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC),
+		Key:       []byte("key"),
+		Headers: []*sarama.RecordHeader{
+			{Key: []byte("header"), Value: []byte("value")},
+		},
+		Value: value,
+	}
+}
+
+// TestNewArchiveUnknownSampling checks that unknown sampling is refused
+func TestNewArchiveUnknownSampling(t *testing.T) {
+	_, err := main.NewArchive(main.ArchiveConfiguration{
+		Directory: t.TempDir(),
+		Sampling:  "sometimes",
+	}, nil)
+	assert.EqualError(t, err, "unknown archive sampling 'sometimes'")
+}
+
+// TestNewArchiveTotalSizeLessThanFileSize checks that total size limit that
+// can't hold even one archive file is refused
+func TestNewArchiveTotalSizeLessThanFileSize(t *testing.T) {
+	_, err := main.NewArchive(main.ArchiveConfiguration{
+		Directory:    t.TempDir(),
+		MaxFileSize:  1000,
+		MaxTotalSize: 999,
+	}, nil)
+	assert.EqualError(t, err, "archive max total size 999 is less than max file size 1000")
+}
+
+// TestArchiveNthSampling checks that every Nth message is archived
+// together with its metadata
+func TestArchiveNthSampling(t *testing.T) {
+	directory := t.TempDir()
+	archive, err := main.NewArchive(main.ArchiveConfiguration{
+		Directory: directory,
+		Sampling:  main.SamplingNth,
+		EveryNth:  3,
+	}, nil)
+	assert.NoError(t, err)
+
+	for offset := int64(1); offset <= 10; offset++ {
+		archive.Record(consumerMessage(offset, []byte(`{"a":1}`)), nil)
+	}
+	assert.NoError(t, archive.Close())
+
+	records := archivedRecords(t, directory)
+	assert.Len(t, records, 3)
+	assert.Equal(t, int64(3), records[0].Offset)
+	assert.Equal(t, int64(9), records[2].Offset)
+
+	record := records[0]
+	assert.Equal(t, "topic", record.Topic)
+	assert.Equal(t, int32(1), record.Partition)
+	assert.Equal(t, "key", record.Key)
+	assert.Equal(t, []main.MessageHeader{{Key: "header", Value: "value"}}, record.Headers)
+	assert.Equal(t, `{"a":1}`, record.Value)
+	assert.True(t, record.Timestamp.Equal(time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)))
+	assert.Empty(t, record.Error)
+}
+
+// TestArchiveErrorsSampling checks that only failed messages are archived
+// in errors sampling mode
+func TestArchiveErrorsSampling(t *testing.T) {
+	directory := t.TempDir()
+	archive, err := main.NewArchive(main.ArchiveConfiguration{
+		Directory: directory,
+		Sampling:  main.SamplingErrors,
+	}, nil)
+	assert.NoError(t, err)
+
+	archive.Record(consumerMessage(1, []byte("ok")), nil)
+	archive.Record(consumerMessage(2, []byte("bad")), errors.New("processing failed"))
+	assert.NoError(t, archive.Close())
+
+	records := archivedRecords(t, directory)
+	assert.Len(t, records, 1)
+	assert.Equal(t, int64(2), records[0].Offset)
+	assert.Equal(t, "processing failed", records[0].Error)
+}
+
+// TestArchiveRandomSampling checks boundary percentages of random sampling
+func TestArchiveRandomSampling(t *testing.T) {
+	for _, percentage := range []float64{0, 100} {
+		directory := t.TempDir()
+		archive, err := main.NewArchive(main.ArchiveConfiguration{
+			Directory:  directory,
+			Sampling:   main.SamplingRandom,
+			Percentage: percentage,
+		}, nil)
+		assert.NoError(t, err)
+
+		for offset := int64(0); offset < 10; offset++ {
+			archive.Record(consumerMessage(offset, []byte("x")), nil)
+		}
+		assert.NoError(t, archive.Close())
+
+		assert.Len(t, archivedRecords(t, directory), int(percentage/10))
+	}
+}
+
+// TestArchiveDuplicateHeaders checks that headers with the same key are all
+// archived in original order
+func TestArchiveDuplicateHeaders(t *testing.T) {
+	directory := t.TempDir()
+	archive, err := main.NewArchive(main.ArchiveConfiguration{Directory: directory}, nil)
+	assert.NoError(t, err)
+
+	msg := consumerMessage(1, []byte("x"))
+	msg.Headers = append(msg.Headers, &sarama.RecordHeader{Key: []byte("header"), Value: []byte("other")})
+	archive.Record(msg, nil)
+	assert.NoError(t, archive.Close())
+
+	records := archivedRecords(t, directory)
+	assert.Len(t, records, 1)
+	assert.Equal(t, []main.MessageHeader{
+		{Key: "header", Value: "value"},
+		{Key: "header", Value: "other"},
+	}, records[0].Headers)
+	assert.Equal(t, msg.Headers, records[0].ConsumerMessage().Headers)
+}
+
+// TestArchiveBinaryPayload checks that payload that is not valid UTF-8 is
+// stored in Base64 encoding and can be reconstructed
+func TestArchiveBinaryPayload(t *testing.T) {
+	directory := t.TempDir()
+	archive, err := main.NewArchive(main.ArchiveConfiguration{Directory: directory}, nil)
+	assert.NoError(t, err)
+
+	payload := []byte{0xff, 0xfe, 0x00}
+	archive.Record(consumerMessage(1, payload), nil)
+	assert.NoError(t, archive.Close())
+
+	records := archivedRecords(t, directory)
+	assert.Len(t, records, 1)
+	assert.Empty(t, records[0].Value)
+	assert.Equal(t, payload, records[0].ConsumerMessage().Value)
+}
+
+// TestArchiveRotation checks that archive file is rotated when it reaches
+// configured size and the oldest files are removed when total size limit
+// is reached
+func TestArchiveRotation(t *testing.T) {
+	directory := t.TempDir()
+	archive, err := main.NewArchive(main.ArchiveConfiguration{
+		Directory:    directory,
+		MaxFileSize:  300,
+		MaxTotalSize: 900,
+	}, nil)
+	assert.NoError(t, err)
+
+	for offset := int64(0); offset < 20; offset++ {
+		archive.Record(consumerMessage(offset, []byte(`{"payload":"0123456789"}`)), nil)
+	}
+	assert.NoError(t, archive.Close())
+
+	files, err := main.ArchiveFiles(directory)
+	assert.NoError(t, err)
+	assert.True(t, len(files) > 1)
+
+	var totalSize int64
+	for _, name := range files {
+		info, err := os.Stat(name)
+		assert.NoError(t, err)
+		assert.True(t, info.Size() <= 300)
+		totalSize += info.Size()
+	}
+	assert.True(t, totalSize <= 900)
+
+	// the newest message needs to be kept
+	records := archivedRecords(t, directory)
+	assert.Equal(t, int64(19), records[len(records)-1].Offset)
+}
+
+// TestArchiveMaxAge checks that archive files older than configured age are
+// removed
+func TestArchiveMaxAge(t *testing.T) {
+	directory := t.TempDir()
+	archive, err := main.NewArchive(main.ArchiveConfiguration{
+		Directory:   directory,
+		MaxFileSize: 200,
+		MaxAge:      time.Hour,
+	}, nil)
+	assert.NoError(t, err)
+
+	archive.Record(consumerMessage(1, []byte("first")), nil)
+	files, err := main.ArchiveFiles(directory)
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+
+	// make the first file old
+	old := time.Now().Add(-2 * time.Hour)
+	assert.NoError(t, os.Chtimes(files[0], old, old))
+
+	// enforce rotation
+	for offset := int64(2); offset < 5; offset++ {
+		archive.Record(consumerMessage(offset, []byte("next message")), nil)
+	}
+	assert.NoError(t, archive.Close())
+
+	for _, record := range archivedRecords(t, directory) {
+		assert.NotEqual(t, int64(1), record.Offset)
+	}
+}
+
+// TestArchiveCleanup checks that archive files older than configured age are
+// removed by periodic cleanup even when no message is archived
+func TestArchiveCleanup(t *testing.T) {
+	directory := t.TempDir()
+	clock := newManualClock(time.Now())
+	archive, err := main.NewArchive(main.ArchiveConfiguration{
+		Directory:   directory,
+		MaxFileSize: 200,
+		MaxAge:      time.Hour,
+	}, clock)
+	assert.NoError(t, err)
+
+	archive.Record(consumerMessage(1, []byte("first")), nil)
+	clock.Advance(time.Second)
+	archive.Record(consumerMessage(2, []byte("second")), nil)
+
+	files, err := main.ArchiveFiles(directory)
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+
+	clock.Advance(2 * time.Hour)
+	archive.Cleanup()
+	assert.NoError(t, archive.Close())
+
+	// actual archive file is kept
+	records := archivedRecords(t, directory)
+	assert.Len(t, records, 1)
+	assert.Equal(t, int64(2), records[0].Offset)
+}
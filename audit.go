@@ -0,0 +1,420 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains implementation of topic configuration audit.
+// Live configuration of each topic with expected values declared in
+// [topics.expected] table is retrieved from broker and compared with these
+// values: number of partitions, replication factor, retention.ms,
+// cleanup.policy, min.insync.replicas and max.message.bytes. Differences
+// are reported as findings. Audit is performed once by -audit-topics
+// command, or periodically by the service, in which case alert is raised
+// for each new finding.
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/rs/zerolog/log"
+)
+
+// AlertTopicConfigurationDrift is type of alert raised when live
+// configuration of topic differs from expected one
+const AlertTopicConfigurationDrift = "topic_configuration_drift"
+
+// defaultAuditInterval is used when audit interval is not configured
+const defaultAuditInterval = time.Hour
+
+// Audited settings that are not part of topic configuration
+const (
+	auditSettingExists            = "exists"
+	auditSettingPartitions        = "partitions"
+	auditSettingReplicationFactor = "replication.factor"
+)
+
+// Audited topic configuration options, max.message.bytes is defined in
+// size.go
+const (
+	retentionMsConfig       = "retention.ms"
+	cleanupPolicyConfig     = "cleanup.policy"
+	minInsyncReplicasConfig = "min.insync.replicas"
+)
+
+// topicAuditMessage is used in log messages produced by topic audit
+const topicAuditMessage = "Topic configuration audit"
+
+// AuditFinding represents one difference between expected and live
+// configuration of topic
+type AuditFinding struct {
+	Topic    string `json:"topic"`
+	Setting  string `json:"setting"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+// AuditReport contains result of the most recent topic audit
+type AuditReport struct {
+	Time     time.Time      `json:"time"`
+	Findings []AuditFinding `json:"findings"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// expectedConfig represents expected value of one topic configuration
+// option
+type expectedConfig struct {
+	name  string
+	value string
+}
+
+// configs method returns expected values of topic configuration options
+// that are set
+func (expectation TopicExpectation) configs() []expectedConfig {
+	var configs []expectedConfig
+	if expectation.RetentionMs != 0 {
+		configs = append(configs, expectedConfig{retentionMsConfig, strconv.FormatInt(expectation.RetentionMs, 10)})
+	}
+	if expectation.CleanupPolicy != "" {
+		configs = append(configs, expectedConfig{cleanupPolicyConfig, expectation.CleanupPolicy})
+	}
+	if expectation.MinInsyncReplicas > 0 {
+		configs = append(configs, expectedConfig{minInsyncReplicasConfig, strconv.Itoa(expectation.MinInsyncReplicas)})
+	}
+	if expectation.MaxMessageBytes > 0 {
+		configs = append(configs, expectedConfig{maxMessageBytesConfig, strconv.FormatInt(expectation.MaxMessageBytes, 10)})
+	}
+	return configs
+}
+
+// isSet method returns true when at least one expected value is set
+func (expectation TopicExpectation) isSet() bool {
+	return expectation.Partitions > 0 || expectation.ReplicationFactor > 0 || len(expectation.configs()) > 0
+}
+
+// auditedTopics function returns topics with at least one expected value
+// set
+func auditedTopics(topics []TopicConfiguration) []TopicConfiguration {
+	var audited []TopicConfiguration
+	for _, topic := range topics {
+		if topic.Expected.isSet() {
+			audited = append(audited, topic)
+		}
+	}
+	return audited
+}
+
+// normalizeCleanupPolicy function converts list of cleanup policies into
+// canonical form, so "delete,compact" is the same as "compact, delete"
+func normalizeCleanupPolicy(policy string) string {
+	policies := strings.Split(policy, ",")
+	for i := range policies {
+		policies[i] = strings.TrimSpace(policies[i])
+	}
+	sort.Strings(policies)
+	return strings.Join(policies, ",")
+}
+
+// replicationFactor function returns the lowest number of replicas of
+// topic partitions
+func replicationFactor(partitions []*sarama.PartitionMetadata) int {
+	factor := 0
+	for i, partition := range partitions {
+		if i == 0 || len(partition.Replicas) < factor {
+			factor = len(partition.Replicas)
+		}
+	}
+	return factor
+}
+
+// describeTopicConfig function retrieves values of selected configuration
+// options of given topic
+func describeTopicConfig(broker *sarama.Broker, topic string, names []string) (map[string]string, error) {
+	response, err := broker.DescribeConfigs(&sarama.DescribeConfigsRequest{
+		Resources: []*sarama.ConfigResource{{
+			Type:        sarama.TopicResource,
+			Name:        topic,
+			ConfigNames: names,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(names))
+	for _, resource := range response.Resources {
+		if resource.ErrorCode != int16(sarama.ErrNoError) {
+			return nil, fmt.Errorf("unable to describe configuration of topic '%s': %v",
+				topic, sarama.KError(resource.ErrorCode))
+		}
+		for _, entry := range resource.Configs {
+			values[entry.Name] = entry.Value
+		}
+	}
+	return values, nil
+}
+
+// auditTopicsConfiguration function retrieves live configuration of given
+// topics and compares it with expected values. Findings are returned in
+// order of topics and settings.
+func auditTopicsConfiguration(broker *sarama.Broker, topics []TopicConfiguration) ([]AuditFinding, error) {
+	names := make([]string, len(topics))
+	for i, topic := range topics {
+		names[i] = topic.Name
+	}
+
+	metadata, err := broker.GetMetadata(&sarama.MetadataRequest{Topics: names})
+	if err != nil {
+		return nil, err
+	}
+
+	findings := []AuditFinding{}
+	compare := func(topic, setting, expected, actual string) {
+		if expected != actual {
+			findings = append(findings, AuditFinding{
+				Topic:    topic,
+				Setting:  setting,
+				Expected: expected,
+				Actual:   actual,
+			})
+		}
+	}
+
+	for _, topic := range topics {
+		topicMetadata := findTopicMetadata(metadata, topic.Name)
+		if topicMetadata == nil || topicMetadata.Err == sarama.ErrUnknownTopicOrPartition {
+			compare(topic.Name, auditSettingExists, "true", "false")
+			continue
+		}
+		if topicMetadata.Err != sarama.ErrNoError {
+			return nil, topicMetadata.Err
+		}
+
+		expected := topic.Expected
+		if expected.Partitions > 0 {
+			compare(topic.Name, auditSettingPartitions,
+				strconv.Itoa(expected.Partitions), strconv.Itoa(len(topicMetadata.Partitions)))
+		}
+		if expected.ReplicationFactor > 0 {
+			compare(topic.Name, auditSettingReplicationFactor,
+				strconv.Itoa(expected.ReplicationFactor), strconv.Itoa(replicationFactor(topicMetadata.Partitions)))
+		}
+
+		configs := expected.configs()
+		if len(configs) == 0 {
+			continue
+		}
+		configNames := make([]string, len(configs))
+		for i, config := range configs {
+			configNames[i] = config.name
+		}
+		values, err := describeTopicConfig(broker, topic.Name, configNames)
+		if err != nil {
+			return nil, err
+		}
+		for _, config := range configs {
+			expectedValue, actualValue := config.value, values[config.name]
+			if config.name == cleanupPolicyConfig {
+				expectedValue = normalizeCleanupPolicy(expectedValue)
+				actualValue = normalizeCleanupPolicy(actualValue)
+			}
+			compare(topic.Name, config.name, expectedValue, actualValue)
+		}
+	}
+
+	return findings, nil
+}
+
+// runTopicAudit function connects to broker and audits configuration of
+// given topics. Exit code is returned together with error when the audit
+// fails.
+func runTopicAudit(brokerCfg BrokerConfiguration, topics []TopicConfiguration) ([]AuditFinding, int, error) {
+	saramaConfig, err := newSaramaConfig(brokerCfg)
+	if err != nil {
+		return nil, ExitStatusKafkaError, err
+	}
+	// topic configuration is retrieved by DescribeConfigs request
+	if !saramaConfig.Version.IsAtLeast(minimalAdminProtocolVersion) {
+		saramaConfig.Version = minimalAdminProtocolVersion
+	}
+
+	broker, exitCode, err := connectToBroker(brokerCfg, saramaConfig)
+	if err != nil {
+		return nil, exitCode, err
+	}
+	if broker == nil {
+		return nil, exitCode, errors.New(notConnectedToBrokerMessage)
+	}
+	defer func() {
+		err := broker.Close()
+		if err != nil {
+			log.Error().Err(err).Msg(closingBrokerConnectionMessage)
+		}
+	}()
+
+	findings, err := auditTopicsConfiguration(broker, topics)
+	if err != nil {
+		log.Error().Err(err).Msg(topicAuditMessage)
+		return nil, ExitStatusKafkaError, err
+	}
+	return findings, ExitStatusOK, nil
+}
+
+// auditTopics function compares live configuration of all topics that have
+// expected values configured with these values and displays differences
+func auditTopics(config ConfigStruct) (int, error) {
+	topics := auditedTopics(GetTopicsConfiguration(&config))
+	if len(topics) == 0 {
+		err := fmt.Errorf("expected configuration is not specified for any topic")
+		log.Error().Err(err).Msg(topicAuditMessage)
+		return ExitStatusError, err
+	}
+
+	findings, exitCode, err := runTopicAudit(GetBrokerConfiguration(&config), topics)
+	if err != nil {
+		return exitCode, err
+	}
+
+	if len(findings) == 0 {
+		fmt.Printf("Configuration of %d topic(s) matches expected values\n", len(topics))
+		return ExitStatusOK, nil
+	}
+
+	w := newTableWriter()
+	fmt.Fprintln(w, "TOPIC\tSETTING\tEXPECTED\tACTUAL")
+	for _, finding := range findings {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", finding.Topic, finding.Setting, finding.Expected, finding.Actual)
+	}
+	err = w.Flush()
+	if err != nil {
+		return ExitStatusError, err
+	}
+
+	return ExitStatusTopicConfigurationError,
+		fmt.Errorf("%d difference(s) from expected topic configuration found", len(findings))
+}
+
+// TopicAuditor periodically audits configuration of topics
+type TopicAuditor struct {
+	mutex     sync.Mutex
+	config    AuditConfiguration
+	brokerCfg BrokerConfiguration
+	topics    []TopicConfiguration
+	alerts    *AlertManager
+	clock     Clock
+	report    AuditReport
+	reported  map[AuditFinding]bool
+}
+
+// NewTopicAuditor constructs new topic auditor. Just topics with expected
+// values configured are audited.
+func NewTopicAuditor(config AuditConfiguration, brokerCfg BrokerConfiguration,
+	topics []TopicConfiguration, alerts *AlertManager, clock Clock) *TopicAuditor {
+	if config.Interval <= 0 {
+		config.Interval = defaultAuditInterval
+	}
+
+	return &TopicAuditor{
+		config:    config,
+		brokerCfg: brokerCfg,
+		topics:    auditedTopics(topics),
+		alerts:    alerts,
+		clock:     clockOrDefault(clock),
+		report:    AuditReport{Findings: []AuditFinding{}},
+		reported:  make(map[AuditFinding]bool),
+	}
+}
+
+// Audit method audits configuration of all topics. Alert is raised for
+// each finding that has not been found by the previous audit.
+func (auditor *TopicAuditor) Audit() ([]AuditFinding, error) {
+	findings, _, err := runTopicAudit(auditor.brokerCfg, auditor.topics)
+
+	auditor.mutex.Lock()
+	defer auditor.mutex.Unlock()
+
+	auditor.report = AuditReport{
+		Time:     auditor.clock.Now(),
+		Findings: []AuditFinding{},
+	}
+	if err != nil {
+		auditor.report.Error = err.Error()
+		return nil, err
+	}
+	auditor.report.Findings = findings
+
+	reported := make(map[AuditFinding]bool, len(findings))
+	for _, finding := range findings {
+		reported[finding] = true
+		if auditor.reported[finding] {
+			continue
+		}
+		severity := SeverityWarning
+		if finding.Setting == auditSettingExists {
+			severity = SeverityCritical
+		}
+		auditor.alerts.Raise(Alert{
+			Type:     AlertTopicConfigurationDrift,
+			Severity: severity,
+			Topic:    finding.Topic,
+			Message: fmt.Sprintf("Topic setting %s is %s, expected %s",
+				finding.Setting, finding.Actual, finding.Expected),
+			Details: map[string]interface{}{
+				"setting":  finding.Setting,
+				"expected": finding.Expected,
+				"actual":   finding.Actual,
+			},
+		})
+	}
+	auditor.reported = reported
+
+	return findings, nil
+}
+
+// Report method returns result of the most recent audit
+func (auditor *TopicAuditor) Report() AuditReport {
+	auditor.mutex.Lock()
+	defer auditor.mutex.Unlock()
+
+	report := auditor.report
+	report.Findings = append([]AuditFinding{}, auditor.report.Findings...)
+	return report
+}
+
+// Run method audits configuration of topics immediately and then in
+// regular intervals. It blocks current thread.
+func (auditor *TopicAuditor) Run() {
+	ticker := time.NewTicker(auditor.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		findings, err := auditor.Audit()
+		if err != nil {
+			log.Error().Err(err).Msg(topicAuditMessage)
+		} else {
+			log.Info().
+				Int("topics", len(auditor.topics)).
+				Int("findings", len(findings)).
+				Msg(topicAuditMessage)
+		}
+		<-ticker.C
+	}
+}
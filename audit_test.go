@@ -0,0 +1,224 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Unit test definitions for functions and methods defined in source file
+// audit.go
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/tisnik/go-capture"
+
+	main "github.com/RedHatInsights/insights-kafka-monitor"
+)
+
+// newAuditMockBroker function constructs mock broker with test topic that
+// has one partition with three replicas and selected configuration
+func newAuditMockBroker(t *testing.T) *sarama.MockBroker {
+	broker := sarama.NewMockBroker(t, 1)
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockWrapper(
+			metadataResponse(broker, broker.BrokerID(), []int32{1, 2, 3}, []int32{1, 2, 3})),
+		"DescribeConfigsRequest": sarama.NewMockWrapper(&sarama.DescribeConfigsResponse{
+			Resources: []*sarama.ResourceResponse{{
+				Type: sarama.TopicResource,
+				Name: testTopic,
+				Configs: []*sarama.ConfigEntry{
+					{Name: "retention.ms", Value: "604800000"},
+					{Name: "cleanup.policy", Value: "delete,compact"},
+					{Name: "min.insync.replicas", Value: "1"},
+					{Name: "max.message.bytes", Value: "1048588"},
+				},
+			}},
+		}),
+	})
+	return broker
+}
+
+// auditConfiguration function prepares configuration with expected values
+// of test topic
+func auditConfiguration(broker *sarama.MockBroker, expected main.TopicExpectation) main.ConfigStruct {
+	configuration := configurationForMockBroker(broker, testGroup)
+	configuration.Topics = []main.TopicConfiguration{
+		{Name: testTopic, Expected: expected},
+	}
+	return configuration
+}
+
+// matchingExpectation contains expected values matching mock broker
+var matchingExpectation = main.TopicExpectation{
+	Partitions:        1,
+	ReplicationFactor: 3,
+	RetentionMs:       604800000,
+	CleanupPolicy:     "compact, delete",
+	MinInsyncReplicas: 1,
+	MaxMessageBytes:   1048588,
+}
+
+// driftingExpectation contains expected values that partially differ from
+// mock broker
+var driftingExpectation = main.TopicExpectation{
+	Partitions:        3,
+	ReplicationFactor: 3,
+	RetentionMs:       604800000,
+	MinInsyncReplicas: 2,
+}
+
+// TestAuditTopicsNoExpectations checks that audit fails when no expected
+// values are configured
+func TestAuditTopicsNoExpectations(t *testing.T) {
+	broker := newAuditMockBroker(t)
+	defer broker.Close()
+
+	code, err := main.AuditTopics(configurationForMockBroker(broker, testGroup))
+	assert.Error(t, err)
+	assert.Equal(t, main.ExitStatusError, code)
+}
+
+// TestAuditTopicsMatchingConfiguration checks the function auditTopics when
+// live configuration matches expected values
+func TestAuditTopicsMatchingConfiguration(t *testing.T) {
+	broker := newAuditMockBroker(t)
+	defer broker.Close()
+
+	output, err := capture.StandardOutput(func() {
+		code, err := main.AuditTopics(auditConfiguration(broker, matchingExpectation))
+		assert.NoError(t, err)
+		assert.Equal(t, main.ExitStatusOK, code)
+	})
+	checkCapture(t, err)
+
+	assert.Contains(t, output, "matches expected values")
+}
+
+// TestAuditTopicsDrift checks the function auditTopics when live
+// configuration differs from expected values
+func TestAuditTopicsDrift(t *testing.T) {
+	broker := newAuditMockBroker(t)
+	defer broker.Close()
+
+	output, err := capture.StandardOutput(func() {
+		code, err := main.AuditTopics(auditConfiguration(broker, driftingExpectation))
+		assert.Error(t, err)
+		assert.Equal(t, main.ExitStatusTopicConfigurationError, code)
+	})
+	checkCapture(t, err)
+
+	assert.Contains(t, output, "TOPIC")
+	assert.Regexp(t, testTopic+`\s+partitions\s+3\s+1`, output)
+	assert.Regexp(t, testTopic+`\s+min.insync.replicas\s+2\s+1`, output)
+	assert.NotContains(t, output, "retention.ms")
+	assert.NotContains(t, output, "replication.factor")
+}
+
+// TestAuditTopicsMissingTopic checks the function auditTopics when audited
+// topic does not exist
+func TestAuditTopicsMissingTopic(t *testing.T) {
+	broker := newAuditMockBroker(t)
+	defer broker.Close()
+
+	configuration := auditConfiguration(broker, matchingExpectation)
+	configuration.Topics[0].Name = "other_topic"
+
+	output, err := capture.StandardOutput(func() {
+		code, err := main.AuditTopics(configuration)
+		assert.Error(t, err)
+		assert.Equal(t, main.ExitStatusTopicConfigurationError, code)
+	})
+	checkCapture(t, err)
+
+	assert.Regexp(t, `other_topic\s+exists\s+true\s+false`, output)
+}
+
+// TestTopicAuditorRaisesAlertsOnce checks that alert is raised just for
+// findings that have not been found by previous audit
+func TestTopicAuditorRaisesAlertsOnce(t *testing.T) {
+	broker := newAuditMockBroker(t)
+	defer broker.Close()
+
+	configuration := auditConfiguration(broker, driftingExpectation)
+	alerts := main.NewAlertManager(nil)
+	auditor := main.NewTopicAuditor(main.AuditConfiguration{Enabled: true},
+		configuration.Broker, configuration.Topics, alerts, nil)
+
+	findings, err := auditor.Audit()
+	assert.NoError(t, err)
+	assert.Len(t, findings, 2)
+	assert.Len(t, alerts.Recent(), 2)
+
+	_, err = auditor.Audit()
+	assert.NoError(t, err)
+	assert.Len(t, alerts.Recent(), 2)
+
+	for _, alert := range alerts.Recent() {
+		assert.Equal(t, main.AlertTopicConfigurationDrift, alert.Type)
+		assert.Equal(t, main.SeverityWarning, alert.Severity)
+		assert.Equal(t, testTopic, alert.Topic)
+	}
+
+	report := auditor.Report()
+	assert.Empty(t, report.Error)
+	assert.Equal(t, findings, report.Findings)
+}
+
+// TestTopicAuditorBrokerNotAvailable checks that audit error is stored in
+// report
+func TestTopicAuditorBrokerNotAvailable(t *testing.T) {
+	broker := newAuditMockBroker(t)
+	configuration := auditConfiguration(broker, driftingExpectation)
+	broker.Close()
+
+	alerts := main.NewAlertManager(nil)
+	auditor := main.NewTopicAuditor(main.AuditConfiguration{Enabled: true},
+		configuration.Broker, configuration.Topics, alerts, nil)
+
+	_, err := auditor.Audit()
+	assert.Error(t, err)
+	assert.NotEmpty(t, auditor.Report().Error)
+	assert.Empty(t, alerts.Recent())
+}
+
+// TestAuditEndpoint checks the audit endpoint
+func TestAuditEndpoint(t *testing.T) {
+	consumer := NewDummyConsumer()
+	server := main.NewHTTPServer(main.ServerConfiguration{}, consumer)
+
+	response := performRequest(server, "/api/v1/audit")
+	assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+
+	broker := newAuditMockBroker(t)
+	defer broker.Close()
+
+	configuration := auditConfiguration(broker, driftingExpectation)
+	consumer.Auditor = main.NewTopicAuditor(main.AuditConfiguration{Enabled: true},
+		configuration.Broker, configuration.Topics, main.NewAlertManager(nil), nil)
+	_, err := consumer.Auditor.Audit()
+	assert.NoError(t, err)
+
+	response = performRequest(server, "/api/v1/audit")
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	var report main.AuditReport
+	err = json.Unmarshal(response.Body.Bytes(), &report)
+	assert.NoError(t, err)
+	assert.Len(t, report.Findings, 2)
+}
@@ -0,0 +1,215 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains functions used to perform deep health check of
+// Kafka cluster: retrieving cluster metadata, checking that configured topics
+// exist, that every partition has a leader and full set of in-sync replicas,
+// and that group coordinator is reachable.
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Shopify/sarama"
+	"github.com/rs/zerolog/log"
+)
+
+// Messages used by health checks
+const (
+	metadataRetrievalMessage         = "Retrieving cluster metadata"
+	topicNotFoundMessage             = "Topic not found"
+	partitionWithoutLeaderMessage    = "Partition without leader"
+	underReplicatedPartitionMessage  = "Under-replicated partition"
+	topicCheckSuccessMessage         = "Topic check OK"
+	coordinatorMessage               = "Group coordinator"
+	coordinatorSuccessMessage        = "Group coordinator check OK"
+	coordinatorCheckSkippedMessage   = "Group is not configured, skipping coordinator check"
+	replicasAttribute                = "replicas"
+	inSyncReplicasAttribute          = "in-sync replicas"
+	coordinatorAddressAttribute      = "coordinator"
+	notConnectedToCoordinatorMessage = "not connected to group coordinator"
+)
+
+// connectToBroker function opens connection to broker specified in broker
+// configuration and checks that the connection has been established. The
+// same sarama config as used by consumer is to be provided, so network
+// settings (timeouts, TLS, SASL) are applied. Exit code is returned together
+// with error when the connection fails.
+func connectToBroker(brokerConfiguration BrokerConfiguration, saramaConfig *sarama.Config) (*sarama.Broker, int, error) {
+	log.Info().Str(brokerAddressMessage, brokerConfiguration.Address).Msg(brokerAddressMessage)
+
+	// create new broker instance (w/o any checks)
+	broker := sarama.NewBroker(brokerConfiguration.Address)
+
+	// check broker connection
+	err := broker.Open(saramaConfig)
+	if err != nil {
+		log.Error().Err(err).Msg(connectionToBrokerMessage)
+		return nil, ExitStatusKafkaError, err
+	}
+
+	// check if connection remain
+	connected, err := broker.Connected()
+	if err != nil {
+		log.Error().Err(err).Msg(connectionToBrokerMessage)
+		return nil, ExitStatusKafkaError, err
+	}
+	if !connected {
+		log.Error().Err(err).Msg(notConnectedToBrokerMessage)
+		return nil, ExitStatusConsumerError, err
+	}
+
+	log.Info().Msg(brokerConnectionSuccessMessage)
+	return broker, ExitStatusOK, nil
+}
+
+// checkTopicsMetadata function retrieves cluster metadata for given topics
+// and checks that all topics exist, that every partition has a leader and
+// that every partition has full set of in-sync replicas.
+func checkTopicsMetadata(broker *sarama.Broker, topics []string) (int, error) {
+	log.Info().Strs("topics", topics).Msg(metadataRetrievalMessage)
+
+	metadata, err := broker.GetMetadata(&sarama.MetadataRequest{Topics: topics})
+	if err != nil {
+		log.Error().Err(err).Msg(metadataRetrievalMessage)
+		return ExitStatusKafkaError, err
+	}
+
+	for _, topic := range topics {
+		topicMetadata := findTopicMetadata(metadata, topic)
+		if topicMetadata == nil || topicMetadata.Err == sarama.ErrUnknownTopicOrPartition {
+			err := fmt.Errorf("topic '%s' does not exist", topic)
+			log.Error().Str(topicKey, topic).Msg(topicNotFoundMessage)
+			return ExitStatusTopicError, err
+		}
+		if topicMetadata.Err != sarama.ErrNoError {
+			log.Error().Err(topicMetadata.Err).Str(topicKey, topic).Msg(metadataRetrievalMessage)
+			return ExitStatusTopicError, topicMetadata.Err
+		}
+
+		exitCode, err := checkPartitionsMetadata(topic, topicMetadata.Partitions)
+		if err != nil {
+			return exitCode, err
+		}
+
+		log.Info().
+			Str(topicKey, topic).
+			Int("partitions", len(topicMetadata.Partitions)).
+			Msg(topicCheckSuccessMessage)
+	}
+
+	return ExitStatusOK, nil
+}
+
+// findTopicMetadata function returns metadata for selected topic or nil when
+// the topic is not part of metadata response.
+func findTopicMetadata(metadata *sarama.MetadataResponse, topic string) *sarama.TopicMetadata {
+	for _, topicMetadata := range metadata.Topics {
+		if topicMetadata.Name == topic {
+			return topicMetadata
+		}
+	}
+	return nil
+}
+
+// checkPartitionsMetadata function checks that every partition has a leader
+// and that all replicas are in sync.
+func checkPartitionsMetadata(topic string, partitions []*sarama.PartitionMetadata) (int, error) {
+	for _, partition := range partitions {
+		if partition.Leader < 0 || partition.Err == sarama.ErrLeaderNotAvailable {
+			err := fmt.Errorf("partition %d of topic '%s' does not have a leader", partition.ID, topic)
+			log.Error().
+				Str(topicKey, topic).
+				Int32(partitionKey, partition.ID).
+				Msg(partitionWithoutLeaderMessage)
+			return ExitStatusPartitionLeaderError, err
+		}
+
+		if len(partition.Isr) < len(partition.Replicas) {
+			err := fmt.Errorf("partition %d of topic '%s' is under-replicated (%d of %d replicas in sync)",
+				partition.ID, topic, len(partition.Isr), len(partition.Replicas))
+			log.Error().
+				Str(topicKey, topic).
+				Int32(partitionKey, partition.ID).
+				Ints32(replicasAttribute, partition.Replicas).
+				Ints32(inSyncReplicasAttribute, partition.Isr).
+				Msg(underReplicatedPartitionMessage)
+			return ExitStatusReplicationError, err
+		}
+	}
+
+	return ExitStatusOK, nil
+}
+
+// checkGroupCoordinator function finds coordinator for given consumer group
+// and checks that the coordinator is reachable. Connection to coordinator
+// is opened with given sarama config.
+func checkGroupCoordinator(broker *sarama.Broker, group string, saramaConfig *sarama.Config) (int, error) {
+	if group == "" {
+		log.Info().Msg(coordinatorCheckSkippedMessage)
+		return ExitStatusOK, nil
+	}
+
+	response, err := broker.FindCoordinator(&sarama.FindCoordinatorRequest{
+		CoordinatorKey:  group,
+		CoordinatorType: sarama.CoordinatorGroup,
+	})
+	if err != nil {
+		log.Error().Err(err).Str(groupKey, group).Msg(coordinatorMessage)
+		return ExitStatusCoordinatorError, err
+	}
+	if response.Err != sarama.ErrNoError {
+		log.Error().Err(response.Err).Str(groupKey, group).Msg(coordinatorMessage)
+		return ExitStatusCoordinatorError, response.Err
+	}
+
+	coordinator := response.Coordinator
+	log.Info().
+		Str(groupKey, group).
+		Str(coordinatorAddressAttribute, coordinator.Addr()).
+		Msg(coordinatorMessage)
+
+	// coordinator might be the same broker we are already connected to, but
+	// the check needs to be performed via new connection anyway
+	err = coordinator.Open(saramaConfig)
+	if err != nil {
+		log.Error().Err(err).Msg(coordinatorMessage)
+		return ExitStatusCoordinatorError, err
+	}
+
+	defer func() {
+		err := coordinator.Close()
+		if err != nil {
+			log.Error().Err(err).Msg(closingBrokerConnectionMessage)
+		}
+	}()
+
+	connected, err := coordinator.Connected()
+	if err != nil {
+		log.Error().Err(err).Msg(coordinatorMessage)
+		return ExitStatusCoordinatorError, err
+	}
+	if !connected {
+		log.Error().Msg(notConnectedToCoordinatorMessage)
+		return ExitStatusCoordinatorError, errors.New(notConnectedToCoordinatorMessage)
+	}
+
+	log.Info().Str(groupKey, group).Msg(coordinatorSuccessMessage)
+
+	return ExitStatusOK, nil
+}
@@ -0,0 +1,161 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Unit test definitions for functions and methods defined in source file
+// broker_check.go
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-kafka-monitor"
+)
+
+const (
+	testTopic = "test_topic"
+	testGroup = "test_group"
+)
+
+// configurationForMockBroker function prepares configuration structure that
+// points to given mock broker.
+func configurationForMockBroker(broker *sarama.MockBroker, group string) main.ConfigStruct {
+	configuration := main.ConfigStruct{}
+	configuration.Broker = main.BrokerConfiguration{
+		Address: broker.Addr(),
+		Topic:   testTopic,
+		Group:   group,
+		Enabled: true,
+	}
+	return configuration
+}
+
+// metadataResponse function constructs metadata response with one topic
+// that contains one partition with specified leader, replicas and ISR.
+func metadataResponse(broker *sarama.MockBroker, leader int32, replicas, isr []int32) *sarama.MetadataResponse {
+	response := new(sarama.MetadataResponse)
+	response.AddBroker(broker.Addr(), broker.BrokerID())
+	response.AddTopicPartition(testTopic, 0, leader, replicas, isr, []int32{}, sarama.ErrNoError)
+	return response
+}
+
+// TestTryToConnectToKafkaHealthyCluster checks the deep health check against
+// cluster that is fully operational.
+func TestTryToConnectToKafkaHealthyCluster(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader(testTopic, 0, broker.BrokerID()),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, testGroup, broker),
+	})
+
+	code, err := main.TryToConnectToKafka(configurationForMockBroker(broker, testGroup))
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+}
+
+// TestTryToConnectToKafkaNoGroup checks that coordinator check is skipped
+// when consumer group is not configured.
+func TestTryToConnectToKafkaNoGroup(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader(testTopic, 0, broker.BrokerID()),
+	})
+
+	code, err := main.TryToConnectToKafka(configurationForMockBroker(broker, ""))
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+}
+
+// TestTryToConnectToKafkaTopicNotFound checks the deep health check when
+// configured topic does not exist.
+func TestTryToConnectToKafkaTopicNotFound(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("other_topic", 0, broker.BrokerID()),
+	})
+
+	code, err := main.TryToConnectToKafka(configurationForMockBroker(broker, testGroup))
+	assert.EqualError(t, err, "topic 'test_topic' does not exist")
+	assert.Equal(t, main.ExitStatusTopicError, code)
+}
+
+// TestTryToConnectToKafkaPartitionWithoutLeader checks the deep health check
+// when one partition does not have a leader.
+func TestTryToConnectToKafkaPartitionWithoutLeader(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockWrapper(
+			metadataResponse(broker, -1, []int32{1}, []int32{})),
+	})
+
+	code, err := main.TryToConnectToKafka(configurationForMockBroker(broker, testGroup))
+	assert.EqualError(t, err, "partition 0 of topic 'test_topic' does not have a leader")
+	assert.Equal(t, main.ExitStatusPartitionLeaderError, code)
+}
+
+// TestTryToConnectToKafkaUnderReplicatedPartition checks the deep health
+// check when one partition does not have full ISR.
+func TestTryToConnectToKafkaUnderReplicatedPartition(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockWrapper(
+			metadataResponse(broker, 1, []int32{1, 2, 3}, []int32{1, 2})),
+	})
+
+	code, err := main.TryToConnectToKafka(configurationForMockBroker(broker, testGroup))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "under-replicated (2 of 3 replicas in sync)")
+	assert.Equal(t, main.ExitStatusReplicationError, code)
+}
+
+// TestTryToConnectToKafkaCoordinatorNotAvailable checks the deep health
+// check when group coordinator can not be found.
+func TestTryToConnectToKafkaCoordinatorNotAvailable(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader(testTopic, 0, broker.BrokerID()),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetError(sarama.CoordinatorGroup, testGroup, sarama.ErrConsumerCoordinatorNotAvailable),
+	})
+
+	code, err := main.TryToConnectToKafka(configurationForMockBroker(broker, testGroup))
+	assert.Equal(t, sarama.ErrConsumerCoordinatorNotAvailable, err)
+	assert.Equal(t, main.ExitStatusCoordinatorError, code)
+}
@@ -0,0 +1,335 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains implementation of canary. Canary periodically
+// produces tagged heartbeat message into dedicated canary topic and
+// measures time until the message is read back by the monitor itself. It
+// makes possible to distinguish "no traffic" from "pipeline is broken".
+// Canary messages are read by plain partition consumers from all partitions
+// of canary topic, so they are not split between monitor replicas sharing
+// consumer group. Canary messages are recognized by header.
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/rs/zerolog/log"
+)
+
+// CanaryHeader is header used to tag canary messages. Header value contains
+// canary ID.
+const CanaryHeader = "x-insights-monitor-canary"
+
+// Alert types raised by canary
+const (
+	AlertCanaryLost    = "canary_lost"
+	AlertCanaryLatency = "canary_latency"
+)
+
+// Default canary settings
+const (
+	defaultCanaryInterval = time.Minute
+	defaultCanaryTimeout  = 5 * time.Minute
+)
+
+// Messages used by canary
+const (
+	canarySendMessage     = "Unable to send canary message"
+	canaryReceivedMessage = "Canary message received"
+	canaryCloseMessage    = "Unable to close canary consumer"
+)
+
+// CanaryStats contains statistic about canary messages
+type CanaryStats struct {
+	Sent           uint64        `json:"sent"`
+	Received       uint64        `json:"received"`
+	Lost           uint64        `json:"lost"`
+	Pending        int           `json:"pending"`
+	LastLatency    time.Duration `json:"last_latency"`
+	AverageLatency time.Duration `json:"average_latency"`
+	MaxLatency     time.Duration `json:"max_latency"`
+	LastReceived   time.Time     `json:"last_received"`
+}
+
+// canaryPayload represents content of canary message
+type canaryPayload struct {
+	Canary string    `json:"canary"`
+	Sent   time.Time `json:"sent"`
+}
+
+// Canary produces canary messages and tracks their round-trip latency
+type Canary struct {
+	mutex        sync.Mutex
+	config       CanaryConfiguration
+	producer     sarama.SyncProducer
+	consumer     sarama.Consumer
+	partitions   []sarama.PartitionConsumer
+	alerts       *AlertManager
+	clock        Clock
+	instance     string
+	sequence     uint64
+	pending      map[string]time.Time
+	stats        CanaryStats
+	totalLatency time.Duration
+}
+
+// NewCanary constructs new canary that produces messages by given producer.
+// Canary messages are produced into topic from canary configuration. The
+// topic has to be configured and it must not be the monitored topic.
+func NewCanary(config CanaryConfiguration, monitoredTopic string, producer sarama.SyncProducer, alerts *AlertManager, clock Clock) (*Canary, error) {
+	if config.Topic == "" {
+		return nil, fmt.Errorf("canary topic is not configured")
+	}
+	if config.Topic == monitoredTopic {
+		return nil, fmt.Errorf("canary topic '%s' can't be the monitored topic", config.Topic)
+	}
+	if config.Interval <= 0 {
+		config.Interval = defaultCanaryInterval
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultCanaryTimeout
+	}
+
+	// instance ID is used to distinguish canaries sent by this monitor from
+	// canaries sent by other monitors consuming the same topic
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "monitor"
+	}
+	instance := fmt.Sprintf("%s-%08x", hostname, rand.Uint32()) // #nosec G404
+
+	return &Canary{
+		config:   config,
+		producer: producer,
+		alerts:   alerts,
+		clock:    clockOrDefault(clock),
+		instance: instance,
+		pending:  make(map[string]time.Time),
+	}, nil
+}
+
+// Topic method returns name of topic where canary messages are produced
+func (canary *Canary) Topic() string {
+	return canary.config.Topic
+}
+
+// Listen method starts reading canary messages from all partitions of
+// canary topic by given consumer. Messages produced after this call are
+// read. Consumer is closed together with canary.
+func (canary *Canary) Listen(consumer sarama.Consumer) error {
+	canary.consumer = consumer
+
+	partitions, err := consumer.Partitions(canary.config.Topic)
+	if err != nil {
+		return err
+	}
+
+	for _, partition := range partitions {
+		partitionConsumer, err := consumer.ConsumePartition(canary.config.Topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			return err
+		}
+		canary.partitions = append(canary.partitions, partitionConsumer)
+		go canary.receive(partitionConsumer)
+	}
+	return nil
+}
+
+// receive method passes canary messages read by partition consumer to
+// Received method until the partition consumer is closed
+func (canary *Canary) receive(partitionConsumer sarama.PartitionConsumer) {
+	for msg := range partitionConsumer.Messages() {
+		if IsCanaryMessage(msg) {
+			canary.Received(msg)
+		}
+	}
+}
+
+// IsCanaryMessage function checks if given message is canary message
+func IsCanaryMessage(msg *sarama.ConsumerMessage) bool {
+	for _, header := range msg.Headers {
+		if header != nil && string(header.Key) == CanaryHeader {
+			return true
+		}
+	}
+	return false
+}
+
+// canaryID function returns ID of canary message
+func canaryID(msg *sarama.ConsumerMessage) string {
+	for _, header := range msg.Headers {
+		if header != nil && string(header.Key) == CanaryHeader {
+			return string(header.Value)
+		}
+	}
+	return ""
+}
+
+// Send method produces new canary message
+func (canary *Canary) Send() error {
+	canary.mutex.Lock()
+	canary.sequence++
+	id := fmt.Sprintf("%s-%d", canary.instance, canary.sequence)
+	canary.mutex.Unlock()
+
+	sent := canary.clock.Now()
+
+	// payload can always be marshalled
+	payload, _ := json.Marshal(canaryPayload{Canary: id, Sent: sent})
+
+	_, _, err := canary.producer.SendMessage(&sarama.ProducerMessage{
+		Topic: canary.config.Topic,
+		Value: sarama.ByteEncoder(payload),
+		Headers: []sarama.RecordHeader{
+			recordHeader(CanaryHeader, id),
+		},
+	})
+	if err != nil {
+		log.Error().Err(err).Str(topicKey, canary.config.Topic).Msg(canarySendMessage)
+		return err
+	}
+
+	canary.mutex.Lock()
+	defer canary.mutex.Unlock()
+
+	canary.pending[id] = sent
+	canary.stats.Sent++
+	return nil
+}
+
+// Received method is to be called for each canary message read from canary
+// topic. Round trip latency is computed for canaries sent by this monitor,
+// canaries sent by other monitors are ignored.
+func (canary *Canary) Received(msg *sarama.ConsumerMessage) {
+	id := canaryID(msg)
+
+	canary.mutex.Lock()
+	defer canary.mutex.Unlock()
+
+	sent, found := canary.pending[id]
+	if !found {
+		return
+	}
+	delete(canary.pending, id)
+
+	now := canary.clock.Now()
+	latency := now.Sub(sent)
+
+	canary.stats.Received++
+	canary.stats.LastReceived = now
+	canary.stats.LastLatency = latency
+	if latency > canary.stats.MaxLatency {
+		canary.stats.MaxLatency = latency
+	}
+	canary.totalLatency += latency
+
+	log.Info().
+		Str(topicKey, msg.Topic).
+		Int32(partitionKey, msg.Partition).
+		Int64(offsetKey, msg.Offset).
+		Dur("latency", latency).
+		Msg(canaryReceivedMessage)
+
+	if canary.config.LatencyThreshold > 0 && latency > canary.config.LatencyThreshold {
+		canary.alerts.Raise(Alert{
+			Type:     AlertCanaryLatency,
+			Severity: SeverityWarning,
+			Topic:    msg.Topic,
+			Message:  fmt.Sprintf("Canary round-trip latency %v exceeds threshold %v", latency, canary.config.LatencyThreshold),
+			Details: map[string]interface{}{
+				"canary":    id,
+				"latency":   latency.String(),
+				"threshold": canary.config.LatencyThreshold.String(),
+			},
+		})
+	}
+}
+
+// CheckLost method finds canaries that have not been received in
+// configured timeout and reports them as lost
+func (canary *Canary) CheckLost() {
+	canary.mutex.Lock()
+	defer canary.mutex.Unlock()
+
+	now := canary.clock.Now()
+	for id, sent := range canary.pending {
+		if now.Sub(sent) < canary.config.Timeout {
+			continue
+		}
+		delete(canary.pending, id)
+		canary.stats.Lost++
+
+		canary.alerts.Raise(Alert{
+			Type:     AlertCanaryLost,
+			Severity: SeverityCritical,
+			Topic:    canary.config.Topic,
+			Message:  fmt.Sprintf("Canary message has not been consumed in %v", canary.config.Timeout),
+			Details: map[string]interface{}{
+				"canary": id,
+				"sent":   sent,
+			},
+		})
+	}
+}
+
+// Stats method returns actual canary statistic
+func (canary *Canary) Stats() CanaryStats {
+	canary.mutex.Lock()
+	defer canary.mutex.Unlock()
+
+	stats := canary.stats
+	stats.Pending = len(canary.pending)
+	if stats.Received > 0 {
+		stats.AverageLatency = canary.totalLatency / time.Duration(stats.Received)
+	}
+	return stats
+}
+
+// Run method periodically checks lost canaries and sends new canary
+// message. It blocks current thread.
+func (canary *Canary) Run() {
+	ticker := time.NewTicker(canary.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		canary.CheckLost()
+		// errors are logged by Send itself
+		_ = canary.Send()
+		<-ticker.C
+	}
+}
+
+// Close method closes consumer and producer used by canary
+func (canary *Canary) Close() error {
+	for _, partitionConsumer := range canary.partitions {
+		if err := partitionConsumer.Close(); err != nil {
+			log.Error().Err(err).Str(topicKey, canary.config.Topic).Msg(canaryCloseMessage)
+		}
+	}
+	if canary.consumer != nil {
+		if err := canary.consumer.Close(); err != nil {
+			log.Error().Err(err).Str(topicKey, canary.config.Topic).Msg(canaryCloseMessage)
+		}
+	}
+	return canary.producer.Close()
+}
@@ -0,0 +1,203 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Unit test definitions for functions and methods defined in source file
+// canary.go
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-kafka-monitor"
+)
+
+// consumedCanary function converts canary message produced by mocked
+// producer into consumed message
+func consumedCanary(produced *sarama.ProducerMessage) *sarama.ConsumerMessage {
+	value, _ := produced.Value.Encode()
+	msg := &sarama.ConsumerMessage{
+		Topic: produced.Topic,
+		Value: value,
+	}
+	for i := range produced.Headers {
+		msg.Headers = append(msg.Headers, &produced.Headers[i])
+	}
+	return msg
+}
+
+// newCanary function constructs canary producing messages into "canary"
+// topic by given producer
+func newCanary(t *testing.T, config main.CanaryConfiguration, producer sarama.SyncProducer, alerts *main.AlertManager, clock main.Clock) *main.Canary {
+	config.Topic = "canary"
+	canary, err := main.NewCanary(config, "topic", producer, alerts, clock)
+	assert.NoError(t, err)
+	return canary
+}
+
+// TestNewCanaryTopic checks that dedicated canary topic is required
+func TestNewCanaryTopic(t *testing.T) {
+	_, err := main.NewCanary(main.CanaryConfiguration{}, "topic", &syncProducerMock{}, nil, nil)
+	assert.EqualError(t, err, "canary topic is not configured")
+
+	_, err = main.NewCanary(main.CanaryConfiguration{Topic: "topic"}, "topic", &syncProducerMock{}, nil, nil)
+	assert.EqualError(t, err, "canary topic 'topic' can't be the monitored topic")
+}
+
+// TestCanaryRoundTrip checks that round-trip latency is measured for
+// received canary message
+func TestCanaryRoundTrip(t *testing.T) {
+	producer := &syncProducerMock{}
+	alerts := main.NewAlertManager(nil)
+	canary := newCanary(t, main.CanaryConfiguration{}, producer, alerts, nil)
+
+	assert.NoError(t, canary.Send())
+	assert.Len(t, producer.messages, 1)
+	assert.Equal(t, "canary", producer.messages[0].Topic)
+	assert.Equal(t, 1, canary.Stats().Pending)
+
+	msg := consumedCanary(producer.messages[0])
+	assert.True(t, main.IsCanaryMessage(msg))
+	canary.Received(msg)
+
+	stats := canary.Stats()
+	assert.Equal(t, uint64(1), stats.Sent)
+	assert.Equal(t, uint64(1), stats.Received)
+	assert.Equal(t, 0, stats.Pending)
+	assert.True(t, stats.LastLatency > 0)
+	assert.Equal(t, stats.LastLatency, stats.MaxLatency)
+	assert.Equal(t, stats.LastLatency, stats.AverageLatency)
+
+	// the same canary is not counted twice
+	canary.Received(msg)
+	assert.Equal(t, uint64(1), canary.Stats().Received)
+
+	assert.Empty(t, alerts.Recent())
+}
+
+// TestCanaryForeignMessage checks that canaries sent by other monitors are
+// ignored
+func TestCanaryForeignMessage(t *testing.T) {
+	canary := newCanary(t, main.CanaryConfiguration{}, &syncProducerMock{}, nil, nil)
+
+	canary.Received(&sarama.ConsumerMessage{
+		Headers: []*sarama.RecordHeader{
+			{Key: []byte(main.CanaryHeader), Value: []byte("other-monitor-1")},
+		},
+	})
+	assert.Equal(t, uint64(0), canary.Stats().Received)
+}
+
+// TestCanaryLatencyAlert checks that alert is raised when round-trip
+// latency exceeds configured threshold
+func TestCanaryLatencyAlert(t *testing.T) {
+	producer := &syncProducerMock{}
+	clock := newManualClock(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	alerts := main.NewAlertManager(clock)
+	canary := newCanary(t, main.CanaryConfiguration{LatencyThreshold: time.Second}, producer, alerts, clock)
+
+	assert.NoError(t, canary.Send())
+	clock.Advance(2 * time.Second)
+	canary.Received(consumedCanary(producer.messages[0]))
+
+	assert.Equal(t, []string{main.AlertCanaryLatency}, alertTypes(alerts))
+}
+
+// TestCanaryLost checks that canary not received in timeout is reported as
+// lost
+func TestCanaryLost(t *testing.T) {
+	producer := &syncProducerMock{}
+	clock := newManualClock(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	alerts := main.NewAlertManager(clock)
+	canary := newCanary(t, main.CanaryConfiguration{Timeout: time.Minute}, producer, alerts, clock)
+
+	assert.NoError(t, canary.Send())
+	canary.CheckLost()
+	clock.Advance(time.Minute)
+	canary.CheckLost()
+
+	stats := canary.Stats()
+	assert.Equal(t, uint64(1), stats.Lost)
+	assert.Equal(t, 0, stats.Pending)
+	assert.Equal(t, []string{main.AlertCanaryLost}, alertTypes(alerts))
+}
+
+// TestProcessMessageSkipsCanary checks that canary messages are not
+// validated by consumer
+func TestProcessMessageSkipsCanary(t *testing.T) {
+	producer := &syncProducerMock{}
+	canary := newCanary(t, main.CanaryConfiguration{}, producer, nil, nil)
+	assert.NoError(t, canary.Send())
+
+	decoders, err := main.NewDecoders([]main.TopicConfiguration{
+		{Name: "canary", Decoders: []string{"base64"}},
+	})
+	assert.NoError(t, err)
+
+	consumer := main.KafkaConsumer{Decoders: decoders}
+
+	// canary payload is not Base64-encoded, but it must not fail
+	assert.NoError(t, consumer.ProcessMessage(consumedCanary(producer.messages[0])))
+}
+
+// TestCanaryListen checks that canary messages are read back from all
+// partitions of canary topic without consumer group
+func TestCanaryListen(t *testing.T) {
+	producer := &syncProducerMock{}
+	canary := newCanary(t, main.CanaryConfiguration{}, producer, nil, nil)
+	assert.NoError(t, canary.Send())
+	assert.NoError(t, canary.Send())
+
+	consumer := mocks.NewConsumer(t, nil)
+	consumer.SetTopicMetadata(map[string][]int32{"canary": {0, 1}})
+	for partition, produced := range producer.messages {
+		msg := consumedCanary(produced)
+		consumer.ExpectConsumePartition("canary", int32(partition), sarama.OffsetNewest).
+			YieldMessage(msg)
+	}
+
+	assert.NoError(t, canary.Listen(consumer))
+	assert.Eventually(t, func() bool {
+		return canary.Stats().Received == 2
+	}, time.Second, time.Millisecond)
+
+	assert.NoError(t, canary.Close())
+}
+
+// TestCanaryTopicNotConsumed checks that canary topic is not consumed by
+// consumer group, so canaries are not split between monitor replicas
+func TestCanaryTopicNotConsumed(t *testing.T) {
+	group := newFakeConsumerGroup(t, "topic")
+
+	consumer := NewDummyConsumer()
+	consumer.ConsumerGroup = group
+	consumer.Canary = newCanary(t, main.CanaryConfiguration{}, &syncProducerMock{}, nil, nil)
+	done := serveInBackground(consumer)
+
+	session := group.Rebalance(0)
+	session.End()
+	session.Wait()
+
+	assert.Equal(t, []string{"topic"}, session.SubscribedTopics())
+
+	assert.NoError(t, consumer.Close())
+	waitForServe(t, done)
+}
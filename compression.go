@@ -0,0 +1,543 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains implementation of batch inspector. Record batch
+// metadata (compression codec, record format version) is not available in
+// messages returned by consumer group, so the inspector periodically
+// fetches the most recent batches from all partitions of consumed topics by
+// low-level fetch request and inspects them. Last inspected offset is
+// remembered for each partition, so every batch is inspected just once.
+// Ratio between compressed batch size and uncompressed payload size is
+// computed too. Compressed size is known exactly for legacy message sets
+// (format versions 0 and 1) and for batches without compression only; for
+// compressed record batches (format version 2) it is estimated by
+// compressing the payload by the same codec and reported separately.
+// Batches without compression and batches compressed by codec that is not
+// supported by consumers are flagged.
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	snappy "github.com/eapache/go-xerial-snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4"
+	"github.com/rs/zerolog/log"
+)
+
+// Alert types raised by batch inspector
+const (
+	AlertUncompressedBatches = "uncompressed_batches"
+	AlertUnsupportedCodec    = "unsupported_codec"
+)
+
+// Default batch inspector settings
+const (
+	defaultCompressionInterval = 5 * time.Minute
+	defaultCompressionMessages = 100
+	compressionFetchMaxBytes   = 1024 * 1024
+)
+
+// fetchRequestVersion is version of fetch request used by inspector. It
+// is the oldest version that returns record batches (format version 2)
+// without down-conversion.
+const fetchRequestVersion = 4
+
+// TopicCompressionStats contains statistic about batches inspected in one
+// topic
+type TopicCompressionStats struct {
+	Topic          string            `json:"topic"`
+	Batches        uint64            `json:"batches"`
+	Codecs         map[string]uint64 `json:"codecs"`
+	FormatVersions map[string]uint64 `json:"format_versions"`
+	// PayloadBytes and CompressedBytes are sizes of batches with exactly
+	// known compressed size
+	PayloadBytes    uint64 `json:"payload_bytes"`
+	CompressedBytes uint64 `json:"compressed_bytes"`
+	// CompressionRatio is ratio between compressed and uncompressed size,
+	// i.e. lower value means better compression
+	CompressionRatio float64 `json:"compression_ratio"`
+	// EstimatedPayloadBytes and EstimatedCompressedBytes are sizes of
+	// compressed record batches, compressed size is estimated by
+	// compressing the payload again
+	EstimatedPayloadBytes     uint64    `json:"estimated_payload_bytes"`
+	EstimatedCompressedBytes  uint64    `json:"estimated_compressed_bytes"`
+	EstimatedCompressionRatio float64   `json:"estimated_compression_ratio"`
+	Uncompressed              uint64    `json:"uncompressed"`
+	Unsupported               uint64    `json:"unsupported"`
+	LastInspected             time.Time `json:"last_inspected"`
+}
+
+// batchInfo contains metadata of one inspected batch
+type batchInfo struct {
+	codec           sarama.CompressionCodec
+	version         int8
+	producerID      int64
+	payloadBytes    int
+	compressedBytes int
+	// estimated is set when compressed size is not known exactly
+	estimated  bool
+	lastOffset int64
+}
+
+// BatchInspector periodically inspects record batches of consumed topics
+type BatchInspector struct {
+	mutex     sync.Mutex
+	config    CompressionConfiguration
+	brokerCfg BrokerConfiguration
+	alerts    *AlertManager
+	clock     Clock
+	supported map[string]bool
+	stats     map[string]*TopicCompressionStats
+	// offsets contains offset of the next batch to be inspected in each
+	// partition
+	offsets map[partitionID]int64
+}
+
+// NewBatchInspector constructs new batch inspector. Codec names are
+// checked against names known by sarama.
+func NewBatchInspector(config CompressionConfiguration, brokerCfg BrokerConfiguration, alerts *AlertManager, clock Clock) (*BatchInspector, error) {
+	if config.Interval <= 0 {
+		config.Interval = defaultCompressionInterval
+	}
+	if config.Messages <= 0 {
+		config.Messages = defaultCompressionMessages
+	}
+
+	known := map[string]bool{}
+	for _, codec := range []sarama.CompressionCodec{
+		sarama.CompressionNone, sarama.CompressionGZIP, sarama.CompressionSnappy,
+		sarama.CompressionLZ4, sarama.CompressionZSTD,
+	} {
+		known[codec.String()] = true
+	}
+
+	// all codecs are supported when the list is not configured
+	var supported map[string]bool
+	if len(config.SupportedCodecs) > 0 {
+		supported = make(map[string]bool, len(config.SupportedCodecs))
+		for _, codec := range config.SupportedCodecs {
+			if !known[codec] {
+				return nil, fmt.Errorf("unknown compression codec '%s'", codec)
+			}
+			supported[codec] = true
+		}
+	}
+
+	return &BatchInspector{
+		config:    config,
+		brokerCfg: brokerCfg,
+		alerts:    alerts,
+		clock:     clockOrDefault(clock),
+		supported: supported,
+		stats:     make(map[string]*TopicCompressionStats),
+		offsets:   make(map[partitionID]int64),
+	}, nil
+}
+
+// compressPayload function compresses payload by given codec at default
+// compression level. It is used to estimate size of compressed record
+// batch.
+func compressPayload(codec sarama.CompressionCodec, payload []byte) ([]byte, error) {
+	switch codec {
+	case sarama.CompressionNone:
+		return payload, nil
+	case sarama.CompressionGZIP:
+		var buffer bytes.Buffer
+		writer := gzip.NewWriter(&buffer)
+		if _, err := writer.Write(payload); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		return buffer.Bytes(), nil
+	case sarama.CompressionSnappy:
+		return snappy.Encode(payload), nil
+	case sarama.CompressionLZ4:
+		var buffer bytes.Buffer
+		writer := lz4.NewWriter(&buffer)
+		if _, err := writer.Write(payload); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		return buffer.Bytes(), nil
+	case sarama.CompressionZSTD:
+		encoder, err := zstd.NewWriter(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer encoder.Close()
+		return encoder.EncodeAll(payload, nil), nil
+	default:
+		return nil, fmt.Errorf("unknown compression codec %d", codec)
+	}
+}
+
+// inspectRecordBatch function returns metadata of record batch (format
+// version 2). Control batches (transaction markers) are skipped.
+func inspectRecordBatch(batch *sarama.RecordBatch) (batchInfo, bool) {
+	if batch.Control || len(batch.Records) == 0 {
+		return batchInfo{}, false
+	}
+
+	var payload bytes.Buffer
+	for _, record := range batch.Records {
+		payload.Write(record.Key)
+		payload.Write(record.Value)
+	}
+
+	info := batchInfo{
+		codec:           batch.Codec,
+		version:         batch.Version,
+		producerID:      batch.ProducerID,
+		payloadBytes:    payload.Len(),
+		compressedBytes: payload.Len(),
+		estimated:       batch.Codec != sarama.CompressionNone,
+		lastOffset:      batch.FirstOffset + int64(batch.LastOffsetDelta),
+	}
+	if info.estimated {
+		compressed, err := compressPayload(batch.Codec, payload.Bytes())
+		if err == nil {
+			info.compressedBytes = len(compressed)
+		}
+	}
+	return info, true
+}
+
+// inspectMessageSet function returns metadata of all batches in legacy
+// message set (format versions 0 and 1). Each compressed message is a
+// wrapper of nested message set, so its size is size of compressed batch
+// and its offset is offset of the last nested message.
+func inspectMessageSet(set *sarama.MessageSet) []batchInfo {
+	var infos []batchInfo
+	for _, block := range set.Messages {
+		msg := block.Msg
+		if msg == nil {
+			continue
+		}
+
+		info := batchInfo{
+			codec:      msg.Codec,
+			version:    msg.Version,
+			producerID: -1,
+			lastOffset: block.Offset,
+		}
+		if msg.Codec == sarama.CompressionNone || msg.Set == nil {
+			info.payloadBytes = len(msg.Key) + len(msg.Value)
+			info.compressedBytes = info.payloadBytes
+		} else {
+			info.compressedBytes = len(msg.Value)
+			for _, inner := range msg.Set.Messages {
+				if inner.Msg != nil {
+					info.payloadBytes += len(inner.Msg.Key) + len(inner.Msg.Value)
+				}
+			}
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// inspectRecords function returns metadata of all batches contained in
+// records returned by fetch request
+func inspectRecords(records []*sarama.Records) []batchInfo {
+	var infos []batchInfo
+	for _, record := range records {
+		if record == nil {
+			continue
+		}
+		if record.RecordBatch != nil {
+			if info, ok := inspectRecordBatch(record.RecordBatch); ok {
+				infos = append(infos, info)
+			}
+		}
+		if record.MsgSet != nil {
+			infos = append(infos, inspectMessageSet(record.MsgSet)...)
+		}
+	}
+	return infos
+}
+
+// Record method inspects batches fetched from partitions of topic, updates
+// statistic of topic, and raises alerts for batches without compression
+// and batches compressed by unsupported codec. Batches that have already
+// been inspected are skipped.
+func (inspector *BatchInspector) Record(topic string, partitions map[int32][]*sarama.Records) {
+	inspector.mutex.Lock()
+	defer inspector.mutex.Unlock()
+
+	var batches []batchInfo
+	for partition, records := range partitions {
+		id := partitionID{topic, partition}
+		next := inspector.offsets[id]
+		for _, batch := range inspectRecords(records) {
+			if batch.lastOffset < next {
+				continue
+			}
+			batches = append(batches, batch)
+			if batch.lastOffset >= inspector.offsets[id] {
+				inspector.offsets[id] = batch.lastOffset + 1
+			}
+		}
+	}
+
+	stats, found := inspector.stats[topic]
+	if !found {
+		stats = &TopicCompressionStats{
+			Topic:          topic,
+			Codecs:         make(map[string]uint64),
+			FormatVersions: make(map[string]uint64),
+		}
+		inspector.stats[topic] = stats
+	}
+	stats.LastInspected = inspector.clock.Now()
+
+	var uncompressed, unsupported int
+	unsupportedCodecs := map[string]bool{}
+	producers := map[int64]bool{}
+
+	for _, batch := range batches {
+		codec := batch.codec.String()
+		stats.Batches++
+		stats.Codecs[codec]++
+		stats.FormatVersions[fmt.Sprintf("v%d", batch.version)]++
+		if batch.estimated {
+			stats.EstimatedPayloadBytes += uint64(batch.payloadBytes)
+			stats.EstimatedCompressedBytes += uint64(batch.compressedBytes)
+		} else {
+			stats.PayloadBytes += uint64(batch.payloadBytes)
+			stats.CompressedBytes += uint64(batch.compressedBytes)
+		}
+
+		flagged := false
+		if inspector.config.RequireCompression && batch.codec == sarama.CompressionNone {
+			stats.Uncompressed++
+			uncompressed++
+			flagged = true
+		}
+		if inspector.supported != nil && !inspector.supported[codec] {
+			stats.Unsupported++
+			unsupported++
+			unsupportedCodecs[codec] = true
+			flagged = true
+		}
+		if flagged && batch.producerID >= 0 {
+			producers[batch.producerID] = true
+		}
+	}
+
+	if stats.PayloadBytes > 0 {
+		stats.CompressionRatio = float64(stats.CompressedBytes) / float64(stats.PayloadBytes)
+	}
+	if stats.EstimatedPayloadBytes > 0 {
+		stats.EstimatedCompressionRatio = float64(stats.EstimatedCompressedBytes) / float64(stats.EstimatedPayloadBytes)
+	}
+
+	// producer IDs are assigned to idempotent producers only
+	producerIDs := make([]int64, 0, len(producers))
+	for id := range producers {
+		producerIDs = append(producerIDs, id)
+	}
+	sort.Slice(producerIDs, func(i, j int) bool { return producerIDs[i] < producerIDs[j] })
+
+	if uncompressed > 0 {
+		inspector.alerts.Raise(Alert{
+			Type:     AlertUncompressedBatches,
+			Severity: SeverityWarning,
+			Topic:    topic,
+			Message:  fmt.Sprintf("%d of %d inspected batches are not compressed", uncompressed, len(batches)),
+			Details: map[string]interface{}{
+				"batches":   uncompressed,
+				"producers": producerIDs,
+			},
+		})
+	}
+	if unsupported > 0 {
+		codecs := make([]string, 0, len(unsupportedCodecs))
+		for codec := range unsupportedCodecs {
+			codecs = append(codecs, codec)
+		}
+		sort.Strings(codecs)
+
+		inspector.alerts.Raise(Alert{
+			Type:     AlertUnsupportedCodec,
+			Severity: SeverityCritical,
+			Topic:    topic,
+			Message:  fmt.Sprintf("%d of %d inspected batches are compressed by unsupported codec %s", unsupported, len(batches), strings.Join(codecs, ", ")),
+			Details: map[string]interface{}{
+				"batches":   unsupported,
+				"codecs":    codecs,
+				"producers": producerIDs,
+			},
+		})
+	}
+}
+
+// Inspect method fetches the most recent batches not inspected yet from all
+// partitions of given topics and inspects them
+func (inspector *BatchInspector) Inspect(topics []string) error {
+	// record batches are returned without down-conversion by newer
+	// protocol versions only
+	saramaConfig, err := newSaramaConfig(inspector.brokerCfg)
+	if err != nil {
+		return err
+	}
+	if !saramaConfig.Version.IsAtLeast(sarama.V0_11_0_0) {
+		saramaConfig.Version = sarama.V0_11_0_0
+	}
+
+	client, err := sarama.NewClient([]string{inspector.brokerCfg.Address}, saramaConfig)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := client.Close()
+		if err != nil {
+			log.Error().Err(err).Msg(closingBrokerConnectionMessage)
+		}
+	}()
+
+	for _, topic := range topics {
+		records, err := inspector.fetchTopic(client, topic)
+		if err != nil {
+			log.Error().Err(err).Str(topicKey, topic).Msg("Unable to inspect record batches")
+			continue
+		}
+		inspector.Record(topic, records)
+	}
+	return nil
+}
+
+// fetchTopic method fetches the most recent batches not inspected yet from
+// all partitions of topic
+func (inspector *BatchInspector) fetchTopic(client sarama.Client, topic string) (map[int32][]*sarama.Records, error) {
+	partitions, err := client.Partitions(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make(map[int32][]*sarama.Records)
+	for _, partition := range partitions {
+		oldest, err := client.GetOffset(topic, partition, sarama.OffsetOldest)
+		if err != nil {
+			return nil, err
+		}
+		newest, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			return nil, err
+		}
+		if newest <= oldest {
+			continue
+		}
+		start := newest - int64(inspector.config.Messages)
+		if start < oldest {
+			start = oldest
+		}
+		if next := inspector.nextOffset(topic, partition); start < next {
+			start = next
+		}
+		if start >= newest {
+			continue
+		}
+
+		leader, err := client.Leader(topic, partition)
+		if err != nil {
+			return nil, err
+		}
+
+		request := &sarama.FetchRequest{
+			Version:     fetchRequestVersion,
+			MaxWaitTime: int32(partitionReadTimeout / time.Millisecond),
+			MinBytes:    1,
+			MaxBytes:    compressionFetchMaxBytes,
+		}
+		request.AddBlock(topic, partition, start, compressionFetchMaxBytes)
+
+		response, err := leader.Fetch(request)
+		if err != nil {
+			return nil, err
+		}
+		block := response.GetBlock(topic, partition)
+		if block == nil {
+			continue
+		}
+		if block.Err != sarama.ErrNoError {
+			return nil, block.Err
+		}
+		records[partition] = block.RecordsSet
+	}
+	return records, nil
+}
+
+// nextOffset method returns offset of the next batch to be inspected in
+// given partition
+func (inspector *BatchInspector) nextOffset(topic string, partition int32) int64 {
+	inspector.mutex.Lock()
+	defer inspector.mutex.Unlock()
+
+	return inspector.offsets[partitionID{topic, partition}]
+}
+
+// Stats method returns compression statistic for all inspected topics
+func (inspector *BatchInspector) Stats() []TopicCompressionStats {
+	inspector.mutex.Lock()
+	defer inspector.mutex.Unlock()
+
+	stats := make([]TopicCompressionStats, 0, len(inspector.stats))
+	for _, topicStats := range inspector.stats {
+		copied := *topicStats
+		copied.Codecs = make(map[string]uint64, len(topicStats.Codecs))
+		for codec, count := range topicStats.Codecs {
+			copied.Codecs[codec] = count
+		}
+		copied.FormatVersions = make(map[string]uint64, len(topicStats.FormatVersions))
+		for version, count := range topicStats.FormatVersions {
+			copied.FormatVersions[version] = count
+		}
+		stats = append(stats, copied)
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Topic < stats[j].Topic
+	})
+	return stats
+}
+
+// Run method periodically inspects batches of given topics. It blocks
+// current thread.
+func (inspector *BatchInspector) Run(topics []string) {
+	ticker := time.NewTicker(inspector.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		err := inspector.Inspect(topics)
+		if err != nil {
+			log.Error().Err(err).Msg("Unable to inspect record batches")
+		}
+		<-ticker.C
+	}
+}
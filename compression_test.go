@@ -0,0 +1,227 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Unit test definitions for functions and methods defined in source file
+// compression.go
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-kafka-monitor"
+)
+
+// compressiblePayload is payload that can be compressed well by all codecs
+var compressiblePayload = bytes.Repeat([]byte(`{"foo": "bar"}`), 100)
+
+// recordBatch function constructs records containing one record batch
+// (format version 2) compressed by given codec
+func recordBatch(codec sarama.CompressionCodec, producerID int64) *sarama.Records {
+	return &sarama.Records{
+		RecordBatch: &sarama.RecordBatch{
+			Version:    2,
+			Codec:      codec,
+			ProducerID: producerID,
+			Records: []*sarama.Record{
+				{Key: []byte("key"), Value: compressiblePayload},
+			},
+		},
+	}
+}
+
+// newTestBatchInspector function constructs batch inspector with given
+// list of supported codecs
+func newTestBatchInspector(t *testing.T, requireCompression bool, codecs []string, alerts *main.AlertManager) *main.BatchInspector {
+	inspector, err := main.NewBatchInspector(main.CompressionConfiguration{
+		RequireCompression: requireCompression,
+		SupportedCodecs:    codecs,
+	}, main.BrokerConfiguration{}, alerts, nil)
+	assert.NoError(t, err)
+	return inspector
+}
+
+// TestBatchInspectorRecordBatches checks that codecs, record format
+// versions, and compression ratio are reported for record batches
+func TestBatchInspectorRecordBatches(t *testing.T) {
+	alerts := main.NewAlertManager(nil)
+	inspector := newTestBatchInspector(t, false, nil, alerts)
+
+	inspector.Record("topic", map[int32][]*sarama.Records{0: {
+		recordBatch(sarama.CompressionGZIP, -1),
+		recordBatch(sarama.CompressionSnappy, -1),
+		recordBatch(sarama.CompressionLZ4, -1),
+		recordBatch(sarama.CompressionZSTD, -1),
+		// control batches are not inspected
+		{RecordBatch: &sarama.RecordBatch{Version: 2, Control: true}},
+	}})
+
+	stats := inspector.Stats()
+	assert.Len(t, stats, 1)
+	assert.Equal(t, uint64(4), stats[0].Batches)
+	assert.Equal(t, map[string]uint64{"gzip": 1, "snappy": 1, "lz4": 1, "zstd": 1}, stats[0].Codecs)
+	assert.Equal(t, map[string]uint64{"v2": 4}, stats[0].FormatVersions)
+
+	// compressed size of record batches is just estimated
+	assert.Equal(t, uint64(0), stats[0].PayloadBytes)
+	assert.Equal(t, 0.0, stats[0].CompressionRatio)
+	assert.Equal(t, uint64(4*(3+len(compressiblePayload))), stats[0].EstimatedPayloadBytes)
+	assert.Less(t, stats[0].EstimatedCompressionRatio, 0.5)
+	assert.Empty(t, alertTypes(alerts))
+}
+
+// TestBatchInspectorLegacyMessageSet checks that compressed size of legacy
+// message set is taken from wrapper message
+func TestBatchInspectorLegacyMessageSet(t *testing.T) {
+	inspector := newTestBatchInspector(t, false, nil, nil)
+
+	inspector.Record("topic", map[int32][]*sarama.Records{0: {{
+		MsgSet: &sarama.MessageSet{
+			Messages: []*sarama.MessageBlock{
+				{Msg: &sarama.Message{
+					Version: 1,
+					Codec:   sarama.CompressionGZIP,
+					Value:   make([]byte, 50),
+					Set: &sarama.MessageSet{
+						Messages: []*sarama.MessageBlock{
+							{Msg: &sarama.Message{Value: make([]byte, 100)}},
+							{Msg: &sarama.Message{Value: make([]byte, 100)}},
+						},
+					},
+				}},
+			},
+		},
+	}}})
+
+	stats := inspector.Stats()
+	assert.Equal(t, map[string]uint64{"v1": 1}, stats[0].FormatVersions)
+	assert.Equal(t, uint64(200), stats[0].PayloadBytes)
+	assert.Equal(t, uint64(50), stats[0].CompressedBytes)
+	assert.Equal(t, 0.25, stats[0].CompressionRatio)
+}
+
+// TestBatchInspectorFlags checks that batches without compression and
+// batches compressed by unsupported codec are flagged
+func TestBatchInspectorFlags(t *testing.T) {
+	alerts := main.NewAlertManager(nil)
+	inspector := newTestBatchInspector(t, true, []string{"none", "gzip"}, alerts)
+
+	inspector.Record("topic", map[int32][]*sarama.Records{0: {
+		recordBatch(sarama.CompressionGZIP, 1),
+		recordBatch(sarama.CompressionNone, 2),
+		recordBatch(sarama.CompressionZSTD, 3),
+	}})
+
+	stats := inspector.Stats()
+	assert.Equal(t, uint64(1), stats[0].Uncompressed)
+	assert.Equal(t, uint64(1), stats[0].Unsupported)
+	assert.Equal(t, []string{main.AlertUncompressedBatches, main.AlertUnsupportedCodec}, alertTypes(alerts))
+
+	recent := alerts.Recent()
+	assert.Equal(t, []int64{2, 3}, recent[0].Details["producers"])
+	assert.Equal(t, []string{"zstd"}, recent[1].Details["codecs"])
+}
+
+// TestBatchInspectorSkipsInspectedBatches checks that batches that have
+// already been inspected are not counted and alerted again
+func TestBatchInspectorSkipsInspectedBatches(t *testing.T) {
+	alerts := main.NewAlertManager(nil)
+	inspector := newTestBatchInspector(t, true, nil, alerts)
+
+	first := recordBatch(sarama.CompressionNone, 1)
+	first.RecordBatch.FirstOffset = 10
+	second := recordBatch(sarama.CompressionNone, 1)
+	second.RecordBatch.FirstOffset = 11
+
+	inspector.Record("topic", map[int32][]*sarama.Records{0: {first}, 1: {first}})
+	// no new batches in the topic
+	inspector.Record("topic", map[int32][]*sarama.Records{0: {first}, 1: {first}})
+	// one new batch in partition 0
+	inspector.Record("topic", map[int32][]*sarama.Records{0: {first, second}})
+
+	stats := inspector.Stats()
+	assert.Equal(t, uint64(3), stats[0].Batches)
+	assert.Equal(t, uint64(3), stats[0].Uncompressed)
+	assert.Equal(t, []string{main.AlertUncompressedBatches, main.AlertUncompressedBatches}, alertTypes(alerts))
+}
+
+// TestNewBatchInspectorUnknownCodec checks that unknown codec is refused
+func TestNewBatchInspectorUnknownCodec(t *testing.T) {
+	_, err := main.NewBatchInspector(main.CompressionConfiguration{
+		SupportedCodecs: []string{"gzip", "brotli"},
+	}, main.BrokerConfiguration{}, nil, nil)
+	assert.EqualError(t, err, "unknown compression codec 'brotli'")
+}
+
+// TestBatchInspectorInspect checks that batches are fetched from broker
+func TestBatchInspectorInspect(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader(testTopic, 0, broker.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset(testTopic, 0, sarama.OffsetOldest, 0).
+			SetOffset(testTopic, 0, sarama.OffsetNewest, 2),
+		"FetchRequest": sarama.NewMockFetchResponse(t, 2).
+			SetMessage(testTopic, 0, 0, sarama.StringEncoder(`{"a":1}`)).
+			SetMessage(testTopic, 0, 1, sarama.StringEncoder(`{"a":2}`)).
+			SetHighWaterMark(testTopic, 0, 2),
+	})
+
+	config := configurationForMockBroker(broker, testGroup)
+	clock := newManualClock(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	inspector, err := main.NewBatchInspector(main.CompressionConfiguration{}, main.GetBrokerConfiguration(&config), nil, clock)
+	assert.NoError(t, err)
+
+	assert.NoError(t, inspector.Inspect([]string{testTopic}))
+
+	stats := inspector.Stats()
+	assert.Len(t, stats, 1)
+	assert.Equal(t, testTopic, stats[0].Topic)
+	assert.Equal(t, uint64(2), stats[0].Codecs["none"])
+	assert.Equal(t, clock.Now(), stats[0].LastInspected)
+
+	// batches are inspected just once
+	assert.NoError(t, inspector.Inspect([]string{testTopic}))
+	assert.Equal(t, uint64(2), inspector.Stats()[0].Batches)
+}
+
+// TestCompressionEndpoint checks the compression endpoint
+func TestCompressionEndpoint(t *testing.T) {
+	consumer := NewDummyConsumer()
+	server := main.NewHTTPServer(main.ServerConfiguration{}, consumer)
+
+	response := performRequest(server, "/api/v1/compression")
+	assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+
+	consumer.BatchInspector = newTestBatchInspector(t, false, nil, nil)
+	consumer.BatchInspector.Record("topic", map[int32][]*sarama.Records{
+		0: {recordBatch(sarama.CompressionGZIP, -1)},
+	})
+
+	response = performRequest(server, "/api/v1/compression")
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Body.String(), `"codecs":{"gzip":1}`)
+}
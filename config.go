@@ -34,11 +34,156 @@ package main
 // topic = "ccx.ocp.results"
 // group = "aggregator"
 // enabled = true
+// version = "auto"
+// rebalance_strategy = "sticky"
+// static_membership = true
+// instance_id = ""
+// session_timeout = "5m"
 //
 // [logging]
 // debug = true
 // log_level = ""
 //
+// [server]
+// address = ":8080"
+// api_prefix = "/api/v1/"
+//
+// [profiling]
+// enabled = true
+// summary_interval = "10m"
+//
+// [drift]
+// enabled = true
+// warmup_messages = 1000
+// warmup_period = "1h"
+// baseline_file = "baseline.json"
+// alert_interval = "10m"
+//
+// [archive]
+// enabled = true
+// directory = "archive"
+// sampling = "nth"
+// every_nth = 100
+// max_file_size = 10485760
+// max_total_size = 104857600
+// max_age = "24h"
+// cleanup_interval = "1m"
+//
+// [dead_letter]
+// enabled = true
+// sink = "file"
+// file = "dead_letters.jsonl"
+// topic = "insights.monitor.dlq"
+//
+// [canary]
+// enabled = true
+// topic = "insights.monitor.canary"
+// interval = "1m"
+// timeout = "5m"
+// latency_threshold = "30s"
+//
+// [pipeline]
+// stages = ["size", "decode", "filter", "validate", "dedup", "profile", "drift", "correlate", "archive", "alert", "log"]
+//
+// [correlation]
+// enabled = true
+// stages = ["platform.upload.ccx", "ccx.ocp.results"]
+// timeout = "10m"
+// max_tracked = 100000
+//
+// [sequence]
+// enabled = true
+// dedup_window = "1h"
+// dedup_max_keys = 100000
+//
+// [traffic]
+// enabled = true
+// interval = "1m"
+// skew_ratio = 2.0
+// top_keys = 10
+// sketch_size = 100
+//
+// [size]
+// enabled = true
+// window = 1000
+// max_bytes = 1048576
+// sigma = 4.0
+// min_samples = 100
+// broker_limit_ratio = 0.9
+// alert_interval = "1m"
+//
+// [compression]
+// enabled = true
+// interval = "5m"
+// messages = 100
+// require_compression = true
+// supported_codecs = ["gzip", "snappy"]
+//
+// [history]
+// enabled = true
+// directory = "/tmp/history"
+// interval = "1m"
+// retention = "168h"
+//
+// [report]
+// enabled = true
+// interval = "24h"
+// directory = "/tmp/reports"
+// formats = ["markdown", "json"]
+// webhook = "https://hooks.example.com/kafka-monitor"
+// top_messages = 5
+// top_errors = 5
+// quiet_period = "10m"
+// latency_samples = 10000
+//
+// [dashboard]
+// enabled = true
+// interval = "5s"
+// points = 60
+// messages = 20
+// alert_window = "15m"
+//
+// [redaction]
+// enabled = true
+// json_paths = ["AccountNumber", "OrgID", "Report.credentials.*"]
+// patterns = ['[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}', 'Bearer [A-Za-z0-9._~+/-]+=*']
+// hash_keys = true
+// hash_salt = "salt"
+// mask = "[REDACTED]"
+//
+// [sessions]
+// enabled = true
+// history = 100
+// storm_window = "10m"
+// storm_threshold = 5
+//
+// [audit]
+// enabled = true
+// interval = "1h"
+//
+// [[topics]]
+// name = "ccx.ocp.results"
+// decoders = ["base64", "gzip", "json"]
+// stages = ["decode", "validate", "log"]
+// filter_field = "Metadata.type"
+// filter_values = ["result"]
+// required_fields = ["OrgID", "ClusterName", "Report"]
+// alert_field = "Metadata.status"
+// alert_values = ["error"]
+// alert_interval = "1m"
+// cardinality_fields = ["OrgID", "ClusterName"]
+// correlation_id = "json:RequestId"
+// dedup_key = "payload"
+// ignore_offset_gaps = false
+//
+// [topics.expected]
+// partitions = 3
+// replication_factor = 3
+// retention_ms = 604800000
+// cleanup_policy = "delete"
+// min_insync_replicas = 2
+// max_message_bytes = 1048588
+//
 // Environment variables that can be used to override configuration file settings:
 // TBD
 
@@ -47,6 +192,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 
@@ -65,9 +211,28 @@ const (
 // ConfigStruct is a structure holding the whole notification service
 // configuration
 type ConfigStruct struct {
-	Broker  BrokerConfiguration  `mapstructure:"broker"  toml:"broker"`
-	Logging LoggingConfiguration `mapstructure:"logging" toml:"logging"`
-	Output  OutputConfiguration  `mapstructure:"output"  toml:"output"`
+	Broker      BrokerConfiguration      `mapstructure:"broker"      toml:"broker"`
+	Logging     LoggingConfiguration     `mapstructure:"logging"     toml:"logging"`
+	Output      OutputConfiguration      `mapstructure:"output"      toml:"output"`
+	Server      ServerConfiguration      `mapstructure:"server"      toml:"server"`
+	Profiling   ProfilingConfiguration   `mapstructure:"profiling"   toml:"profiling"`
+	Drift       DriftConfiguration       `mapstructure:"drift"       toml:"drift"`
+	Archive     ArchiveConfiguration     `mapstructure:"archive"     toml:"archive"`
+	DeadLetter  DeadLetterConfiguration  `mapstructure:"dead_letter" toml:"dead_letter"`
+	Canary      CanaryConfiguration      `mapstructure:"canary"      toml:"canary"`
+	Pipeline    PipelineConfiguration    `mapstructure:"pipeline"    toml:"pipeline"`
+	Correlation CorrelationConfiguration `mapstructure:"correlation" toml:"correlation"`
+	Sequence    SequenceConfiguration    `mapstructure:"sequence"    toml:"sequence"`
+	Traffic     TrafficConfiguration     `mapstructure:"traffic"     toml:"traffic"`
+	Size        SizeConfiguration        `mapstructure:"size"        toml:"size"`
+	Compression CompressionConfiguration `mapstructure:"compression" toml:"compression"`
+	History     HistoryConfiguration     `mapstructure:"history"     toml:"history"`
+	Report      ReportConfiguration      `mapstructure:"report"      toml:"report"`
+	Dashboard   DashboardConfiguration   `mapstructure:"dashboard"   toml:"dashboard"`
+	Redaction   RedactionConfiguration   `mapstructure:"redaction"   toml:"redaction"`
+	Sessions    SessionConfiguration     `mapstructure:"sessions"    toml:"sessions"`
+	Audit       AuditConfiguration       `mapstructure:"audit"       toml:"audit"`
+	Topics      []TopicConfiguration     `mapstructure:"topics"      toml:"topics"`
 }
 
 // LoggingConfiguration represents configuration for logging in general
@@ -96,6 +261,412 @@ type BrokerConfiguration struct {
 	Group string `mapstructure:"group" toml:"group"`
 	// Enabled is set to true if Kafka consumer is to be enabled
 	Enabled bool `mapstructure:"enabled" toml:"enabled"`
+	// Version is Kafka protocol version used to talk to broker, for
+	// example "2.8.0". Version is detected by ApiVersions request when set
+	// to "auto". Version 0.10.2.0 is used when it is not set
+	Version string `mapstructure:"version" toml:"version"`
+	// RebalanceStrategy is strategy used to assign partitions to consumer
+	// group members. Possible values are:
+	// "range" (default)
+	// "roundrobin"
+	// "sticky"
+	RebalanceStrategy string `mapstructure:"rebalance_strategy" toml:"rebalance_strategy"`
+	// StaticMembership is set to true if consumer is to join consumer
+	// group as static member, so restarted consumer gets its partitions
+	// back without rebalance
+	StaticMembership bool `mapstructure:"static_membership" toml:"static_membership"`
+	// InstanceID is group instance ID used by static member. Pod name
+	// (POD_NAME environment variable or hostname) is used when it is not set
+	InstanceID string `mapstructure:"instance_id" toml:"instance_id"`
+	// SessionTimeout is timeout after which consumer group member is
+	// considered dead and its partitions are reassigned. For static
+	// membership it needs to exceed time needed to restart the pod,
+	// otherwise rebalance is triggered anyway. Sarama default (10s) is used
+	// when it is not set. It is bounded by group.max.session.timeout.ms
+	// broker setting
+	SessionTimeout time.Duration `mapstructure:"session_timeout" toml:"session_timeout"`
+}
+
+// TopicConfiguration represents topic-specific configuration. Each topic
+// is configured in its own [[topics]] table.
+type TopicConfiguration struct {
+	// Name is name of Kafka topic the configuration belongs to
+	Name string `mapstructure:"name" toml:"name"`
+	// Decoders is ordered list of decoders to be applied on message
+	// payload. Possible values are:
+	// "base64"
+	// "gzip"
+	// "json"
+	// "avro"
+	// "protobuf"
+	Decoders []string `mapstructure:"decoders" toml:"decoders"`
+	// AvroSchema is path to Avro schema file used by "avro" decoder
+	AvroSchema string `mapstructure:"avro_schema" toml:"avro_schema"`
+	// ProtobufDescriptor is path to file descriptor set (as produced by
+	// protoc --include_imports --descriptor_set_out) used by "protobuf"
+	// decoder
+	ProtobufDescriptor string `mapstructure:"protobuf_descriptor" toml:"protobuf_descriptor"`
+	// ProtobufMessage is fully qualified name of Protobuf message type
+	// stored in the topic
+	ProtobufMessage string `mapstructure:"protobuf_message" toml:"protobuf_message"`
+	// CardinalityFields is list of fields (in dot notation) whose
+	// cardinality is to be estimated by profiler
+	CardinalityFields []string `mapstructure:"cardinality_fields" toml:"cardinality_fields"`
+	// Stages is ordered list of message processing stages used for the
+	// topic. Stages configured in [pipeline] section are used when it is
+	// not set
+	Stages []string `mapstructure:"stages" toml:"stages"`
+	// FilterField is field (in dot notation) checked by "filter" stage
+	FilterField string `mapstructure:"filter_field" toml:"filter_field"`
+	// FilterValues is list of values of filter field that are accepted by
+	// "filter" stage, other messages are dropped
+	FilterValues []string `mapstructure:"filter_values" toml:"filter_values"`
+	// RequiredFields is list of fields (in dot notation) that need to be
+	// present in each message, checked by "validate" stage
+	RequiredFields []string `mapstructure:"required_fields" toml:"required_fields"`
+	// AlertField is field (in dot notation) checked by "alert" stage
+	AlertField string `mapstructure:"alert_field" toml:"alert_field"`
+	// AlertValues is list of values of alert field that trigger alert
+	// raised by "alert" stage
+	AlertValues []string `mapstructure:"alert_values" toml:"alert_values"`
+	// AlertInterval is the minimal time between two alerts raised by
+	// "alert" stage for the same value. 1 minute is used when it is not set
+	AlertInterval time.Duration `mapstructure:"alert_interval" toml:"alert_interval"`
+	// CorrelationID is rule used to extract correlation ID from messages.
+	// Possible values are "key", "header:<name>", "json:<path>", and
+	// "payload"
+	CorrelationID string `mapstructure:"correlation_id" toml:"correlation_id"`
+	// DedupKey is rule used to extract deduplication key from messages.
+	// Possible values are the same as for CorrelationID. Duplicates are
+	// not detected for topic when the rule is not set
+	DedupKey string `mapstructure:"dedup_key" toml:"dedup_key"`
+	// IgnoreOffsetGaps is set to true if no alerts are to be raised for
+	// offset gaps in the topic, for example for transactional topics where
+	// offsets of control records are skipped. Gaps are ignored
+	// automatically for compacted topics
+	IgnoreOffsetGaps bool `mapstructure:"ignore_offset_gaps" toml:"ignore_offset_gaps"`
+	// Expected contains expected values of topic configuration checked by
+	// topic audit
+	Expected TopicExpectation `mapstructure:"expected" toml:"expected"`
+}
+
+// TopicExpectation contains expected values of topic configuration that
+// are compared with live configuration by topic audit. Values that are not
+// set (zero) are not checked.
+type TopicExpectation struct {
+	// Partitions is expected number of partitions
+	Partitions int `mapstructure:"partitions" toml:"partitions"`
+	// ReplicationFactor is expected number of replicas of each partition
+	ReplicationFactor int `mapstructure:"replication_factor" toml:"replication_factor"`
+	// RetentionMs is expected value of retention.ms, -1 means unlimited
+	// retention
+	RetentionMs int64 `mapstructure:"retention_ms" toml:"retention_ms"`
+	// CleanupPolicy is expected value of cleanup.policy ("delete",
+	// "compact", or "compact,delete")
+	CleanupPolicy string `mapstructure:"cleanup_policy" toml:"cleanup_policy"`
+	// MinInsyncReplicas is expected value of min.insync.replicas
+	MinInsyncReplicas int `mapstructure:"min_insync_replicas" toml:"min_insync_replicas"`
+	// MaxMessageBytes is expected value of max.message.bytes
+	MaxMessageBytes int64 `mapstructure:"max_message_bytes" toml:"max_message_bytes"`
+}
+
+// PipelineConfiguration represents configuration of message processing
+// pipeline
+type PipelineConfiguration struct {
+	// Stages is ordered list of message processing stages. Possible values
+	// are "size", "decode", "filter", "validate", "dedup", "profile", "drift",
+	// "correlate", "archive", "alert", "log", and any stage registered by
+	// RegisterProcessor
+	Stages []string `mapstructure:"stages" toml:"stages"`
+}
+
+// ServerConfiguration represents configuration of HTTP server that provides
+// REST API. Server is not started when address is not set.
+type ServerConfiguration struct {
+	// Address is address and port the server listens on
+	Address string `mapstructure:"address" toml:"address"`
+	// APIPrefix is prefix used by all REST API endpoints
+	APIPrefix string `mapstructure:"api_prefix" toml:"api_prefix"`
+}
+
+// ProfilingConfiguration represents configuration of message payload
+// profiler
+type ProfilingConfiguration struct {
+	// Enabled is set to true if payloads are to be profiled
+	Enabled bool `mapstructure:"enabled" toml:"enabled"`
+	// SummaryInterval is interval between two profile summaries written
+	// into log. Summaries are not written when the interval is zero.
+	SummaryInterval time.Duration `mapstructure:"summary_interval" toml:"summary_interval"`
+}
+
+// DriftConfiguration represents configuration of schema drift detection
+type DriftConfiguration struct {
+	// Enabled is set to true if schema drift is to be detected
+	Enabled bool `mapstructure:"enabled" toml:"enabled"`
+	// WarmupMessages is number of messages used to learn baseline
+	// structure of payloads consumed from one topic
+	WarmupMessages uint64 `mapstructure:"warmup_messages" toml:"warmup_messages"`
+	// WarmupPeriod is the maximum time used to learn baseline structure.
+	// Warm-up ends when either the number of messages or the period is
+	// reached.
+	WarmupPeriod time.Duration `mapstructure:"warmup_period" toml:"warmup_period"`
+	// BaselineFile is path to file where learned baselines are stored.
+	// Baselines are kept in memory only when the path is not set.
+	BaselineFile string `mapstructure:"baseline_file" toml:"baseline_file"`
+	// AlertInterval is the minimal time between two alerts for the same
+	// missing required field. 10 minutes are used when it is not set
+	AlertInterval time.Duration `mapstructure:"alert_interval" toml:"alert_interval"`
+}
+
+// ArchiveConfiguration represents configuration of message sampling archive
+type ArchiveConfiguration struct {
+	// Enabled is set to true if sampled messages are to be archived
+	Enabled bool `mapstructure:"enabled" toml:"enabled"`
+	// Directory is path to directory where archive files are stored
+	Directory string `mapstructure:"directory" toml:"directory"`
+	// Sampling selects which messages are archived. Possible values are:
+	// "nth" - every Nth message
+	// "random" - random percentage of messages
+	// "errors" - only messages that failed processing
+	Sampling string `mapstructure:"sampling" toml:"sampling"`
+	// EveryNth is N for "nth" sampling
+	EveryNth uint64 `mapstructure:"every_nth" toml:"every_nth"`
+	// Percentage is percentage of messages archived by "random" sampling
+	Percentage float64 `mapstructure:"percentage" toml:"percentage"`
+	// MaxFileSize is size of archive file in bytes that triggers rotation
+	MaxFileSize int64 `mapstructure:"max_file_size" toml:"max_file_size"`
+	// MaxTotalSize is the maximum size of all archive files in bytes. It
+	// can't be less than MaxFileSize
+	MaxTotalSize int64 `mapstructure:"max_total_size" toml:"max_total_size"`
+	// MaxAge is the maximum age of archive files
+	MaxAge time.Duration `mapstructure:"max_age" toml:"max_age"`
+	// CleanupInterval is interval between two cleanups of archive files
+	// that are over limits. 1 minute is used when it is not set
+	CleanupInterval time.Duration `mapstructure:"cleanup_interval" toml:"cleanup_interval"`
+}
+
+// DeadLetterConfiguration represents configuration of dead-letter capture
+// of messages that failed processing
+type DeadLetterConfiguration struct {
+	// Enabled is set to true if failed messages are to be captured
+	Enabled bool `mapstructure:"enabled" toml:"enabled"`
+	// Sink selects where failed messages are captured. Possible values
+	// are "file" and "kafka"
+	Sink string `mapstructure:"sink" toml:"sink"`
+	// File is path to file used by "file" sink
+	File string `mapstructure:"file" toml:"file"`
+	// Topic is name of dead-letter topic used by "kafka" sink
+	Topic string `mapstructure:"topic" toml:"topic"`
+}
+
+// CanaryConfiguration represents configuration of canary that periodically
+// produces heartbeat messages and measures their round-trip latency
+type CanaryConfiguration struct {
+	// Enabled is set to true if canary messages are to be produced
+	Enabled bool `mapstructure:"enabled" toml:"enabled"`
+	// Topic is name of dedicated topic where canary messages are produced.
+	// It is mandatory and it can't be the monitored topic
+	Topic string `mapstructure:"topic" toml:"topic"`
+	// Interval is time between two canary messages
+	Interval time.Duration `mapstructure:"interval" toml:"interval"`
+	// Timeout is time after that canary message is considered lost
+	Timeout time.Duration `mapstructure:"timeout" toml:"timeout"`
+	// LatencyThreshold is round-trip latency that triggers alert
+	LatencyThreshold time.Duration `mapstructure:"latency_threshold" toml:"latency_threshold"`
+}
+
+// CorrelationConfiguration represents configuration of correlation of
+// messages across topics
+type CorrelationConfiguration struct {
+	// Enabled is set to true if messages are to be correlated
+	Enabled bool `mapstructure:"enabled" toml:"enabled"`
+	// Stages is ordered list of topics messages flow through. All topics
+	// are consumed by the monitor and rule used to extract correlation ID
+	// needs to be configured for each of them in [[topics]] table
+	Stages []string `mapstructure:"stages" toml:"stages"`
+	// Timeout is time after that message not seen in next stage is
+	// considered dropped
+	Timeout time.Duration `mapstructure:"timeout" toml:"timeout"`
+	// MaxTracked is the maximum number of tracked IDs. The least recently
+	// seen IDs are evicted when the limit is reached
+	MaxTracked int `mapstructure:"max_tracked" toml:"max_tracked"`
+}
+
+// SequenceConfiguration represents configuration of detection of offset
+// gaps and regressions, out-of-order timestamps, and duplicate messages
+type SequenceConfiguration struct {
+	// Enabled is set to true if sequence of messages is to be checked
+	Enabled bool `mapstructure:"enabled" toml:"enabled"`
+	// DedupWindow is time window in which messages with the same
+	// deduplication key are considered duplicates
+	DedupWindow time.Duration `mapstructure:"dedup_window" toml:"dedup_window"`
+	// DedupMaxKeys is the maximum number of deduplication keys kept in
+	// memory. The least recently seen keys are evicted when the limit is
+	// reached
+	DedupMaxKeys int `mapstructure:"dedup_max_keys" toml:"dedup_max_keys"`
+}
+
+// TrafficConfiguration represents configuration of analysis of traffic of
+// partitions and message keys
+type TrafficConfiguration struct {
+	// Enabled is set to true if traffic is to be analyzed
+	Enabled bool `mapstructure:"enabled" toml:"enabled"`
+	// Interval is length of time window used to compute rates. Traffic
+	// summary is written into log after each window
+	Interval time.Duration `mapstructure:"interval" toml:"interval"`
+	// SkewRatio is ratio between message rate of partition and average
+	// message rate of topic partitions that is considered skewed
+	SkewRatio float64 `mapstructure:"skew_ratio" toml:"skew_ratio"`
+	// TopKeys is number of the most frequent message keys reported for
+	// each topic
+	TopKeys int `mapstructure:"top_keys" toml:"top_keys"`
+	// SketchSize is number of counters used to find the most frequent
+	// message keys. More counters give more precise results
+	SketchSize int `mapstructure:"sketch_size" toml:"sketch_size"`
+}
+
+// SizeConfiguration represents configuration of message size monitoring
+type SizeConfiguration struct {
+	// Enabled is set to true if message sizes are to be monitored
+	Enabled bool `mapstructure:"enabled" toml:"enabled"`
+	// Window is number of the most recent messages used to compute size
+	// statistic for each topic
+	Window int `mapstructure:"window" toml:"window"`
+	// MaxBytes is message size in bytes (key, value, and headers) that
+	// triggers alert. Size limit is not checked when it is zero
+	MaxBytes int `mapstructure:"max_bytes" toml:"max_bytes"`
+	// Sigma is number of standard deviations from mean size that triggers
+	// alert. Size anomalies are not detected when it is zero
+	Sigma float64 `mapstructure:"sigma" toml:"sigma"`
+	// MinSamples is number of messages needed before size anomalies are
+	// detected
+	MinSamples int `mapstructure:"min_samples" toml:"min_samples"`
+	// BrokerLimitRatio is ratio of max.message.bytes configured for topic
+	// on broker that triggers warning
+	BrokerLimitRatio float64 `mapstructure:"broker_limit_ratio" toml:"broker_limit_ratio"`
+	// AlertInterval is the minimal time between two alerts of the same
+	// type for the same topic. 1 minute is used when it is not set
+	AlertInterval time.Duration `mapstructure:"alert_interval" toml:"alert_interval"`
+}
+
+// CompressionConfiguration represents configuration of inspection of
+// compression codecs and record formats used by producers
+type CompressionConfiguration struct {
+	// Enabled is set to true if record batches are to be inspected
+	Enabled bool `mapstructure:"enabled" toml:"enabled"`
+	// Interval is time between two inspections
+	Interval time.Duration `mapstructure:"interval" toml:"interval"`
+	// Messages is number of the most recent messages fetched from each
+	// partition during inspection
+	Messages int `mapstructure:"messages" toml:"messages"`
+	// RequireCompression is set to true if batches without compression are
+	// to be flagged
+	RequireCompression bool `mapstructure:"require_compression" toml:"require_compression"`
+	// SupportedCodecs is list of codecs supported by consumers. Possible
+	// values are "none", "gzip", "snappy", "lz4", and "zstd". All codecs
+	// are supported when the list is empty
+	SupportedCodecs []string `mapstructure:"supported_codecs" toml:"supported_codecs"`
+}
+
+// HistoryConfiguration represents configuration of history of consumer
+// progress
+type HistoryConfiguration struct {
+	// Enabled is set to true if snapshots of consumer progress are to be
+	// stored
+	Enabled bool `mapstructure:"enabled" toml:"enabled"`
+	// Directory is directory where history files are stored
+	Directory string `mapstructure:"directory" toml:"directory"`
+	// Interval is time between two snapshots
+	Interval time.Duration `mapstructure:"interval" toml:"interval"`
+	// Retention is how long snapshots are kept
+	Retention time.Duration `mapstructure:"retention" toml:"retention"`
+}
+
+// ReportConfiguration represents configuration of periodic summary reports
+type ReportConfiguration struct {
+	// Enabled is set to true if reports are to be generated
+	Enabled bool `mapstructure:"enabled" toml:"enabled"`
+	// Interval is length of report period, for example "1h" for hourly or
+	// "24h" for daily reports
+	Interval time.Duration `mapstructure:"interval" toml:"interval"`
+	// Directory is directory where reports are written
+	Directory string `mapstructure:"directory" toml:"directory"`
+	// Formats is list of formats of report files. Possible values are
+	// "markdown" and "json"
+	Formats []string `mapstructure:"formats" toml:"formats"`
+	// Webhook is URL where reports are sent as JSON, reports are not sent
+	// when it is empty
+	Webhook string `mapstructure:"webhook" toml:"webhook"`
+	// TopMessages is number of the largest messages included in report
+	TopMessages int `mapstructure:"top_messages" toml:"top_messages"`
+	// TopErrors is number of the most frequent error reasons included in
+	// report
+	TopErrors int `mapstructure:"top_errors" toml:"top_errors"`
+	// QuietPeriod is the shortest time without messages that is reported
+	QuietPeriod time.Duration `mapstructure:"quiet_period" toml:"quiet_period"`
+	// LatencySamples is number of processing latencies sampled for each
+	// topic to compute percentiles
+	LatencySamples int `mapstructure:"latency_samples" toml:"latency_samples"`
+}
+
+// DashboardConfiguration represents configuration of live dashboard
+type DashboardConfiguration struct {
+	// Enabled is set to true if dashboard is to be provided by HTTP server
+	Enabled bool `mapstructure:"enabled" toml:"enabled"`
+	// Interval is time between two updates of dashboard
+	Interval time.Duration `mapstructure:"interval" toml:"interval"`
+	// Points is number of throughput samples displayed in sparklines
+	Points int `mapstructure:"points" toml:"points"`
+	// Messages is number of the most recent messages displayed
+	Messages int `mapstructure:"messages" toml:"messages"`
+	// AlertWindow is how long alerts are displayed as active
+	AlertWindow time.Duration `mapstructure:"alert_window" toml:"alert_window"`
+}
+
+// RedactionConfiguration represents configuration of redaction of
+// sensitive data from message content
+type RedactionConfiguration struct {
+	// Enabled is set to true if sensitive data are to be redacted
+	Enabled bool `mapstructure:"enabled" toml:"enabled"`
+	// JSONPaths is list of dot-separated paths to JSON values to be
+	// masked. Wildcard "*" matches any key, arrays are traversed
+	// transparently
+	JSONPaths []string `mapstructure:"json_paths" toml:"json_paths"`
+	// Patterns is list of regular expressions matching text to be masked
+	Patterns []string `mapstructure:"patterns" toml:"patterns"`
+	// HashKeys is set to true if message keys are to be replaced by their
+	// hashes
+	HashKeys bool `mapstructure:"hash_keys" toml:"hash_keys"`
+	// HashSalt is prepended to message keys before hashing
+	HashSalt string `mapstructure:"hash_salt" toml:"hash_salt"`
+	// Mask is text used in place of redacted data
+	Mask string `mapstructure:"mask" toml:"mask"`
+}
+
+// SessionConfiguration represents configuration of consumer group session
+// tracking
+type SessionConfiguration struct {
+	// Enabled is set to true if consumer group sessions are to be tracked
+	Enabled bool `mapstructure:"enabled" toml:"enabled"`
+	// History is number of the most recent finished sessions kept
+	History int `mapstructure:"history" toml:"history"`
+	// StormWindow is length of time window used to compute rebalance
+	// frequency
+	StormWindow time.Duration `mapstructure:"storm_window" toml:"storm_window"`
+	// StormThreshold is number of rebalances within storm window that
+	// triggers alert. Rebalance storms are not detected when it is zero
+	StormThreshold int `mapstructure:"storm_threshold" toml:"storm_threshold"`
+}
+
+// AuditConfiguration represents configuration of periodic audit of topic
+// configuration. Expected values are configured for each topic in
+// [topics.expected] table.
+type AuditConfiguration struct {
+	// Enabled is set to true if topic configuration is to be audited
+	// periodically
+	Enabled bool `mapstructure:"enabled" toml:"enabled"`
+	// Interval is time between two audits
+	Interval time.Duration `mapstructure:"interval" toml:"interval"`
 }
 
 // OutputConfiguration configures which log messages to use
@@ -185,3 +756,106 @@ func GetBrokerConfiguration(config *ConfigStruct) BrokerConfiguration {
 func GetOutputConfiguration(config *ConfigStruct) OutputConfiguration {
 	return config.Output
 }
+
+// GetServerConfiguration returns HTTP server configuration
+func GetServerConfiguration(config *ConfigStruct) ServerConfiguration {
+	return config.Server
+}
+
+// GetProfilingConfiguration returns profiling configuration
+func GetProfilingConfiguration(config *ConfigStruct) ProfilingConfiguration {
+	return config.Profiling
+}
+
+// GetDriftConfiguration returns schema drift detection configuration
+func GetDriftConfiguration(config *ConfigStruct) DriftConfiguration {
+	return config.Drift
+}
+
+// GetArchiveConfiguration returns message archive configuration
+func GetArchiveConfiguration(config *ConfigStruct) ArchiveConfiguration {
+	return config.Archive
+}
+
+// GetDeadLetterConfiguration returns dead-letter capture configuration
+func GetDeadLetterConfiguration(config *ConfigStruct) DeadLetterConfiguration {
+	return config.DeadLetter
+}
+
+// GetCanaryConfiguration returns canary configuration
+func GetCanaryConfiguration(config *ConfigStruct) CanaryConfiguration {
+	return config.Canary
+}
+
+// GetPipelineConfiguration returns message processing pipeline
+// configuration
+func GetPipelineConfiguration(config *ConfigStruct) PipelineConfiguration {
+	return config.Pipeline
+}
+
+// GetCorrelationConfiguration returns configuration of correlation of
+// messages across topics
+func GetCorrelationConfiguration(config *ConfigStruct) CorrelationConfiguration {
+	return config.Correlation
+}
+
+// GetSequenceConfiguration returns configuration of detection of offset
+// gaps, out-of-order and duplicate messages
+func GetSequenceConfiguration(config *ConfigStruct) SequenceConfiguration {
+	return config.Sequence
+}
+
+// GetTrafficConfiguration returns configuration of traffic analysis
+func GetTrafficConfiguration(config *ConfigStruct) TrafficConfiguration {
+	return config.Traffic
+}
+
+// GetSizeConfiguration returns configuration of message size monitoring
+func GetSizeConfiguration(config *ConfigStruct) SizeConfiguration {
+	return config.Size
+}
+
+// GetCompressionConfiguration returns configuration of inspection of
+// compression codecs and record formats
+func GetCompressionConfiguration(config *ConfigStruct) CompressionConfiguration {
+	return config.Compression
+}
+
+// GetHistoryConfiguration returns configuration of history of consumer
+// progress
+func GetHistoryConfiguration(config *ConfigStruct) HistoryConfiguration {
+	return config.History
+}
+
+// GetReportConfiguration returns configuration of periodic summary reports
+func GetReportConfiguration(config *ConfigStruct) ReportConfiguration {
+	return config.Report
+}
+
+// GetDashboardConfiguration returns configuration of live dashboard
+func GetDashboardConfiguration(config *ConfigStruct) DashboardConfiguration {
+	return config.Dashboard
+}
+
+// GetRedactionConfiguration returns configuration of redaction of
+// sensitive data
+func GetRedactionConfiguration(config *ConfigStruct) RedactionConfiguration {
+	return config.Redaction
+}
+
+// GetSessionConfiguration returns configuration of consumer group session
+// tracking
+func GetSessionConfiguration(config *ConfigStruct) SessionConfiguration {
+	return config.Sessions
+}
+
+// GetAuditConfiguration returns configuration of periodic audit of topic
+// configuration
+func GetAuditConfiguration(config *ConfigStruct) AuditConfiguration {
+	return config.Audit
+}
+
+// GetTopicsConfiguration returns configuration for all topics
+func GetTopicsConfiguration(config *ConfigStruct) []TopicConfiguration {
+	return config.Topics
+}
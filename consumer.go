@@ -18,9 +18,14 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Shopify/sarama"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
@@ -36,6 +41,15 @@ const (
 
 	// key for message partition used in structured log messages
 	partitionKey = "partition"
+
+	// key for consumer group generation ID used in structured log messages
+	generationIDKey = "generation_id"
+
+	// key for consumer group member ID used in structured log messages
+	memberIDKey = "member_id"
+
+	// key for partitions claimed by session used in structured log messages
+	claimsKey = "claims"
 )
 
 // Consumer represents any consumer of insights-rules messages
@@ -64,9 +78,92 @@ type KafkaConsumer struct {
 	ConsumerGroup                        sarama.ConsumerGroup
 	numberOfSuccessfullyConsumedMessages uint64
 	numberOfErrorsConsumingMessages      uint64
+	numberOfConsumerGroupErrors          uint64
 	Verbose                              bool
+	Decoders                             map[string]DecoderChain
+	Profiler                             *Profiler
+	DriftDetector                        *DriftDetector
+	Archive                              *Archive
+	DeadLetter                           DeadLetterSink
+	Canary                               *Canary
+	Pipeline                             *Pipeline
+	Correlator                           *Correlator
+	SequenceChecker                      *SequenceChecker
+	TrafficAnalyzer                      *TrafficAnalyzer
+	SizeMonitor                          *SizeMonitor
+	BatchInspector                       *BatchInspector
+	Alerts                               *AlertManager
+	History                              *HistoryStore
+	Reporter                             *Reporter
+	Dashboard                            *Dashboard
+	Redactor                             *Redactor
+	Sessions                             *SessionTracker
+	Auditor                              *TopicAuditor
+	progress                             progressTracker
+	pipelineOnce                         sync.Once
+	offsetClient                         sarama.Client
+	offsetClientMutex                    sync.Mutex
 	Ready                                chan bool
 	Cancel                               context.CancelFunc
+	consumerGroupFactory                 ConsumerGroupFactory
+	clock                                Clock
+	logger                               *zerolog.Logger
+}
+
+// ConsumerGroupFactory is a function that constructs consumer group. It has
+// the same signature as sarama.NewConsumerGroup that is used by default.
+type ConsumerGroupFactory func(addrs []string, groupID string, config *sarama.Config) (sarama.ConsumerGroup, error)
+
+// Clock is a source of actual time used by consumer. System time is used
+// when no clock is provided.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is a clock that returns system time
+type systemClock struct{}
+
+// Now method returns actual system time
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// clockOrDefault function returns given clock, or system clock when no
+// clock is given. It is used by constructors of components that measure
+// time, so the same clock can be injected into consumer and all its
+// components.
+func clockOrDefault(clock Clock) Clock {
+	if clock == nil {
+		return systemClock{}
+	}
+	return clock
+}
+
+// ConsumerOption is an optional argument of consumer constructors
+type ConsumerOption func(consumer *KafkaConsumer)
+
+// WithConsumerGroupFactory option replaces function used to construct
+// consumer group, for example by function that returns fake consumer group
+// in tests
+func WithConsumerGroupFactory(factory ConsumerGroupFactory) ConsumerOption {
+	return func(consumer *KafkaConsumer) {
+		consumer.consumerGroupFactory = factory
+	}
+}
+
+// WithClock option replaces clock used by consumer to measure time
+func WithClock(clock Clock) ConsumerOption {
+	return func(consumer *KafkaConsumer) {
+		consumer.clock = clock
+	}
+}
+
+// WithLogger option replaces logger used by consumer. Global logger is used
+// by default.
+func WithLogger(logger zerolog.Logger) ConsumerOption {
+	return func(consumer *KafkaConsumer) {
+		consumer.logger = &logger
+	}
 }
 
 // DefaultSaramaConfig is a config which will be used by default
@@ -74,9 +171,143 @@ type KafkaConsumer struct {
 // useful for testing
 var DefaultSaramaConfig *sarama.Config
 
+// newSaramaConfig constructs sarama config from broker configuration. The
+// same config is used by consumer and by all commands that need to talk to
+// Kafka cluster. Protocol version is detected when "auto" version is
+// configured.
+func newSaramaConfig(brokerCfg BrokerConfiguration) (*sarama.Config, error) {
+	version, err := ProtocolVersion(brokerCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	saramaConfig := sarama.NewConfig()
+	saramaConfig.Version = version
+
+	/* TODO: we need to do it in production code
+	if brokerCfg.Timeout > 0 {
+		saramaConfig.Net.DialTimeout = brokerCfg.Timeout
+		saramaConfig.Net.ReadTimeout = brokerCfg.Timeout
+		saramaConfig.Net.WriteTimeout = brokerCfg.Timeout
+	}
+	*/
+
+	return saramaConfig, nil
+}
+
+// minimalHeadersProtocolVersion is the oldest Kafka protocol version that
+// supports record headers
+var minimalHeadersProtocolVersion = sarama.V0_11_0_0
+
+// headersSaramaConfig constructs sarama config that is able to produce and
+// consume record headers. Protocol version is raised if needed and
+// successfully produced messages are returned, so the config can be used by
+// sync producer as well.
+func headersSaramaConfig(brokerCfg BrokerConfiguration) (*sarama.Config, error) {
+	saramaConfig, err := newSaramaConfig(brokerCfg)
+	if err != nil {
+		return nil, err
+	}
+	if !saramaConfig.Version.IsAtLeast(minimalHeadersProtocolVersion) {
+		saramaConfig.Version = minimalHeadersProtocolVersion
+	}
+	saramaConfig.Producer.Return.Successes = true
+	return saramaConfig, nil
+}
+
+// Strategies used to assign partitions to consumer group members
+const (
+	RebalanceStrategyRange      = "range"
+	RebalanceStrategyRoundRobin = "roundrobin"
+	RebalanceStrategySticky     = "sticky"
+)
+
+// rebalanceStrategies maps configured strategy names to sarama balance
+// strategies
+var rebalanceStrategies = map[string]sarama.BalanceStrategy{
+	RebalanceStrategyRange:      sarama.BalanceStrategyRange,
+	RebalanceStrategyRoundRobin: sarama.BalanceStrategyRoundRobin,
+	RebalanceStrategySticky:     sarama.BalanceStrategySticky,
+}
+
+// podNameEnvVariable is name of environment variable that contains name of
+// pod the monitor runs in
+const podNameEnvVariable = "POD_NAME"
+
+// minimalStaticMembershipProtocolVersion is the oldest Kafka protocol
+// version that supports static group membership
+var minimalStaticMembershipProtocolVersion = sarama.V2_3_0_0
+
+// RebalanceStrategy function returns name of strategy used to assign
+// partitions to consumer group members, range strategy is used by default
+func RebalanceStrategy(brokerCfg BrokerConfiguration) string {
+	if brokerCfg.RebalanceStrategy == "" {
+		return RebalanceStrategyRange
+	}
+	return brokerCfg.RebalanceStrategy
+}
+
+// GroupInstanceID function returns group instance ID used for static group
+// membership. Configured ID is used when set, otherwise it is derived from
+// pod name. Empty string is returned when static membership is disabled.
+func GroupInstanceID(brokerCfg BrokerConfiguration) (string, error) {
+	if !brokerCfg.StaticMembership {
+		return "", nil
+	}
+	if brokerCfg.InstanceID != "" {
+		return brokerCfg.InstanceID, nil
+	}
+	if podName := os.Getenv(podNameEnvVariable); podName != "" {
+		return podName, nil
+	}
+	// hostname is set to pod name by Kubernetes
+	return os.Hostname()
+}
+
+// consumerGroupSaramaConfig method returns copy of sarama config with
+// partition assignment strategy, static group membership, and session
+// timeout set up according to broker configuration. Protocol version is
+// raised if needed by static membership.
+func (consumer *KafkaConsumer) consumerGroupSaramaConfig(saramaConfig *sarama.Config) (*sarama.Config, error) {
+	brokerCfg := consumer.Configuration
+
+	strategy, found := rebalanceStrategies[RebalanceStrategy(brokerCfg)]
+	if !found {
+		return nil, fmt.Errorf("unknown rebalance strategy '%s'", brokerCfg.RebalanceStrategy)
+	}
+
+	instanceID, err := GroupInstanceID(brokerCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// config provided by caller is not changed
+	config := *saramaConfig
+	config.Consumer.Group.Rebalance.Strategy = strategy
+	config.Consumer.Group.InstanceId = instanceID
+	// errors are returned via Errors channel to be logged and counted by
+	// consumer
+	config.Consumer.Return.Errors = true
+	if brokerCfg.SessionTimeout > 0 {
+		config.Consumer.Group.Session.Timeout = brokerCfg.SessionTimeout
+		// heartbeats need to be sent several times during session timeout
+		if maxHeartbeat := brokerCfg.SessionTimeout / 3; config.Consumer.Group.Heartbeat.Interval > maxHeartbeat {
+			config.Consumer.Group.Heartbeat.Interval = maxHeartbeat
+		}
+	}
+	if instanceID != "" && !config.Version.IsAtLeast(minimalStaticMembershipProtocolVersion) {
+		consumer.log().Warn().
+			Str("configured version", config.Version.String()).
+			Str("used version", minimalStaticMembershipProtocolVersion.String()).
+			Msg("Protocol version raised as required by static membership")
+		config.Version = minimalStaticMembershipProtocolVersion
+	}
+	return &config, nil
+}
+
 // NewConsumer constructs new implementation of Consumer interface
-func NewConsumer(brokerCfg BrokerConfiguration, verbose bool) (*KafkaConsumer, error) {
-	return NewWithSaramaConfig(brokerCfg, DefaultSaramaConfig, verbose)
+func NewConsumer(brokerCfg BrokerConfiguration, verbose bool, options ...ConsumerOption) (*KafkaConsumer, error) {
+	return NewWithSaramaConfig(brokerCfg, DefaultSaramaConfig, verbose, options...)
 }
 
 // NewWithSaramaConfig constructs new implementation of Consumer interface with custom sarama config
@@ -84,40 +315,79 @@ func NewWithSaramaConfig(
 	brokerCfg BrokerConfiguration,
 	saramaConfig *sarama.Config,
 	verbose bool,
+	options ...ConsumerOption,
 ) (*KafkaConsumer, error) {
 	if saramaConfig == nil {
-		saramaConfig = sarama.NewConfig()
-		saramaConfig.Version = sarama.V0_10_2_0
-
-		/* TODO: we need to do it in production code
-		if brokerCfg.Timeout > 0 {
-			saramaConfig.Net.DialTimeout = brokerCfg.Timeout
-			saramaConfig.Net.ReadTimeout = brokerCfg.Timeout
-			saramaConfig.Net.WriteTimeout = brokerCfg.Timeout
+		var err error
+		saramaConfig, err = newSaramaConfig(brokerCfg)
+		if err != nil {
+			return nil, err
 		}
-		*/
 	}
 
-	log.Info().
+	consumer := &KafkaConsumer{
+		Configuration:                        brokerCfg,
+		Verbose:                              verbose,
+		numberOfSuccessfullyConsumedMessages: 0,
+		numberOfErrorsConsumingMessages:      0,
+		Ready:                                make(chan bool),
+		consumerGroupFactory:                 sarama.NewConsumerGroup,
+	}
+
+	for _, option := range options {
+		option(consumer)
+	}
+
+	saramaConfig, err := consumer.consumerGroupSaramaConfig(saramaConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	consumer.log().Info().
 		Str("addr", brokerCfg.Address).
 		Str("group", brokerCfg.Group).
+		Str("rebalance strategy", RebalanceStrategy(brokerCfg)).
+		Str("group instance ID", saramaConfig.Consumer.Group.InstanceId).
+		Str("protocol version", saramaConfig.Version.String()).
+		Dur("session timeout", saramaConfig.Consumer.Group.Session.Timeout).
 		Msg("Configuration")
 
-	consumerGroup, err := sarama.NewConsumerGroup([]string{brokerCfg.Address}, brokerCfg.Group, saramaConfig)
+	consumerGroup, err := consumer.consumerGroupFactory([]string{brokerCfg.Address}, brokerCfg.Group, saramaConfig)
 	if err != nil {
 		return nil, err
 	}
+	consumer.ConsumerGroup = consumerGroup
 
-	consumer := &KafkaConsumer{
-		Configuration:                        brokerCfg,
-		ConsumerGroup:                        consumerGroup,
-		Verbose:                              verbose,
-		numberOfSuccessfullyConsumedMessages: 0,
-		numberOfErrorsConsumingMessages:      0,
-		Ready:                                make(chan bool),
+	return consumer, nil
+}
+
+// now method returns actual time from clock used by consumer
+func (consumer *KafkaConsumer) now() time.Time {
+	return clockOrDefault(consumer.clock).Now()
+}
+
+// log method returns logger used by consumer
+func (consumer *KafkaConsumer) log() *zerolog.Logger {
+	if consumer.logger == nil {
+		return &log.Logger
 	}
+	return consumer.logger
+}
 
-	return consumer, nil
+// topics method returns list of topics consumed by consumer. Topics that
+// are part of correlated pipeline are consumed together with the monitored
+// topic.
+func (consumer *KafkaConsumer) topics() []string {
+	topics := []string{consumer.Configuration.Topic}
+	if consumer.Correlator == nil {
+		return topics
+	}
+	for _, topic := range consumer.Correlator.Topics() {
+		if topic != consumer.Configuration.Topic {
+			topics = append(topics, topic)
+		}
+	}
+	return topics
 }
 
 // Serve starts listening for messages and processing them. It blocks current thread.
@@ -125,57 +395,110 @@ func (consumer *KafkaConsumer) Serve() {
 	ctx, cancel := context.WithCancel(context.Background())
 	consumer.Cancel = cancel
 
+	go consumer.logConsumerGroupErrors(ctx)
+
 	go func() {
 		for {
 			// `Consume` should be called inside an infinite loop, when a
 			// server-side rebalance happens, the consumer session will need to be
 			// recreated to get the new claims
-			if err := consumer.ConsumerGroup.Consume(ctx, []string{consumer.Configuration.Topic}, consumer); err != nil {
-				log.Fatal().Err(err).Msg("Unable to recreate Kafka session")
-			}
+			err := consumer.ConsumerGroup.Consume(ctx, consumer.topics(), consumer)
 
 			// check if context was cancelled, signaling that the consumer should stop
 			if ctx.Err() != nil {
-				log.Info().Err(ctx.Err()).Msg("Stopping consumer")
+				consumer.log().Info().Err(ctx.Err()).Msg("Stopping consumer")
 				return
 			}
 
-			log.Info().Msg("Created new kafka session")
+			if err != nil {
+				consumer.log().Fatal().Err(err).Msg("Unable to recreate Kafka session")
+			}
+
+			consumer.log().Info().Msg("Created new kafka session")
 
 			consumer.Ready = make(chan bool)
 		}
 	}()
 
 	// Await till the consumer has been set up
-	log.Info().Msg("Waiting for consumer to become ready")
-	<-consumer.Ready
-	log.Info().Msg("Finished waiting for consumer to become ready")
-
-	// Actual processing is done in goroutine created by sarama (see ConsumeClaim below)
-	log.Info().Msg("Started serving consumer")
+	consumer.log().Info().Msg("Waiting for consumer to become ready")
+	select {
+	case <-consumer.Ready:
+		consumer.log().Info().Msg("Finished waiting for consumer to become ready")
+
+		// Actual processing is done in goroutine created by sarama (see ConsumeClaim below)
+		consumer.log().Info().Msg("Started serving consumer")
+	case <-ctx.Done():
+	}
 	<-ctx.Done()
-	log.Info().Msg("Context cancelled, exiting")
+	consumer.log().Info().Msg("Context cancelled, exiting")
 
 	cancel()
 }
 
+// logConsumerGroupErrors method logs and counts errors reported by consumer
+// group outside of Consume call, for example errors of offset commits. It
+// runs until given context is cancelled.
+func (consumer *KafkaConsumer) logConsumerGroupErrors(ctx context.Context) {
+	errs := consumer.ConsumerGroup.Errors()
+	for {
+		select {
+		case err, ok := <-errs:
+			if !ok {
+				return
+			}
+			atomic.AddUint64(&consumer.numberOfConsumerGroupErrors, 1)
+			consumer.log().Error().Err(err).Msg("Consumer group error")
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
 // Setup is run at the beginning of a new session, before ConsumeClaim
-func (consumer *KafkaConsumer) Setup(sarama.ConsumerGroupSession) error {
-	log.Info().Msg("New session has been setup")
+func (consumer *KafkaConsumer) Setup(session sarama.ConsumerGroupSession) error {
+	if session != nil {
+		consumer.log().Info().
+			Int32(generationIDKey, session.GenerationID()).
+			Str(memberIDKey, session.MemberID()).
+			Interface(claimsKey, sessionClaims(session)).
+			Msg("New session has been setup")
+		if consumer.Sessions != nil {
+			consumer.Sessions.Start(session)
+		}
+		if consumer.TrafficAnalyzer != nil {
+			consumer.TrafficAnalyzer.SetClaims(session.Claims())
+		}
+		if consumer.SequenceChecker != nil {
+			consumer.SequenceChecker.SetClaims(session.Claims())
+		}
+	} else {
+		consumer.log().Info().Msg("New session has been setup")
+	}
 	// Mark the consumer as ready
 	close(consumer.Ready)
 	return nil
 }
 
 // Cleanup is run at the end of a session, once all ConsumeClaim goroutines have exited
-func (consumer *KafkaConsumer) Cleanup(sarama.ConsumerGroupSession) error {
-	log.Info().Msg("New session has been finished")
+func (consumer *KafkaConsumer) Cleanup(session sarama.ConsumerGroupSession) error {
+	if session != nil {
+		consumer.log().Info().
+			Int32(generationIDKey, session.GenerationID()).
+			Str(memberIDKey, session.MemberID()).
+			Msg("New session has been finished")
+		if consumer.Sessions != nil {
+			consumer.Sessions.End(session)
+		}
+	} else {
+		consumer.log().Info().Msg("New session has been finished")
+	}
 	return nil
 }
 
 // ConsumeClaim starts a consumer loop of ConsumerGroupClaim's Messages().
 func (consumer *KafkaConsumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
-	log.Info().
+	consumer.log().Info().
 		Int64(offsetKey, claim.InitialOffset()).
 		Msg("Starting messages loop")
 
@@ -184,6 +507,7 @@ func (consumer *KafkaConsumer) ConsumeClaim(session sarama.ConsumerGroupSession,
 		// log.Info().Int64(offsetKey, message.Offset).Msg("Message retrieved")
 
 		consumer.HandleMessage(message)
+		consumer.progress.setHighWaterMark(message.Topic, message.Partition, claim.HighWaterMarkOffset())
 
 		session.MarkMessage(message, "")
 	}
@@ -199,77 +523,232 @@ func (consumer *KafkaConsumer) Close() error {
 
 	if consumer.ConsumerGroup != nil {
 		if err := consumer.ConsumerGroup.Close(); err != nil {
-			log.Error().
+			consumer.log().Error().
 				Err(err).
 				Msg("Unable to close consumer group")
 		}
 	}
 
+	if consumer.Archive != nil {
+		if err := consumer.Archive.Close(); err != nil {
+			consumer.log().Error().
+				Err(err).
+				Msg("Unable to close message archive")
+		}
+	}
+
+	if consumer.DeadLetter != nil {
+		if err := consumer.DeadLetter.Close(); err != nil {
+			consumer.log().Error().
+				Err(err).
+				Msg(deadLetterCloseMessage)
+		}
+	}
+
+	if consumer.Canary != nil {
+		if err := consumer.Canary.Close(); err != nil {
+			consumer.log().Error().
+				Err(err).
+				Msg("Unable to close canary producer")
+		}
+	}
+
+	consumer.offsetClientMutex.Lock()
+	defer consumer.offsetClientMutex.Unlock()
+	if consumer.offsetClient != nil {
+		if err := consumer.offsetClient.Close(); err != nil {
+			consumer.log().Error().
+				Err(err).
+				Msg(closingBrokerConnectionMessage)
+		}
+		consumer.offsetClient = nil
+	}
+
 	return nil
 }
 
 // GetNumberOfSuccessfullyConsumedMessages returns number of consumed messages
 // since creating KafkaConsumer obj
 func (consumer *KafkaConsumer) GetNumberOfSuccessfullyConsumedMessages() uint64 {
-	return consumer.numberOfSuccessfullyConsumedMessages
+	return atomic.LoadUint64(&consumer.numberOfSuccessfullyConsumedMessages)
 }
 
 // GetNumberOfErrorsConsumingMessages returns number of errors during consuming messages
 // since creating KafkaConsumer obj
 func (consumer *KafkaConsumer) GetNumberOfErrorsConsumingMessages() uint64 {
-	return consumer.numberOfErrorsConsumingMessages
+	return atomic.LoadUint64(&consumer.numberOfErrorsConsumingMessages)
+}
+
+// GetNumberOfConsumerGroupErrors returns number of errors reported by
+// consumer group since creating KafkaConsumer obj
+func (consumer *KafkaConsumer) GetNumberOfConsumerGroupErrors() uint64 {
+	return atomic.LoadUint64(&consumer.numberOfConsumerGroupErrors)
+}
+
+// Progress returns progress of consumer in all partitions it consumed
+// messages from, ordered by topic and partition
+func (consumer *KafkaConsumer) Progress() []PartitionProgress {
+	return consumer.progress.snapshot()
+}
+
+// highWaterMarksClient method returns Kafka client used to retrieve high
+// water marks. The client is constructed on the first use and reused by all
+// later refreshes, so protocol version is detected just once. The client is
+// closed together with consumer.
+func (consumer *KafkaConsumer) highWaterMarksClient() (sarama.Client, error) {
+	consumer.offsetClientMutex.Lock()
+	defer consumer.offsetClientMutex.Unlock()
+
+	if consumer.offsetClient != nil {
+		return consumer.offsetClient, nil
+	}
+
+	saramaConfig, err := newSaramaConfig(consumer.Configuration)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := sarama.NewClient([]string{consumer.Configuration.Address}, saramaConfig)
+	if err != nil {
+		return nil, err
+	}
+	consumer.offsetClient = client
+	return client, nil
+}
+
+// RefreshHighWaterMarks method retrieves actual high water marks of all
+// partitions the consumer consumed messages from and updates lag of
+// consumer. High water marks are otherwise updated by consumed messages
+// only, so lag would not grow when consumer is stuck.
+func (consumer *KafkaConsumer) RefreshHighWaterMarks() error {
+	partitions := consumer.progress.partitionIDs()
+	if len(partitions) == 0 {
+		return nil
+	}
+
+	client, err := consumer.highWaterMarksClient()
+	if err != nil {
+		return err
+	}
+
+	for _, id := range partitions {
+		highWaterMark, err := client.GetOffset(id.topic, id.partition, sarama.OffsetNewest)
+		if err != nil {
+			consumer.log().Warn().Err(err).
+				Str(topicKey, id.topic).
+				Int32(partitionKey, id.partition).
+				Msg("Unable to retrieve high water mark")
+			continue
+		}
+		consumer.progress.setHighWaterMark(id.topic, id.partition, highWaterMark)
+	}
+	return nil
 }
 
 // HandleMessage handles the message and does all logging, metrics, etc
 func (consumer *KafkaConsumer) HandleMessage(msg *sarama.ConsumerMessage) {
 	if msg == nil {
-		log.Error().Msg("nil message")
+		consumer.log().Error().Msg("nil message")
 		return
 	}
 
-	log.Info().
+	consumer.log().Info().
 		Int64(offsetKey, msg.Offset).
 		Int32(partitionKey, msg.Partition).
 		Str(topicKey, msg.Topic).
 		Time("message_timestamp", msg.Timestamp).
 		Msg("Started processing message")
 
-	startTime := time.Now()
+	if consumer.SequenceChecker != nil {
+		consumer.SequenceChecker.Check(msg)
+	}
+	if consumer.TrafficAnalyzer != nil {
+		consumer.TrafficAnalyzer.Record(msg)
+	}
+
+	startTime := consumer.now()
 	err := consumer.ProcessMessage(msg)
-	timeAfterProcessingMessage := time.Now()
+	timeAfterProcessingMessage := consumer.now()
 	messageProcessingDuration := timeAfterProcessingMessage.Sub(startTime).Seconds()
 
 	// Something went wrong while processing the message.
 	if err != nil {
-		log.Error().
-			Err(err).
+		consumer.log().Error().
+			Err(consumer.Redactor.RedactError(err)).
 			Msg("Error processing message consumed from Kafka")
-		consumer.numberOfErrorsConsumingMessages++
+		atomic.AddUint64(&consumer.numberOfErrorsConsumingMessages, 1)
+		consumer.captureDeadLetter(msg, err)
 	} else {
 		// The message was processed successfully.
-		consumer.numberOfSuccessfullyConsumedMessages++
+		atomic.AddUint64(&consumer.numberOfSuccessfullyConsumedMessages, 1)
+	}
+	consumer.progress.record(msg, err != nil)
+	if consumer.Reporter != nil {
+		consumer.Reporter.Record(msg, timeAfterProcessingMessage.Sub(startTime), err)
+	}
+	if consumer.Dashboard != nil {
+		consumer.Dashboard.Record(msg, err)
 	}
 
-	log.Info().
+	// successfully processed messages are archived by archive stage, so
+	// just failed messages are archived here
+	if err != nil && consumer.Archive != nil && consumer.pipeline().HasStage(msg.Topic, StageArchive) {
+		consumer.Archive.Record(msg, err)
+	}
+
+	consumer.log().Info().
 		Str(topicKey, consumer.Configuration.Topic).
 		Str(groupKey, consumer.Configuration.Group).
 		Int64(offsetKey, msg.Offset).
 		Int32(partitionKey, msg.Partition).
 		Str(topicKey, msg.Topic).
-		Uint64("consumed messages", consumer.numberOfSuccessfullyConsumedMessages).
-		Uint64("errors", consumer.numberOfErrorsConsumingMessages).
+		Uint64("consumed messages", consumer.GetNumberOfSuccessfullyConsumedMessages()).
+		Uint64("errors", consumer.GetNumberOfErrorsConsumingMessages()).
 		Msgf("Processing of message took '%v' seconds", messageProcessingDuration)
 }
 
-// ProcessMessage processes an incoming message
-func (consumer *KafkaConsumer) ProcessMessage(msg *sarama.ConsumerMessage) error {
-	value := msg.Value
+// captureDeadLetter method captures message that failed processing into
+// dead-letter sink, if the sink is configured
+func (consumer *KafkaConsumer) captureDeadLetter(msg *sarama.ConsumerMessage, processingError error) {
+	if consumer.DeadLetter == nil {
+		return
+	}
 
-	log.Info().Int("length", len(value)).Msg("Message length")
+	err := consumer.DeadLetter.Capture(msg, processingError)
+	if err != nil {
+		consumer.log().Error().
+			Err(err).
+			Int64(offsetKey, msg.Offset).
+			Int32(partitionKey, msg.Partition).
+			Str(topicKey, msg.Topic).
+			Msg(deadLetterCaptureMessage)
+	}
+}
 
-	if consumer.Verbose {
-		log.Info().Str("content", string(value)).Msg("Message value")
+// ProcessMessage processes an incoming message by passing it through all
+// stages of message processing pipeline
+func (consumer *KafkaConsumer) ProcessMessage(msg *sarama.ConsumerMessage) error {
+	// canary messages are not validated
+	if IsCanaryMessage(msg) {
+		return nil
 	}
 
-	return nil
+	return consumer.pipeline().Process(&DecodedMessage{
+		Message: msg,
+		Content: msg.Value,
+	})
+}
+
+// pipeline method returns message processing pipeline used by consumer.
+// Pipeline with default stages is constructed just once when no pipeline
+// is configured.
+func (consumer *KafkaConsumer) pipeline() *Pipeline {
+	consumer.pipelineOnce.Do(func() {
+		if consumer.Pipeline == nil {
+			// default stages are always registered, so no error can be
+			// returned
+			consumer.Pipeline, _ = NewPipeline(consumer, PipelineConfiguration{}, nil)
+		}
+	})
+	return consumer.Pipeline
 }
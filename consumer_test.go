@@ -17,10 +17,16 @@ limitations under the License.
 package main_test
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"os"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/Shopify/sarama"
+	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
 
 	main "github.com/RedHatInsights/insights-kafka-monitor"
@@ -29,7 +35,7 @@ import (
 // TestNewConsumerBadBroker function checks the consumer creation by
 // using a non accessible Kafka broker.
 func TestNewConsumerBadBroker(t *testing.T) {
-	const expectedErr = "kafka: client has run out of available brokers to talk to (Is your cluster reachable?)"
+	const expectedErr = "kafka: client has run out of available brokers to talk to: dial tcp: missing address"
 
 	// invalid broker configuration
 	var brokerConfiguration = main.BrokerConfiguration{
@@ -58,7 +64,7 @@ func TestNewConsumerBadBroker(t *testing.T) {
 // non accessible Kafka broker. This test assumes there is no local Kafka
 // instance currently running
 func TestNewConsumerLocalBroker(t *testing.T) {
-	const expectedErr = "kafka: client has run out of available brokers to talk to (Is your cluster reachable?)"
+	const expectedErr = "kafka: client has run out of available brokers to talk to: dial tcp 127.0.0.1:9092: connect: connection refused"
 
 	// valid broker configuration for local Kafka instance
 	var brokerConfiguration = main.BrokerConfiguration{
@@ -259,3 +265,329 @@ func TestHandleCorrectMessage(t *testing.T) {
 	assert.Equal(t, uint64(1), dummyConsumer.GetNumberOfSuccessfullyConsumedMessages())
 	assert.Equal(t, uint64(0), dummyConsumer.GetNumberOfErrorsConsumingMessages())
 }
+
+// TestServeConsumesMessages function checks the whole flow from Serve via
+// ConsumeClaim to HandleMessage, including rebalance.
+func TestServeConsumesMessages(t *testing.T) {
+	decoders, err := main.NewDecoders([]main.TopicConfiguration{
+		{Name: "topic", Decoders: []string{"json"}},
+	})
+	assert.NoError(t, err)
+
+	group := newFakeConsumerGroup(t, "topic")
+
+	consumer := NewDummyConsumer()
+	consumer.ConsumerGroup = group
+	consumer.Decoders = decoders
+	done := serveInBackground(consumer)
+
+	// the first session with two partitions claimed
+	session := group.Rebalance(0, 1)
+	session.Send(0, []byte(`{"foo": "bar"}`))
+	session.Send(1, []byte(`not a JSON`))
+	session.Send(1, []byte(`{"foo": "baz"}`))
+	session.End()
+	session.Wait()
+
+	assert.Equal(t, uint64(2), consumer.GetNumberOfSuccessfullyConsumedMessages())
+	assert.Equal(t, uint64(1), consumer.GetNumberOfErrorsConsumingMessages())
+
+	// all messages are marked, even those that failed processing
+	assert.Equal(t, int64(1), session.MarkedOffset(0))
+	assert.Equal(t, int64(2), session.MarkedOffset(1))
+
+	// rebalance to just one partition
+	session = group.Rebalance(1)
+	session.Send(1, []byte(`{"foo": 1}`))
+	session.End()
+	session.Wait()
+
+	assert.Equal(t, uint64(3), consumer.GetNumberOfSuccessfullyConsumedMessages())
+	assert.Equal(t, uint64(1), consumer.GetNumberOfErrorsConsumingMessages())
+
+	assert.NoError(t, consumer.Close())
+	waitForServe(t, done)
+}
+
+// TestServeStopsOnClose function checks that session is finished when
+// consumer is closed during consuming.
+func TestServeStopsOnClose(t *testing.T) {
+	group := newFakeConsumerGroup(t, "topic")
+
+	consumer := NewDummyConsumer()
+	consumer.ConsumerGroup = group
+	done := serveInBackground(consumer)
+
+	session := group.Rebalance(0)
+	session.Send(0, []byte(`{}`))
+
+	assert.NoError(t, consumer.Close())
+	session.Wait()
+	waitForServe(t, done)
+
+	assert.Equal(t, uint64(1), consumer.GetNumberOfSuccessfullyConsumedMessages())
+}
+
+// TestServeConsumerGroupErrors function checks that errors reported by
+// consumer group via Errors channel are counted and do not stop consumer.
+func TestServeConsumerGroupErrors(t *testing.T) {
+	group := newFakeConsumerGroup(t, "topic")
+
+	consumer := NewDummyConsumer()
+	consumer.ConsumerGroup = group
+	done := serveInBackground(consumer)
+
+	session := group.Rebalance(0)
+	group.SendError(errors.New("offset commit failed"))
+	group.SendError(errors.New("offset commit failed"))
+
+	assert.Eventually(t, func() bool {
+		return consumer.GetNumberOfConsumerGroupErrors() == 2
+	}, harnessTimeout, 10*time.Millisecond)
+
+	// consumer still consumes messages
+	session.Send(0, []byte(`{}`))
+	session.End()
+	session.Wait()
+	assert.Equal(t, uint64(1), consumer.GetNumberOfSuccessfullyConsumedMessages())
+
+	assert.NoError(t, consumer.Close())
+	waitForServe(t, done)
+}
+
+// steppingClock is a clock that moves forward by given step each time the
+// actual time is read
+type steppingClock struct {
+	now  time.Time
+	step time.Duration
+}
+
+func (clock *steppingClock) Now() time.Time {
+	now := clock.now
+	clock.now = clock.now.Add(clock.step)
+	return now
+}
+
+// manualClock is a clock that is moved forward explicitly by test. It can
+// be shared by consumer and all its components.
+type manualClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+// newManualClock function constructs clock set to given time
+func newManualClock(now time.Time) *manualClock {
+	return &manualClock{now: now}
+}
+
+func (clock *manualClock) Now() time.Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	return clock.now
+}
+
+// Advance method moves clock forward by given duration
+func (clock *manualClock) Advance(duration time.Duration) {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	clock.now = clock.now.Add(duration)
+}
+
+// TestNewConsumerWithConsumerGroupFactory function checks that consumer
+// group is constructed by injected factory.
+func TestNewConsumerWithConsumerGroupFactory(t *testing.T) {
+	group := newFakeConsumerGroup(t, "topic")
+
+	brokerConfiguration := main.BrokerConfiguration{
+		Address: "kafka:9092",
+		Topic:   "topic",
+		Group:   "group",
+	}
+
+	var addresses []string
+	var groupID string
+	factory := func(addrs []string, id string, config *sarama.Config) (sarama.ConsumerGroup, error) {
+		addresses = addrs
+		groupID = id
+		return group, nil
+	}
+
+	consumer, err := main.NewConsumer(brokerConfiguration, false, main.WithConsumerGroupFactory(factory))
+	assert.NoError(t, err)
+	assert.Equal(t, group, consumer.ConsumerGroup)
+	assert.Equal(t, []string{"kafka:9092"}, addresses)
+	assert.Equal(t, "group", groupID)
+
+	done := serveInBackground(consumer)
+	session := group.Rebalance(0)
+	session.Send(0, []byte(`{}`))
+	session.End()
+	session.Wait()
+
+	assert.Equal(t, uint64(1), consumer.GetNumberOfSuccessfullyConsumedMessages())
+
+	assert.NoError(t, consumer.Close())
+	waitForServe(t, done)
+}
+
+// TestNewConsumerConsumerGroupFactoryError function checks that error
+// returned by consumer group factory is reported.
+func TestNewConsumerConsumerGroupFactoryError(t *testing.T) {
+	factory := func(addrs []string, id string, config *sarama.Config) (sarama.ConsumerGroup, error) {
+		return nil, errors.New("connection refused")
+	}
+
+	consumer, err := main.NewConsumer(main.BrokerConfiguration{}, false, main.WithConsumerGroupFactory(factory))
+	assert.EqualError(t, err, "connection refused")
+	assert.Nil(t, consumer)
+}
+
+// TestHandleMessageWithClockAndLogger function checks that injected clock is
+// used to measure processing time and that injected logger is used.
+func TestHandleMessageWithClockAndLogger(t *testing.T) {
+	group := newFakeConsumerGroup(t, "topic")
+	factory := func(addrs []string, id string, config *sarama.Config) (sarama.ConsumerGroup, error) {
+		return group, nil
+	}
+
+	clock := &steppingClock{
+		now:  time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+		step: 2 * time.Second,
+	}
+	buffer := new(bytes.Buffer)
+
+	consumer, err := main.NewConsumer(main.BrokerConfiguration{Topic: "topic"}, false,
+		main.WithConsumerGroupFactory(factory),
+		main.WithClock(clock),
+		main.WithLogger(zerolog.New(buffer)))
+	assert.NoError(t, err)
+
+	consumer.HandleMessage(&sarama.ConsumerMessage{Topic: "topic", Value: []byte(`{}`)})
+
+	assert.Contains(t, buffer.String(), "Processing of message took '2' seconds")
+	assert.Contains(t, buffer.String(), "Message length")
+}
+
+// TestDefaultPipelineStats function checks that default pipeline is
+// constructed just once, so statistic of its stages is not lost between
+// messages.
+func TestDefaultPipelineStats(t *testing.T) {
+	group := newFakeConsumerGroup(t, "topic")
+	factory := func(addrs []string, id string, config *sarama.Config) (sarama.ConsumerGroup, error) {
+		return group, nil
+	}
+
+	consumer, err := main.NewConsumer(main.BrokerConfiguration{Topic: "topic"}, false,
+		main.WithConsumerGroupFactory(factory))
+	assert.NoError(t, err)
+
+	consumer.HandleMessage(&sarama.ConsumerMessage{Topic: "topic", Value: []byte(`{}`)})
+	consumer.HandleMessage(&sarama.ConsumerMessage{Topic: "topic", Value: []byte(`{}`)})
+
+	assert.NotNil(t, consumer.Pipeline)
+	assert.Equal(t, uint64(2), consumer.Pipeline.Stats()["topic"][main.StageLog].Processed)
+}
+
+// TestNewConsumerRebalanceStrategy function checks that consumer group is
+// constructed with configured rebalance strategy and static membership.
+func TestNewConsumerRebalanceStrategy(t *testing.T) {
+	group := newFakeConsumerGroup(t, "topic")
+
+	var saramaConfig *sarama.Config
+	factory := func(addrs []string, id string, config *sarama.Config) (sarama.ConsumerGroup, error) {
+		saramaConfig = config
+		return group, nil
+	}
+
+	brokerConfiguration := main.BrokerConfiguration{
+		Address:           "kafka:9092",
+		Topic:             "topic",
+		Group:             "group",
+		RebalanceStrategy: main.RebalanceStrategySticky,
+		StaticMembership:  true,
+		InstanceID:        "monitor-0",
+		SessionTimeout:    5 * time.Minute,
+	}
+	providedConfig := sarama.NewConfig()
+	buffer := new(bytes.Buffer)
+
+	_, err := main.NewWithSaramaConfig(brokerConfiguration, providedConfig, false,
+		main.WithConsumerGroupFactory(factory),
+		main.WithLogger(zerolog.New(buffer)))
+	assert.NoError(t, err)
+
+	assert.Equal(t, sarama.BalanceStrategySticky, saramaConfig.Consumer.Group.Rebalance.Strategy)
+	assert.Equal(t, "monitor-0", saramaConfig.Consumer.Group.InstanceId)
+	assert.Equal(t, 5*time.Minute, saramaConfig.Consumer.Group.Session.Timeout)
+	// static membership needs newer protocol
+	assert.True(t, saramaConfig.Version.IsAtLeast(sarama.V2_3_0_0))
+	assert.Contains(t, buffer.String(), "Protocol version raised as required by static membership")
+	assert.NoError(t, saramaConfig.Validate())
+
+	// provided config is not changed
+	assert.Equal(t, sarama.BalanceStrategyRange, providedConfig.Consumer.Group.Rebalance.Strategy)
+	assert.Equal(t, "", providedConfig.Consumer.Group.InstanceId)
+	assert.Equal(t, 10*time.Second, providedConfig.Consumer.Group.Session.Timeout)
+}
+
+// TestNewConsumerShortSessionTimeout function checks that heartbeat
+// interval is shortened when session timeout is short.
+func TestNewConsumerShortSessionTimeout(t *testing.T) {
+	var saramaConfig *sarama.Config
+	factory := func(addrs []string, id string, config *sarama.Config) (sarama.ConsumerGroup, error) {
+		saramaConfig = config
+		return newFakeConsumerGroup(t, "topic"), nil
+	}
+
+	_, err := main.NewConsumer(main.BrokerConfiguration{Topic: "topic", SessionTimeout: 3 * time.Second}, false,
+		main.WithConsumerGroupFactory(factory))
+	assert.NoError(t, err)
+
+	assert.Equal(t, 3*time.Second, saramaConfig.Consumer.Group.Session.Timeout)
+	assert.Equal(t, time.Second, saramaConfig.Consumer.Group.Heartbeat.Interval)
+	assert.NoError(t, saramaConfig.Validate())
+}
+
+// TestNewConsumerUnknownRebalanceStrategy function checks that unknown
+// rebalance strategy is refused.
+func TestNewConsumerUnknownRebalanceStrategy(t *testing.T) {
+	factory := func(addrs []string, id string, config *sarama.Config) (sarama.ConsumerGroup, error) {
+		t.Fatal("consumer group should not be constructed")
+		return nil, nil
+	}
+
+	consumer, err := main.NewConsumer(main.BrokerConfiguration{RebalanceStrategy: "random"}, false,
+		main.WithConsumerGroupFactory(factory))
+	assert.EqualError(t, err, "unknown rebalance strategy 'random'")
+	assert.Nil(t, consumer)
+}
+
+// TestGroupInstanceID function checks how group instance ID is derived.
+func TestGroupInstanceID(t *testing.T) {
+	t.Setenv("POD_NAME", "insights-kafka-monitor-7d9f-x2k4")
+
+	// static membership is disabled
+	instanceID, err := main.GroupInstanceID(main.BrokerConfiguration{InstanceID: "monitor-0"})
+	assert.NoError(t, err)
+	assert.Equal(t, "", instanceID)
+
+	// configured ID has precedence
+	instanceID, err = main.GroupInstanceID(main.BrokerConfiguration{StaticMembership: true, InstanceID: "monitor-0"})
+	assert.NoError(t, err)
+	assert.Equal(t, "monitor-0", instanceID)
+
+	// ID is derived from pod name
+	instanceID, err = main.GroupInstanceID(main.BrokerConfiguration{StaticMembership: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "insights-kafka-monitor-7d9f-x2k4", instanceID)
+
+	// hostname is used when pod name is not known
+	t.Setenv("POD_NAME", "")
+	hostname, err := os.Hostname()
+	assert.NoError(t, err)
+	instanceID, err = main.GroupInstanceID(main.BrokerConfiguration{StaticMembership: true})
+	assert.NoError(t, err)
+	assert.Equal(t, hostname, instanceID)
+}
@@ -0,0 +1,331 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains implementation of correlation of messages
+// across topics. Messages flowing through pipeline of topics (for example
+// upload -> results -> notifications) are joined by ID extracted from each
+// message (from key, from header, or from JSON payload), as configured by
+// correlation_id rule for each topic in [[topics]] table (see id_rule.go). Arrival time of
+// each ID into each stage is tracked, so it is possible to report
+// stage-to-stage latency and number of messages dropped in each stage.
+// Tracked IDs are kept in bounded in-memory store.
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/rs/zerolog/log"
+)
+
+// Default correlation settings
+const (
+	defaultCorrelationTimeout    = 10 * time.Minute
+	defaultCorrelationMaxTracked = 100000
+)
+
+// CorrelationStageStats contains statistic about one stage of correlated
+// pipeline
+type CorrelationStageStats struct {
+	Topic string `json:"topic"`
+	// Seen is number of IDs that arrived into the stage
+	Seen uint64 `json:"seen"`
+	// MissingID is number of messages without correlation ID
+	MissingID uint64 `json:"missing_id"`
+	// Orphans is number of IDs that were not seen in previous stage
+	Orphans uint64 `json:"orphans"`
+	// Dropped is number of IDs that were last seen in the stage and did
+	// not arrive into next stage in timeout
+	Dropped uint64 `json:"dropped"`
+}
+
+// CorrelationTransitionStats contains statistic about latency between two
+// consecutive stages
+type CorrelationTransitionStats struct {
+	From           string        `json:"from"`
+	To             string        `json:"to"`
+	Count          uint64        `json:"count"`
+	AverageLatency time.Duration `json:"average_latency"`
+	MinLatency     time.Duration `json:"min_latency"`
+	MaxLatency     time.Duration `json:"max_latency"`
+
+	totalLatency time.Duration
+}
+
+// CorrelationStats contains statistic about all correlated stages
+type CorrelationStats struct {
+	Tracked     int                          `json:"tracked"`
+	Completed   uint64                       `json:"completed"`
+	Evicted     uint64                       `json:"evicted"`
+	Stages      []CorrelationStageStats      `json:"stages"`
+	Transitions []CorrelationTransitionStats `json:"transitions"`
+}
+
+// correlationStage represents one correlated topic and rule used to
+// extract ID from its messages
+type correlationStage struct {
+	topic string
+	rule  idRule
+}
+
+// correlatedID represents one tracked ID
+type correlatedID struct {
+	id       string
+	arrivals []time.Time
+	last     int
+	lastSeen time.Time
+	element  *list.Element
+}
+
+// Correlator joins messages from several topics by correlation ID
+type Correlator struct {
+	mutex       sync.Mutex
+	config      CorrelationConfiguration
+	stages      []correlationStage
+	stageIndex  map[string]int
+	tracked     map[string]*correlatedID
+	order       *list.List
+	clock       Clock
+	completed   uint64
+	evicted     uint64
+	stageStats  []CorrelationStageStats
+	transitions []CorrelationTransitionStats
+}
+
+// NewCorrelator constructs new correlator for stages from configuration.
+// Rules used to extract ID are taken from topics configuration.
+func NewCorrelator(config CorrelationConfiguration, topicsConfig []TopicConfiguration, clock Clock) (*Correlator, error) {
+	if len(config.Stages) < 2 {
+		return nil, fmt.Errorf("at least two correlation stages need to be configured")
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultCorrelationTimeout
+	}
+	if config.MaxTracked <= 0 {
+		config.MaxTracked = defaultCorrelationMaxTracked
+	}
+
+	rules := make(map[string]string, len(topicsConfig))
+	for _, topicConfig := range topicsConfig {
+		rules[topicConfig.Name] = topicConfig.CorrelationID
+	}
+
+	stages := make([]correlationStage, len(config.Stages))
+	stageIndex := make(map[string]int, len(config.Stages))
+	stageStats := make([]CorrelationStageStats, len(config.Stages))
+	for i, topic := range config.Stages {
+		if _, found := stageIndex[topic]; found {
+			return nil, fmt.Errorf("topic '%s' is used in more correlation stages", topic)
+		}
+		if rules[topic] == "" {
+			return nil, fmt.Errorf("topic '%s': correlation ID rule is not configured", topic)
+		}
+		rule, err := parseIDRule(rules[topic])
+		if err != nil {
+			return nil, fmt.Errorf("topic '%s': %v", topic, err)
+		}
+		stages[i] = correlationStage{topic: topic, rule: rule}
+		stageIndex[topic] = i
+		stageStats[i].Topic = topic
+	}
+
+	transitions := make([]CorrelationTransitionStats, len(stages)-1)
+	for i := range transitions {
+		transitions[i].From = stages[i].topic
+		transitions[i].To = stages[i+1].topic
+	}
+
+	return &Correlator{
+		config:      config,
+		stages:      stages,
+		stageIndex:  stageIndex,
+		tracked:     make(map[string]*correlatedID),
+		order:       list.New(),
+		clock:       clockOrDefault(clock),
+		stageStats:  stageStats,
+		transitions: transitions,
+	}, nil
+}
+
+// Topics method returns names of all correlated topics
+func (correlator *Correlator) Topics() []string {
+	topics := make([]string, len(correlator.stages))
+	copy(topics, correlator.config.Stages)
+	return topics
+}
+
+// HasTopic method checks if given topic is one of correlated stages
+func (correlator *Correlator) HasTopic(topic string) bool {
+	_, found := correlator.stageIndex[topic]
+	return found
+}
+
+// arrivalTime function returns time when message arrived into topic.
+// Message timestamp is used when it is available.
+func (correlator *Correlator) arrivalTime(msg *sarama.ConsumerMessage) time.Time {
+	if msg.Timestamp.IsZero() {
+		return correlator.clock.Now()
+	}
+	return msg.Timestamp
+}
+
+// Track method records arrival of message into its stage
+func (correlator *Correlator) Track(message *DecodedMessage) {
+	index, found := correlator.stageIndex[message.Message.Topic]
+	if !found {
+		return
+	}
+	stage := correlator.stages[index]
+	id := stage.rule.extract(message)
+	arrival := correlator.arrivalTime(message.Message)
+
+	correlator.mutex.Lock()
+	defer correlator.mutex.Unlock()
+
+	if id == "" {
+		correlator.stageStats[index].MissingID++
+		return
+	}
+	correlator.stageStats[index].Seen++
+
+	entry, found := correlator.tracked[id]
+	if !found {
+		if index > 0 {
+			correlator.stageStats[index].Orphans++
+		}
+		entry = &correlatedID{
+			id:       id,
+			arrivals: make([]time.Time, len(correlator.stages)),
+			last:     -1,
+		}
+		entry.element = correlator.order.PushBack(entry)
+		correlator.tracked[id] = entry
+		correlator.evict()
+	} else {
+		correlator.order.MoveToBack(entry.element)
+	}
+
+	entry.arrivals[index] = arrival
+	entry.lastSeen = correlator.clock.Now()
+	if index > 0 && !entry.arrivals[index-1].IsZero() {
+		correlator.transitions[index-1].add(arrival.Sub(entry.arrivals[index-1]))
+	}
+	if index > entry.last {
+		entry.last = index
+	}
+
+	// ID reached the last stage, so it does not need to be tracked anymore
+	if index == len(correlator.stages)-1 {
+		correlator.remove(entry)
+		correlator.completed++
+	}
+}
+
+// add method updates transition statistic by new latency
+func (transition *CorrelationTransitionStats) add(latency time.Duration) {
+	if transition.Count == 0 || latency < transition.MinLatency {
+		transition.MinLatency = latency
+	}
+	if latency > transition.MaxLatency {
+		transition.MaxLatency = latency
+	}
+	transition.Count++
+	transition.totalLatency += latency
+}
+
+// remove method stops tracking of given ID
+func (correlator *Correlator) remove(entry *correlatedID) {
+	correlator.order.Remove(entry.element)
+	delete(correlator.tracked, entry.id)
+}
+
+// evict method removes the least recently seen IDs when store is full
+func (correlator *Correlator) evict() {
+	for len(correlator.tracked) > correlator.config.MaxTracked {
+		oldest := correlator.order.Front().Value.(*correlatedID)
+		correlator.remove(oldest)
+		correlator.evicted++
+	}
+}
+
+// Expire method finds IDs that have not arrived into next stage in
+// configured timeout and counts them as dropped in the last stage they
+// were seen in
+func (correlator *Correlator) Expire() {
+	correlator.mutex.Lock()
+	defer correlator.mutex.Unlock()
+
+	deadline := correlator.clock.Now().Add(-correlator.config.Timeout)
+
+	// IDs are ordered by time they were last seen
+	for element := correlator.order.Front(); element != nil; element = correlator.order.Front() {
+		entry := element.Value.(*correlatedID)
+		if entry.lastSeen.After(deadline) {
+			break
+		}
+		correlator.remove(entry)
+		correlator.stageStats[entry.last].Dropped++
+
+		log.Debug().
+			Str("id", entry.id).
+			Str(topicKey, correlator.stages[entry.last].topic).
+			Msg("Correlated message dropped")
+	}
+}
+
+// Stats method returns actual correlation statistic
+func (correlator *Correlator) Stats() CorrelationStats {
+	correlator.mutex.Lock()
+	defer correlator.mutex.Unlock()
+
+	stats := CorrelationStats{
+		Tracked:     len(correlator.tracked),
+		Completed:   correlator.completed,
+		Evicted:     correlator.evicted,
+		Stages:      make([]CorrelationStageStats, len(correlator.stageStats)),
+		Transitions: make([]CorrelationTransitionStats, len(correlator.transitions)),
+	}
+	copy(stats.Stages, correlator.stageStats)
+	copy(stats.Transitions, correlator.transitions)
+
+	for i := range stats.Transitions {
+		transition := &stats.Transitions[i]
+		if transition.Count > 0 {
+			transition.AverageLatency = transition.totalLatency / time.Duration(transition.Count)
+		}
+	}
+	return stats
+}
+
+// Run method periodically expires IDs that have not arrived into next
+// stage. It blocks current thread.
+func (correlator *Correlator) Run() {
+	interval := correlator.config.Timeout / 10
+	if interval < time.Second {
+		interval = time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		correlator.Expire()
+	}
+}
@@ -0,0 +1,207 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Unit test definitions for functions and methods defined in source file
+// correlation.go
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-kafka-monitor"
+)
+
+// correlationStart is arrival time of the first correlated message
+var correlationStart = time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// correlationTopics function returns configuration of three correlated
+// topics, each using different rule to extract correlation ID
+func correlationTopics() []main.TopicConfiguration {
+	return []main.TopicConfiguration{
+		{Name: "upload", CorrelationID: "key"},
+		{Name: "results", CorrelationID: "header:request_id"},
+		{Name: "notifications", CorrelationID: "json:request.id"},
+	}
+}
+
+// newTestCorrelator function constructs correlator for three correlated
+// topics with clock controlled by test
+func newTestCorrelator(t *testing.T, maxTracked int, clock main.Clock) *main.Correlator {
+	correlator, err := main.NewCorrelator(main.CorrelationConfiguration{
+		Enabled:    true,
+		Stages:     []string{"upload", "results", "notifications"},
+		Timeout:    time.Minute,
+		MaxTracked: maxTracked,
+	}, correlationTopics(), clock)
+	assert.NoError(t, err)
+	return correlator
+}
+
+// correlatedMessage function constructs message for given topic that
+// arrived into the topic at given time
+func correlatedMessage(topic, key, header, content string, arrival time.Time) *main.DecodedMessage {
+	message := decodedMessage(topic, content)
+	message.Message.Key = []byte(key)
+	message.Message.Timestamp = arrival
+	if header != "" {
+		message.Message.Headers = []*sarama.RecordHeader{
+			{Key: []byte("request_id"), Value: []byte(header)},
+		}
+	}
+	return message
+}
+
+// TestCorrelatorLatency checks that stage-to-stage latency is computed for
+// message that passed through all stages
+func TestCorrelatorLatency(t *testing.T) {
+	clock := newManualClock(correlationStart)
+	correlator := newTestCorrelator(t, 0, clock)
+
+	correlator.Track(correlatedMessage("upload", "id1", "", `{}`, correlationStart))
+	correlator.Track(correlatedMessage("results", "", "id1", `{}`, correlationStart.Add(2*time.Second)))
+	correlator.Track(correlatedMessage("notifications", "", "", `{"request": {"id": "id1"}}`, correlationStart.Add(5*time.Second)))
+
+	stats := correlator.Stats()
+	assert.Equal(t, 0, stats.Tracked)
+	assert.Equal(t, uint64(1), stats.Completed)
+
+	assert.Len(t, stats.Transitions, 2)
+	assert.Equal(t, "upload", stats.Transitions[0].From)
+	assert.Equal(t, "results", stats.Transitions[0].To)
+	assert.Equal(t, uint64(1), stats.Transitions[0].Count)
+	assert.Equal(t, 2*time.Second, stats.Transitions[0].AverageLatency)
+	assert.Equal(t, 3*time.Second, stats.Transitions[1].MaxLatency)
+}
+
+// TestCorrelatorDropped checks that messages not seen in next stage in
+// timeout are counted as dropped in the last stage they were seen in
+func TestCorrelatorDropped(t *testing.T) {
+	clock := newManualClock(correlationStart)
+	correlator := newTestCorrelator(t, 0, clock)
+
+	correlator.Track(correlatedMessage("upload", "id1", "", `{}`, clock.Now()))
+	correlator.Track(correlatedMessage("upload", "id2", "", `{}`, clock.Now()))
+	correlator.Track(correlatedMessage("results", "", "id2", `{}`, clock.Now()))
+
+	// timeout has not been reached yet
+	clock.Advance(30 * time.Second)
+	correlator.Expire()
+	assert.Equal(t, 2, correlator.Stats().Tracked)
+
+	clock.Advance(time.Minute)
+	correlator.Expire()
+
+	stats := correlator.Stats()
+	assert.Equal(t, 0, stats.Tracked)
+	assert.Equal(t, uint64(1), stats.Stages[0].Dropped)
+	assert.Equal(t, uint64(1), stats.Stages[1].Dropped)
+	assert.Equal(t, uint64(0), stats.Stages[2].Dropped)
+}
+
+// TestCorrelatorOrphansAndMissingIDs checks that IDs not seen in previous
+// stage and messages without ID are counted
+func TestCorrelatorOrphansAndMissingIDs(t *testing.T) {
+	clock := newManualClock(correlationStart)
+	correlator := newTestCorrelator(t, 0, clock)
+
+	correlator.Track(correlatedMessage("results", "", "id1", `{}`, clock.Now()))
+	correlator.Track(correlatedMessage("notifications", "", "", `{"request": {}}`, clock.Now()))
+	correlator.Track(correlatedMessage("other", "id1", "", `{}`, clock.Now()))
+
+	stats := correlator.Stats()
+	assert.Equal(t, uint64(1), stats.Stages[1].Seen)
+	assert.Equal(t, uint64(1), stats.Stages[1].Orphans)
+	assert.Equal(t, uint64(1), stats.Stages[2].MissingID)
+	assert.Equal(t, 1, stats.Tracked)
+}
+
+// TestCorrelatorBoundedStore checks that the least recently seen IDs are
+// evicted when the store is full
+func TestCorrelatorBoundedStore(t *testing.T) {
+	clock := newManualClock(correlationStart)
+	correlator := newTestCorrelator(t, 2, clock)
+
+	correlator.Track(correlatedMessage("upload", "id1", "", `{}`, clock.Now()))
+	correlator.Track(correlatedMessage("upload", "id2", "", `{}`, clock.Now()))
+	correlator.Track(correlatedMessage("upload", "id3", "", `{}`, clock.Now()))
+
+	stats := correlator.Stats()
+	assert.Equal(t, 2, stats.Tracked)
+	assert.Equal(t, uint64(1), stats.Evicted)
+
+	// the first ID is not tracked anymore
+	correlator.Track(correlatedMessage("results", "", "id1", `{}`, clock.Now()))
+	assert.Equal(t, uint64(1), correlator.Stats().Stages[1].Orphans)
+}
+
+// TestNewCorrelatorImproperConfiguration checks that improper correlation
+// configuration is refused
+func TestNewCorrelatorImproperConfiguration(t *testing.T) {
+	topics := []main.TopicConfiguration{
+		{Name: "a", CorrelationID: "key"},
+		{Name: "b", CorrelationID: "header:"},
+		{Name: "c", CorrelationID: "xml:id"},
+	}
+
+	for stages, expected := range map[string]string{
+		"a":   "at least two correlation stages need to be configured",
+		"a,a": "topic 'a' is used in more correlation stages",
+		"a,b": "topic 'b': name needs to be specified in ID rule 'header:'",
+		"a,c": "topic 'c': unknown ID rule 'xml:id'",
+		"a,d": "topic 'd': correlation ID rule is not configured",
+	} {
+		_, err := main.NewCorrelator(main.CorrelationConfiguration{
+			Stages: strings.Split(stages, ","),
+		}, topics, nil)
+		assert.EqualError(t, err, expected, stages)
+	}
+}
+
+// TestCorrelateStage checks that correlate stage of pipeline tracks
+// messages consumed from correlated topics
+func TestCorrelateStage(t *testing.T) {
+	clock := newManualClock(correlationStart)
+	consumer := NewDummyConsumer()
+	consumer.Correlator = newTestCorrelator(t, 0, clock)
+
+	message := correlatedMessage("upload", "id1", "", `{}`, clock.Now())
+	assert.NoError(t, consumer.ProcessMessage(message.Message))
+
+	assert.Equal(t, uint64(1), consumer.Correlator.Stats().Stages[0].Seen)
+}
+
+// TestCorrelationEndpoint checks the correlation endpoint
+func TestCorrelationEndpoint(t *testing.T) {
+	consumer := NewDummyConsumer()
+	server := main.NewHTTPServer(main.ServerConfiguration{}, consumer)
+
+	response := performRequest(server, "/api/v1/correlation")
+	assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+
+	clock := newManualClock(correlationStart)
+	consumer.Correlator = newTestCorrelator(t, 0, clock)
+
+	response = performRequest(server, "/api/v1/correlation")
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Body.String(), `"from":"upload"`)
+}
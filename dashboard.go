@@ -0,0 +1,269 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains implementation of live dashboard. Dashboard
+// keeps throughput of each topic sampled in regular intervals (to be
+// displayed as sparklines), metadata of the most recently consumed
+// messages together with their payloads, and it provides actual state
+// including lag of all partitions and active alerts. State is pushed to
+// HTML page (see dashboard_page.go) via Server-Sent Events.
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// Default dashboard settings
+const (
+	defaultDashboardInterval    = 5 * time.Second
+	defaultDashboardPoints      = 60
+	defaultDashboardMessages    = 20
+	defaultDashboardAlertWindow = 15 * time.Minute
+)
+
+// DashboardMessage contains metadata of one consumed message
+type DashboardMessage struct {
+	// ID identifies message in dashboard, payload of message can be
+	// retrieved by this ID
+	ID        uint64    `json:"id"`
+	Topic     string    `json:"topic"`
+	Partition int32     `json:"partition"`
+	Offset    int64     `json:"offset"`
+	Timestamp time.Time `json:"timestamp"`
+	Key       string    `json:"key,omitempty"`
+	Size      int       `json:"size"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// DashboardTopic contains state of one topic displayed by dashboard
+type DashboardTopic struct {
+	Topic string `json:"topic"`
+	// Throughput contains message rates in sampled intervals, the oldest
+	// first
+	Throughput []float64           `json:"throughput"`
+	Partitions []PartitionProgress `json:"partitions"`
+}
+
+// DashboardState contains actual state displayed by dashboard
+type DashboardState struct {
+	Time     time.Time          `json:"time"`
+	Topics   []DashboardTopic   `json:"topics"`
+	Messages []DashboardMessage `json:"messages"`
+	Alerts   []Alert            `json:"alerts"`
+}
+
+// dashboardEntry contains recently consumed message together with its
+// payload
+type dashboardEntry struct {
+	metadata DashboardMessage
+	payload  []byte
+}
+
+// Dashboard collects data displayed by live dashboard
+type Dashboard struct {
+	mutex      sync.Mutex
+	config     DashboardConfiguration
+	alerts     *AlertManager
+	clock      Clock
+	nextID     uint64
+	entries    []dashboardEntry
+	counts     map[string]uint64
+	throughput map[string][]float64
+	sampled    time.Time
+	redactor   *Redactor
+}
+
+// NewDashboard constructs new dashboard
+func NewDashboard(config DashboardConfiguration, alerts *AlertManager, clock Clock) *Dashboard {
+	if config.Interval <= 0 {
+		config.Interval = defaultDashboardInterval
+	}
+	if config.Points <= 0 {
+		config.Points = defaultDashboardPoints
+	}
+	if config.Messages <= 0 {
+		config.Messages = defaultDashboardMessages
+	}
+	if config.AlertWindow <= 0 {
+		config.AlertWindow = defaultDashboardAlertWindow
+	}
+
+	clock = clockOrDefault(clock)
+	return &Dashboard{
+		config:     config,
+		alerts:     alerts,
+		clock:      clock,
+		entries:    make([]dashboardEntry, 0, config.Messages),
+		counts:     make(map[string]uint64),
+		throughput: make(map[string][]float64),
+		sampled:    clock.Now(),
+	}
+}
+
+// SetRedactor method sets redactor used to redact keys and payloads of
+// displayed messages
+func (dashboard *Dashboard) SetRedactor(redactor *Redactor) {
+	dashboard.redactor = redactor
+}
+
+// Interval method returns interval between two updates of dashboard
+func (dashboard *Dashboard) Interval() time.Duration {
+	return dashboard.config.Interval
+}
+
+// Record method records message consumed from topic
+func (dashboard *Dashboard) Record(msg *sarama.ConsumerMessage, processingError error) {
+	dashboard.mutex.Lock()
+	defer dashboard.mutex.Unlock()
+
+	dashboard.nextID++
+	entry := dashboardEntry{
+		metadata: DashboardMessage{
+			ID:        dashboard.nextID,
+			Topic:     msg.Topic,
+			Partition: msg.Partition,
+			Offset:    msg.Offset,
+			Timestamp: msg.Timestamp,
+			Key:       dashboard.redactor.RedactKey(string(msg.Key)),
+			Size:      len(msg.Value),
+		},
+		payload: dashboard.redactor.RedactPayload(msg.Value),
+	}
+	if processingError != nil {
+		entry.metadata.Error = dashboard.redactor.RedactString(processingError.Error())
+	}
+
+	if len(dashboard.entries) == dashboard.config.Messages {
+		// drop the oldest message
+		copy(dashboard.entries, dashboard.entries[1:])
+		dashboard.entries = dashboard.entries[:dashboard.config.Messages-1]
+	}
+	dashboard.entries = append(dashboard.entries, entry)
+
+	dashboard.counts[msg.Topic]++
+	if _, found := dashboard.throughput[msg.Topic]; !found {
+		dashboard.throughput[msg.Topic] = []float64{}
+	}
+}
+
+// Sample method computes message rate of all topics since previous sample
+// and appends it to throughput series
+func (dashboard *Dashboard) Sample() {
+	dashboard.mutex.Lock()
+	defer dashboard.mutex.Unlock()
+
+	now := dashboard.clock.Now()
+	elapsed := now.Sub(dashboard.sampled).Seconds()
+	dashboard.sampled = now
+
+	for topic, series := range dashboard.throughput {
+		rate := 0.0
+		if elapsed > 0 {
+			rate = float64(dashboard.counts[topic]) / elapsed
+		}
+		series = append(series, rate)
+		if len(series) > dashboard.config.Points {
+			series = series[len(series)-dashboard.config.Points:]
+		}
+		dashboard.throughput[topic] = series
+		dashboard.counts[topic] = 0
+	}
+}
+
+// State method returns actual state of dashboard. Lag of partitions is
+// taken from given consumer progress.
+func (dashboard *Dashboard) State(progress []PartitionProgress) DashboardState {
+	dashboard.mutex.Lock()
+	defer dashboard.mutex.Unlock()
+
+	now := dashboard.clock.Now()
+	state := DashboardState{
+		Time:     now,
+		Topics:   []DashboardTopic{},
+		Messages: make([]DashboardMessage, 0, len(dashboard.entries)),
+		Alerts:   []Alert{},
+	}
+
+	topics := make(map[string]*DashboardTopic)
+	topic := func(name string) *DashboardTopic {
+		if _, found := topics[name]; !found {
+			topics[name] = &DashboardTopic{
+				Topic:      name,
+				Throughput: []float64{},
+				Partitions: []PartitionProgress{},
+			}
+		}
+		return topics[name]
+	}
+	for name, series := range dashboard.throughput {
+		topic(name).Throughput = append([]float64{}, series...)
+	}
+	for _, partition := range progress {
+		t := topic(partition.Topic)
+		t.Partitions = append(t.Partitions, partition)
+	}
+	for _, t := range topics {
+		state.Topics = append(state.Topics, *t)
+	}
+	sort.Slice(state.Topics, func(i, j int) bool {
+		return state.Topics[i].Topic < state.Topics[j].Topic
+	})
+
+	// the newest messages first
+	for i := len(dashboard.entries) - 1; i >= 0; i-- {
+		state.Messages = append(state.Messages, dashboard.entries[i].metadata)
+	}
+
+	if dashboard.alerts != nil {
+		deadline := now.Add(-dashboard.config.AlertWindow)
+		recent := dashboard.alerts.Recent()
+		for i := len(recent) - 1; i >= 0 && recent[i].Time.After(deadline); i-- {
+			state.Alerts = append(state.Alerts, recent[i])
+		}
+	}
+
+	return state
+}
+
+// Payload method returns payload of recently consumed message with given
+// ID. False is returned when the message is not available anymore.
+func (dashboard *Dashboard) Payload(id uint64) (DashboardMessage, []byte, bool) {
+	dashboard.mutex.Lock()
+	defer dashboard.mutex.Unlock()
+
+	for _, entry := range dashboard.entries {
+		if entry.metadata.ID == id {
+			return entry.metadata, entry.payload, true
+		}
+	}
+	return DashboardMessage{}, nil, false
+}
+
+// Run method periodically samples throughput of all topics. It blocks
+// current thread.
+func (dashboard *Dashboard) Run() {
+	ticker := time.NewTicker(dashboard.config.Interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		dashboard.Sample()
+	}
+}
@@ -0,0 +1,179 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains HTML page of live dashboard. The page is
+// self-contained (styles, scripts and sparklines rendered as inline SVG),
+// so it works in clusters without access to external assets. Dashboard
+// state is received from events endpoint via Server-Sent Events and
+// payloads of messages are retrieved from message endpoint on click. Both
+// endpoints are addressed relatively to the page.
+
+// dashboardPage is HTML page of live dashboard
+const dashboardPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Insights Kafka monitor</title>
+<style>
+body { font-family: sans-serif; margin: 1em 2em; color: #222; }
+h1 { font-size: 1.4em; }
+h2 { font-size: 1.1em; margin-top: 1.5em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 0.2em 0.6em; text-align: left; font-size: 0.9em; }
+th { background: #f0f0f0; }
+td.number { text-align: right; }
+tr.error { background: #fde8e8; }
+tr.message { cursor: pointer; }
+tr.message:hover { background: #eef4ff; }
+.topic { display: inline-block; vertical-align: top; border: 1px solid #ccc; padding: 0.5em 1em; margin: 0 1em 1em 0; }
+.critical { color: #b00; font-weight: bold; }
+.warning { color: #b60; }
+#status { color: #888; font-size: 0.8em; }
+#payload { white-space: pre-wrap; background: #f8f8f8; border: 1px solid #ccc; padding: 0.5em; max-height: 20em; overflow: auto; }
+</style>
+</head>
+<body>
+<h1>Insights Kafka monitor</h1>
+<div id="status">connecting...</div>
+
+<h2>Topics</h2>
+<div id="topics"></div>
+
+<h2>Active alerts</h2>
+<table>
+<thead><tr><th>Time</th><th>Severity</th><th>Type</th><th>Topic</th><th>Message</th></tr></thead>
+<tbody id="alerts"></tbody>
+</table>
+
+<h2>Recent messages</h2>
+<table>
+<thead><tr><th>Topic</th><th>Partition</th><th>Offset</th><th>Timestamp</th><th>Key</th><th>Size</th><th>Error</th></tr></thead>
+<tbody id="messages"></tbody>
+</table>
+<pre id="payload" hidden></pre>
+
+<script>
+"use strict";
+
+function element(name, text, className) {
+  var e = document.createElement(name);
+  if (text !== undefined) { e.textContent = text; }
+  if (className) { e.className = className; }
+  return e;
+}
+
+function row(cells, className) {
+  var tr = element("tr", undefined, className);
+  cells.forEach(function (cell) {
+    var numeric = typeof cell === "number";
+    tr.appendChild(element("td", String(cell), numeric ? "number" : ""));
+  });
+  return tr;
+}
+
+function sparkline(values) {
+  var width = 200, height = 40;
+  var ns = "http://www.w3.org/2000/svg";
+  var svg = document.createElementNS(ns, "svg");
+  svg.setAttribute("width", width);
+  svg.setAttribute("height", height);
+  var max = Math.max.apply(null, values.concat([1]));
+  var step = values.length > 1 ? width / (values.length - 1) : width;
+  var points = values.map(function (value, i) {
+    return (i * step).toFixed(1) + "," + (height - 2 - value / max * (height - 4)).toFixed(1);
+  });
+  var line = document.createElementNS(ns, "polyline");
+  line.setAttribute("points", points.join(" "));
+  line.setAttribute("fill", "none");
+  line.setAttribute("stroke", "#36c");
+  line.setAttribute("stroke-width", "1.5");
+  svg.appendChild(line);
+  return svg;
+}
+
+function renderTopics(topics) {
+  var container = document.getElementById("topics");
+  container.textContent = "";
+  topics.forEach(function (topic) {
+    var div = element("div", undefined, "topic");
+    var rate = topic.throughput.length ? topic.throughput[topic.throughput.length - 1] : 0;
+    div.appendChild(element("h3", topic.topic));
+    div.appendChild(element("div", rate.toFixed(2) + " messages/s"));
+    div.appendChild(sparkline(topic.throughput));
+    var table = element("table");
+    table.appendChild(row(["Partition", "Offset", "High water mark", "Lag", "Errors"]));
+    topic.partitions.forEach(function (p) {
+      table.appendChild(row([p.partition, p.offset, p.high_water_mark, p.lag, p.errors]));
+    });
+    div.appendChild(table);
+    container.appendChild(div);
+  });
+}
+
+function renderAlerts(alerts) {
+  var body = document.getElementById("alerts");
+  body.textContent = "";
+  alerts.forEach(function (alert) {
+    var tr = row([alert.time, alert.severity, alert.type, alert.topic || "", alert.message]);
+    tr.children[1].className = alert.severity;
+    body.appendChild(tr);
+  });
+}
+
+function showPayload(id) {
+  var pre = document.getElementById("payload");
+  fetch("dashboard/message?id=" + id).then(function (response) {
+    return response.json();
+  }).then(function (data) {
+    pre.hidden = false;
+    if (data.value !== undefined) {
+      pre.textContent = data.value;
+    } else if (data.value_base64 !== undefined) {
+      pre.textContent = "(base64) " + data.value_base64;
+    } else {
+      pre.textContent = data.status || "";
+    }
+  });
+}
+
+function renderMessages(messages) {
+  var body = document.getElementById("messages");
+  body.textContent = "";
+  messages.forEach(function (m) {
+    var className = "message" + (m.error ? " error" : "");
+    var tr = row([m.topic, m.partition, m.offset, m.timestamp, m.key || "", m.size, m.error || ""], className);
+    tr.addEventListener("click", function () { showPayload(m.id); });
+    body.appendChild(tr);
+  });
+}
+
+var source = new EventSource("dashboard/events");
+source.onmessage = function (event) {
+  var state = JSON.parse(event.data);
+  document.getElementById("status").textContent = "updated " + state.time;
+  renderTopics(state.topics);
+  renderAlerts(state.alerts);
+  renderMessages(state.messages);
+};
+source.onerror = function () {
+  document.getElementById("status").textContent = "disconnected, reconnecting...";
+};
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,165 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Unit test definitions for functions and methods defined in source file
+// dashboard.go
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-kafka-monitor"
+)
+
+// TestDashboardState checks throughput sampling, recent messages and
+// active alerts
+func TestDashboardState(t *testing.T) {
+	clock := newManualClock(time.Date(2022, 3, 4, 5, 6, 0, 0, time.UTC))
+	alerts := main.NewAlertManager(nil)
+	dashboard := main.NewDashboard(main.DashboardConfiguration{
+		Points:      2,
+		Messages:    2,
+		AlertWindow: time.Minute,
+	}, alerts, clock)
+
+	for i := 0; i < 10; i++ {
+		dashboard.Record(consumerMessage(int64(i), []byte(`{}`)), nil)
+	}
+	clock.Advance(5 * time.Second)
+	dashboard.Sample()
+
+	dashboard.Record(consumerMessage(10, []byte(`x`)), errors.New("bad JSON"))
+	clock.Advance(5 * time.Second)
+	dashboard.Sample()
+	clock.Advance(5 * time.Second)
+	dashboard.Sample()
+
+	alerts.Raise(main.Alert{Time: clock.Now().Add(-2 * time.Minute), Type: "old", Message: "old"})
+	alerts.Raise(main.Alert{Time: clock.Now().Add(-time.Second), Type: "active", Message: "active"})
+
+	state := dashboard.State([]main.PartitionProgress{progress("topic", 1, 10, 15, 11)})
+	assert.Equal(t, clock.Now(), state.Time)
+
+	assert.Len(t, state.Topics, 1)
+	assert.Equal(t, "topic", state.Topics[0].Topic)
+	// just the newest samples are kept
+	assert.Equal(t, []float64{0.2, 0}, state.Topics[0].Throughput)
+	assert.Equal(t, int64(4), state.Topics[0].Partitions[0].Lag)
+
+	assert.Len(t, state.Messages, 2)
+	assert.Equal(t, int64(10), state.Messages[0].Offset)
+	assert.Equal(t, "bad JSON", state.Messages[0].Error)
+	assert.Equal(t, 1, state.Messages[0].Size)
+	assert.Equal(t, int64(9), state.Messages[1].Offset)
+
+	assert.Len(t, state.Alerts, 1)
+	assert.Equal(t, "active", state.Alerts[0].Type)
+}
+
+// TestDashboardPayload checks that payloads of recent messages are
+// available
+func TestDashboardPayload(t *testing.T) {
+	dashboard := main.NewDashboard(main.DashboardConfiguration{Messages: 1}, nil, nil)
+
+	dashboard.Record(consumerMessage(1, []byte(`{"first": true}`)), nil)
+	dashboard.Record(consumerMessage(2, []byte(`{"second": true}`)), nil)
+
+	_, _, found := dashboard.Payload(1)
+	assert.False(t, found)
+
+	metadata, payload, found := dashboard.Payload(2)
+	assert.True(t, found)
+	assert.Equal(t, int64(2), metadata.Offset)
+	assert.Equal(t, `{"second": true}`, string(payload))
+}
+
+// TestDashboardEndpoints checks the dashboard page and message endpoints
+func TestDashboardEndpoints(t *testing.T) {
+	consumer := NewDummyConsumer()
+	server := main.NewHTTPServer(main.ServerConfiguration{}, consumer)
+
+	for _, endpoint := range []string{"dashboard", "dashboard/events", "dashboard/message?id=1"} {
+		response := performRequest(server, "/api/v1/"+endpoint)
+		assert.Equal(t, http.StatusServiceUnavailable, response.Code, endpoint)
+	}
+
+	consumer.Dashboard = main.NewDashboard(main.DashboardConfiguration{}, consumer.Alerts, nil)
+	consumer.HandleMessage(consumerMessage(1, []byte(`{"foo": "bar"}`)))
+
+	response := performRequest(server, "/api/v1/dashboard")
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "text/html; charset=utf-8", response.Header().Get("Content-Type"))
+	assert.Contains(t, response.Body.String(), `new EventSource("dashboard/events")`)
+	// no external assets are referenced
+	assert.NotContains(t, response.Body.String(), "src=")
+	assert.NotContains(t, response.Body.String(), "href=")
+
+	response = performRequest(server, "/api/v1/dashboard/message?id=1")
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Body.String(), `"value":"{\"foo\": \"bar\"}"`)
+
+	response = performRequest(server, "/api/v1/dashboard/message?id=2")
+	assert.Equal(t, http.StatusNotFound, response.Code)
+
+	response = performRequest(server, "/api/v1/dashboard/message?id=foo")
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+}
+
+// TestDashboardEventsEndpoint checks that dashboard state is streamed via
+// Server-Sent Events
+func TestDashboardEventsEndpoint(t *testing.T) {
+	consumer := NewDummyConsumer()
+	consumer.Dashboard = main.NewDashboard(main.DashboardConfiguration{Interval: 10 * time.Millisecond}, consumer.Alerts, nil)
+	consumer.HandleMessage(consumerMessage(1, []byte(`{}`)))
+
+	server := httptest.NewServer(main.NewHTTPServer(main.ServerConfiguration{}, consumer).Handler())
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/api/v1/dashboard/events")
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, response.Body.Close())
+	}()
+	assert.Equal(t, "text/event-stream", response.Header.Get("Content-Type"))
+
+	// two events are read to check that the state is sent repeatedly
+	reader := bufio.NewReader(response.Body)
+	for i := 0; i < 2; i++ {
+		line, err := reader.ReadString('\n')
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(line, "data: "), line)
+
+		var state main.DashboardState
+		assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &state))
+		assert.Len(t, state.Messages, 1)
+		assert.Equal(t, "topic", state.Topics[0].Topic)
+
+		// events are separated by empty line
+		line, err = reader.ReadString('\n')
+		assert.NoError(t, err)
+		assert.Equal(t, "\n", line)
+	}
+}
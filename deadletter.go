@@ -0,0 +1,373 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains implementation of dead-letter capture. Messages
+// that failed processing are captured into configured sink - either into
+// local file in JSON Lines format or into dead-letter Kafka topic. Captured
+// messages can be listed and re-checked by -list-dead-letters and
+// -recheck-dead-letters commands, for example after schema or decoders
+// configuration has been fixed.
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/rs/zerolog/log"
+)
+
+// Dead-letter sinks
+const (
+	DeadLetterSinkFile  = "file"
+	DeadLetterSinkKafka = "kafka"
+)
+
+// Headers added to messages produced into dead-letter topic
+const (
+	DeadLetterErrorHeader     = "x-dead-letter-error"
+	DeadLetterTopicHeader     = "x-dead-letter-topic"
+	DeadLetterPartitionHeader = "x-dead-letter-partition"
+	DeadLetterOffsetHeader    = "x-dead-letter-offset"
+	DeadLetterTimestampHeader = "x-dead-letter-timestamp"
+)
+
+// Messages used by dead-letter capture
+const (
+	deadLetterCaptureMessage = "Unable to capture failed message"
+	deadLetterReadMessage    = "Read dead letters"
+	deadLetterCloseMessage   = "Closing dead-letter sink"
+)
+
+// maxDeadLetterLineSize is the maximum size of one line in dead-letter file
+const maxDeadLetterLineSize = 100 * 1024 * 1024
+
+// DeadLetterSink represents any sink that captures failed messages
+type DeadLetterSink interface {
+	Capture(msg *sarama.ConsumerMessage, processingError error) error
+	Close() error
+}
+
+// NewDeadLetterSink constructs dead-letter sink selected in configuration
+func NewDeadLetterSink(config DeadLetterConfiguration, brokerCfg BrokerConfiguration, clock Clock) (DeadLetterSink, error) {
+	switch config.Sink {
+	case DeadLetterSinkFile, "":
+		return NewFileDeadLetterSink(config.File, clock)
+	case DeadLetterSinkKafka:
+		producerConfig, err := headersSaramaConfig(brokerCfg)
+		if err != nil {
+			return nil, err
+		}
+		producer, err := sarama.NewSyncProducer([]string{brokerCfg.Address}, producerConfig)
+		if err != nil {
+			return nil, err
+		}
+		return NewKafkaDeadLetterSink(producer, config.Topic), nil
+	default:
+		return nil, fmt.Errorf("unknown dead-letter sink '%s'", config.Sink)
+	}
+}
+
+// FileDeadLetterSink captures failed messages into local file in JSON Lines
+// format
+type FileDeadLetterSink struct {
+	mutex sync.Mutex
+	file  *os.File
+	clock Clock
+}
+
+// NewFileDeadLetterSink constructs new dead-letter sink that appends failed
+// messages into given file. Time of capture is taken from given clock.
+func NewFileDeadLetterSink(fileName string, clock Clock) (*FileDeadLetterSink, error) {
+	if fileName == "" {
+		return nil, fmt.Errorf("dead-letter file is not configured")
+	}
+
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600) // #nosec G304
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileDeadLetterSink{file: file, clock: clockOrDefault(clock)}, nil
+}
+
+// Capture method appends failed message into dead-letter file
+func (sink *FileDeadLetterSink) Capture(msg *sarama.ConsumerMessage, processingError error) error {
+	line, err := json.Marshal(NewMessageRecord(msg, processingError, sink.clock.Now()))
+	if err != nil {
+		return err
+	}
+
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+
+	_, err = sink.file.Write(append(line, '\n'))
+	return err
+}
+
+// Close method closes dead-letter file
+func (sink *FileDeadLetterSink) Close() error {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+
+	return sink.file.Close()
+}
+
+// KafkaDeadLetterSink produces failed messages into dead-letter topic.
+// Original key, value, and headers are kept; error reason and original
+// coordinates are added as new headers.
+type KafkaDeadLetterSink struct {
+	producer sarama.SyncProducer
+	topic    string
+}
+
+// NewKafkaDeadLetterSink constructs new dead-letter sink that produces
+// failed messages into given topic by given producer
+func NewKafkaDeadLetterSink(producer sarama.SyncProducer, topic string) *KafkaDeadLetterSink {
+	return &KafkaDeadLetterSink{
+		producer: producer,
+		topic:    topic,
+	}
+}
+
+// Capture method produces failed message into dead-letter topic
+func (sink *KafkaDeadLetterSink) Capture(msg *sarama.ConsumerMessage, processingError error) error {
+	headers := make([]sarama.RecordHeader, 0, len(msg.Headers)+5)
+	for _, header := range msg.Headers {
+		if header != nil {
+			headers = append(headers, *header)
+		}
+	}
+
+	reason := ""
+	if processingError != nil {
+		reason = processingError.Error()
+	}
+
+	headers = append(headers,
+		recordHeader(DeadLetterErrorHeader, reason),
+		recordHeader(DeadLetterTopicHeader, msg.Topic),
+		recordHeader(DeadLetterPartitionHeader, strconv.FormatInt(int64(msg.Partition), 10)),
+		recordHeader(DeadLetterOffsetHeader, strconv.FormatInt(msg.Offset, 10)),
+		recordHeader(DeadLetterTimestampHeader, msg.Timestamp.Format(time.RFC3339Nano)),
+	)
+
+	producerMessage := &sarama.ProducerMessage{
+		Topic:   sink.topic,
+		Value:   sarama.ByteEncoder(msg.Value),
+		Headers: headers,
+	}
+	if msg.Key != nil {
+		producerMessage.Key = sarama.ByteEncoder(msg.Key)
+	}
+
+	_, _, err := sink.producer.SendMessage(producerMessage)
+	return err
+}
+
+// Close method closes producer used by dead-letter sink
+func (sink *KafkaDeadLetterSink) Close() error {
+	return sink.producer.Close()
+}
+
+// recordHeader function constructs record header from strings
+func recordHeader(key, value string) sarama.RecordHeader {
+	return sarama.RecordHeader{
+		Key:   []byte(key),
+		Value: []byte(value),
+	}
+}
+
+// deadLetterRecord function converts message read from dead-letter topic
+// into record with original coordinates
+func deadLetterRecord(msg *sarama.ConsumerMessage) MessageRecord {
+	record := NewMessageRecord(msg, nil, msg.Timestamp)
+	record.Headers = nil
+
+	for _, header := range msg.Headers {
+		if header == nil {
+			continue
+		}
+		value := string(header.Value)
+		switch string(header.Key) {
+		case DeadLetterErrorHeader:
+			record.Error = value
+		case DeadLetterTopicHeader:
+			record.Topic = value
+		case DeadLetterPartitionHeader:
+			partition, err := strconv.ParseInt(value, 10, 32)
+			if err == nil {
+				record.Partition = int32(partition)
+			}
+		case DeadLetterOffsetHeader:
+			offset, err := strconv.ParseInt(value, 10, 64)
+			if err == nil {
+				record.Offset = offset
+			}
+		case DeadLetterTimestampHeader:
+			timestamp, err := time.Parse(time.RFC3339Nano, value)
+			if err == nil {
+				record.Timestamp = timestamp
+			}
+		default:
+			record.Headers = append(record.Headers, MessageHeader{
+				Key:   string(header.Key),
+				Value: value,
+			})
+		}
+	}
+	return record
+}
+
+// readDeadLetterFile function reads all records stored in dead-letter file
+func readDeadLetterFile(fileName string) ([]MessageRecord, error) {
+	file, err := os.Open(fileName) // #nosec G304
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := file.Close()
+		if err != nil {
+			log.Error().Err(err).Str(filenameAttribute, fileName).Msg(deadLetterReadMessage)
+		}
+	}()
+
+	var records []MessageRecord
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, maxDeadLetterLineSize)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record MessageRecord
+		err := json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	return records, scanner.Err()
+}
+
+// readDeadLetterTopic function reads all records stored in dead-letter topic
+func readDeadLetterTopic(brokerCfg BrokerConfiguration, topic string) ([]MessageRecord, error) {
+	client, err := newReaderClient(brokerCfg)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := client.Close()
+		if err != nil {
+			log.Error().Err(err).Msg(closingBrokerConnectionMessage)
+		}
+	}()
+
+	var records []MessageRecord
+	err = readTopic(client, topic, func(msg *sarama.ConsumerMessage) bool {
+		records = append(records, deadLetterRecord(msg))
+		return true
+	})
+	return records, err
+}
+
+// readDeadLetters function reads all records captured by configured
+// dead-letter sink
+func readDeadLetters(config ConfigStruct) ([]MessageRecord, error) {
+	deadLetterConfig := GetDeadLetterConfiguration(&config)
+
+	switch deadLetterConfig.Sink {
+	case DeadLetterSinkFile, "":
+		return readDeadLetterFile(deadLetterConfig.File)
+	case DeadLetterSinkKafka:
+		return readDeadLetterTopic(GetBrokerConfiguration(&config), deadLetterConfig.Topic)
+	default:
+		return nil, fmt.Errorf("unknown dead-letter sink '%s'", deadLetterConfig.Sink)
+	}
+}
+
+// listDeadLetters function displays all messages captured by dead-letter
+// sink.
+func listDeadLetters(config ConfigStruct) (int, error) {
+	records, err := readDeadLetters(config)
+	if err != nil {
+		log.Error().Err(err).Msg(deadLetterReadMessage)
+		return ExitStatusError, err
+	}
+
+	w := newTableWriter()
+	fmt.Fprintln(w, "TOPIC\tPARTITION\tOFFSET\tTIMESTAMP\tSIZE\tERROR")
+	for _, record := range records {
+		msg := record.ConsumerMessage()
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%d\t%s\n",
+			record.Topic, record.Partition, record.Offset,
+			record.Timestamp.Format(time.RFC3339), len(msg.Value), record.Error)
+	}
+	err = w.Flush()
+	if err != nil {
+		return ExitStatusError, err
+	}
+
+	return ExitStatusOK, nil
+}
+
+// recheckDeadLetters function processes all messages captured by
+// dead-letter sink again using actual configuration and displays which of
+// them still fail.
+func recheckDeadLetters(config ConfigStruct) (int, error) {
+	records, err := readDeadLetters(config)
+	if err != nil {
+		log.Error().Err(err).Msg(deadLetterReadMessage)
+		return ExitStatusError, err
+	}
+
+	consumer, err := newOfflineConsumer(config)
+	if err != nil {
+		return ExitStatusError, err
+	}
+
+	failing := 0
+
+	w := newTableWriter()
+	fmt.Fprintln(w, "TOPIC\tPARTITION\tOFFSET\tRESULT\tERROR")
+	for _, record := range records {
+		result := "OK"
+		reason := ""
+		err := consumer.ProcessMessage(record.ConsumerMessage())
+		if err != nil {
+			result = "FAILED"
+			reason = consumer.Redactor.RedactError(err).Error()
+			failing++
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\n",
+			record.Topic, record.Partition, record.Offset, result, reason)
+	}
+	err = w.Flush()
+	if err != nil {
+		return ExitStatusError, err
+	}
+
+	fmt.Printf("\n%d of %d messages still fail\n", failing, len(records))
+
+	return ExitStatusOK, nil
+}
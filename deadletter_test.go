@@ -0,0 +1,256 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Unit test definitions for functions and methods defined in source file
+// deadletter.go
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/tisnik/go-capture"
+
+	main "github.com/RedHatInsights/insights-kafka-monitor"
+)
+
+// syncProducerMock is a sarama.SyncProducer that just remembers all
+// produced messages
+type syncProducerMock struct {
+	messages []*sarama.ProducerMessage
+	closed   bool
+}
+
+func (producer *syncProducerMock) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	producer.messages = append(producer.messages, msg)
+	return 0, int64(len(producer.messages) - 1), nil
+}
+
+func (producer *syncProducerMock) SendMessages(msgs []*sarama.ProducerMessage) error {
+	producer.messages = append(producer.messages, msgs...)
+	return nil
+}
+
+func (producer *syncProducerMock) Close() error {
+	producer.closed = true
+	return nil
+}
+
+// producedHeaders function converts headers of produced message into map
+func producedHeaders(msg *sarama.ProducerMessage) map[string]string {
+	headers := make(map[string]string)
+	for _, header := range msg.Headers {
+		headers[string(header.Key)] = string(header.Value)
+	}
+	return headers
+}
+
+// TestNewDeadLetterSinkUnknownSink checks that unknown sink is refused
+func TestNewDeadLetterSinkUnknownSink(t *testing.T) {
+	_, err := main.NewDeadLetterSink(main.DeadLetterConfiguration{Sink: "printer"}, main.BrokerConfiguration{}, nil)
+	assert.EqualError(t, err, "unknown dead-letter sink 'printer'")
+}
+
+// TestFileDeadLetterSinkNoFile checks that file needs to be configured for
+// file sink
+func TestFileDeadLetterSinkNoFile(t *testing.T) {
+	_, err := main.NewDeadLetterSink(main.DeadLetterConfiguration{Sink: main.DeadLetterSinkFile}, main.BrokerConfiguration{}, nil)
+	assert.EqualError(t, err, "dead-letter file is not configured")
+}
+
+// TestKafkaDeadLetterSink checks that failed message is produced into
+// dead-letter topic with error reason and original coordinates
+func TestKafkaDeadLetterSink(t *testing.T) {
+	producer := &syncProducerMock{}
+	sink := main.NewKafkaDeadLetterSink(producer, "dlq")
+
+	msg := consumerMessage(42, []byte("payload"))
+	assert.NoError(t, sink.Capture(msg, errors.New("invalid payload")))
+	assert.NoError(t, sink.Close())
+	assert.True(t, producer.closed)
+
+	assert.Len(t, producer.messages, 1)
+	produced := producer.messages[0]
+	assert.Equal(t, "dlq", produced.Topic)
+	assert.Equal(t, sarama.ByteEncoder("payload"), produced.Value)
+	assert.Equal(t, sarama.ByteEncoder("key"), produced.Key)
+
+	headers := producedHeaders(produced)
+	assert.Equal(t, "value", headers["header"])
+	assert.Equal(t, "invalid payload", headers[main.DeadLetterErrorHeader])
+	assert.Equal(t, "topic", headers[main.DeadLetterTopicHeader])
+	assert.Equal(t, "1", headers[main.DeadLetterPartitionHeader])
+	assert.Equal(t, "42", headers[main.DeadLetterOffsetHeader])
+	assert.Equal(t, "2022-01-02T03:04:05Z", headers[main.DeadLetterTimestampHeader])
+}
+
+// TestDeadLetterRecord checks that original coordinates are restored from
+// message read from dead-letter topic
+func TestDeadLetterRecord(t *testing.T) {
+	producer := &syncProducerMock{}
+	sink := main.NewKafkaDeadLetterSink(producer, "dlq")
+	assert.NoError(t, sink.Capture(consumerMessage(42, []byte("payload")), errors.New("invalid payload")))
+
+	produced := producer.messages[0]
+	msg := &sarama.ConsumerMessage{
+		Topic:     produced.Topic,
+		Partition: 0,
+		Offset:    0,
+		Key:       []byte("key"),
+		Value:     []byte("payload"),
+	}
+	for i := range produced.Headers {
+		msg.Headers = append(msg.Headers, &produced.Headers[i])
+	}
+
+	record := main.DeadLetterRecord(msg)
+	assert.Equal(t, "topic", record.Topic)
+	assert.Equal(t, int32(1), record.Partition)
+	assert.Equal(t, int64(42), record.Offset)
+	assert.Equal(t, 2022, record.Timestamp.Year())
+	assert.Equal(t, "invalid payload", record.Error)
+	assert.Equal(t, []main.MessageHeader{{Key: "header", Value: "value"}}, record.Headers)
+	assert.Equal(t, "payload", record.Value)
+}
+
+// TestListAndRecheckDeadLetters checks that messages captured into file
+// can be listed and re-checked with fixed configuration
+func TestListAndRecheckDeadLetters(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "dead_letters.jsonl")
+
+	sink, err := main.NewFileDeadLetterSink(fileName, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, sink.Capture(consumerMessage(1, []byte(`{"a":1}`)), errors.New("first failure")))
+	assert.NoError(t, sink.Capture(consumerMessage(2, []byte(`not a JSON`)), errors.New("second failure")))
+	assert.NoError(t, sink.Close())
+
+	config := main.ConfigStruct{
+		DeadLetter: main.DeadLetterConfiguration{
+			Sink: main.DeadLetterSinkFile,
+			File: fileName,
+		},
+		Topics: []main.TopicConfiguration{
+			{Name: "topic", Decoders: []string{"json"}},
+		},
+	}
+
+	output, err := capture.StandardOutput(func() {
+		code, err := main.ListDeadLetters(config)
+		assert.NoError(t, err)
+		assert.Equal(t, main.ExitStatusOK, code)
+	})
+	checkCapture(t, err)
+
+	assert.Contains(t, output, "first failure")
+	assert.Contains(t, output, "second failure")
+
+	output, err = capture.StandardOutput(func() {
+		code, err := main.RecheckDeadLetters(config)
+		assert.NoError(t, err)
+		assert.Equal(t, main.ExitStatusOK, code)
+	})
+	checkCapture(t, err)
+
+	assert.Regexp(t, `topic\s+1\s+1\s+OK`, output)
+	assert.Regexp(t, `topic\s+1\s+2\s+FAILED\s+json decoder`, output)
+	assert.Contains(t, output, "1 of 2 messages still fail")
+}
+
+// TestRecheckDeadLettersRedacted checks that failure reasons displayed by
+// re-check are redacted
+func TestRecheckDeadLettersRedacted(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "dead_letters.jsonl")
+
+	sink, err := main.NewFileDeadLetterSink(fileName, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, sink.Capture(consumerMessage(1, []byte(`not a JSON`)), errors.New("failure")))
+	assert.NoError(t, sink.Close())
+
+	config := main.ConfigStruct{
+		DeadLetter: main.DeadLetterConfiguration{
+			Sink: main.DeadLetterSinkFile,
+			File: fileName,
+		},
+		Redaction: main.RedactionConfiguration{
+			Enabled:  true,
+			Patterns: []string{"not valid JSON"},
+		},
+		Topics: []main.TopicConfiguration{
+			{Name: "topic", Decoders: []string{"json"}},
+		},
+	}
+
+	output, err := capture.StandardOutput(func() {
+		code, err := main.RecheckDeadLetters(config)
+		assert.NoError(t, err)
+		assert.Equal(t, main.ExitStatusOK, code)
+	})
+	checkCapture(t, err)
+
+	assert.Regexp(t, `topic\s+1\s+1\s+FAILED\s+json decoder`, output)
+	assert.Contains(t, output, "[REDACTED]")
+	assert.NotContains(t, output, "not valid JSON")
+}
+
+// TestListDeadLettersMissingFile checks that error is reported when
+// dead-letter file does not exist
+func TestListDeadLettersMissingFile(t *testing.T) {
+	config := main.ConfigStruct{
+		DeadLetter: main.DeadLetterConfiguration{
+			File: filepath.Join(t.TempDir(), "missing.jsonl"),
+		},
+	}
+
+	code, err := main.ListDeadLetters(config)
+	assert.Error(t, err)
+	assert.Equal(t, main.ExitStatusError, code)
+}
+
+// TestHandleMessageCapturesDeadLetter checks that message that failed
+// processing is captured by dead-letter sink
+func TestHandleMessageCapturesDeadLetter(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "dead_letters.jsonl")
+	sink, err := main.NewFileDeadLetterSink(fileName, nil)
+	assert.NoError(t, err)
+
+	decoders, err := main.NewDecoders([]main.TopicConfiguration{
+		{Name: "topic", Decoders: []string{"json"}},
+	})
+	assert.NoError(t, err)
+
+	consumer := main.KafkaConsumer{
+		Decoders:   decoders,
+		DeadLetter: sink,
+	}
+	consumer.HandleMessage(consumerMessage(1, []byte(`{"a":1}`)))
+	consumer.HandleMessage(consumerMessage(2, []byte(`not a JSON`)))
+	assert.NoError(t, consumer.Close())
+
+	output, err := capture.StandardOutput(func() {
+		_, err := main.ListDeadLetters(main.ConfigStruct{
+			DeadLetter: main.DeadLetterConfiguration{File: fileName},
+		})
+		assert.NoError(t, err)
+	})
+	checkCapture(t, err)
+
+	assert.NotRegexp(t, `topic\s+1\s+1\s+`, output)
+	assert.Regexp(t, `topic\s+1\s+2\s+`, output)
+}
@@ -0,0 +1,347 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains definition of Decoder interface and all built-in
+// decoders that can be used to transform message payload into a form that is
+// suitable for further processing. Decoders are selected per topic in
+// configuration file and they are applied in the order specified there:
+//
+// [[topics]]
+// name = "ccx.ocp.results"
+// decoders = ["base64", "gzip", "json"]
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"github.com/linkedin/goavro/v2"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Names of built-in decoders as used in configuration file
+const (
+	base64DecoderName   = "base64"
+	gzipDecoderName     = "gzip"
+	jsonDecoderName     = "json"
+	avroDecoderName     = "avro"
+	protobufDecoderName = "protobuf"
+)
+
+// maxFieldDepth is the maximum depth of nested objects that are walked when
+// fields of JSON content are inspected
+const maxFieldDepth = 5
+
+// maxDecompressedSize is the maximum size of decompressed payload. It
+// protects the monitor against decompression bombs.
+const maxDecompressedSize = 100 * 1024 * 1024
+
+// Decoder represents any decoder that is able to transform message payload
+// into another form, for example to decompress it or to convert it from
+// binary format into JSON.
+type Decoder interface {
+	Decode(payload []byte) ([]byte, error)
+}
+
+// DecoderChain is ordered list of decoders applied one after another on
+// message payload.
+type DecoderChain []Decoder
+
+// Decode method applies all decoders from the chain on given payload.
+func (chain DecoderChain) Decode(payload []byte) ([]byte, error) {
+	var err error
+	for _, decoder := range chain {
+		payload, err = decoder.Decode(payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return payload, nil
+}
+
+// DecodedMessage represents message consumed from Kafka together with its
+// decoded content. Decoded content is shared by all processing steps that
+// need to inspect message payload.
+type DecodedMessage struct {
+	Message *sarama.ConsumerMessage
+	Content []byte
+
+	parsed   bool
+	value    interface{}
+	parseErr error
+}
+
+// JSON method returns message content parsed as JSON. Content is parsed at
+// most once regardless how many times the method is called.
+func (message *DecodedMessage) JSON() (interface{}, error) {
+	if !message.parsed {
+		message.parseErr = json.Unmarshal(message.Content, &message.value)
+		message.parsed = true
+	}
+	return message.value, message.parseErr
+}
+
+// Field method returns value of field selected by path in dot notation, for
+// example "Report.ClusterName". The second return value is false when
+// content is not JSON object or when the field does not exist.
+func (message *DecodedMessage) Field(path string) (interface{}, bool) {
+	document, err := message.JSON()
+	if err != nil {
+		return nil, false
+	}
+
+	value := document
+	for _, name := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		value, ok = object[name]
+		if !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// WalkFields method calls given function for all fields of JSON object
+// stored in message content. Nested objects are walked recursively up to
+// maxFieldDepth levels, paths of nested fields are in dot notation. Nothing
+// is walked when content is not JSON object.
+func (message *DecodedMessage) WalkFields(visit func(path string, value interface{})) {
+	document, err := message.JSON()
+	if err != nil {
+		return
+	}
+	if object, ok := document.(map[string]interface{}); ok {
+		walkFields("", object, 1, visit)
+	}
+}
+
+// walkFields function calls given function for all fields of given object
+// and all nested objects
+func walkFields(prefix string, object map[string]interface{}, depth int, visit func(string, interface{})) {
+	for name, value := range object {
+		path := prefix + name
+		visit(path, value)
+
+		if nested, ok := value.(map[string]interface{}); ok && depth < maxFieldDepth {
+			walkFields(path+".", nested, depth+1, visit)
+		}
+	}
+}
+
+// Base64Decoder decodes payload encoded by standard Base64 encoding
+type Base64Decoder struct{}
+
+// Decode method decodes Base64-encoded payload
+func (Base64Decoder) Decode(payload []byte) ([]byte, error) {
+	payload = bytes.TrimSpace(payload)
+	decoded := make([]byte, base64.StdEncoding.DecodedLen(len(payload)))
+	n, err := base64.StdEncoding.Decode(decoded, payload)
+	if err != nil {
+		return nil, fmt.Errorf("base64 decoder: %v", err)
+	}
+	return decoded[:n], nil
+}
+
+// GzipDecoder decompresses payload compressed by gzip
+type GzipDecoder struct{}
+
+// Decode method decompresses gzipped payload
+func (GzipDecoder) Decode(payload []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("gzip decoder: %v", err)
+	}
+	defer func() {
+		// error is not interesting there as all data has been read
+		_ = reader.Close()
+	}()
+
+	decompressed, err := ioutil.ReadAll(io.LimitReader(reader, maxDecompressedSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("gzip decoder: %v", err)
+	}
+	if len(decompressed) > maxDecompressedSize {
+		return nil, fmt.Errorf("gzip decoder: decompressed payload exceeds %d bytes", maxDecompressedSize)
+	}
+	return decompressed, nil
+}
+
+// JSONDecoder checks that payload is valid JSON. Payload is not changed.
+type JSONDecoder struct{}
+
+// Decode method checks that payload is valid JSON
+func (JSONDecoder) Decode(payload []byte) ([]byte, error) {
+	if !json.Valid(payload) {
+		return nil, errors.New("json decoder: payload is not valid JSON")
+	}
+	return payload, nil
+}
+
+// AvroDecoder converts Avro binary payload into JSON
+type AvroDecoder struct {
+	codec *goavro.Codec
+}
+
+// NewAvroDecoder constructs Avro decoder that uses schema read from given
+// file
+func NewAvroDecoder(schemaFile string) (*AvroDecoder, error) {
+	schema, err := ioutil.ReadFile(schemaFile) // #nosec G304
+	if err != nil {
+		return nil, err
+	}
+
+	codec, err := goavro.NewCodec(string(schema))
+	if err != nil {
+		return nil, err
+	}
+
+	return &AvroDecoder{codec: codec}, nil
+}
+
+// Decode method converts Avro binary payload into JSON
+func (decoder *AvroDecoder) Decode(payload []byte) ([]byte, error) {
+	native, _, err := decoder.codec.NativeFromBinary(payload)
+	if err != nil {
+		return nil, fmt.Errorf("avro decoder: %v", err)
+	}
+
+	decoded, err := decoder.codec.TextualFromNative(nil, native)
+	if err != nil {
+		return nil, fmt.Errorf("avro decoder: %v", err)
+	}
+	return decoded, nil
+}
+
+// ProtobufDecoder converts Protobuf binary payload into JSON
+type ProtobufDecoder struct {
+	descriptor protoreflect.MessageDescriptor
+}
+
+// NewProtobufDecoder constructs Protobuf decoder for given message type. The
+// message type is looked up in file descriptor set read from given file.
+func NewProtobufDecoder(descriptorFile, messageName string) (*ProtobufDecoder, error) {
+	content, err := ioutil.ReadFile(descriptorFile) // #nosec G304
+	if err != nil {
+		return nil, err
+	}
+
+	var descriptorSet descriptorpb.FileDescriptorSet
+	err = proto.Unmarshal(content, &descriptorSet)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := protodesc.NewFiles(&descriptorSet)
+	if err != nil {
+		return nil, err
+	}
+
+	descriptor, err := files.FindDescriptorByName(protoreflect.FullName(messageName))
+	if err != nil {
+		return nil, err
+	}
+
+	messageDescriptor, ok := descriptor.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("'%s' is not a message type", messageName)
+	}
+
+	return &ProtobufDecoder{descriptor: messageDescriptor}, nil
+}
+
+// Decode method converts Protobuf binary payload into JSON
+func (decoder *ProtobufDecoder) Decode(payload []byte) ([]byte, error) {
+	message := dynamicpb.NewMessage(decoder.descriptor)
+
+	err := proto.Unmarshal(payload, message)
+	if err != nil {
+		return nil, fmt.Errorf("protobuf decoder: %v", err)
+	}
+
+	decoded, err := protojson.Marshal(message)
+	if err != nil {
+		return nil, fmt.Errorf("protobuf decoder: %v", err)
+	}
+	return decoded, nil
+}
+
+// NewDecoder function constructs decoder with given name. Topic
+// configuration is needed by decoders that use schema files.
+func NewDecoder(name string, topicConfig TopicConfiguration) (Decoder, error) {
+	switch name {
+	case base64DecoderName:
+		return Base64Decoder{}, nil
+	case gzipDecoderName:
+		return GzipDecoder{}, nil
+	case jsonDecoderName:
+		return JSONDecoder{}, nil
+	case avroDecoderName:
+		return NewAvroDecoder(topicConfig.AvroSchema)
+	case protobufDecoderName:
+		return NewProtobufDecoder(topicConfig.ProtobufDescriptor, topicConfig.ProtobufMessage)
+	default:
+		return nil, fmt.Errorf("unknown decoder '%s'", name)
+	}
+}
+
+// NewDecoderChain function constructs chain of decoders configured for
+// given topic.
+func NewDecoderChain(topicConfig TopicConfiguration) (DecoderChain, error) {
+	chain := make(DecoderChain, 0, len(topicConfig.Decoders))
+
+	for _, name := range topicConfig.Decoders {
+		decoder, err := NewDecoder(name, topicConfig)
+		if err != nil {
+			return nil, fmt.Errorf("topic '%s': %v", topicConfig.Name, err)
+		}
+		chain = append(chain, decoder)
+	}
+
+	return chain, nil
+}
+
+// NewDecoders function constructs decoder chains for all configured topics.
+// The returned map is indexed by topic name.
+func NewDecoders(topicsConfig []TopicConfiguration) (map[string]DecoderChain, error) {
+	decoders := make(map[string]DecoderChain, len(topicsConfig))
+
+	for _, topicConfig := range topicsConfig {
+		chain, err := NewDecoderChain(topicConfig)
+		if err != nil {
+			return nil, err
+		}
+		decoders[topicConfig.Name] = chain
+	}
+
+	return decoders, nil
+}
@@ -0,0 +1,247 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Unit test definitions for functions and methods defined in source file
+// decoder.go
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/linkedin/goavro/v2"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	main "github.com/RedHatInsights/insights-kafka-monitor"
+)
+
+const avroSchema = `{
+	"type": "record",
+	"name": "Report",
+	"fields": [
+		{"name": "OrgID", "type": "int"},
+		{"name": "ClusterName", "type": "string"}
+	]
+}`
+
+// gzipPayload function compresses given payload by gzip
+func gzipPayload(t *testing.T, payload string) []byte {
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	_, err := writer.Write([]byte(payload))
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+	return buffer.Bytes()
+}
+
+// writeTempFile function writes given content into file in temporary
+// directory and returns full path to such file
+func writeTempFile(t *testing.T, name string, content []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	err := ioutil.WriteFile(path, content, 0600)
+	assert.NoError(t, err)
+	return path
+}
+
+// TestDecoderChain checks decoding base64-wrapped gzip archive containing
+// JSON
+func TestDecoderChain(t *testing.T) {
+	chain, err := main.NewDecoderChain(main.TopicConfiguration{
+		Name:     "topic",
+		Decoders: []string{"base64", "gzip", "json"},
+	})
+	assert.NoError(t, err)
+
+	payload := base64.StdEncoding.EncodeToString(gzipPayload(t, `{"OrgID": 42}`))
+
+	decoded, err := chain.Decode([]byte(payload))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"OrgID": 42}`, string(decoded))
+}
+
+// TestDecoderChainUnknownDecoder checks that unknown decoder is reported
+func TestDecoderChainUnknownDecoder(t *testing.T) {
+	_, err := main.NewDecoderChain(main.TopicConfiguration{
+		Name:     "topic",
+		Decoders: []string{"xml"},
+	})
+	assert.EqualError(t, err, "topic 'topic': unknown decoder 'xml'")
+}
+
+// TestBase64DecoderImproperInput checks Base64 decoder for improper input
+func TestBase64DecoderImproperInput(t *testing.T) {
+	_, err := main.Base64Decoder{}.Decode([]byte("!@#$"))
+	assert.Error(t, err)
+}
+
+// TestGzipDecoderImproperInput checks gzip decoder for improper input
+func TestGzipDecoderImproperInput(t *testing.T) {
+	_, err := main.GzipDecoder{}.Decode([]byte("this is not gzip"))
+	assert.Error(t, err)
+}
+
+// TestJSONDecoder checks JSON decoder for proper and improper input
+func TestJSONDecoder(t *testing.T) {
+	decoded, err := main.JSONDecoder{}.Decode([]byte(`{"foo": "bar"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"foo": "bar"}`, string(decoded))
+
+	_, err = main.JSONDecoder{}.Decode([]byte(`[42`))
+	assert.EqualError(t, err, "json decoder: payload is not valid JSON")
+}
+
+// TestAvroDecoder checks conversion from Avro binary format into JSON
+func TestAvroDecoder(t *testing.T) {
+	schemaFile := writeTempFile(t, "schema.avsc", []byte(avroSchema))
+
+	decoder, err := main.NewAvroDecoder(schemaFile)
+	assert.NoError(t, err)
+
+	codec, err := goavro.NewCodec(avroSchema)
+	assert.NoError(t, err)
+	payload, err := codec.BinaryFromNative(nil, map[string]interface{}{
+		"OrgID":       42,
+		"ClusterName": "cluster",
+	})
+	assert.NoError(t, err)
+
+	decoded, err := decoder.Decode(payload)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"OrgID": 42, "ClusterName": "cluster"}`, string(decoded))
+
+	_, err = decoder.Decode([]byte{})
+	assert.Error(t, err)
+}
+
+// TestAvroDecoderMissingSchema checks that missing schema file is reported
+func TestAvroDecoderMissingSchema(t *testing.T) {
+	_, err := main.NewAvroDecoder("this_file_does_not_exist.avsc")
+	assert.Error(t, err)
+}
+
+// TestProtobufDecoder checks conversion from Protobuf binary format into
+// JSON
+func TestProtobufDecoder(t *testing.T) {
+	// descriptor for simple message with one field
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("report.proto"),
+		Package: proto.String("test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Report"),
+			Field: []*descriptorpb.FieldDescriptorProto{{
+				Name:     proto.String("cluster_name"),
+				JsonName: proto.String("clusterName"),
+				Number:   proto.Int32(1),
+				Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			}},
+		}},
+	}
+	descriptorSet, err := proto.Marshal(&descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{file},
+	})
+	assert.NoError(t, err)
+	descriptorFile := writeTempFile(t, "report.desc", descriptorSet)
+
+	decoder, err := main.NewProtobufDecoder(descriptorFile, "test.Report")
+	assert.NoError(t, err)
+
+	// prepare binary payload
+	fileDescriptor, err := protodesc.NewFile(file, nil)
+	assert.NoError(t, err)
+	messageDescriptor := fileDescriptor.Messages().ByName("Report")
+	message := dynamicpb.NewMessage(messageDescriptor)
+	message.Set(messageDescriptor.Fields().ByName("cluster_name"), protoreflect.ValueOfString("cluster"))
+	payload, err := proto.Marshal(message)
+	assert.NoError(t, err)
+
+	decoded, err := decoder.Decode(payload)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"clusterName": "cluster"}`, string(decoded))
+
+	_, err = main.NewProtobufDecoder(descriptorFile, "test.Unknown")
+	assert.Error(t, err)
+}
+
+// TestDecodeStage checks that decode stage applies decoders configured for
+// message topic on message payload
+func TestDecodeStage(t *testing.T) {
+	dummyConsumer := NewDummyConsumer()
+	dummyConsumer.Decoders = map[string]main.DecoderChain{
+		"topic": {main.Base64Decoder{}, main.JSONDecoder{}},
+	}
+	pipeline, err := main.NewPipeline(dummyConsumer, main.PipelineConfiguration{
+		Stages: []string{main.StageDecode},
+	}, nil)
+	assert.NoError(t, err)
+
+	message := decodedMessage("topic", base64.StdEncoding.EncodeToString([]byte(`{"foo": "bar"}`)))
+	assert.NoError(t, pipeline.Process(message))
+	assert.JSONEq(t, `{"foo": "bar"}`, string(message.Content))
+
+	// decoding failure is reported by the stage
+	assert.Error(t, pipeline.Process(decodedMessage("topic", "not base64!")))
+	assert.Equal(t, uint64(1), pipeline.Stats()["topic"][main.StageDecode].Errors)
+}
+
+// TestDecodeStageWithoutDecoders checks that payload is not changed when
+// no decoders are configured for topic
+func TestDecodeStageWithoutDecoders(t *testing.T) {
+	pipeline, err := main.NewPipeline(NewDummyConsumer(), main.PipelineConfiguration{
+		Stages: []string{main.StageDecode},
+	}, nil)
+	assert.NoError(t, err)
+
+	message := decodedMessage("topic", "payload")
+	assert.NoError(t, pipeline.Process(message))
+	assert.Equal(t, "payload", string(message.Content))
+	assert.False(t, pipeline.HasStage("topic", main.StageDecode))
+
+	// payload is not JSON
+	_, err = message.JSON()
+	assert.Error(t, err)
+}
+
+// TestHandleMessageDecodingError checks that decoding failure is counted as
+// processing error
+func TestHandleMessageDecodingError(t *testing.T) {
+	dummyConsumer := NewDummyConsumer()
+	dummyConsumer.Decoders = map[string]main.DecoderChain{
+		"topic": {main.JSONDecoder{}},
+	}
+
+	message := sarama.ConsumerMessage{
+		Topic: "topic",
+		Value: []byte(`[42`),
+	}
+
+	dummyConsumer.HandleMessage(&message)
+
+	assert.Equal(t, uint64(0), dummyConsumer.GetNumberOfSuccessfullyConsumedMessages())
+	assert.Equal(t, uint64(1), dummyConsumer.GetNumberOfErrorsConsumingMessages())
+}
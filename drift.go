@@ -0,0 +1,375 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains implementation of schema drift detector. The
+// detector learns baseline structure of payloads (set of fields and their
+// types) over warm-up window. After that it raises alerts when new fields
+// appear, required fields disappear or types of fields change. Field is
+// considered to be required when it was present in all messages consumed
+// during warm-up. Required field stays required when it disappears, alert
+// for missing field is just throttled. Learned baselines can be stored into
+// local file so they survive restarts.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Alert types raised by drift detector
+const (
+	AlertSchemaNewField     = "schema_new_field"
+	AlertSchemaMissingField = "schema_missing_field"
+	AlertSchemaTypeChanged  = "schema_type_changed"
+)
+
+// defaultWarmupMessages is used when neither number of messages nor period
+// is configured for warm-up
+const defaultWarmupMessages = 100
+
+// defaultDriftAlertInterval is the default minimal time between two alerts
+// for the same missing field
+const defaultDriftAlertInterval = 10 * time.Minute
+
+// Messages used by drift detector
+const (
+	baselineLearnedMessage = "Schema baseline learned"
+	baselineLoadMessage    = "Load schema baselines"
+	baselineStoreMessage   = "Store schema baselines"
+)
+
+// BaselineField represents one field of learned baseline structure
+type BaselineField struct {
+	Present  uint64          `json:"present"`
+	Types    map[string]bool `json:"types"`
+	Required bool            `json:"required"`
+}
+
+// Baseline represents learned structure of payloads consumed from one topic
+type Baseline struct {
+	Learned  bool                      `json:"learned"`
+	Messages uint64                    `json:"messages"`
+	Started  time.Time                 `json:"started"`
+	Fields   map[string]*BaselineField `json:"fields"`
+}
+
+// DriftDetector detects changes in payload structure
+type DriftDetector struct {
+	mutex     sync.Mutex
+	config    DriftConfiguration
+	baselines map[string]*Baseline
+	alerts    *AlertManager
+	clock     Clock
+	missing   *alertThrottle
+}
+
+// NewDriftDetector constructs new drift detector. Baselines are loaded from
+// file when the file is configured and exists.
+func NewDriftDetector(config DriftConfiguration, alerts *AlertManager, clock Clock) (*DriftDetector, error) {
+	if config.WarmupMessages == 0 && config.WarmupPeriod == 0 {
+		config.WarmupMessages = defaultWarmupMessages
+	}
+	if config.AlertInterval <= 0 {
+		config.AlertInterval = defaultDriftAlertInterval
+	}
+
+	clock = clockOrDefault(clock)
+	detector := &DriftDetector{
+		config:    config,
+		baselines: make(map[string]*Baseline),
+		alerts:    alerts,
+		clock:     clock,
+		missing:   newAlertThrottle(config.AlertInterval, clock),
+	}
+
+	if config.BaselineFile == "" {
+		return detector, nil
+	}
+
+	content, err := ioutil.ReadFile(config.BaselineFile)
+	if os.IsNotExist(err) {
+		// baselines will be learned
+		return detector, nil
+	}
+	if err != nil {
+		log.Error().Err(err).Str(filenameAttribute, config.BaselineFile).Msg(baselineLoadMessage)
+		return nil, err
+	}
+
+	err = json.Unmarshal(content, &detector.baselines)
+	if err != nil {
+		log.Error().Err(err).Str(filenameAttribute, config.BaselineFile).Msg(baselineLoadMessage)
+		return nil, err
+	}
+
+	log.Info().
+		Str(filenameAttribute, config.BaselineFile).
+		Int("topics", len(detector.baselines)).
+		Msg(baselineLoadMessage)
+
+	return detector, nil
+}
+
+// Baselines method returns copy of baselines for all topics
+func (detector *DriftDetector) Baselines() map[string]Baseline {
+	detector.mutex.Lock()
+	defer detector.mutex.Unlock()
+
+	baselines := make(map[string]Baseline, len(detector.baselines))
+	for topic, baseline := range detector.baselines {
+		fields := make(map[string]*BaselineField, len(baseline.Fields))
+		for path, field := range baseline.Fields {
+			types := make(map[string]bool, len(field.Types))
+			for valueType := range field.Types {
+				types[valueType] = true
+			}
+			fields[path] = &BaselineField{
+				Present:  field.Present,
+				Types:    types,
+				Required: field.Required,
+			}
+		}
+		copied := *baseline
+		copied.Fields = fields
+		baselines[topic] = copied
+	}
+	return baselines
+}
+
+// messageFields function returns types of all fields found in message
+func messageFields(message *DecodedMessage) map[string]string {
+	fields := make(map[string]string)
+	message.WalkFields(func(path string, value interface{}) {
+		fields[path] = jsonType(value)
+	})
+	return fields
+}
+
+// Check method updates baseline for message topic during warm-up and checks
+// message structure against the baseline after warm-up.
+func (detector *DriftDetector) Check(message *DecodedMessage) {
+	document, err := message.JSON()
+	if err != nil {
+		return
+	}
+	if _, ok := document.(map[string]interface{}); !ok {
+		return
+	}
+
+	fields := messageFields(message)
+	topic := message.Message.Topic
+
+	detector.mutex.Lock()
+	defer detector.mutex.Unlock()
+
+	baseline, found := detector.baselines[topic]
+	if !found {
+		baseline = &Baseline{
+			Started: detector.clock.Now(),
+			Fields:  make(map[string]*BaselineField),
+		}
+		detector.baselines[topic] = baseline
+	}
+
+	var changed bool
+	if baseline.Learned {
+		changed = detector.checkAgainstBaseline(topic, baseline, fields)
+	} else {
+		changed = detector.learn(topic, baseline, fields)
+	}
+
+	if changed {
+		detector.store()
+	}
+}
+
+// learn method updates baseline by fields found in message. It returns true
+// when warm-up has finished.
+func (detector *DriftDetector) learn(topic string, baseline *Baseline, fields map[string]string) bool {
+	baseline.Messages++
+	for path, valueType := range fields {
+		field, found := baseline.Fields[path]
+		if !found {
+			field = &BaselineField{Types: make(map[string]bool)}
+			baseline.Fields[path] = field
+		}
+		field.Present++
+		field.Types[valueType] = true
+	}
+
+	warmupMessagesReached := detector.config.WarmupMessages > 0 &&
+		baseline.Messages >= detector.config.WarmupMessages
+	warmupPeriodReached := detector.config.WarmupPeriod > 0 &&
+		detector.clock.Now().Sub(baseline.Started) >= detector.config.WarmupPeriod
+	if !warmupMessagesReached && !warmupPeriodReached {
+		return false
+	}
+
+	for _, field := range baseline.Fields {
+		field.Required = field.Present == baseline.Messages
+	}
+	baseline.Learned = true
+
+	log.Info().
+		Str(topicKey, topic).
+		Uint64("messages", baseline.Messages).
+		Int("fields", len(baseline.Fields)).
+		Msg(baselineLearnedMessage)
+
+	return true
+}
+
+// checkAgainstBaseline method compares fields found in message with learned
+// baseline and raises alert for each difference. New fields and changed
+// types are reported just once as the baseline is updated accordingly.
+// Missing required fields are reported at most once per alert interval for
+// each field. It returns true when baseline has been updated.
+func (detector *DriftDetector) checkAgainstBaseline(topic string, baseline *Baseline, fields map[string]string) bool {
+	changed := false
+
+	// new fields and changed types
+	for path, valueType := range fields {
+		field, found := baseline.Fields[path]
+		if !found {
+			detector.alerts.Raise(Alert{
+				Type:     AlertSchemaNewField,
+				Severity: SeverityWarning,
+				Topic:    topic,
+				Message:  fmt.Sprintf("New field '%s' appeared", path),
+				Details:  map[string]interface{}{"field": path, "type": valueType},
+			})
+			baseline.Fields[path] = &BaselineField{Types: map[string]bool{valueType: true}}
+			changed = true
+			continue
+		}
+
+		// null is allowed for optional fields
+		if !field.Types[valueType] && !(valueType == jsonTypeNull && !field.Required) {
+			detector.alerts.Raise(Alert{
+				Type:     AlertSchemaTypeChanged,
+				Severity: SeverityWarning,
+				Topic:    topic,
+				Message:  fmt.Sprintf("Type of field '%s' changed", path),
+				Details: map[string]interface{}{
+					"field":          path,
+					"type":           valueType,
+					"baseline_types": sortedTypes(field.Types),
+				},
+			})
+			field.Types[valueType] = true
+			changed = true
+		}
+	}
+
+	// missing required fields, sorted so parents are processed before
+	// their children
+	paths := make([]string, 0, len(baseline.Fields))
+	for path, field := range baseline.Fields {
+		if field.Required {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	var missing []string
+	for _, path := range paths {
+		if _, found := fields[path]; found || hasMissingParent(path, missing) {
+			continue
+		}
+		missing = append(missing, path)
+		allowed, suppressed := detector.missing.allow(topic + "/" + path)
+		if !allowed {
+			continue
+		}
+		detector.alerts.Raise(Alert{
+			Type:     AlertSchemaMissingField,
+			Severity: SeverityCritical,
+			Topic:    topic,
+			Message:  fmt.Sprintf("Required field '%s' is missing", path),
+			Details: map[string]interface{}{
+				"field":      path,
+				"suppressed": suppressed,
+			},
+		})
+	}
+
+	return changed
+}
+
+// hasMissingParent function checks if any parent of given field is in the
+// list of missing fields
+func hasMissingParent(path string, missing []string) bool {
+	for _, parent := range missing {
+		if strings.HasPrefix(path, parent+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedTypes function returns sorted list of types from set of types
+func sortedTypes(types map[string]bool) []string {
+	result := make([]string, 0, len(types))
+	for valueType := range types {
+		result = append(result, valueType)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// store method writes all baselines into configured file. File is written
+// atomically, so baselines can't be corrupted when the monitor is killed.
+func (detector *DriftDetector) store() {
+	if detector.config.BaselineFile == "" {
+		return
+	}
+
+	content, err := json.MarshalIndent(detector.baselines, "", "  ")
+	if err != nil {
+		log.Error().Err(err).Msg(baselineStoreMessage)
+		return
+	}
+
+	directory := filepath.Dir(detector.config.BaselineFile)
+	temporary, err := ioutil.TempFile(directory, ".baseline")
+	if err != nil {
+		log.Error().Err(err).Msg(baselineStoreMessage)
+		return
+	}
+
+	_, err = temporary.Write(content)
+	if closeErr := temporary.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temporary.Name(), detector.config.BaselineFile)
+	}
+	if err != nil {
+		log.Error().Err(err).Str(filenameAttribute, detector.config.BaselineFile).Msg(baselineStoreMessage)
+		// temporary file is not needed anymore
+		_ = os.Remove(temporary.Name())
+	}
+}
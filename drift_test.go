@@ -0,0 +1,161 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Unit test definitions for functions and methods defined in source file
+// drift.go
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-kafka-monitor"
+)
+
+// alertTypes function returns types of all alerts raised so far
+func alertTypes(manager *main.AlertManager) []string {
+	var types []string
+	for _, alert := range manager.Recent() {
+		types = append(types, alert.Type)
+	}
+	return types
+}
+
+// TestDriftDetectorWarmup checks that baseline is learned during warm-up
+// and no alerts are raised during it
+func TestDriftDetectorWarmup(t *testing.T) {
+	alerts := main.NewAlertManager(nil)
+	detector, err := main.NewDriftDetector(main.DriftConfiguration{WarmupMessages: 2}, alerts, nil)
+	assert.NoError(t, err)
+
+	detector.Check(decodedMessage("topic", `{"a": 1, "b": "x"}`))
+	assert.False(t, detector.Baselines()["topic"].Learned)
+
+	detector.Check(decodedMessage("topic", `{"a": 2, "c": true}`))
+	baseline := detector.Baselines()["topic"]
+	assert.True(t, baseline.Learned)
+	assert.True(t, baseline.Fields["a"].Required)
+	assert.False(t, baseline.Fields["b"].Required)
+	assert.False(t, baseline.Fields["c"].Required)
+
+	assert.Empty(t, alerts.Recent())
+}
+
+// TestDriftDetectorDrift checks all kinds of detected drifts
+func TestDriftDetectorDrift(t *testing.T) {
+	alerts := main.NewAlertManager(nil)
+	detector, err := main.NewDriftDetector(main.DriftConfiguration{WarmupMessages: 1}, alerts, nil)
+	assert.NoError(t, err)
+
+	detector.Check(decodedMessage("topic", `{"a": 1, "report": {"x": 1, "y": "z"}}`))
+
+	// the same structure - no drift
+	detector.Check(decodedMessage("topic", `{"a": 2, "report": {"x": 3, "y": "w"}}`))
+	assert.Empty(t, alerts.Recent())
+
+	// new field
+	detector.Check(decodedMessage("topic", `{"a": 2, "b": 3, "report": {"x": 3, "y": "w"}}`))
+	assert.Equal(t, []string{main.AlertSchemaNewField}, alertTypes(alerts))
+
+	// the same new field is reported just once
+	detector.Check(decodedMessage("topic", `{"a": 2, "b": 3, "report": {"x": 3, "y": "w"}}`))
+	assert.Len(t, alerts.Recent(), 1)
+
+	// type changed
+	detector.Check(decodedMessage("topic", `{"a": "2", "report": {"x": 3, "y": "w"}}`))
+	assert.Equal(t, main.AlertSchemaTypeChanged, alerts.Recent()[1].Type)
+
+	// missing required object is reported once, without its children
+	detector.Check(decodedMessage("topic", `{"a": 1}`))
+	recent := alerts.Recent()
+	assert.Len(t, recent, 3)
+	assert.Equal(t, main.AlertSchemaMissingField, recent[2].Type)
+	assert.Equal(t, "report", recent[2].Details["field"])
+}
+
+// TestDriftDetectorMissingFieldThrottled checks that missing required field
+// stays required and its alert is repeated after alert interval only
+func TestDriftDetectorMissingFieldThrottled(t *testing.T) {
+	clock := newManualClock(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	alerts := main.NewAlertManager(clock)
+	config := main.DriftConfiguration{WarmupMessages: 1, AlertInterval: time.Minute}
+	detector, err := main.NewDriftDetector(config, alerts, clock)
+	assert.NoError(t, err)
+
+	detector.Check(decodedMessage("topic", `{"a": 1, "b": 2}`))
+
+	detector.Check(decodedMessage("topic", `{"a": 1}`))
+	detector.Check(decodedMessage("topic", `{"a": 1}`))
+	assert.Equal(t, []string{main.AlertSchemaMissingField}, alertTypes(alerts))
+	assert.True(t, detector.Baselines()["topic"].Fields["b"].Required)
+
+	clock.Advance(time.Minute)
+	detector.Check(decodedMessage("topic", `{"a": 1}`))
+	recent := alerts.Recent()
+	assert.Len(t, recent, 2)
+	assert.Equal(t, main.AlertSchemaMissingField, recent[1].Type)
+	assert.Equal(t, uint64(1), recent[1].Details["suppressed"])
+	assert.Equal(t, clock.Now(), recent[1].Time)
+}
+
+// TestDriftDetectorNonJSON checks that non JSON messages are ignored
+func TestDriftDetectorNonJSON(t *testing.T) {
+	detector, err := main.NewDriftDetector(main.DriftConfiguration{}, nil, nil)
+	assert.NoError(t, err)
+
+	detector.Check(decodedMessage("topic", `this is not JSON`))
+	detector.Check(decodedMessage("topic", `[1, 2, 3]`))
+	assert.Empty(t, detector.Baselines())
+}
+
+// TestDriftDetectorPersistence checks that learned baseline survives
+// restart
+func TestDriftDetectorPersistence(t *testing.T) {
+	config := main.DriftConfiguration{
+		WarmupMessages: 1,
+		BaselineFile:   filepath.Join(t.TempDir(), "baseline.json"),
+	}
+
+	detector, err := main.NewDriftDetector(config, nil, nil)
+	assert.NoError(t, err)
+	detector.Check(decodedMessage("topic", `{"a": 1}`))
+
+	// new detector needs to load baseline from file
+	alerts := main.NewAlertManager(nil)
+	detector, err = main.NewDriftDetector(config, alerts, nil)
+	assert.NoError(t, err)
+	assert.True(t, detector.Baselines()["topic"].Learned)
+
+	detector.Check(decodedMessage("topic", `{"b": 1}`))
+	assert.ElementsMatch(t,
+		[]string{main.AlertSchemaNewField, main.AlertSchemaMissingField},
+		alertTypes(alerts))
+}
+
+// TestDriftDetectorImproperBaselineFile checks that improper baseline file
+// is reported
+func TestDriftDetectorImproperBaselineFile(t *testing.T) {
+	config := main.DriftConfiguration{
+		BaselineFile: writeTempFile(t, "baseline.json", []byte("{")),
+	}
+
+	_, err := main.NewDriftDetector(config, nil, nil)
+	assert.Error(t, err)
+}
@@ -27,4 +27,34 @@ var (
 	ShowAuthors         = showAuthors
 	ShowConfiguration   = showConfiguration
 	DoSelectedOperation = doSelectedOperation
+	TryToConnectToKafka = tryToConnectToKafka
+
+	// functions from the inventory.go source file
+	ListTopics    = listTopics
+	DescribeTopic = describeTopic
+	ListGroups    = listGroups
+	DescribeGroup = describeGroup
+
+	// functions from the deadletter.go source file
+	ListDeadLetters    = listDeadLetters
+	RecheckDeadLetters = recheckDeadLetters
+	DeadLetterRecord   = deadLetterRecord
+
+	// functions from the replay.go source file
+	Replay = replay
+
+	// functions from the history.go source file
+	History = history
+
+	// functions from the sequence.go source file
+	FetchCompactedTopics = fetchCompactedTopics
+
+	// functions from the size.go source file
+	FetchBrokerLimits = fetchBrokerLimits
+
+	// functions from the protocol.go source file
+	SelectProtocolVersion = selectProtocolVersion
+
+	// functions from the audit.go source file
+	AuditTopics = auditTopics
 )
@@ -4,10 +4,16 @@ go 1.14
 
 require (
 	github.com/BurntSushi/toml v1.0.0
-	github.com/Shopify/sarama v1.31.1
-	github.com/redhatinsights/app-common-go v1.6.0
+	github.com/Shopify/sarama v1.34.1
+	github.com/eapache/go-xerial-snappy v0.0.0-20180814174437-776d5712da21
+	github.com/klauspost/compress v1.15.6
+	github.com/linkedin/goavro/v2 v2.11.1
+	github.com/pierrec/lz4 v2.6.1+incompatible
 	github.com/rs/zerolog v1.26.1
 	github.com/spf13/viper v1.10.1
-	github.com/stretchr/testify v1.7.0
+	github.com/stretchr/testify v1.7.1
 	github.com/tisnik/go-capture v1.0.1
+	google.golang.org/protobuf v1.28.1
 )
+
+require github.com/frankban/quicktest v1.14.0 // indirect
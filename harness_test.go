@@ -0,0 +1,373 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Test harness that makes possible to test the whole consumer flow
+// (Serve -> Setup -> ConsumeClaim -> HandleMessage -> Cleanup) without real
+// Kafka. Fake consumer group is to be assigned into KafkaConsumer instead of
+// real sarama consumer group. Each consumer group session (i.e. each
+// rebalance) is started by test explicitly with selected set of claimed
+// partitions. Messages are then sent into claims one by one and session is
+// finished by test as well.
+//
+// Example:
+//
+//     group := newFakeConsumerGroup(t, "topic")
+//     consumer.ConsumerGroup = group
+//     go consumer.Serve()
+//
+//     session := group.Rebalance(0, 1)
+//     session.Send(0, []byte(`{"foo": "bar"}`))
+//     session.End()
+//     session.Wait()
+//
+// Asynchronous errors can be injected by SendError, they are reported via
+// Errors channel.
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// harnessTimeout is the maximum time the harness waits for any event
+const harnessTimeout = 5 * time.Second
+
+// harnessMessagesBuffer is size of buffer for messages sent into claim
+const harnessMessagesBuffer = 100
+
+// fakeConsumerGroup is an implementation of sarama.ConsumerGroup interface
+// driven by test
+type fakeConsumerGroup struct {
+	t          *testing.T
+	topic      string
+	sessions   chan *fakeSession
+	errors     chan error
+	closed     chan struct{}
+	closeOnce  sync.Once
+	generation int32
+}
+
+// newFakeConsumerGroup function constructs new fake consumer group that
+// provides claims for given topic
+func newFakeConsumerGroup(t *testing.T, topic string) *fakeConsumerGroup {
+	return &fakeConsumerGroup{
+		t:        t,
+		topic:    topic,
+		sessions: make(chan *fakeSession),
+		errors:   make(chan error, harnessMessagesBuffer),
+		closed:   make(chan struct{}),
+	}
+}
+
+// Consume method waits for next session started by test and runs it. It
+// returns when the session is finished, when the context is cancelled, or
+// when the group is closed.
+func (group *fakeConsumerGroup) Consume(ctx context.Context, topics []string, handler sarama.ConsumerGroupHandler) error {
+	var session *fakeSession
+	select {
+	case session = <-group.sessions:
+	case <-ctx.Done():
+		return nil
+	case <-group.closed:
+		return sarama.ErrClosedConsumerGroup
+	}
+
+	session.ctx = ctx
+	session.subscribed = topics
+	defer close(session.finished)
+
+	err := handler.Setup(session)
+	if err != nil {
+		return err
+	}
+
+	// claims are finished when context is cancelled, the same as for
+	// real consumer group
+	go func() {
+		select {
+		case <-ctx.Done():
+			session.End()
+		case <-session.ended:
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for _, claim := range session.claims {
+		wg.Add(1)
+		go func(claim *fakeClaim) {
+			defer wg.Done()
+			err := handler.ConsumeClaim(session, claim)
+			if err != nil {
+				group.errors <- err
+			}
+		}(claim)
+	}
+	wg.Wait()
+
+	return handler.Cleanup(session)
+}
+
+// SendError method reports error via Errors channel, the same as real
+// consumer group does for errors that happen outside of Consume
+func (group *fakeConsumerGroup) SendError(err error) {
+	group.errors <- err
+}
+
+// Errors method returns channel with errors returned by ConsumeClaim or
+// sent by SendError
+func (group *fakeConsumerGroup) Errors() <-chan error {
+	return group.errors
+}
+
+// Close method closes the consumer group
+func (group *fakeConsumerGroup) Close() error {
+	group.closeOnce.Do(func() {
+		close(group.closed)
+	})
+	return nil
+}
+
+// Pause method does nothing, pausing is not supported by the harness
+func (group *fakeConsumerGroup) Pause(partitions map[string][]int32) {
+}
+
+// Resume method does nothing, pausing is not supported by the harness
+func (group *fakeConsumerGroup) Resume(partitions map[string][]int32) {
+}
+
+// PauseAll method does nothing, pausing is not supported by the harness
+func (group *fakeConsumerGroup) PauseAll() {
+}
+
+// ResumeAll method does nothing, pausing is not supported by the harness
+func (group *fakeConsumerGroup) ResumeAll() {
+}
+
+// Rebalance method starts new consumer group session with given partitions
+// claimed. It blocks until the session is taken by Consume.
+func (group *fakeConsumerGroup) Rebalance(partitions ...int32) *fakeSession {
+	group.generation++
+
+	session := &fakeSession{
+		t:          group.t,
+		topic:      group.topic,
+		generation: group.generation,
+		claims:     make(map[int32]*fakeClaim, len(partitions)),
+		marked:     make(map[int32]int64),
+		ended:      make(chan struct{}),
+		finished:   make(chan struct{}),
+	}
+	for _, partition := range partitions {
+		session.claims[partition] = &fakeClaim{
+			topic:     group.topic,
+			partition: partition,
+			messages:  make(chan *sarama.ConsumerMessage, harnessMessagesBuffer),
+		}
+	}
+
+	select {
+	case group.sessions <- session:
+	case <-time.After(harnessTimeout):
+		group.t.Fatal("consumer group session has not been started")
+	}
+
+	return session
+}
+
+// fakeSession is an implementation of sarama.ConsumerGroupSession
+// interface driven by test
+type fakeSession struct {
+	t          *testing.T
+	ctx        context.Context
+	topic      string
+	subscribed []string
+	generation int32
+	claims     map[int32]*fakeClaim
+	mutex      sync.Mutex
+	marked     map[int32]int64
+	endOnce    sync.Once
+	ended      chan struct{}
+	finished   chan struct{}
+}
+
+// Claims method returns all claimed partitions
+func (session *fakeSession) Claims() map[string][]int32 {
+	partitions := make([]int32, 0, len(session.claims))
+	for partition := range session.claims {
+		partitions = append(partitions, partition)
+	}
+	return map[string][]int32{session.topic: partitions}
+}
+
+// MemberID method returns member ID of the consumer
+func (session *fakeSession) MemberID() string {
+	return "fake-member"
+}
+
+// GenerationID method returns generation of the session
+func (session *fakeSession) GenerationID() int32 {
+	return session.generation
+}
+
+// MarkOffset method marks offset as consumed
+func (session *fakeSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	if offset > session.marked[partition] {
+		session.marked[partition] = offset
+	}
+}
+
+// Commit method does nothing as offsets are not committed anywhere
+func (session *fakeSession) Commit() {
+}
+
+// ResetOffset method resets marked offset
+func (session *fakeSession) ResetOffset(topic string, partition int32, offset int64, metadata string) {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	session.marked[partition] = offset
+}
+
+// MarkMessage method marks message as consumed
+func (session *fakeSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	session.MarkOffset(msg.Topic, msg.Partition, msg.Offset+1, metadata)
+}
+
+// Context method returns context of the session
+func (session *fakeSession) Context() context.Context {
+	return session.ctx
+}
+
+// Send method sends new message into claim for given partition. Offset of
+// the message is assigned automatically.
+func (session *fakeSession) Send(partition int32, value []byte) *sarama.ConsumerMessage {
+	claim, found := session.claims[partition]
+	if !found {
+		session.t.Fatalf("partition %d is not claimed", partition)
+	}
+
+	msg := &sarama.ConsumerMessage{
+		Topic:     session.topic,
+		Partition: partition,
+		Offset:    atomic.AddInt64(&claim.nextOffset, 1) - 1,
+		Timestamp: time.Now(),
+		Value:     value,
+	}
+
+	select {
+	case claim.messages <- msg:
+	case <-time.After(harnessTimeout):
+		session.t.Fatalf("message can not be sent into partition %d", partition)
+	}
+	return msg
+}
+
+// End method finishes all claims, i.e. simulates rebalance or shutdown
+func (session *fakeSession) End() {
+	session.endOnce.Do(func() {
+		for _, claim := range session.claims {
+			close(claim.messages)
+		}
+		close(session.ended)
+	})
+}
+
+// Wait method waits until the session is finished by consumer
+func (session *fakeSession) Wait() {
+	select {
+	case <-session.finished:
+	case <-time.After(harnessTimeout):
+		session.t.Fatal("consumer group session has not been finished")
+	}
+}
+
+// SubscribedTopics method returns topics passed to Consume by consumer
+func (session *fakeSession) SubscribedTopics() []string {
+	return session.subscribed
+}
+
+// MarkedOffset method returns the next offset to be consumed from given
+// partition, as marked by consumer
+func (session *fakeSession) MarkedOffset(partition int32) int64 {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	return session.marked[partition]
+}
+
+// fakeClaim is an implementation of sarama.ConsumerGroupClaim interface
+type fakeClaim struct {
+	topic      string
+	partition  int32
+	nextOffset int64
+	messages   chan *sarama.ConsumerMessage
+}
+
+// Topic method returns claimed topic
+func (claim *fakeClaim) Topic() string {
+	return claim.topic
+}
+
+// Partition method returns claimed partition
+func (claim *fakeClaim) Partition() int32 {
+	return claim.partition
+}
+
+// InitialOffset method returns offset of the first message in claim
+func (claim *fakeClaim) InitialOffset() int64 {
+	return 0
+}
+
+// HighWaterMarkOffset method returns offset of the next message to be sent
+// into the claim
+func (claim *fakeClaim) HighWaterMarkOffset() int64 {
+	return atomic.LoadInt64(&claim.nextOffset)
+}
+
+// Messages method returns channel with messages
+func (claim *fakeClaim) Messages() <-chan *sarama.ConsumerMessage {
+	return claim.messages
+}
+
+// serveInBackground function starts consumer in background. Returned
+// channel is closed when Serve finishes.
+func serveInBackground(consumer interface{ Serve() }) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		consumer.Serve()
+	}()
+	return done
+}
+
+// waitForServe function waits until Serve started by serveInBackground
+// finishes
+func waitForServe(t *testing.T, done <-chan struct{}) {
+	select {
+	case <-done:
+	case <-time.After(harnessTimeout):
+		t.Fatal("Serve has not been finished")
+	}
+}
@@ -0,0 +1,386 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains implementation of history of consumer
+// progress. Snapshots of per-partition offsets, high water marks, lag,
+// throughput and error counts are periodically appended into local
+// directory in JSON Lines format, one file per day. Files older than
+// configured retention are removed. Stored snapshots can be queried by
+// -history command and via REST API, so questions like "what did lag look
+// like last night" can be answered after the fact.
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Default history settings
+const (
+	defaultHistoryInterval  = time.Minute
+	defaultHistoryRetention = 7 * 24 * time.Hour
+	defaultHistoryRange     = 24 * time.Hour
+)
+
+// History file naming
+const (
+	historyFilePrefix     = "history-"
+	historyFileSuffix     = ".jsonl"
+	historyFileTimeFormat = "20060102"
+)
+
+// Messages used by history store
+const (
+	historyWriteMessage   = "Unable to write snapshot into history"
+	historyReadMessage    = "Unable to read history"
+	historyCleanupMessage = "History cleanup"
+)
+
+// PartitionSnapshot contains progress of consumer in one partition at the
+// time the snapshot was taken
+type PartitionSnapshot struct {
+	PartitionProgress
+	// MessagesPerSec is rate of messages consumed from partition since
+	// previous snapshot
+	MessagesPerSec float64 `json:"messages_per_sec"`
+}
+
+// HistorySnapshot contains progress of consumer in all partitions at given
+// time
+type HistorySnapshot struct {
+	Time       time.Time           `json:"time"`
+	Consumed   uint64              `json:"consumed"`
+	Errors     uint64              `json:"errors"`
+	Partitions []PartitionSnapshot `json:"partitions"`
+}
+
+// HistoryStore stores snapshots of consumer progress into local directory
+type HistoryStore struct {
+	mutex    sync.Mutex
+	config   HistoryConfiguration
+	clock    Clock
+	previous map[partitionID]PartitionProgress
+	taken    time.Time
+}
+
+// NewHistoryStore constructs new history store. History directory is
+// created if it does not exist.
+func NewHistoryStore(config HistoryConfiguration, clock Clock) (*HistoryStore, error) {
+	if config.Directory == "" {
+		return nil, fmt.Errorf("history directory is not configured")
+	}
+	if config.Interval <= 0 {
+		config.Interval = defaultHistoryInterval
+	}
+	if config.Retention <= 0 {
+		config.Retention = defaultHistoryRetention
+	}
+
+	err := os.MkdirAll(config.Directory, 0750)
+	if err != nil {
+		return nil, err
+	}
+
+	return &HistoryStore{
+		config:   config,
+		clock:    clockOrDefault(clock),
+		previous: make(map[partitionID]PartitionProgress),
+	}, nil
+}
+
+// Record method takes snapshot from actual consumer progress, computes
+// message rates since previous snapshot, and appends the snapshot into
+// history file
+func (store *HistoryStore) Record(progress []PartitionProgress, consumed, errors uint64) (HistorySnapshot, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	now := store.clock.Now()
+	elapsed := now.Sub(store.taken).Seconds()
+
+	snapshot := HistorySnapshot{
+		Time:       now.UTC(),
+		Consumed:   consumed,
+		Errors:     errors,
+		Partitions: make([]PartitionSnapshot, 0, len(progress)),
+	}
+	for _, partition := range progress {
+		partitionSnapshot := PartitionSnapshot{PartitionProgress: partition}
+		id := partitionID{topic: partition.Topic, partition: partition.Partition}
+		if previous, found := store.previous[id]; found && elapsed > 0 && partition.Messages >= previous.Messages {
+			partitionSnapshot.MessagesPerSec = float64(partition.Messages-previous.Messages) / elapsed
+		}
+		store.previous[id] = partition
+		snapshot.Partitions = append(snapshot.Partitions, partitionSnapshot)
+	}
+	store.taken = now
+
+	line, err := json.Marshal(snapshot)
+	if err != nil {
+		return snapshot, err
+	}
+	line = append(line, '\n')
+
+	fileName := store.fileName(now)
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600) // #nosec G304
+	if err != nil {
+		return snapshot, err
+	}
+	_, err = file.Write(line)
+	if err != nil {
+		// write error is more important than close error
+		_ = file.Close()
+		return snapshot, err
+	}
+	return snapshot, file.Close()
+}
+
+// fileName method returns name of history file for given time
+func (store *HistoryStore) fileName(t time.Time) string {
+	name := historyFilePrefix + t.UTC().Format(historyFileTimeFormat) + historyFileSuffix
+	return filepath.Join(store.config.Directory, name)
+}
+
+// HistoryFiles function returns names of all history files stored in given
+// directory, the oldest first
+func HistoryFiles(directory string) ([]string, error) {
+	entries, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && strings.HasPrefix(name, historyFilePrefix) && strings.HasSuffix(name, historyFileSuffix) {
+			files = append(files, filepath.Join(directory, name))
+		}
+	}
+
+	// file names contain date, so they can be sorted by name
+	sort.Strings(files)
+	return files, nil
+}
+
+// historyFileDate function returns date contained in name of history file
+func historyFileDate(fileName string) (time.Time, error) {
+	name := filepath.Base(fileName)
+	name = strings.TrimSuffix(strings.TrimPrefix(name, historyFilePrefix), historyFileSuffix)
+	return time.Parse(historyFileTimeFormat, name)
+}
+
+// Cleanup method removes history files that contain just snapshots older
+// than configured retention
+func (store *HistoryStore) Cleanup() {
+	files, err := HistoryFiles(store.config.Directory)
+	if err != nil {
+		log.Error().Err(err).Msg(historyCleanupMessage)
+		return
+	}
+
+	deadline := store.clock.Now().Add(-store.config.Retention)
+	for _, name := range files {
+		date, err := historyFileDate(name)
+		if err != nil {
+			continue
+		}
+		// file contains snapshots taken during the whole day
+		if date.Add(24 * time.Hour).After(deadline) {
+			continue
+		}
+		err = os.Remove(name)
+		if err != nil {
+			log.Error().Err(err).Str(filenameAttribute, name).Msg(historyCleanupMessage)
+			continue
+		}
+		log.Info().Str(filenameAttribute, name).Msg("History file removed")
+	}
+}
+
+// Query method returns snapshots taken in given time range (including
+// both bounds), the oldest first. When topic is not empty, just partitions
+// of given topic are included in snapshots.
+func (store *HistoryStore) Query(start, end time.Time, topic string) ([]HistorySnapshot, error) {
+	return QueryHistory(store.config.Directory, start, end, topic)
+}
+
+// QueryHistory function reads snapshots taken in given time range from
+// history files stored in given directory
+func QueryHistory(directory string, start, end time.Time, topic string) ([]HistorySnapshot, error) {
+	files, err := HistoryFiles(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := []HistorySnapshot{}
+	for _, name := range files {
+		date, err := historyFileDate(name)
+		if err != nil {
+			continue
+		}
+		// skip files that can not contain snapshots from given range
+		if date.After(end) || date.Add(24*time.Hour).Before(start) {
+			continue
+		}
+		snapshots, err = readHistoryFile(name, start, end, topic, snapshots)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return snapshots, nil
+}
+
+// readHistoryFile function appends snapshots from given time range read
+// from history file to the snapshots slice
+func readHistoryFile(fileName string, start, end time.Time, topic string, snapshots []HistorySnapshot) ([]HistorySnapshot, error) {
+	file, err := os.Open(fileName) // #nosec G304
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := file.Close()
+		if err != nil {
+			log.Error().Err(err).Str(filenameAttribute, fileName).Msg(historyReadMessage)
+		}
+	}()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxDeadLetterLineSize)
+	for scanner.Scan() {
+		var snapshot HistorySnapshot
+		err := json.Unmarshal(scanner.Bytes(), &snapshot)
+		if err != nil {
+			// file might be truncated when the process was killed
+			log.Warn().Err(err).Str(filenameAttribute, fileName).Msg(historyReadMessage)
+			continue
+		}
+		if snapshot.Time.Before(start) || snapshot.Time.After(end) {
+			continue
+		}
+		if topic != "" {
+			partitions := snapshot.Partitions[:0]
+			for _, partition := range snapshot.Partitions {
+				if partition.Topic == topic {
+					partitions = append(partitions, partition)
+				}
+			}
+			if len(partitions) == 0 {
+				continue
+			}
+			snapshot.Partitions = partitions
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, scanner.Err()
+}
+
+// Run method periodically refreshes high water marks, records progress of
+// consumer, and removes old history files. It blocks current thread.
+func (store *HistoryStore) Run(consumer *KafkaConsumer) {
+	ticker := time.NewTicker(store.config.Interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		// lag needs to be computed from actual high water marks even when
+		// no messages are consumed
+		err := consumer.RefreshHighWaterMarks()
+		if err != nil {
+			log.Warn().Err(err).Msg("Unable to refresh high water marks")
+		}
+
+		_, err = store.Record(consumer.Progress(),
+			consumer.GetNumberOfSuccessfullyConsumedMessages(),
+			consumer.GetNumberOfErrorsConsumingMessages())
+		if err != nil {
+			log.Error().Err(err).Msg(historyWriteMessage)
+		}
+		store.Cleanup()
+	}
+}
+
+// parseHistoryBound function parses bound of history time range. Bound can
+// be specified as RFC 3339 timestamp or as duration before actual time.
+func parseHistoryBound(value string, now time.Time, defaultValue time.Time) (time.Time, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+	if duration, err := time.ParseDuration(value); err == nil {
+		return now.Add(-duration), nil
+	}
+	timestamp, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("improper history bound '%s', RFC 3339 timestamp or duration expected", value)
+	}
+	return timestamp, nil
+}
+
+// history function displays snapshots of consumer progress stored in
+// history directory. Relative bounds of time range are computed from time
+// taken from given clock.
+func history(config ConfigStruct, cliFlags CliFlags, clock Clock) (int, error) {
+	historyConfiguration := GetHistoryConfiguration(&config)
+	if historyConfiguration.Directory == "" {
+		err := fmt.Errorf("history directory is not configured")
+		log.Error().Err(err).Msg(historyReadMessage)
+		return ExitStatusError, err
+	}
+
+	now := clockOrDefault(clock).Now()
+	start, err := parseHistoryBound(cliFlags.HistoryStart, now, now.Add(-defaultHistoryRange))
+	if err != nil {
+		log.Error().Err(err).Msg(historyReadMessage)
+		return ExitStatusError, err
+	}
+	end, err := parseHistoryBound(cliFlags.HistoryEnd, now, now)
+	if err != nil {
+		log.Error().Err(err).Msg(historyReadMessage)
+		return ExitStatusError, err
+	}
+
+	snapshots, err := QueryHistory(historyConfiguration.Directory, start, end, cliFlags.HistoryTopic)
+	if err != nil {
+		log.Error().Err(err).Msg(historyReadMessage)
+		return ExitStatusError, err
+	}
+
+	w := newTableWriter()
+	fmt.Fprintln(w, "TIME\tTOPIC\tPARTITION\tOFFSET\tHIGH WATER MARK\tLAG\tMESSAGES/S\tERRORS")
+	for _, snapshot := range snapshots {
+		for _, partition := range snapshot.Partitions {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%.2f\t%d\n",
+				snapshot.Time.Format(time.RFC3339), partition.Topic, partition.Partition,
+				partition.Offset, partition.HighWaterMark, partition.Lag,
+				partition.MessagesPerSec, partition.Errors)
+		}
+	}
+	err = w.Flush()
+	if err != nil {
+		return ExitStatusError, err
+	}
+
+	return ExitStatusOK, nil
+}
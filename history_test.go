@@ -0,0 +1,264 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Unit test definitions for functions and methods defined in source files
+// history.go and progress.go
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/tisnik/go-capture"
+
+	main "github.com/RedHatInsights/insights-kafka-monitor"
+)
+
+// newHistoryStore function constructs history store in temporary directory
+func newHistoryStore(t *testing.T, clock main.Clock) *main.HistoryStore {
+	store, err := main.NewHistoryStore(main.HistoryConfiguration{
+		Directory: t.TempDir(),
+		Retention: 48 * time.Hour,
+	}, clock)
+	assert.NoError(t, err)
+	return store
+}
+
+// progress function returns progress of one partition of topic
+func progress(topic string, partition int32, offset, highWaterMark int64, messages uint64) main.PartitionProgress {
+	return main.PartitionProgress{
+		Topic:         topic,
+		Partition:     partition,
+		Offset:        offset,
+		HighWaterMark: highWaterMark,
+		Lag:           highWaterMark - offset - 1,
+		Messages:      messages,
+	}
+}
+
+// TestNewHistoryStoreWithoutDirectory checks that history directory needs
+// to be configured
+func TestNewHistoryStoreWithoutDirectory(t *testing.T) {
+	_, err := main.NewHistoryStore(main.HistoryConfiguration{}, nil)
+	assert.EqualError(t, err, "history directory is not configured")
+}
+
+// TestConsumerProgress checks that offsets, high water marks, lag and
+// errors are tracked for each partition
+func TestConsumerProgress(t *testing.T) {
+	decoders, err := main.NewDecoders([]main.TopicConfiguration{
+		{Name: "topic", Decoders: []string{"json"}},
+	})
+	assert.NoError(t, err)
+
+	group := newFakeConsumerGroup(t, "topic")
+
+	consumer := NewDummyConsumer()
+	consumer.ConsumerGroup = group
+	consumer.Decoders = decoders
+	done := serveInBackground(consumer)
+
+	session := group.Rebalance(0, 1)
+	session.Send(0, []byte(`{"foo": "bar"}`))
+	session.Send(0, []byte(`not a JSON`))
+	session.Send(0, []byte(`{"foo": "baz"}`))
+	session.Send(1, []byte(`{"foo": 1}`))
+	session.End()
+	session.Wait()
+
+	progress := consumer.Progress()
+	assert.Len(t, progress, 2)
+	assert.Equal(t, "topic", progress[0].Topic)
+	assert.Equal(t, int32(0), progress[0].Partition)
+	assert.Equal(t, int64(2), progress[0].Offset)
+	assert.Equal(t, int64(3), progress[0].HighWaterMark)
+	assert.Equal(t, int64(0), progress[0].Lag)
+	assert.Equal(t, uint64(3), progress[0].Messages)
+	assert.Equal(t, uint64(1), progress[0].Errors)
+	assert.Equal(t, int32(1), progress[1].Partition)
+	assert.Equal(t, uint64(1), progress[1].Messages)
+
+	assert.NoError(t, consumer.Close())
+	waitForServe(t, done)
+}
+
+// TestRefreshHighWaterMarks checks that high water marks and lag of
+// consumer are refreshed from broker without consuming any messages
+func TestRefreshHighWaterMarks(t *testing.T) {
+	broker := newInventoryMockBroker(t)
+	defer broker.Close()
+
+	config := configurationForMockBroker(broker, testGroup)
+	consumer := NewDummyConsumer()
+	consumer.Configuration = main.GetBrokerConfiguration(&config)
+
+	// nothing to refresh
+	assert.NoError(t, consumer.RefreshHighWaterMarks())
+
+	msg := consumerMessage(9, []byte(`{}`))
+	msg.Topic = testTopic
+	msg.Partition = 0
+	consumer.HandleMessage(msg)
+
+	assert.NoError(t, consumer.RefreshHighWaterMarks())
+
+	progress := consumer.Progress()
+	assert.Len(t, progress, 1)
+	assert.Equal(t, int64(42), progress[0].HighWaterMark)
+	assert.Equal(t, int64(32), progress[0].Lag)
+
+	// client connected by the first refresh is reused
+	requests := len(broker.History())
+	assert.NoError(t, consumer.RefreshHighWaterMarks())
+	for _, exchange := range broker.History()[requests:] {
+		_, isOffsetRequest := exchange.Request.(*sarama.OffsetRequest)
+		assert.True(t, isOffsetRequest)
+	}
+
+	assert.NoError(t, consumer.Close())
+}
+
+// TestHistoryRecordAndQuery checks that recorded snapshots can be queried
+// by time range and by topic
+func TestHistoryRecordAndQuery(t *testing.T) {
+	clock := newManualClock(time.Date(2022, 3, 4, 23, 59, 0, 0, time.UTC))
+	store := newHistoryStore(t, clock)
+
+	_, err := store.Record([]main.PartitionProgress{
+		progress("topic1", 0, 9, 20, 10),
+		progress("topic2", 0, 4, 5, 5),
+	}, 15, 0)
+	assert.NoError(t, err)
+
+	// the second snapshot is stored into file for the next day
+	clock.Advance(2 * time.Minute)
+	snapshot, err := store.Record([]main.PartitionProgress{
+		progress("topic1", 0, 69, 70, 70),
+		progress("topic2", 0, 4, 5, 5),
+	}, 75, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 0.5, snapshot.Partitions[0].MessagesPerSec)
+	assert.Equal(t, 0.0, snapshot.Partitions[1].MessagesPerSec)
+
+	snapshots, err := store.Query(clock.Now().Add(-time.Hour), clock.Now(), "")
+	assert.NoError(t, err)
+	assert.Len(t, snapshots, 2)
+	assert.Equal(t, int64(10), snapshots[0].Partitions[0].Lag)
+	assert.Equal(t, uint64(1), snapshots[1].Errors)
+	assert.Equal(t, clock.Now(), snapshots[1].Time)
+
+	snapshots, err = store.Query(clock.Now().Add(-time.Minute), clock.Now(), "topic1")
+	assert.NoError(t, err)
+	assert.Len(t, snapshots, 1)
+	assert.Len(t, snapshots[0].Partitions, 1)
+	assert.Equal(t, int64(69), snapshots[0].Partitions[0].Offset)
+
+	snapshots, err = store.Query(clock.Now().Add(-time.Hour), clock.Now(), "unknown")
+	assert.NoError(t, err)
+	assert.Empty(t, snapshots)
+}
+
+// TestHistoryCleanup checks that history files older than retention are
+// removed
+func TestHistoryCleanup(t *testing.T) {
+	clock := newManualClock(time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC))
+	store := newHistoryStore(t, clock)
+
+	for i := 0; i < 4; i++ {
+		_, err := store.Record(nil, 0, 0)
+		assert.NoError(t, err)
+		clock.Advance(24 * time.Hour)
+	}
+
+	store.Cleanup()
+
+	snapshots, err := store.Query(time.Time{}, clock.Now(), "")
+	assert.NoError(t, err)
+	// files from March 1st and March 2nd contain snapshots older than two
+	// days and are removed
+	assert.Len(t, snapshots, 2)
+	assert.Equal(t, 3, snapshots[0].Time.Day())
+}
+
+// TestHistoryCommand checks that stored snapshots are displayed by
+// -history command
+func TestHistoryCommand(t *testing.T) {
+	directory := t.TempDir()
+	clock := newManualClock(time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC))
+	store, err := main.NewHistoryStore(main.HistoryConfiguration{Directory: directory}, clock)
+	assert.NoError(t, err)
+	_, err = store.Record([]main.PartitionProgress{
+		progress("topic1", 0, 41, 50, 42),
+		progress("topic2", 3, 7, 8, 8),
+	}, 50, 0)
+	assert.NoError(t, err)
+
+	config := main.ConfigStruct{}
+	config.History.Directory = directory
+
+	output, err := capture.StandardOutput(func() {
+		code, err := main.History(config, main.CliFlags{History: true, HistoryTopic: "topic1", HistoryStart: "1h"}, clock)
+		assert.NoError(t, err)
+		assert.Equal(t, main.ExitStatusOK, code)
+	})
+	checkCapture(t, err)
+
+	assert.Contains(t, output, "LAG")
+	assert.Regexp(t, `topic1\s+0\s+41\s+50\s+8\s+0.00\s+0`, output)
+	assert.NotContains(t, output, "topic2")
+}
+
+// TestHistoryCommandImproperRange checks that improper bound of time range
+// is refused
+func TestHistoryCommandImproperRange(t *testing.T) {
+	config := main.ConfigStruct{}
+	config.History.Directory = t.TempDir()
+
+	code, err := main.History(config, main.CliFlags{History: true, HistoryEnd: "tomorrow"}, nil)
+	assert.Error(t, err)
+	assert.Equal(t, main.ExitStatusError, code)
+}
+
+// TestHistoryEndpoint checks the history endpoint
+func TestHistoryEndpoint(t *testing.T) {
+	factory := func(addrs []string, id string, config *sarama.Config) (sarama.ConsumerGroup, error) {
+		return newFakeConsumerGroup(t, "topic"), nil
+	}
+	clock := newManualClock(time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC))
+	consumer, err := main.NewConsumer(main.BrokerConfiguration{Topic: "topic"}, false,
+		main.WithConsumerGroupFactory(factory),
+		main.WithClock(clock))
+	assert.NoError(t, err)
+	server := main.NewHTTPServer(main.ServerConfiguration{}, consumer)
+
+	response := performRequest(server, "/api/v1/history")
+	assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+
+	consumer.History = newHistoryStore(t, clock)
+	_, err = consumer.History.Record([]main.PartitionProgress{progress("topic", 1, 41, 50, 42)}, 42, 0)
+	assert.NoError(t, err)
+
+	response = performRequest(server, "/api/v1/history?start=1h&topic=topic")
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Body.String(), `"lag":8`)
+
+	response = performRequest(server, "/api/v1/history?start=yesterday")
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+}
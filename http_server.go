@@ -0,0 +1,451 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains implementation of HTTP server that provides REST
+// API to retrieve actual state of Kafka monitor.
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Endpoints provided by REST API. All endpoints are prefixed by API prefix
+// taken from configuration.
+const (
+	// MainEndpoint returns simple status message
+	MainEndpoint = ""
+
+	// ProfileEndpoint returns payload profiles for all topics
+	ProfileEndpoint = "profile"
+
+	// AlertsEndpoint returns the most recent alerts
+	AlertsEndpoint = "alerts"
+
+	// BaselinesEndpoint returns learned schema baselines for all topics
+	BaselinesEndpoint = "baselines"
+
+	// CanaryEndpoint returns canary statistic
+	CanaryEndpoint = "canary"
+
+	// StatsEndpoint returns statistic about consumed messages and
+	// processing pipeline
+	StatsEndpoint = "stats"
+
+	// CorrelationEndpoint returns statistic about messages correlated
+	// across topics
+	CorrelationEndpoint = "correlation"
+
+	// SequenceEndpoint returns statistic about offset gaps and regressions,
+	// out-of-order timestamps, and duplicate messages
+	SequenceEndpoint = "sequence"
+
+	// TrafficEndpoint returns per-partition rates and the most frequent
+	// message keys for all topics
+	TrafficEndpoint = "traffic"
+
+	// SizesEndpoint returns rolling statistic about message sizes for all
+	// topics
+	SizesEndpoint = "sizes"
+
+	// CompressionEndpoint returns compression codecs, record format
+	// versions, and compression ratios for all topics
+	CompressionEndpoint = "compression"
+
+	// HistoryEndpoint returns stored snapshots of offsets, lag and
+	// throughput of all consumed partitions
+	HistoryEndpoint = "history"
+
+	// DashboardEndpoint returns HTML page of live dashboard
+	DashboardEndpoint = "dashboard"
+
+	// DashboardEventsEndpoint streams dashboard state via Server-Sent
+	// Events
+	DashboardEventsEndpoint = "dashboard/events"
+
+	// DashboardMessageEndpoint returns payload of recently consumed
+	// message displayed by dashboard
+	DashboardMessageEndpoint = "dashboard/message"
+
+	// AuditEndpoint returns result of the most recent topic configuration
+	// audit
+	AuditEndpoint = "audit"
+)
+
+// defaultAPIPrefix is used when API prefix is not configured
+const defaultAPIPrefix = "/api/v1/"
+
+// Messages used by HTTP server
+const (
+	httpServerStartingMessage  = "Starting HTTP server"
+	httpServerErrorMessage     = "HTTP server error"
+	responseWritingMessage     = "Unable to write response"
+	profilingDisabledMessage   = "payload profiling is disabled"
+	driftDisabledMessage       = "schema drift detection is disabled"
+	alertsDisabledMessage      = "alerts are not available"
+	canaryDisabledMessage      = "canary is disabled"
+	correlationDisabledMessage = "correlation is disabled"
+	sequenceDisabledMessage    = "sequence check is disabled"
+	trafficDisabledMessage     = "traffic analysis is disabled"
+	sizeDisabledMessage        = "message size monitoring is disabled"
+	compressionDisabledMessage = "compression inspection is disabled"
+	historyDisabledMessage     = "history is disabled"
+	dashboardDisabledMessage   = "dashboard is disabled"
+	auditDisabledMessage       = "topic configuration audit is disabled"
+	messageNotFoundMessage     = "message is not available"
+	consumerMissingMessage     = "consumer is not available"
+)
+
+// HTTPServer represents HTTP server that provides REST API
+type HTTPServer struct {
+	Config   ServerConfiguration
+	Consumer *KafkaConsumer
+	mux      *http.ServeMux
+}
+
+// NewHTTPServer constructs new HTTP server with all endpoints registered
+func NewHTTPServer(config ServerConfiguration, consumer *KafkaConsumer) *HTTPServer {
+	if config.APIPrefix == "" {
+		config.APIPrefix = defaultAPIPrefix
+	}
+	if !strings.HasSuffix(config.APIPrefix, "/") {
+		config.APIPrefix += "/"
+	}
+
+	server := &HTTPServer{
+		Config:   config,
+		Consumer: consumer,
+		mux:      http.NewServeMux(),
+	}
+	server.addEndpoints()
+
+	return server
+}
+
+// addEndpoints method registers handlers for all REST API endpoints
+func (server *HTTPServer) addEndpoints() {
+	prefix := server.Config.APIPrefix
+
+	server.mux.HandleFunc(prefix+MainEndpoint, server.mainEndpoint)
+	server.mux.HandleFunc(prefix+ProfileEndpoint, server.profileEndpoint)
+	server.mux.HandleFunc(prefix+AlertsEndpoint, server.alertsEndpoint)
+	server.mux.HandleFunc(prefix+BaselinesEndpoint, server.baselinesEndpoint)
+	server.mux.HandleFunc(prefix+CanaryEndpoint, server.canaryEndpoint)
+	server.mux.HandleFunc(prefix+StatsEndpoint, server.statsEndpoint)
+	server.mux.HandleFunc(prefix+CorrelationEndpoint, server.correlationEndpoint)
+	server.mux.HandleFunc(prefix+SequenceEndpoint, server.sequenceEndpoint)
+	server.mux.HandleFunc(prefix+TrafficEndpoint, server.trafficEndpoint)
+	server.mux.HandleFunc(prefix+SizesEndpoint, server.sizesEndpoint)
+	server.mux.HandleFunc(prefix+CompressionEndpoint, server.compressionEndpoint)
+	server.mux.HandleFunc(prefix+HistoryEndpoint, server.historyEndpoint)
+	server.mux.HandleFunc(prefix+DashboardEndpoint, server.dashboardEndpoint)
+	server.mux.HandleFunc(prefix+DashboardEventsEndpoint, server.dashboardEventsEndpoint)
+	server.mux.HandleFunc(prefix+DashboardMessageEndpoint, server.dashboardMessageEndpoint)
+	server.mux.HandleFunc(prefix+AuditEndpoint, server.auditEndpoint)
+}
+
+// Handler method returns HTTP handler that dispatches requests to all
+// endpoints
+func (server *HTTPServer) Handler() http.Handler {
+	return server.mux
+}
+
+// Start method starts HTTP server. It blocks current thread.
+func (server *HTTPServer) Start() error {
+	log.Info().Str("address", server.Config.Address).Msg(httpServerStartingMessage)
+
+	err := http.ListenAndServe(server.Config.Address, server.mux) // #nosec G114
+	if err != nil {
+		log.Error().Err(err).Msg(httpServerErrorMessage)
+		return err
+	}
+	return nil
+}
+
+// sendJSON function sends given data in JSON format with specified status
+// code
+func sendJSON(writer http.ResponseWriter, status int, data interface{}) {
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	writer.WriteHeader(status)
+
+	err := json.NewEncoder(writer).Encode(data)
+	if err != nil {
+		log.Error().Err(err).Msg(responseWritingMessage)
+	}
+}
+
+// sendError function sends error message in JSON format
+func sendError(writer http.ResponseWriter, status int, message string) {
+	sendJSON(writer, status, map[string]string{"status": message})
+}
+
+// mainEndpoint method handles requests to main endpoint
+func (server *HTTPServer) mainEndpoint(writer http.ResponseWriter, request *http.Request) {
+	if request.URL.Path != server.Config.APIPrefix+MainEndpoint {
+		sendError(writer, http.StatusNotFound, "not found")
+		return
+	}
+	sendJSON(writer, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// profileEndpoint method returns payload profiles for all topics
+func (server *HTTPServer) profileEndpoint(writer http.ResponseWriter, request *http.Request) {
+	if server.Consumer == nil || server.Consumer.Profiler == nil {
+		sendError(writer, http.StatusServiceUnavailable, profilingDisabledMessage)
+		return
+	}
+	sendJSON(writer, http.StatusOK, server.Consumer.Profiler.Summary())
+}
+
+// alertsEndpoint method returns the most recent alerts
+func (server *HTTPServer) alertsEndpoint(writer http.ResponseWriter, request *http.Request) {
+	if server.Consumer == nil || server.Consumer.Alerts == nil {
+		sendError(writer, http.StatusServiceUnavailable, alertsDisabledMessage)
+		return
+	}
+	sendJSON(writer, http.StatusOK, map[string]interface{}{
+		"alerts": server.Consumer.Alerts.Recent(),
+		"counts": server.Consumer.Alerts.Counts(),
+	})
+}
+
+// baselinesEndpoint method returns learned schema baselines
+func (server *HTTPServer) baselinesEndpoint(writer http.ResponseWriter, request *http.Request) {
+	if server.Consumer == nil || server.Consumer.DriftDetector == nil {
+		sendError(writer, http.StatusServiceUnavailable, driftDisabledMessage)
+		return
+	}
+	sendJSON(writer, http.StatusOK, server.Consumer.DriftDetector.Baselines())
+}
+
+// canaryEndpoint method returns canary statistic
+func (server *HTTPServer) canaryEndpoint(writer http.ResponseWriter, request *http.Request) {
+	if server.Consumer == nil || server.Consumer.Canary == nil {
+		sendError(writer, http.StatusServiceUnavailable, canaryDisabledMessage)
+		return
+	}
+	sendJSON(writer, http.StatusOK, server.Consumer.Canary.Stats())
+}
+
+// statsEndpoint method returns statistic about consumed messages and about
+// all stages of message processing pipeline
+func (server *HTTPServer) statsEndpoint(writer http.ResponseWriter, request *http.Request) {
+	if server.Consumer == nil {
+		sendError(writer, http.StatusServiceUnavailable, consumerMissingMessage)
+		return
+	}
+
+	stats := map[string]interface{}{
+		"consumed":     server.Consumer.GetNumberOfSuccessfullyConsumedMessages(),
+		"errors":       server.Consumer.GetNumberOfErrorsConsumingMessages(),
+		"partitions":   server.Consumer.Progress(),
+		"group_errors": server.Consumer.GetNumberOfConsumerGroupErrors(),
+	}
+	if server.Consumer.Pipeline != nil {
+		stats["pipeline"] = server.Consumer.Pipeline.Stats()
+	}
+	if server.Consumer.Sessions != nil {
+		stats["sessions"] = server.Consumer.Sessions.Stats()
+	}
+	sendJSON(writer, http.StatusOK, stats)
+}
+
+// correlationEndpoint method returns stage-to-stage latencies and numbers
+// of messages dropped in each correlated stage
+func (server *HTTPServer) correlationEndpoint(writer http.ResponseWriter, request *http.Request) {
+	if server.Consumer == nil || server.Consumer.Correlator == nil {
+		sendError(writer, http.StatusServiceUnavailable, correlationDisabledMessage)
+		return
+	}
+	sendJSON(writer, http.StatusOK, server.Consumer.Correlator.Stats())
+}
+
+// sequenceEndpoint method returns statistic about offset gaps and
+// regressions, out-of-order timestamps, and duplicate messages
+func (server *HTTPServer) sequenceEndpoint(writer http.ResponseWriter, request *http.Request) {
+	if server.Consumer == nil || server.Consumer.SequenceChecker == nil {
+		sendError(writer, http.StatusServiceUnavailable, sequenceDisabledMessage)
+		return
+	}
+	sendJSON(writer, http.StatusOK, server.Consumer.SequenceChecker.Stats())
+}
+
+// trafficEndpoint method returns per-partition rates, partition skew, and
+// the most frequent message keys for all topics
+func (server *HTTPServer) trafficEndpoint(writer http.ResponseWriter, request *http.Request) {
+	if server.Consumer == nil || server.Consumer.TrafficAnalyzer == nil {
+		sendError(writer, http.StatusServiceUnavailable, trafficDisabledMessage)
+		return
+	}
+	sendJSON(writer, http.StatusOK, server.Consumer.TrafficAnalyzer.Stats())
+}
+
+// sizesEndpoint method returns rolling statistic about message sizes for
+// all topics
+func (server *HTTPServer) sizesEndpoint(writer http.ResponseWriter, request *http.Request) {
+	if server.Consumer == nil || server.Consumer.SizeMonitor == nil {
+		sendError(writer, http.StatusServiceUnavailable, sizeDisabledMessage)
+		return
+	}
+	sendJSON(writer, http.StatusOK, server.Consumer.SizeMonitor.Stats())
+}
+
+// compressionEndpoint method returns compression codecs, record format
+// versions, and compression ratios for all inspected topics
+func (server *HTTPServer) compressionEndpoint(writer http.ResponseWriter, request *http.Request) {
+	if server.Consumer == nil || server.Consumer.BatchInspector == nil {
+		sendError(writer, http.StatusServiceUnavailable, compressionDisabledMessage)
+		return
+	}
+	sendJSON(writer, http.StatusOK, server.Consumer.BatchInspector.Stats())
+}
+
+// historyEndpoint method returns snapshots of consumer progress stored in
+// history. Time range can be selected by start and end query parameters
+// (RFC 3339 timestamps or durations before actual time), and partitions of
+// one topic can be selected by topic query parameter.
+func (server *HTTPServer) historyEndpoint(writer http.ResponseWriter, request *http.Request) {
+	if server.Consumer == nil || server.Consumer.History == nil {
+		sendError(writer, http.StatusServiceUnavailable, historyDisabledMessage)
+		return
+	}
+
+	query := request.URL.Query()
+	now := server.Consumer.now()
+	start, err := parseHistoryBound(query.Get("start"), now, now.Add(-defaultHistoryRange))
+	if err != nil {
+		sendError(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	end, err := parseHistoryBound(query.Get("end"), now, now)
+	if err != nil {
+		sendError(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	snapshots, err := server.Consumer.History.Query(start, end, query.Get("topic"))
+	if err != nil {
+		sendError(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+	sendJSON(writer, http.StatusOK, snapshots)
+}
+
+// dashboardEndpoint method returns HTML page of live dashboard
+func (server *HTTPServer) dashboardEndpoint(writer http.ResponseWriter, request *http.Request) {
+	if server.Consumer == nil || server.Consumer.Dashboard == nil {
+		sendError(writer, http.StatusServiceUnavailable, dashboardDisabledMessage)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writer.WriteHeader(http.StatusOK)
+	_, err := writer.Write([]byte(dashboardPage))
+	if err != nil {
+		log.Error().Err(err).Msg(responseWritingMessage)
+	}
+}
+
+// dashboardEventsEndpoint method periodically sends actual dashboard state
+// as Server-Sent Events until client disconnects
+func (server *HTTPServer) dashboardEventsEndpoint(writer http.ResponseWriter, request *http.Request) {
+	if server.Consumer == nil || server.Consumer.Dashboard == nil {
+		sendError(writer, http.StatusServiceUnavailable, dashboardDisabledMessage)
+		return
+	}
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		sendError(writer, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.Header().Set("Connection", "keep-alive")
+	writer.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(server.Consumer.Dashboard.Interval())
+	defer ticker.Stop()
+
+	for {
+		data, err := json.Marshal(server.Consumer.Dashboard.State(server.Consumer.Progress()))
+		if err != nil {
+			log.Error().Err(err).Msg(responseWritingMessage)
+			return
+		}
+		_, err = fmt.Fprintf(writer, "data: %s\n\n", data)
+		if err != nil {
+			// client has disconnected
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-request.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dashboardMessageEndpoint method returns metadata and payload of recently
+// consumed message selected by id query parameter
+func (server *HTTPServer) dashboardMessageEndpoint(writer http.ResponseWriter, request *http.Request) {
+	if server.Consumer == nil || server.Consumer.Dashboard == nil {
+		sendError(writer, http.StatusServiceUnavailable, dashboardDisabledMessage)
+		return
+	}
+
+	id, err := strconv.ParseUint(request.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		sendError(writer, http.StatusBadRequest, "improper message ID")
+		return
+	}
+
+	metadata, payload, found := server.Consumer.Dashboard.Payload(id)
+	if !found {
+		sendError(writer, http.StatusNotFound, messageNotFoundMessage)
+		return
+	}
+
+	response := map[string]interface{}{
+		"message": metadata,
+	}
+	if utf8.Valid(payload) {
+		response["value"] = string(payload)
+	} else {
+		response["value_base64"] = payload
+	}
+	sendJSON(writer, http.StatusOK, response)
+}
+
+// auditEndpoint method returns result of the most recent topic
+// configuration audit
+func (server *HTTPServer) auditEndpoint(writer http.ResponseWriter, request *http.Request) {
+	if server.Consumer == nil || server.Consumer.Auditor == nil {
+		sendError(writer, http.StatusServiceUnavailable, auditDisabledMessage)
+		return
+	}
+	sendJSON(writer, http.StatusOK, server.Consumer.Auditor.Report())
+}
@@ -0,0 +1,160 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Unit test definitions for functions and methods defined in source file
+// http_server.go
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-kafka-monitor"
+)
+
+// performRequest function sends GET request to given endpoint and returns
+// recorded response
+func performRequest(server *main.HTTPServer, endpoint string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodGet, endpoint, nil)
+	recorder := httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, request)
+	return recorder
+}
+
+// TestMainEndpoint checks the main REST API endpoint
+func TestMainEndpoint(t *testing.T) {
+	server := main.NewHTTPServer(main.ServerConfiguration{}, NewDummyConsumer())
+
+	response := performRequest(server, "/api/v1/")
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.JSONEq(t, `{"status": "ok"}`, response.Body.String())
+
+	response = performRequest(server, "/api/v1/foobar")
+	assert.Equal(t, http.StatusNotFound, response.Code)
+}
+
+// TestCustomAPIPrefix checks that API prefix can be configured
+func TestCustomAPIPrefix(t *testing.T) {
+	server := main.NewHTTPServer(main.ServerConfiguration{APIPrefix: "/monitor"}, NewDummyConsumer())
+
+	response := performRequest(server, "/monitor/")
+	assert.Equal(t, http.StatusOK, response.Code)
+}
+
+// TestProfileEndpointProfilingDisabled checks the profile endpoint when
+// profiling is disabled
+func TestProfileEndpointProfilingDisabled(t *testing.T) {
+	server := main.NewHTTPServer(main.ServerConfiguration{}, NewDummyConsumer())
+
+	response := performRequest(server, "/api/v1/profile")
+	assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+}
+
+// TestProfileEndpoint checks the profile endpoint
+func TestProfileEndpoint(t *testing.T) {
+	consumer := NewDummyConsumer()
+	consumer.Profiler = main.NewProfiler(nil)
+	consumer.Profiler.Profile(decodedMessage("topic", `{"foo": null}`))
+
+	server := main.NewHTTPServer(main.ServerConfiguration{}, consumer)
+
+	response := performRequest(server, "/api/v1/profile")
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	var profiles map[string]main.TopicProfileSummary
+	err := json.Unmarshal(response.Body.Bytes(), &profiles)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), profiles["topic"].Messages)
+	assert.Equal(t, 1.0, profiles["topic"].Fields["foo"].NullRate)
+}
+
+// TestAlertsEndpoint checks the alerts endpoint
+func TestAlertsEndpoint(t *testing.T) {
+	consumer := NewDummyConsumer()
+	server := main.NewHTTPServer(main.ServerConfiguration{}, consumer)
+
+	response := performRequest(server, "/api/v1/alerts")
+	assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+
+	consumer.Alerts = main.NewAlertManager(nil)
+	consumer.Alerts.Raise(main.Alert{Type: "type", Message: "message"})
+
+	response = performRequest(server, "/api/v1/alerts")
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Body.String(), `"message":"message"`)
+}
+
+// TestBaselinesEndpoint checks the baselines endpoint
+func TestBaselinesEndpoint(t *testing.T) {
+	consumer := NewDummyConsumer()
+	server := main.NewHTTPServer(main.ServerConfiguration{}, consumer)
+
+	response := performRequest(server, "/api/v1/baselines")
+	assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+
+	detector, err := main.NewDriftDetector(main.DriftConfiguration{}, nil, nil)
+	assert.NoError(t, err)
+	consumer.DriftDetector = detector
+	detector.Check(decodedMessage("topic", `{"foo": 1}`))
+
+	response = performRequest(server, "/api/v1/baselines")
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Body.String(), `"foo"`)
+}
+
+// TestCanaryEndpoint checks the canary endpoint
+func TestCanaryEndpoint(t *testing.T) {
+	consumer := NewDummyConsumer()
+	server := main.NewHTTPServer(main.ServerConfiguration{}, consumer)
+
+	response := performRequest(server, "/api/v1/canary")
+	assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+
+	consumer.Canary = newCanary(t, main.CanaryConfiguration{}, &syncProducerMock{}, nil, nil)
+	assert.NoError(t, consumer.Canary.Send())
+
+	response = performRequest(server, "/api/v1/canary")
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Body.String(), `"sent":1`)
+}
+
+// TestStatsEndpoint checks the stats endpoint
+func TestStatsEndpoint(t *testing.T) {
+	consumer := NewDummyConsumer()
+	pipeline, err := main.NewPipeline(consumer, main.PipelineConfiguration{}, nil)
+	assert.NoError(t, err)
+	consumer.Pipeline = pipeline
+	consumer.HandleMessage(consumerMessage(1, []byte(`{}`)))
+
+	server := main.NewHTTPServer(main.ServerConfiguration{}, consumer)
+
+	response := performRequest(server, "/api/v1/stats")
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	var stats struct {
+		Consumed uint64                                `json:"consumed"`
+		Errors   uint64                                `json:"errors"`
+		Pipeline map[string]map[string]main.StageStats `json:"pipeline"`
+	}
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &stats))
+	assert.Equal(t, uint64(1), stats.Consumed)
+	assert.Equal(t, uint64(1), stats.Pipeline["topic"][main.StageLog].Processed)
+}
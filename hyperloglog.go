@@ -0,0 +1,96 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains implementation of HyperLogLog sketch that is
+// used to estimate number of distinct values in bounded memory. With the
+// precision used there the sketch needs 4 kB of memory and the standard
+// error of estimation is about 1.6%.
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+// hyperLogLogPrecision is number of bits used to select register
+const hyperLogLogPrecision = 12
+
+// hyperLogLogRegisters is number of registers used by sketch
+const hyperLogLogRegisters = 1 << hyperLogLogPrecision
+
+// HyperLogLog is a sketch used to estimate cardinality of set of values
+type HyperLogLog struct {
+	registers [hyperLogLogRegisters]uint8
+}
+
+// NewHyperLogLog constructs new empty sketch
+func NewHyperLogLog() *HyperLogLog {
+	return &HyperLogLog{}
+}
+
+// hashValue function computes 64bit hash of given value. Result of FNV hash
+// is mixed to improve distribution of bits.
+func hashValue(value string) uint64 {
+	hash := fnv.New64a()
+	// Write method of hash never returns an error
+	_, _ = hash.Write([]byte(value))
+	x := hash.Sum64()
+
+	// finalizer taken from SplitMix64
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// Add method adds value into sketch
+func (hll *HyperLogLog) Add(value string) {
+	hash := hashValue(value)
+	index := hash >> (64 - hyperLogLogPrecision)
+	rank := uint8(bits.LeadingZeros64(hash<<hyperLogLogPrecision|1<<(hyperLogLogPrecision-1)) + 1)
+	if rank > hll.registers[index] {
+		hll.registers[index] = rank
+	}
+}
+
+// Estimate method returns estimated number of distinct values added into
+// sketch
+func (hll *HyperLogLog) Estimate() uint64 {
+	const m = float64(hyperLogLogRegisters)
+	alpha := 0.7213 / (1 + 1.079/m)
+
+	sum := 0.0
+	zeros := 0
+	for _, register := range hll.registers {
+		sum += math.Pow(2, -float64(register))
+		if register == 0 {
+			zeros++
+		}
+	}
+
+	estimate := alpha * m * m / sum
+
+	// small range correction
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+
+	return uint64(estimate + 0.5)
+}
@@ -0,0 +1,57 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Unit test definitions for functions and methods defined in source file
+// hyperloglog.go
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-kafka-monitor"
+)
+
+// TestHyperLogLogEmpty checks estimation for empty sketch
+func TestHyperLogLogEmpty(t *testing.T) {
+	sketch := main.NewHyperLogLog()
+	assert.Equal(t, uint64(0), sketch.Estimate())
+}
+
+// TestHyperLogLogDuplicates checks that duplicate values are not counted
+func TestHyperLogLogDuplicates(t *testing.T) {
+	sketch := main.NewHyperLogLog()
+	for i := 0; i < 1000; i++ {
+		sketch.Add(fmt.Sprint(i % 10))
+	}
+	assert.Equal(t, uint64(10), sketch.Estimate())
+}
+
+// TestHyperLogLogEstimate checks precision of estimation for larger set
+func TestHyperLogLogEstimate(t *testing.T) {
+	const distinct = 100000
+
+	sketch := main.NewHyperLogLog()
+	for i := 0; i < distinct; i++ {
+		sketch.Add(fmt.Sprintf("cluster-%d", i))
+	}
+
+	// standard error is about 1.6%, so 5% is safe enough
+	assert.InEpsilon(t, distinct, sketch.Estimate(), 0.05)
+}
@@ -0,0 +1,89 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains implementation of rules used to extract ID
+// from messages. The same rules are used to extract correlation ID and
+// deduplication key. Rule "key" takes ID from message key, rule
+// "header:<name>" takes ID from message header, rule "json:<path>" takes
+// ID from JSON payload (path is in dot notation), and rule "payload" uses
+// hash of the whole payload as ID.
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Sources of ID
+const (
+	IDFromKey     = "key"
+	IDFromHeader  = "header"
+	IDFromJSON    = "json"
+	IDFromPayload = "payload"
+)
+
+// idRule represents parsed rule used to extract ID from messages
+type idRule struct {
+	source string
+	name   string
+}
+
+// parseIDRule function parses rule used to extract ID from messages
+func parseIDRule(rule string) (idRule, error) {
+	parsed := idRule{source: rule}
+	if index := strings.Index(rule, ":"); index >= 0 {
+		parsed.source, parsed.name = rule[:index], rule[index+1:]
+	}
+
+	switch parsed.source {
+	case IDFromKey, IDFromPayload:
+		return parsed, nil
+	case IDFromHeader, IDFromJSON:
+		if parsed.name == "" {
+			return parsed, fmt.Errorf("name needs to be specified in ID rule '%s'", rule)
+		}
+		return parsed, nil
+	default:
+		return parsed, fmt.Errorf("unknown ID rule '%s'", rule)
+	}
+}
+
+// extract method extracts ID from message. Empty string is returned when
+// message does not contain ID.
+func (rule idRule) extract(message *DecodedMessage) string {
+	switch rule.source {
+	case IDFromKey:
+		return string(message.Message.Key)
+	case IDFromHeader:
+		for _, header := range message.Message.Headers {
+			if header != nil && string(header.Key) == rule.name {
+				return string(header.Value)
+			}
+		}
+	case IDFromJSON:
+		value, found := message.Field(rule.name)
+		if found && value != nil {
+			return cardinalityValue(value)
+		}
+	case IDFromPayload:
+		hash := sha256.Sum256(message.Content)
+		return hex.EncodeToString(hash[:])
+	}
+	return ""
+}
@@ -35,6 +35,7 @@ const (
 	notConnectedToBrokerMessage    = "Not connected to broker"
 	brokerConnectionSuccessMessage = "Broker connection OK"
 	brokerAddressMessage           = "Broker address"
+	closingBrokerConnectionMessage = "Closing connection to broker"
 	brokerConfigurationMessage     = "Broker configuration"
 	topic                          = "Topic"
 	group                          = "Group"
@@ -58,6 +59,20 @@ const (
 	ExitStatusConsumerError
 	// ExitStatusKafkaError is returned in case of any Kafka-related error
 	ExitStatusKafkaError
+	// ExitStatusTopicError is returned when configured topic does not exist
+	ExitStatusTopicError
+	// ExitStatusPartitionLeaderError is returned when any partition of
+	// configured topic does not have a leader
+	ExitStatusPartitionLeaderError
+	// ExitStatusReplicationError is returned when any partition of
+	// configured topic does not have full set of in-sync replicas
+	ExitStatusReplicationError
+	// ExitStatusCoordinatorError is returned when group coordinator can not
+	// be found or is not reachable
+	ExitStatusCoordinatorError
+	// ExitStatusTopicConfigurationError is returned when live configuration
+	// of any topic differs from expected one
+	ExitStatusTopicConfigurationError
 )
 
 // showVersion function displays version information.
@@ -73,11 +88,20 @@ func showAuthors() {
 // showConfiguration function displays actual configuration.
 func showConfiguration(config ConfigStruct) {
 	brokerConfig := GetBrokerConfiguration(&config)
+	instanceID, err := GroupInstanceID(brokerConfig)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to derive group instance ID")
+	}
 	log.Info().
 		Str(brokerAddressMessage, brokerConfig.Address).
 		Str(topic, brokerConfig.Topic).
 		Str(group, brokerConfig.Group).
 		Bool(enabled, brokerConfig.Enabled).
+		Str("Protocol version", brokerConfig.Version).
+		Str("Rebalance strategy", RebalanceStrategy(brokerConfig)).
+		Bool("Static membership", brokerConfig.StaticMembership).
+		Str("Group instance ID", instanceID).
+		Dur("Session timeout", brokerConfig.SessionTimeout).
 		Msg(brokerConfigurationMessage)
 
 	loggingConfig := GetLoggingConfiguration(&config)
@@ -90,39 +114,221 @@ func showConfiguration(config ConfigStruct) {
 	log.Info().
 		Bool(verbose, outputConfig.Verbose).
 		Msg("Output configuration")
+
+	serverConfig := GetServerConfiguration(&config)
+	log.Info().
+		Str("Address", serverConfig.Address).
+		Str("API prefix", serverConfig.APIPrefix).
+		Msg("Server configuration")
+
+	profilingConfig := GetProfilingConfiguration(&config)
+	log.Info().
+		Bool(enabled, profilingConfig.Enabled).
+		Dur("Summary interval", profilingConfig.SummaryInterval).
+		Msg("Profiling configuration")
+
+	driftConfig := GetDriftConfiguration(&config)
+	log.Info().
+		Bool(enabled, driftConfig.Enabled).
+		Uint64("Warm-up messages", driftConfig.WarmupMessages).
+		Dur("Warm-up period", driftConfig.WarmupPeriod).
+		Str("Baseline file", driftConfig.BaselineFile).
+		Dur("Alert interval", driftConfig.AlertInterval).
+		Msg("Schema drift detection configuration")
+
+	archiveConfig := GetArchiveConfiguration(&config)
+	log.Info().
+		Bool(enabled, archiveConfig.Enabled).
+		Str("Directory", archiveConfig.Directory).
+		Str("Sampling", archiveConfig.Sampling).
+		Uint64("Every Nth", archiveConfig.EveryNth).
+		Float64("Percentage", archiveConfig.Percentage).
+		Int64("Max file size", archiveConfig.MaxFileSize).
+		Int64("Max total size", archiveConfig.MaxTotalSize).
+		Dur("Max age", archiveConfig.MaxAge).
+		Dur("Cleanup interval", archiveConfig.CleanupInterval).
+		Msg("Message archive configuration")
+
+	deadLetterConfig := GetDeadLetterConfiguration(&config)
+	log.Info().
+		Bool(enabled, deadLetterConfig.Enabled).
+		Str("Sink", deadLetterConfig.Sink).
+		Str("File", deadLetterConfig.File).
+		Str(topic, deadLetterConfig.Topic).
+		Msg("Dead-letter capture configuration")
+
+	canaryConfig := GetCanaryConfiguration(&config)
+	log.Info().
+		Bool(enabled, canaryConfig.Enabled).
+		Str(topic, canaryConfig.Topic).
+		Dur("Interval", canaryConfig.Interval).
+		Dur("Timeout", canaryConfig.Timeout).
+		Dur("Latency threshold", canaryConfig.LatencyThreshold).
+		Msg("Canary configuration")
+
+	pipelineConfig := GetPipelineConfiguration(&config)
+	log.Info().
+		Strs("Stages", pipelineConfig.Stages).
+		Msg("Pipeline configuration")
+
+	correlationConfig := GetCorrelationConfiguration(&config)
+	log.Info().
+		Bool(enabled, correlationConfig.Enabled).
+		Strs("Stages", correlationConfig.Stages).
+		Dur("Timeout", correlationConfig.Timeout).
+		Int("Max tracked", correlationConfig.MaxTracked).
+		Msg("Correlation configuration")
+
+	sequenceConfig := GetSequenceConfiguration(&config)
+	log.Info().
+		Bool(enabled, sequenceConfig.Enabled).
+		Dur("Dedup window", sequenceConfig.DedupWindow).
+		Int("Dedup max keys", sequenceConfig.DedupMaxKeys).
+		Msg("Sequence check configuration")
+
+	trafficConfig := GetTrafficConfiguration(&config)
+	log.Info().
+		Bool(enabled, trafficConfig.Enabled).
+		Dur("Interval", trafficConfig.Interval).
+		Float64("Skew ratio", trafficConfig.SkewRatio).
+		Int("Top keys", trafficConfig.TopKeys).
+		Int("Sketch size", trafficConfig.SketchSize).
+		Msg("Traffic analysis configuration")
+
+	sizeConfig := GetSizeConfiguration(&config)
+	log.Info().
+		Bool(enabled, sizeConfig.Enabled).
+		Int("Window", sizeConfig.Window).
+		Int("Max bytes", sizeConfig.MaxBytes).
+		Float64("Sigma", sizeConfig.Sigma).
+		Int("Min samples", sizeConfig.MinSamples).
+		Float64("Broker limit ratio", sizeConfig.BrokerLimitRatio).
+		Dur("Alert interval", sizeConfig.AlertInterval).
+		Msg("Message size monitoring configuration")
+
+	compressionConfig := GetCompressionConfiguration(&config)
+	log.Info().
+		Bool(enabled, compressionConfig.Enabled).
+		Dur("Interval", compressionConfig.Interval).
+		Int("Messages", compressionConfig.Messages).
+		Bool("Require compression", compressionConfig.RequireCompression).
+		Strs("Supported codecs", compressionConfig.SupportedCodecs).
+		Msg("Compression inspection configuration")
+
+	historyConfig := GetHistoryConfiguration(&config)
+	log.Info().
+		Bool(enabled, historyConfig.Enabled).
+		Str("Directory", historyConfig.Directory).
+		Dur("Interval", historyConfig.Interval).
+		Dur("Retention", historyConfig.Retention).
+		Msg("Consumer progress history configuration")
+
+	reportConfig := GetReportConfiguration(&config)
+	log.Info().
+		Bool(enabled, reportConfig.Enabled).
+		Dur("Interval", reportConfig.Interval).
+		Str("Directory", reportConfig.Directory).
+		Strs("Formats", reportConfig.Formats).
+		Bool("Webhook configured", reportConfig.Webhook != "").
+		Int("Top messages", reportConfig.TopMessages).
+		Int("Top errors", reportConfig.TopErrors).
+		Dur("Quiet period", reportConfig.QuietPeriod).
+		Int("Latency samples", reportConfig.LatencySamples).
+		Msg("Summary report configuration")
+
+	dashboardConfig := GetDashboardConfiguration(&config)
+	log.Info().
+		Bool(enabled, dashboardConfig.Enabled).
+		Dur("Interval", dashboardConfig.Interval).
+		Int("Points", dashboardConfig.Points).
+		Int("Messages", dashboardConfig.Messages).
+		Dur("Alert window", dashboardConfig.AlertWindow).
+		Msg("Dashboard configuration")
+
+	redactionConfig := GetRedactionConfiguration(&config)
+	log.Info().
+		Bool(enabled, redactionConfig.Enabled).
+		Strs("JSON paths", redactionConfig.JSONPaths).
+		Int("Patterns", len(redactionConfig.Patterns)).
+		Bool("Hash keys", redactionConfig.HashKeys).
+		Str("Mask", redactionConfig.Mask).
+		Msg("Redaction configuration")
+
+	sessionConfig := GetSessionConfiguration(&config)
+	log.Info().
+		Bool(enabled, sessionConfig.Enabled).
+		Int("History", sessionConfig.History).
+		Dur("Storm window", sessionConfig.StormWindow).
+		Int("Storm threshold", sessionConfig.StormThreshold).
+		Msg("Consumer group session tracking configuration")
+
+	auditConfig := GetAuditConfiguration(&config)
+	log.Info().
+		Bool(enabled, auditConfig.Enabled).
+		Dur("Interval", auditConfig.Interval).
+		Msg("Topic configuration audit configuration")
+
+	for _, topicConfig := range GetTopicsConfiguration(&config) {
+		log.Info().
+			Str(topic, topicConfig.Name).
+			Strs("Decoders", topicConfig.Decoders).
+			Strs("Cardinality fields", topicConfig.CardinalityFields).
+			Strs("Stages", topicConfig.Stages).
+			Str("Filter field", topicConfig.FilterField).
+			Strs("Filter values", topicConfig.FilterValues).
+			Strs("Required fields", topicConfig.RequiredFields).
+			Str("Alert field", topicConfig.AlertField).
+			Strs("Alert values", topicConfig.AlertValues).
+			Dur("Alert interval", topicConfig.AlertInterval).
+			Str("Correlation ID", topicConfig.CorrelationID).
+			Str("Dedup key", topicConfig.DedupKey).
+			Bool("Ignore offset gaps", topicConfig.IgnoreOffsetGaps).
+			Interface("Expected", topicConfig.Expected).
+			Msg("Topic configuration")
+	}
 }
 
-// tryToConnectToKafka function just tries connection to Kafka broker
+// tryToConnectToKafka function tries connection to Kafka broker and then
+// checks cluster metadata for configured topic and consumer group
 func tryToConnectToKafka(config ConfigStruct) (int, error) {
 	log.Info().Msg("Checking connection to Kafka")
 
 	// prepare broker configuration
 	brokerConfiguration := GetBrokerConfiguration(&config)
 
-	log.Info().Str(brokerAddressMessage, brokerConfiguration.Address).Msg(brokerAddressMessage)
-
-	// create new broker instance (w/o any checks)
-	broker := sarama.NewBroker(brokerConfiguration.Address)
-
-	// check broker connection
-	err := broker.Open(nil)
+	// the same config as used by consumer, protocol version is detected by
+	// broker when "auto" version is configured
+	saramaConfig, err := newSaramaConfig(brokerConfiguration)
 	if err != nil {
-		log.Error().Err(err).Msg(connectionToBrokerMessage)
+		log.Error().Err(err).Msg("Unable to select Kafka protocol version")
 		return ExitStatusKafkaError, err
 	}
+	log.Info().Str("version", saramaConfig.Version.String()).Msg("Kafka protocol version")
 
-	// check if connection remain
-	connected, err := broker.Connected()
-	if err != nil {
-		log.Error().Err(err).Msg(connectionToBrokerMessage)
-		return ExitStatusKafkaError, err
+	broker, exitCode, err := connectToBroker(brokerConfiguration, saramaConfig)
+	if err != nil || broker == nil {
+		return exitCode, err
 	}
-	if !connected {
-		log.Error().Err(err).Msg(notConnectedToBrokerMessage)
-		return ExitStatusConsumerError, err
+
+	// connection needs to be closed at the end
+	defer func() {
+		err := broker.Close()
+		if err != nil {
+			log.Error().Err(err).Msg(closingBrokerConnectionMessage)
+		}
+	}()
+
+	// check topic existence, partition leadership and replication
+	exitCode, err = checkTopicsMetadata(broker, []string{brokerConfiguration.Topic})
+	if err != nil {
+		return exitCode, err
 	}
 
-	log.Info().Msg(brokerConnectionSuccessMessage)
+	// check if group coordinator is reachable
+	exitCode, err = checkGroupCoordinator(broker, brokerConfiguration.Group, saramaConfig)
+	if err != nil {
+		return exitCode, err
+	}
 
 	// everything seems to be ok
 	return ExitStatusOK, nil
@@ -147,7 +353,7 @@ func startService(config ConfigStruct) (int, error) {
 	// if broker is disabled, simply don't start it
 	if brokerConfiguration.Enabled {
 		log.Info().Msg("Broker is enabled, about to start it")
-		err := startConsumer(brokerConfiguration, verboseMode)
+		err := startConsumer(config)
 		if err != nil {
 			log.Error().Err(err)
 			return ExitStatusConsumerError, err
@@ -160,16 +366,261 @@ func startService(config ConfigStruct) (int, error) {
 }
 
 // startConsumer function starts the Kafka consumer.
-func startConsumer(config BrokerConfiguration, verbose bool) error {
-	consumer, err := NewConsumer(config, verbose)
+func startConsumer(config ConfigStruct) error {
+	decoders, err := NewDecoders(GetTopicsConfiguration(&config))
+	if err != nil {
+		log.Error().Err(err).Msg("Construct decoders failed")
+		return err
+	}
+
+	brokerConfiguration := GetBrokerConfiguration(&config)
+
+	consumer, err := NewWithSaramaConfig(brokerConfiguration, DefaultSaramaConfig, GetOutputConfiguration(&config).Verbose)
 	if err != nil {
 		log.Error().Err(err).Msg("Construct broker failed")
 		return err
 	}
+	consumer.Decoders = decoders
+
+	consumer.Redactor, err = newRedactor(config)
+	if err != nil {
+		log.Error().Err(err).Msg("Construct redactor failed")
+		return err
+	}
+	consumer.Alerts = NewAlertManager(consumer.clock)
+	consumer.Alerts.SetRedactor(consumer.Redactor)
+
+	profilingConfig := GetProfilingConfiguration(&config)
+	if profilingConfig.Enabled {
+		consumer.Profiler = NewProfiler(GetTopicsConfiguration(&config))
+		if profilingConfig.SummaryInterval > 0 {
+			go consumer.Profiler.LogSummaries(profilingConfig.SummaryInterval)
+		}
+	}
+
+	driftConfig := GetDriftConfiguration(&config)
+	if driftConfig.Enabled {
+		consumer.DriftDetector, err = NewDriftDetector(driftConfig, consumer.Alerts, consumer.clock)
+		if err != nil {
+			log.Error().Err(err).Msg("Construct drift detector failed")
+			return err
+		}
+	}
+
+	archiveConfig := GetArchiveConfiguration(&config)
+	if archiveConfig.Enabled {
+		consumer.Archive, err = NewArchive(archiveConfig, consumer.clock)
+		if err != nil {
+			log.Error().Err(err).Msg("Construct message archive failed")
+			return err
+		}
+		consumer.Archive.SetRedactor(consumer.Redactor)
+		go consumer.Archive.Run()
+	}
+
+	deadLetterConfig := GetDeadLetterConfiguration(&config)
+	if deadLetterConfig.Enabled {
+		consumer.DeadLetter, err = NewDeadLetterSink(deadLetterConfig, GetBrokerConfiguration(&config), consumer.clock)
+		if err != nil {
+			log.Error().Err(err).Msg("Construct dead-letter sink failed")
+			return err
+		}
+	}
+
+	correlationConfig := GetCorrelationConfiguration(&config)
+	if correlationConfig.Enabled {
+		consumer.Correlator, err = NewCorrelator(correlationConfig, GetTopicsConfiguration(&config), consumer.clock)
+		if err != nil {
+			log.Error().Err(err).Msg("Construct correlator failed")
+			return err
+		}
+		go consumer.Correlator.Run()
+	}
+
+	sequenceConfig := GetSequenceConfiguration(&config)
+	if sequenceConfig.Enabled {
+		consumer.SequenceChecker, err = NewSequenceChecker(sequenceConfig, GetTopicsConfiguration(&config), consumer.Alerts, consumer.clock)
+		if err != nil {
+			log.Error().Err(err).Msg("Construct sequence checker failed")
+			return err
+		}
+
+		// offsets normally skip in compacted topics
+		compacted, err := fetchCompactedTopics(brokerConfiguration, consumer.topics())
+		if err != nil {
+			log.Warn().Err(err).Msg("Unable to retrieve cleanup policy of topics")
+		}
+		for _, topic := range compacted {
+			consumer.SequenceChecker.IgnoreOffsetGaps(topic)
+		}
+	}
+
+	if GetTrafficConfiguration(&config).Enabled {
+		consumer.TrafficAnalyzer = NewTrafficAnalyzer(GetTrafficConfiguration(&config), consumer.Alerts, consumer.clock)
+		consumer.TrafficAnalyzer.SetRedactor(consumer.Redactor)
+		go consumer.TrafficAnalyzer.Run()
+	}
+
+	if GetSizeConfiguration(&config).Enabled {
+		consumer.SizeMonitor = NewSizeMonitor(GetSizeConfiguration(&config), consumer.Alerts, consumer.clock)
+
+		// broker limits are optional, monitor works without them
+		limits, err := fetchBrokerLimits(brokerConfiguration, consumer.topics())
+		if err != nil {
+			log.Warn().Err(err).Msg("Unable to retrieve broker message size limits")
+		}
+		for topic, limit := range limits {
+			consumer.SizeMonitor.SetBrokerLimit(topic, limit)
+		}
+	}
+
+	compressionConfig := GetCompressionConfiguration(&config)
+	if compressionConfig.Enabled {
+		consumer.BatchInspector, err = NewBatchInspector(compressionConfig, brokerConfiguration, consumer.Alerts, consumer.clock)
+		if err != nil {
+			log.Error().Err(err).Msg("Construct batch inspector failed")
+			return err
+		}
+		go consumer.BatchInspector.Run(consumer.topics())
+	}
+
+	historyConfig := GetHistoryConfiguration(&config)
+	if historyConfig.Enabled {
+		consumer.History, err = NewHistoryStore(historyConfig, consumer.clock)
+		if err != nil {
+			log.Error().Err(err).Msg("Construct history store failed")
+			return err
+		}
+		go consumer.History.Run(consumer)
+	}
+
+	reportConfig := GetReportConfiguration(&config)
+	if reportConfig.Enabled {
+		consumer.Reporter, err = NewReporter(reportConfig, consumer.topics(), consumer.Alerts, consumer.clock)
+		if err != nil {
+			log.Error().Err(err).Msg("Construct report generator failed")
+			return err
+		}
+		consumer.Reporter.SetRedactor(consumer.Redactor)
+		go consumer.Reporter.Run()
+	}
+
+	dashboardConfig := GetDashboardConfiguration(&config)
+	if dashboardConfig.Enabled {
+		consumer.Dashboard = NewDashboard(dashboardConfig, consumer.Alerts, consumer.clock)
+		consumer.Dashboard.SetRedactor(consumer.Redactor)
+		go consumer.Dashboard.Run()
+	}
+
+	sessionConfig := GetSessionConfiguration(&config)
+	if sessionConfig.Enabled {
+		consumer.Sessions = NewSessionTracker(sessionConfig, consumer.Alerts, consumer.clock)
+	}
+
+	auditConfig := GetAuditConfiguration(&config)
+	if auditConfig.Enabled {
+		consumer.Auditor = NewTopicAuditor(auditConfig, brokerConfiguration,
+			GetTopicsConfiguration(&config), consumer.Alerts, consumer.clock)
+		go consumer.Auditor.Run()
+	}
+
+	consumer.Pipeline, err = NewPipeline(consumer, GetPipelineConfiguration(&config), GetTopicsConfiguration(&config))
+	if err != nil {
+		log.Error().Err(err).Msg("Construct message processing pipeline failed")
+		return err
+	}
+
+	canaryConfig := GetCanaryConfiguration(&config)
+	if canaryConfig.Enabled {
+		canarySaramaConfig, err := headersSaramaConfig(brokerConfiguration)
+		if err != nil {
+			log.Error().Err(err).Msg("Construct canary producer failed")
+			return err
+		}
+		producer, err := sarama.NewSyncProducer([]string{brokerConfiguration.Address}, canarySaramaConfig)
+		if err != nil {
+			log.Error().Err(err).Msg("Construct canary producer failed")
+			return err
+		}
+		consumer.Canary, err = NewCanary(canaryConfig, brokerConfiguration.Topic, producer, consumer.Alerts, consumer.clock)
+		if err != nil {
+			log.Error().Err(err).Msg("Construct canary failed")
+			return err
+		}
+		// canary messages are read back without consumer group, so each
+		// monitor replica receives its own canaries
+		canaryConsumer, err := sarama.NewConsumer([]string{brokerConfiguration.Address}, canarySaramaConfig)
+		if err != nil {
+			log.Error().Err(err).Msg("Construct canary consumer failed")
+			return err
+		}
+		err = consumer.Canary.Listen(canaryConsumer)
+		if err != nil {
+			log.Error().Err(err).Str(topicKey, canaryConfig.Topic).Msg("Read canary topic failed")
+			return err
+		}
+		go consumer.Canary.Run()
+	}
+
+	startHTTPServer(GetServerConfiguration(&config), consumer)
+
 	consumer.Serve()
 	return nil
 }
 
+// newOfflineConsumer function constructs consumer that is not connected to
+// Kafka. It is used to process messages retrieved by other means than by
+// consumer group, for example by -replay command.
+func newOfflineConsumer(config ConfigStruct) (*KafkaConsumer, error) {
+	decoders, err := NewDecoders(GetTopicsConfiguration(&config))
+	if err != nil {
+		log.Error().Err(err).Msg("Construct decoders failed")
+		return nil, err
+	}
+
+	redactor, err := newRedactor(config)
+	if err != nil {
+		log.Error().Err(err).Msg("Construct redactor failed")
+		return nil, err
+	}
+
+	consumer := &KafkaConsumer{
+		Configuration: GetBrokerConfiguration(&config),
+		Verbose:       GetOutputConfiguration(&config).Verbose,
+		Decoders:      decoders,
+		Alerts:        NewAlertManager(nil),
+		Redactor:      redactor,
+	}
+	consumer.Alerts.SetRedactor(redactor)
+
+	if GetProfilingConfiguration(&config).Enabled {
+		consumer.Profiler = NewProfiler(GetTopicsConfiguration(&config))
+	}
+
+	consumer.Pipeline, err = NewPipeline(consumer, GetPipelineConfiguration(&config), GetTopicsConfiguration(&config))
+	if err != nil {
+		log.Error().Err(err).Msg("Construct message processing pipeline failed")
+		return nil, err
+	}
+
+	return consumer, nil
+}
+
+// startHTTPServer function starts HTTP server with REST API in background.
+// Server is not started when its address is not configured.
+func startHTTPServer(config ServerConfiguration, consumer *KafkaConsumer) {
+	if config.Address == "" {
+		log.Info().Msg("HTTP server address is not configured, not starting it")
+		return
+	}
+
+	server := NewHTTPServer(config, consumer)
+	go func() {
+		// errors are logged by server itself
+		_ = server.Start()
+	}()
+}
+
 // doSelectedOperation function perform operation selected on command line.
 // When no operation is specified, the Insights Kafka monitor service is
 // started instead.
@@ -186,6 +637,24 @@ func doSelectedOperation(configuration ConfigStruct, cliFlags CliFlags) (int, er
 		return ExitStatusOK, nil
 	case cliFlags.CheckConnectionToKafka:
 		return tryToConnectToKafka(configuration)
+	case cliFlags.ListTopics:
+		return listTopics(configuration)
+	case cliFlags.DescribeTopic != "":
+		return describeTopic(configuration, cliFlags.DescribeTopic)
+	case cliFlags.ListGroups:
+		return listGroups(configuration)
+	case cliFlags.DescribeGroup != "":
+		return describeGroup(configuration, cliFlags.DescribeGroup)
+	case cliFlags.ListDeadLetters:
+		return listDeadLetters(configuration)
+	case cliFlags.RecheckDeadLetters:
+		return recheckDeadLetters(configuration)
+	case cliFlags.Replay:
+		return replay(configuration, cliFlags)
+	case cliFlags.History:
+		return history(configuration, cliFlags, nil)
+	case cliFlags.AuditTopics:
+		return auditTopics(configuration)
 	default:
 		exitCode, err := startService(configuration)
 		return exitCode, err
@@ -203,6 +672,22 @@ func main() {
 	flag.BoolVar(&cliFlags.ShowAuthors, "authors", false, "show authors")
 	flag.BoolVar(&cliFlags.ShowConfiguration, "show-configuration", false, "show configuration")
 	flag.BoolVar(&cliFlags.CheckConnectionToKafka, "check-kafka", false, "check connection to Kafka")
+	flag.BoolVar(&cliFlags.ListTopics, "list-topics", false, "list all topics available in Kafka cluster")
+	flag.StringVar(&cliFlags.DescribeTopic, "describe-topic", "", "describe selected topic")
+	flag.BoolVar(&cliFlags.ListGroups, "list-groups", false, "list all consumer groups")
+	flag.StringVar(&cliFlags.DescribeGroup, "describe-group", "", "describe selected consumer group")
+	flag.BoolVar(&cliFlags.ListDeadLetters, "list-dead-letters", false, "list messages captured by dead-letter sink")
+	flag.BoolVar(&cliFlags.RecheckDeadLetters, "recheck-dead-letters", false, "process messages captured by dead-letter sink again")
+	flag.BoolVar(&cliFlags.Replay, "replay", false, "process messages from selected range of offsets or timestamps")
+	flag.StringVar(&cliFlags.ReplayTopic, "replay-topic", "", "topic to replay, configured topic is used by default")
+	flag.StringVar(&cliFlags.ReplayPartitions, "replay-partitions", "", "comma-separated list of partitions to replay, all partitions by default")
+	flag.StringVar(&cliFlags.ReplayStart, "replay-start", "", "start offset or RFC 3339 timestamp of replayed range, the oldest offset by default")
+	flag.StringVar(&cliFlags.ReplayEnd, "replay-end", "", "end offset or RFC 3339 timestamp of replayed range (exclusive), the newest offset by default")
+	flag.BoolVar(&cliFlags.History, "history", false, "display stored history of offsets, lag and throughput")
+	flag.StringVar(&cliFlags.HistoryTopic, "history-topic", "", "display history of selected topic only")
+	flag.StringVar(&cliFlags.HistoryStart, "history-start", "", "start of displayed range as RFC 3339 timestamp or duration before now, 24h by default")
+	flag.StringVar(&cliFlags.HistoryEnd, "history-end", "", "end of displayed range as RFC 3339 timestamp or duration before now, now by default")
+	flag.BoolVar(&cliFlags.AuditTopics, "audit-topics", false, "compare live configuration of topics with expected values")
 	flag.Parse()
 
 	// config has exactly the same structure as *.toml file
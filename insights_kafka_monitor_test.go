@@ -89,6 +89,8 @@ func TestShowConfiguration(t *testing.T) {
 	assert.Contains(t, output, "broker_address")
 	assert.Contains(t, output, "broker_topic")
 	assert.Contains(t, output, "Verbose")
+	// default rebalance strategy is displayed when none is configured
+	assert.Regexp(t, `Rebalance strategy\W+range`, output)
 }
 
 // TestDoSelectedOperationShowVersion checks the function showVersion called
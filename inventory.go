@@ -0,0 +1,347 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains implementation of topic and cluster inventory
+// commands: -list-topics, -describe-topic, -list-groups, and
+// -describe-group. All commands are built on top of sarama's ClusterAdmin
+// and use the same broker configuration as the consumer itself.
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Shopify/sarama"
+	"github.com/rs/zerolog/log"
+)
+
+// Messages used by inventory commands
+const (
+	clusterAdminMessage     = "Construct cluster admin"
+	listTopicsMessage       = "List topics"
+	describeTopicMessage    = "Describe topic"
+	listGroupsMessage       = "List consumer groups"
+	describeGroupMessage    = "Describe consumer group"
+	offsetsRetrievalMessage = "Retrieve offsets"
+	closingAdminMessage     = "Closing cluster admin"
+)
+
+// minimalAdminProtocolVersion is the oldest Kafka protocol version that
+// supports all requests made by inventory commands
+var minimalAdminProtocolVersion = sarama.V0_11_0_0
+
+// inventory represents connection to Kafka cluster used by inventory
+// commands. Client is needed to retrieve partition offsets, admin to all
+// other operations.
+type inventory struct {
+	client sarama.Client
+	admin  sarama.ClusterAdmin
+}
+
+// newInventory function connects to Kafka cluster specified in broker
+// configuration.
+func newInventory(brokerCfg BrokerConfiguration) (*inventory, error) {
+	saramaConfig, err := newSaramaConfig(brokerCfg)
+	if err != nil {
+		log.Error().Err(err).Msg(clusterAdminMessage)
+		return nil, err
+	}
+
+	// admin API (DescribeConfigs etc.) is not available for older protocol
+	// versions, so the version needs to be raised for inventory commands
+	if !saramaConfig.Version.IsAtLeast(minimalAdminProtocolVersion) {
+		saramaConfig.Version = minimalAdminProtocolVersion
+	}
+
+	client, err := sarama.NewClient([]string{brokerCfg.Address}, saramaConfig)
+	if err != nil {
+		log.Error().Err(err).Msg(clusterAdminMessage)
+		return nil, err
+	}
+
+	admin, err := sarama.NewClusterAdminFromClient(client)
+	if err != nil {
+		log.Error().Err(err).Msg(clusterAdminMessage)
+		// client needs to be closed explicitly there
+		_ = client.Close()
+		return nil, err
+	}
+
+	return &inventory{
+		client: client,
+		admin:  admin,
+	}, nil
+}
+
+// close method closes cluster admin together with underlying client.
+func (i *inventory) close() {
+	err := i.admin.Close()
+	if err != nil {
+		log.Error().Err(err).Msg(closingAdminMessage)
+	}
+}
+
+// newTableWriter function constructs writer used to display tabular data on
+// standard output.
+func newTableWriter() *tabwriter.Writer {
+	return tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+}
+
+// formatPartitionIDs function converts list of partition or broker IDs into
+// human readable form.
+func formatPartitionIDs(ids []int32) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = fmt.Sprint(id)
+	}
+	return strings.Join(parts, ",")
+}
+
+// sortInt32s function sorts partition or broker IDs in increasing order.
+func sortInt32s(ids []int32) {
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+}
+
+// listTopics function displays all topics available in Kafka cluster.
+func listTopics(config ConfigStruct) (int, error) {
+	inventory, err := newInventory(GetBrokerConfiguration(&config))
+	if err != nil {
+		return ExitStatusKafkaError, err
+	}
+	defer inventory.close()
+
+	topics, err := inventory.admin.ListTopics()
+	if err != nil {
+		log.Error().Err(err).Msg(listTopicsMessage)
+		return ExitStatusKafkaError, err
+	}
+
+	names := make([]string, 0, len(topics))
+	for name := range topics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := newTableWriter()
+	fmt.Fprintln(w, "TOPIC\tPARTITIONS\tREPLICATION FACTOR")
+	for _, name := range names {
+		detail := topics[name]
+		fmt.Fprintf(w, "%s\t%d\t%d\n", name, detail.NumPartitions, detail.ReplicationFactor)
+	}
+
+	return ExitStatusOK, w.Flush()
+}
+
+// describeTopic function displays partitions, leaders, replicas, ISR,
+// earliest and latest offsets, and configuration entries for given topic.
+func describeTopic(config ConfigStruct, topic string) (int, error) {
+	inventory, err := newInventory(GetBrokerConfiguration(&config))
+	if err != nil {
+		return ExitStatusKafkaError, err
+	}
+	defer inventory.close()
+
+	metadata, err := inventory.admin.DescribeTopics([]string{topic})
+	if err != nil {
+		log.Error().Err(err).Msg(describeTopicMessage)
+		return ExitStatusKafkaError, err
+	}
+	if len(metadata) == 0 || metadata[0].Err == sarama.ErrUnknownTopicOrPartition {
+		return ExitStatusTopicError, fmt.Errorf("topic '%s' does not exist", topic)
+	}
+	if metadata[0].Err != sarama.ErrNoError {
+		return ExitStatusTopicError, metadata[0].Err
+	}
+
+	partitions := metadata[0].Partitions
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i].ID < partitions[j].ID })
+
+	fmt.Printf("Topic: %s\n\n", topic)
+
+	w := newTableWriter()
+	fmt.Fprintln(w, "PARTITION\tLEADER\tREPLICAS\tISR\tEARLIEST OFFSET\tLATEST OFFSET")
+	for _, partition := range partitions {
+		earliest, err := inventory.client.GetOffset(topic, partition.ID, sarama.OffsetOldest)
+		if err != nil {
+			log.Error().Err(err).Int32(partitionKey, partition.ID).Msg(offsetsRetrievalMessage)
+			return ExitStatusKafkaError, err
+		}
+		latest, err := inventory.client.GetOffset(topic, partition.ID, sarama.OffsetNewest)
+		if err != nil {
+			log.Error().Err(err).Int32(partitionKey, partition.ID).Msg(offsetsRetrievalMessage)
+			return ExitStatusKafkaError, err
+		}
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%d\t%d\n",
+			partition.ID, partition.Leader,
+			formatPartitionIDs(partition.Replicas), formatPartitionIDs(partition.Isr),
+			earliest, latest)
+	}
+	err = w.Flush()
+	if err != nil {
+		return ExitStatusError, err
+	}
+
+	entries, err := inventory.admin.DescribeConfig(sarama.ConfigResource{
+		Type: sarama.TopicResource,
+		Name: topic,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg(describeTopicMessage)
+		return ExitStatusKafkaError, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+
+	fmt.Println()
+	w = newTableWriter()
+	fmt.Fprintln(w, "CONFIG\tVALUE\tDEFAULT")
+	for _, entry := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%t\n", entry.Name, entry.Value, entry.Default)
+	}
+
+	return ExitStatusOK, w.Flush()
+}
+
+// listGroups function displays all consumer groups known to Kafka cluster.
+func listGroups(config ConfigStruct) (int, error) {
+	inventory, err := newInventory(GetBrokerConfiguration(&config))
+	if err != nil {
+		return ExitStatusKafkaError, err
+	}
+	defer inventory.close()
+
+	groups, err := inventory.admin.ListConsumerGroups()
+	if err != nil {
+		log.Error().Err(err).Msg(listGroupsMessage)
+		return ExitStatusKafkaError, err
+	}
+
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := newTableWriter()
+	fmt.Fprintln(w, "GROUP\tPROTOCOL TYPE")
+	for _, name := range names {
+		fmt.Fprintf(w, "%s\t%s\n", name, groups[name])
+	}
+
+	return ExitStatusOK, w.Flush()
+}
+
+// describeGroup function displays members of given consumer group, their
+// assignments, committed offsets and lag for all partitions the group has
+// committed offsets for.
+func describeGroup(config ConfigStruct, group string) (int, error) {
+	inventory, err := newInventory(GetBrokerConfiguration(&config))
+	if err != nil {
+		return ExitStatusKafkaError, err
+	}
+	defer inventory.close()
+
+	descriptions, err := inventory.admin.DescribeConsumerGroups([]string{group})
+	if err != nil {
+		log.Error().Err(err).Msg(describeGroupMessage)
+		return ExitStatusKafkaError, err
+	}
+	if len(descriptions) == 0 {
+		return ExitStatusKafkaError, fmt.Errorf("consumer group '%s' does not exist", group)
+	}
+	description := descriptions[0]
+	if description.Err != sarama.ErrNoError {
+		return ExitStatusKafkaError, description.Err
+	}
+
+	fmt.Printf("Group: %s\nState: %s\nProtocol: %s\n\n", group, description.State, description.Protocol)
+
+	memberIDs := make([]string, 0, len(description.Members))
+	for memberID := range description.Members {
+		memberIDs = append(memberIDs, memberID)
+	}
+	sort.Strings(memberIDs)
+
+	w := newTableWriter()
+	fmt.Fprintln(w, "MEMBER\tCLIENT\tHOST\tTOPIC\tPARTITIONS")
+	for _, memberID := range memberIDs {
+		member := description.Members[memberID]
+		assignment, err := member.GetMemberAssignment()
+		if err != nil || assignment == nil || len(assignment.Topics) == 0 {
+			fmt.Fprintf(w, "%s\t%s\t%s\t-\t-\n", memberID, member.ClientId, member.ClientHost)
+			continue
+		}
+		assignedTopics := make([]string, 0, len(assignment.Topics))
+		for topic := range assignment.Topics {
+			assignedTopics = append(assignedTopics, topic)
+		}
+		sort.Strings(assignedTopics)
+		for _, topic := range assignedTopics {
+			partitions := assignment.Topics[topic]
+			sortInt32s(partitions)
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+				memberID, member.ClientId, member.ClientHost, topic, formatPartitionIDs(partitions))
+		}
+	}
+	err = w.Flush()
+	if err != nil {
+		return ExitStatusError, err
+	}
+
+	// nil means offsets for all partitions the group has committed to
+	offsets, err := inventory.admin.ListConsumerGroupOffsets(group, nil)
+	if err != nil {
+		log.Error().Err(err).Msg(describeGroupMessage)
+		return ExitStatusKafkaError, err
+	}
+
+	topics := make([]string, 0, len(offsets.Blocks))
+	for topic := range offsets.Blocks {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	fmt.Println()
+	w = newTableWriter()
+	fmt.Fprintln(w, "TOPIC\tPARTITION\tCOMMITTED OFFSET\tLATEST OFFSET\tLAG")
+	for _, topic := range topics {
+		blocks := offsets.Blocks[topic]
+		partitions := make([]int32, 0, len(blocks))
+		for partition := range blocks {
+			partitions = append(partitions, partition)
+		}
+		sortInt32s(partitions)
+
+		for _, partition := range partitions {
+			committed := blocks[partition].Offset
+			latest, err := inventory.client.GetOffset(topic, partition, sarama.OffsetNewest)
+			if err != nil {
+				log.Error().Err(err).Int32(partitionKey, partition).Msg(offsetsRetrievalMessage)
+				return ExitStatusKafkaError, err
+			}
+			lag := "-"
+			if committed >= 0 {
+				lag = fmt.Sprint(latest - committed)
+			}
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\n", topic, partition, committed, latest, lag)
+		}
+	}
+
+	return ExitStatusOK, w.Flush()
+}
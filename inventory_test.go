@@ -0,0 +1,151 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Unit test definitions for functions and methods defined in source file
+// inventory.go
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/tisnik/go-capture"
+
+	main "github.com/RedHatInsights/insights-kafka-monitor"
+)
+
+// newInventoryMockBroker function constructs mock broker that is able to
+// respond to all requests made by inventory commands.
+func newInventoryMockBroker(t *testing.T) *sarama.MockBroker {
+	broker := sarama.NewMockBroker(t, 1)
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetController(broker.BrokerID()).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader(testTopic, 0, broker.BrokerID()),
+		"DescribeConfigsRequest": sarama.NewMockDescribeConfigsResponse(t),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset(testTopic, 0, sarama.OffsetOldest, 10).
+			SetOffset(testTopic, 0, sarama.OffsetNewest, 42),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, testGroup, broker),
+		"ListGroupsRequest": sarama.NewMockListGroupsResponse(t).
+			AddGroup(testGroup, "consumer"),
+		"DescribeGroupsRequest": sarama.NewMockDescribeGroupsResponse(t).
+			AddGroupDescription(testGroup, &sarama.GroupDescription{
+				GroupId:  testGroup,
+				State:    "Stable",
+				Protocol: "range",
+				Members: map[string]*sarama.GroupMemberDescription{
+					"member-1": {
+						MemberId:   "member-1",
+						ClientId:   "client-1",
+						ClientHost: "/127.0.0.1",
+					},
+				},
+			}),
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+			SetOffset(testGroup, testTopic, 0, 40, "", sarama.ErrNoError),
+	})
+
+	return broker
+}
+
+// TestListTopics checks the function listTopics
+func TestListTopics(t *testing.T) {
+	broker := newInventoryMockBroker(t)
+	defer broker.Close()
+
+	output, err := capture.StandardOutput(func() {
+		code, err := main.ListTopics(configurationForMockBroker(broker, testGroup))
+		assert.NoError(t, err)
+		assert.Equal(t, main.ExitStatusOK, code)
+	})
+	checkCapture(t, err)
+
+	assert.Contains(t, output, "TOPIC")
+	assert.Contains(t, output, testTopic)
+}
+
+// TestDescribeTopic checks the function describeTopic
+func TestDescribeTopic(t *testing.T) {
+	broker := newInventoryMockBroker(t)
+	defer broker.Close()
+
+	output, err := capture.StandardOutput(func() {
+		code, err := main.DescribeTopic(configurationForMockBroker(broker, testGroup), testTopic)
+		assert.NoError(t, err)
+		assert.Equal(t, main.ExitStatusOK, code)
+	})
+	checkCapture(t, err)
+
+	assert.Contains(t, output, "Topic: "+testTopic)
+	assert.Regexp(t, `0\s+1\s+1\s+1\s+10\s+42`, output)
+	assert.Contains(t, output, "max.message.bytes")
+	assert.Contains(t, output, "retention.ms")
+}
+
+// TestDescribeNonExistingTopic checks the function describeTopic for topic
+// that does not exist
+func TestDescribeNonExistingTopic(t *testing.T) {
+	broker := newInventoryMockBroker(t)
+	defer broker.Close()
+
+	_, err := capture.StandardOutput(func() {
+		code, err := main.DescribeTopic(configurationForMockBroker(broker, testGroup), "foobar")
+		assert.EqualError(t, err, "topic 'foobar' does not exist")
+		assert.Equal(t, main.ExitStatusTopicError, code)
+	})
+	checkCapture(t, err)
+}
+
+// TestListGroups checks the function listGroups
+func TestListGroups(t *testing.T) {
+	broker := newInventoryMockBroker(t)
+	defer broker.Close()
+
+	output, err := capture.StandardOutput(func() {
+		code, err := main.ListGroups(configurationForMockBroker(broker, testGroup))
+		assert.NoError(t, err)
+		assert.Equal(t, main.ExitStatusOK, code)
+	})
+	checkCapture(t, err)
+
+	assert.Contains(t, output, testGroup)
+	assert.Contains(t, output, "consumer")
+}
+
+// TestDescribeGroup checks the function describeGroup
+func TestDescribeGroup(t *testing.T) {
+	broker := newInventoryMockBroker(t)
+	defer broker.Close()
+
+	output, err := capture.StandardOutput(func() {
+		code, err := main.DescribeGroup(configurationForMockBroker(broker, testGroup), testGroup)
+		assert.NoError(t, err)
+		assert.Equal(t, main.ExitStatusOK, code)
+	})
+	checkCapture(t, err)
+
+	assert.Contains(t, output, "State: Stable")
+	assert.Contains(t, output, "member-1")
+	assert.Contains(t, output, "client-1")
+	// committed offset 40, latest offset 42 -> lag 2
+	assert.Regexp(t, testTopic+`\s+0\s+40\s+42\s+2`, output)
+}
@@ -0,0 +1,139 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains helper functions to read bounded range of
+// messages from topic partitions. Messages are read by plain partition
+// consumer, so no consumer group is used or modified. Client used to read
+// messages needs to use protocol version that supports record headers.
+
+import (
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/rs/zerolog/log"
+)
+
+// partitionReadTimeout is the maximum time to wait for next message from
+// partition. Reading is finished when no message arrives in this time, for
+// example when the last offsets in range belong to transaction markers.
+var partitionReadTimeout = 10 * time.Second
+
+// messageHandler is a function called for each message read from partition.
+// Reading is stopped when the handler returns false.
+type messageHandler func(msg *sarama.ConsumerMessage) bool
+
+// readPartition function reads all messages from given partition with
+// offsets in range <start, end) and calls handler for each message.
+func readPartition(consumer sarama.Consumer, topic string, partition int32, start, end int64, handler messageHandler) error {
+	if start >= end {
+		return nil
+	}
+
+	partitionConsumer, err := consumer.ConsumePartition(topic, partition, start)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		err := partitionConsumer.Close()
+		if err != nil {
+			log.Error().Err(err).Str(topicKey, topic).Int32(partitionKey, partition).Msg("Closing partition consumer")
+		}
+	}()
+
+	for {
+		select {
+		case msg := <-partitionConsumer.Messages():
+			if msg.Offset >= end {
+				return nil
+			}
+			if !handler(msg) || msg.Offset >= end-1 {
+				return nil
+			}
+		case err := <-partitionConsumer.Errors():
+			return err
+		case <-time.After(partitionReadTimeout):
+			log.Warn().
+				Str(topicKey, topic).
+				Int32(partitionKey, partition).
+				Int64("end", end).
+				Msg("No more messages available in partition")
+			return nil
+		}
+	}
+}
+
+// newReaderClient function constructs Kafka client used to read messages
+// from partitions. Protocol version is raised if needed, so record headers
+// are fetched together with messages.
+func newReaderClient(brokerCfg BrokerConfiguration) (sarama.Client, error) {
+	saramaConfig, err := headersSaramaConfig(brokerCfg)
+	if err != nil {
+		return nil, err
+	}
+	return sarama.NewClient([]string{brokerCfg.Address}, saramaConfig)
+}
+
+// readTopic function reads all messages that are actually available in all
+// partitions of given topic and calls handler for each message.
+func readTopic(client sarama.Client, topic string, handler messageHandler) error {
+	partitions, err := client.Partitions(topic)
+	if err != nil {
+		return err
+	}
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		err := consumer.Close()
+		if err != nil {
+			log.Error().Err(err).Str(topicKey, topic).Msg("Closing consumer")
+		}
+	}()
+
+	for _, partition := range partitions {
+		start, err := client.GetOffset(topic, partition, sarama.OffsetOldest)
+		if err != nil {
+			return err
+		}
+		end, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			return err
+		}
+
+		stopped := false
+		err = readPartition(consumer, topic, partition, start, end, func(msg *sarama.ConsumerMessage) bool {
+			if !handler(msg) {
+				stopped = true
+				return false
+			}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		if stopped {
+			return nil
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,523 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains implementation of message processing pipeline.
+// Each consumed message is passed through ordered chain of processors
+// (stages). Order of stages is configured globally in [pipeline] section and
+// it can be overridden for each topic in [[topics]] table, so each stage
+// can be enabled or disabled per topic. Custom stages can be registered by
+// RegisterProcessor function. Time spent in each stage and number of errors
+// reported by each stage are tracked per topic.
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Names of built-in stages
+const (
+	StageSize      = "size"
+	StageDecode    = "decode"
+	StageFilter    = "filter"
+	StageValidate  = "validate"
+	StageDedup     = "dedup"
+	StageProfile   = "profile"
+	StageDrift     = "drift"
+	StageCorrelate = "correlate"
+	StageArchive   = "archive"
+	StageAlert     = "alert"
+	StageLog       = "log"
+)
+
+// AlertMessageMatched is type of alert raised by alert stage
+const AlertMessageMatched = "message_matched"
+
+// defaultStageAlertInterval is the default minimal time between two alerts
+// raised by alert stage for the same value
+const defaultStageAlertInterval = time.Minute
+
+// DefaultStages is the default order of stages. Stages whose components
+// are not enabled or configured are skipped. Components used by other
+// stages (drift, size, ...) raise their alerts directly, alert stage raises
+// alerts for messages matching rule configured for topic.
+var DefaultStages = []string{
+	StageSize,
+	StageDecode,
+	StageFilter,
+	StageValidate,
+	StageDedup,
+	StageProfile,
+	StageDrift,
+	StageCorrelate,
+	StageArchive,
+	StageAlert,
+	StageLog,
+}
+
+// ErrMessageFiltered is returned by processor when message is to be
+// dropped. Remaining stages are skipped, but the message is not considered
+// to be failed.
+var ErrMessageFiltered = errors.New("message filtered out")
+
+// MessageProcessor represents one stage of message processing pipeline
+type MessageProcessor interface {
+	// Name returns name of the stage
+	Name() string
+	// Process processes one message. Decoded content of message can be
+	// replaced by processor.
+	Process(message *DecodedMessage) error
+}
+
+// ProcessorFactory is a function that constructs processor for given topic.
+// Nil processor is to be returned when the stage is not applicable to the
+// topic (for example when the component is disabled).
+type ProcessorFactory func(consumer *KafkaConsumer, topicConfig TopicConfiguration) (MessageProcessor, error)
+
+// processorFactories contains factories of all registered stages
+var processorFactories = map[string]ProcessorFactory{}
+
+// RegisterProcessor function registers new stage that can be used in
+// pipeline configuration
+func RegisterProcessor(name string, factory ProcessorFactory) {
+	processorFactories[name] = factory
+}
+
+func init() {
+	RegisterProcessor(StageSize, newSizeProcessor)
+	RegisterProcessor(StageDecode, newDecodeProcessor)
+	RegisterProcessor(StageFilter, newFilterProcessor)
+	RegisterProcessor(StageValidate, newValidateProcessor)
+	RegisterProcessor(StageDedup, newDedupProcessor)
+	RegisterProcessor(StageProfile, newProfileProcessor)
+	RegisterProcessor(StageDrift, newDriftProcessor)
+	RegisterProcessor(StageCorrelate, newCorrelateProcessor)
+	RegisterProcessor(StageArchive, newArchiveProcessor)
+	RegisterProcessor(StageAlert, newAlertProcessor)
+	RegisterProcessor(StageLog, newLogProcessor)
+}
+
+// StageStats contains statistic about one stage
+type StageStats struct {
+	Processed uint64        `json:"processed"`
+	Errors    uint64        `json:"errors"`
+	Filtered  uint64        `json:"filtered"`
+	TotalTime time.Duration `json:"total_time"`
+	MaxTime   time.Duration `json:"max_time"`
+}
+
+// pipelineStage represents one stage of chain constructed for one topic
+type pipelineStage struct {
+	processor MessageProcessor
+	stats     *StageStats
+}
+
+// Pipeline represents message processing pipeline. Chain of processors is
+// constructed for each topic when the first message from the topic is
+// processed.
+type Pipeline struct {
+	mutex    sync.Mutex
+	consumer *KafkaConsumer
+	stages   []string
+	topics   map[string]TopicConfiguration
+	chains   map[string][]pipelineStage
+}
+
+// NewPipeline constructs new pipeline for given consumer. Components used
+// by stages (decoders, profiler, etc.) are taken from the consumer.
+func NewPipeline(consumer *KafkaConsumer, config PipelineConfiguration, topicsConfig []TopicConfiguration) (*Pipeline, error) {
+	stages := config.Stages
+	if len(stages) == 0 {
+		stages = DefaultStages
+	}
+	err := checkStages(stages)
+	if err != nil {
+		return nil, err
+	}
+
+	topics := make(map[string]TopicConfiguration, len(topicsConfig))
+	for _, topicConfig := range topicsConfig {
+		err := checkStages(topicConfig.Stages)
+		if err != nil {
+			return nil, fmt.Errorf("topic '%s': %v", topicConfig.Name, err)
+		}
+		topics[topicConfig.Name] = topicConfig
+	}
+
+	return &Pipeline{
+		consumer: consumer,
+		stages:   stages,
+		topics:   topics,
+		chains:   make(map[string][]pipelineStage),
+	}, nil
+}
+
+// checkStages function checks that all stages are registered
+func checkStages(stages []string) error {
+	for _, stage := range stages {
+		if _, found := processorFactories[stage]; !found {
+			return fmt.Errorf("unknown pipeline stage '%s'", stage)
+		}
+	}
+	return nil
+}
+
+// topicStages method returns list of stage names enabled for given topic
+func (pipeline *Pipeline) topicStages(topic string) []string {
+	if topicConfig, found := pipeline.topics[topic]; found && len(topicConfig.Stages) > 0 {
+		return topicConfig.Stages
+	}
+	return pipeline.stages
+}
+
+// chain method returns chain of processors for given topic. Chain is
+// constructed if needed.
+func (pipeline *Pipeline) chain(topic string) ([]pipelineStage, error) {
+	pipeline.mutex.Lock()
+	defer pipeline.mutex.Unlock()
+
+	if chain, found := pipeline.chains[topic]; found {
+		return chain, nil
+	}
+
+	topicConfig, found := pipeline.topics[topic]
+	if !found {
+		topicConfig = TopicConfiguration{Name: topic}
+	}
+
+	var chain []pipelineStage
+	for _, stage := range pipeline.topicStages(topic) {
+		processor, err := processorFactories[stage](pipeline.consumer, topicConfig)
+		if err != nil {
+			return nil, err
+		}
+		if processor == nil {
+			continue
+		}
+		chain = append(chain, pipelineStage{
+			processor: processor,
+			stats:     &StageStats{},
+		})
+	}
+
+	pipeline.chains[topic] = chain
+	return chain, nil
+}
+
+// HasStage method checks if given stage is part of chain for given topic
+func (pipeline *Pipeline) HasStage(topic, stage string) bool {
+	chain, err := pipeline.chain(topic)
+	if err != nil {
+		return false
+	}
+	for _, s := range chain {
+		if s.processor.Name() == stage {
+			return true
+		}
+	}
+	return false
+}
+
+// Process method passes message through all stages configured for message
+// topic. Processing is stopped on the first error. Filtered message is not
+// considered to be an error.
+func (pipeline *Pipeline) Process(message *DecodedMessage) error {
+	chain, err := pipeline.chain(message.Message.Topic)
+	if err != nil {
+		return err
+	}
+
+	for _, stage := range chain {
+		// time spent in stage is measured by monotonic clock, clock
+		// injected into consumer is used for message timestamps only
+		started := time.Now()
+		err := stage.processor.Process(message)
+		duration := time.Since(started)
+
+		pipeline.mutex.Lock()
+		stage.stats.Processed++
+		stage.stats.TotalTime += duration
+		if duration > stage.stats.MaxTime {
+			stage.stats.MaxTime = duration
+		}
+		switch {
+		case errors.Is(err, ErrMessageFiltered):
+			stage.stats.Filtered++
+		case err != nil:
+			stage.stats.Errors++
+		}
+		pipeline.mutex.Unlock()
+
+		if errors.Is(err, ErrMessageFiltered) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Stats method returns statistic of all stages, per topic
+func (pipeline *Pipeline) Stats() map[string]map[string]StageStats {
+	pipeline.mutex.Lock()
+	defer pipeline.mutex.Unlock()
+
+	stats := make(map[string]map[string]StageStats, len(pipeline.chains))
+	for topic, chain := range pipeline.chains {
+		topicStats := make(map[string]StageStats, len(chain))
+		for _, stage := range chain {
+			topicStats[stage.processor.Name()] = *stage.stats
+		}
+		stats[topic] = topicStats
+	}
+	return stats
+}
+
+// processorFunc is an adapter to use ordinary function as processor
+type processorFunc struct {
+	name    string
+	process func(message *DecodedMessage) error
+}
+
+// Name method returns name of the stage
+func (processor processorFunc) Name() string {
+	return processor.name
+}
+
+// Process method calls the function
+func (processor processorFunc) Process(message *DecodedMessage) error {
+	return processor.process(message)
+}
+
+// NewProcessorFunc function constructs processor from ordinary function
+func NewProcessorFunc(name string, process func(message *DecodedMessage) error) MessageProcessor {
+	return processorFunc{name: name, process: process}
+}
+
+// newSizeProcessor function constructs stage that updates message size
+// statistic and checks size limits. Size of raw message is checked, so the
+// stage is to be placed before decode stage.
+func newSizeProcessor(consumer *KafkaConsumer, topicConfig TopicConfiguration) (MessageProcessor, error) {
+	if consumer.SizeMonitor == nil {
+		return nil, nil
+	}
+	return NewProcessorFunc(StageSize, func(message *DecodedMessage) error {
+		consumer.SizeMonitor.Check(message.Message)
+		return nil
+	}), nil
+}
+
+// newDecodeProcessor function constructs stage that applies decoders
+// configured for topic
+func newDecodeProcessor(consumer *KafkaConsumer, topicConfig TopicConfiguration) (MessageProcessor, error) {
+	chain, found := consumer.Decoders[topicConfig.Name]
+	if !found {
+		return nil, nil
+	}
+	return NewProcessorFunc(StageDecode, func(message *DecodedMessage) error {
+		content, err := chain.Decode(message.Content)
+		if err != nil {
+			return err
+		}
+		message.Content = content
+		return nil
+	}), nil
+}
+
+// newFilterProcessor function constructs stage that drops messages whose
+// filter field does not have one of configured values
+func newFilterProcessor(consumer *KafkaConsumer, topicConfig TopicConfiguration) (MessageProcessor, error) {
+	field := topicConfig.FilterField
+	if field == "" {
+		return nil, nil
+	}
+
+	values := make(map[string]bool, len(topicConfig.FilterValues))
+	for _, value := range topicConfig.FilterValues {
+		values[value] = true
+	}
+
+	return NewProcessorFunc(StageFilter, func(message *DecodedMessage) error {
+		value, found := message.Field(field)
+		if !found || !values[cardinalityValue(value)] {
+			return ErrMessageFiltered
+		}
+		return nil
+	}), nil
+}
+
+// newValidateProcessor function constructs stage that checks that message
+// is JSON object containing all required fields
+func newValidateProcessor(consumer *KafkaConsumer, topicConfig TopicConfiguration) (MessageProcessor, error) {
+	required := topicConfig.RequiredFields
+	if len(required) == 0 {
+		return nil, nil
+	}
+
+	return NewProcessorFunc(StageValidate, func(message *DecodedMessage) error {
+		document, err := message.JSON()
+		if err != nil {
+			return err
+		}
+		if _, ok := document.(map[string]interface{}); !ok {
+			return fmt.Errorf("message is not JSON object")
+		}
+
+		var missing []string
+		for _, field := range required {
+			if _, found := message.Field(field); !found {
+				missing = append(missing, field)
+			}
+		}
+		if len(missing) > 0 {
+			sort.Strings(missing)
+			return fmt.Errorf("required fields are missing: %s", strings.Join(missing, ", "))
+		}
+		return nil
+	}), nil
+}
+
+// newDedupProcessor function constructs stage that detects duplicate
+// messages. Duplicates are reported, but they are not dropped.
+func newDedupProcessor(consumer *KafkaConsumer, topicConfig TopicConfiguration) (MessageProcessor, error) {
+	if consumer.SequenceChecker == nil || !consumer.SequenceChecker.HasDedupRule(topicConfig.Name) {
+		return nil, nil
+	}
+	return NewProcessorFunc(StageDedup, func(message *DecodedMessage) error {
+		consumer.SequenceChecker.CheckDuplicate(message)
+		return nil
+	}), nil
+}
+
+// newProfileProcessor function constructs stage that updates payload
+// profile
+func newProfileProcessor(consumer *KafkaConsumer, topicConfig TopicConfiguration) (MessageProcessor, error) {
+	if consumer.Profiler == nil {
+		return nil, nil
+	}
+	return NewProcessorFunc(StageProfile, func(message *DecodedMessage) error {
+		consumer.Profiler.Profile(message)
+		return nil
+	}), nil
+}
+
+// newDriftProcessor function constructs stage that detects schema drift
+// and raises alerts
+func newDriftProcessor(consumer *KafkaConsumer, topicConfig TopicConfiguration) (MessageProcessor, error) {
+	if consumer.DriftDetector == nil {
+		return nil, nil
+	}
+	return NewProcessorFunc(StageDrift, func(message *DecodedMessage) error {
+		consumer.DriftDetector.Check(message)
+		return nil
+	}), nil
+}
+
+// newCorrelateProcessor function constructs stage that tracks arrival of
+// correlation IDs for topics that are part of correlated pipeline
+func newCorrelateProcessor(consumer *KafkaConsumer, topicConfig TopicConfiguration) (MessageProcessor, error) {
+	if consumer.Correlator == nil || !consumer.Correlator.HasTopic(topicConfig.Name) {
+		return nil, nil
+	}
+	return NewProcessorFunc(StageCorrelate, func(message *DecodedMessage) error {
+		consumer.Correlator.Track(message)
+		return nil
+	}), nil
+}
+
+// newArchiveProcessor function constructs stage that archives sample of
+// successfully processed messages. Messages that failed in previous stages
+// are archived by HandleMessage.
+func newArchiveProcessor(consumer *KafkaConsumer, topicConfig TopicConfiguration) (MessageProcessor, error) {
+	if consumer.Archive == nil {
+		return nil, nil
+	}
+	return NewProcessorFunc(StageArchive, func(message *DecodedMessage) error {
+		consumer.Archive.Record(message.Message, nil)
+		return nil
+	}), nil
+}
+
+// newAlertProcessor function constructs stage that raises alert for
+// messages whose alert field has one of configured values. Alerts for the
+// same value are raised at most once per alert interval configured for
+// topic.
+func newAlertProcessor(consumer *KafkaConsumer, topicConfig TopicConfiguration) (MessageProcessor, error) {
+	field := topicConfig.AlertField
+	if field == "" || consumer.Alerts == nil {
+		return nil, nil
+	}
+
+	values := make(map[string]bool, len(topicConfig.AlertValues))
+	for _, value := range topicConfig.AlertValues {
+		values[value] = true
+	}
+
+	interval := topicConfig.AlertInterval
+	if interval <= 0 {
+		interval = defaultStageAlertInterval
+	}
+	throttle := newAlertThrottle(interval, consumer.clock)
+
+	return NewProcessorFunc(StageAlert, func(message *DecodedMessage) error {
+		value, found := message.Field(field)
+		if !found {
+			return nil
+		}
+		text := cardinalityValue(value)
+		if !values[text] {
+			return nil
+		}
+
+		allowed, suppressed := throttle.allow(text)
+		if !allowed {
+			return nil
+		}
+		consumer.Alerts.Raise(Alert{
+			Type:     AlertMessageMatched,
+			Severity: SeverityWarning,
+			Topic:    message.Message.Topic,
+			Message:  fmt.Sprintf("Field '%s' has value '%s'", field, text),
+			Details: map[string]interface{}{
+				"field":      field,
+				"value":      text,
+				"partition":  message.Message.Partition,
+				"offset":     message.Message.Offset,
+				"suppressed": suppressed,
+			},
+		})
+		return nil
+	}), nil
+}
+
+// newLogProcessor function constructs stage that writes message length
+// and, in verbose mode, message content into log
+func newLogProcessor(consumer *KafkaConsumer, topicConfig TopicConfiguration) (MessageProcessor, error) {
+	return NewProcessorFunc(StageLog, func(message *DecodedMessage) error {
+		consumer.log().Info().Int("length", len(message.Message.Value)).Msg("Message length")
+		if consumer.Verbose {
+			consumer.log().Info().Str("content", string(consumer.Redactor.RedactPayload(message.Content))).Msg("Message value")
+		}
+		return nil
+	}), nil
+}
@@ -0,0 +1,196 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Unit test definitions for functions and methods defined in source file
+// processor.go
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-kafka-monitor"
+)
+
+// TestNewPipelineUnknownStage checks that unknown stages are refused
+func TestNewPipelineUnknownStage(t *testing.T) {
+	_, err := main.NewPipeline(NewDummyConsumer(), main.PipelineConfiguration{
+		Stages: []string{main.StageDecode, "teleport"},
+	}, nil)
+	assert.EqualError(t, err, "unknown pipeline stage 'teleport'")
+
+	_, err = main.NewPipeline(NewDummyConsumer(), main.PipelineConfiguration{}, []main.TopicConfiguration{
+		{Name: "topic", Stages: []string{"teleport"}},
+	})
+	assert.EqualError(t, err, "topic 'topic': unknown pipeline stage 'teleport'")
+}
+
+// TestPipelineSkipsDisabledStages checks that stages whose components are
+// not enabled are not part of chain
+func TestPipelineSkipsDisabledStages(t *testing.T) {
+	consumer := NewDummyConsumer()
+	pipeline, err := main.NewPipeline(consumer, main.PipelineConfiguration{}, nil)
+	assert.NoError(t, err)
+
+	assert.NoError(t, pipeline.Process(decodedMessage("topic", `{"a": 1}`)))
+
+	stats := pipeline.Stats()["topic"]
+	assert.Len(t, stats, 1)
+	assert.Equal(t, uint64(1), stats[main.StageLog].Processed)
+	assert.False(t, pipeline.HasStage("topic", main.StageProfile))
+
+	consumer.Profiler = main.NewProfiler(nil)
+	pipeline, err = main.NewPipeline(consumer, main.PipelineConfiguration{}, nil)
+	assert.NoError(t, err)
+	assert.True(t, pipeline.HasStage("topic", main.StageProfile))
+}
+
+// TestPipelineFilterAndValidate checks filter and validate stages together
+// with per-stage statistic
+func TestPipelineFilterAndValidate(t *testing.T) {
+	consumer := NewDummyConsumer()
+	pipeline, err := main.NewPipeline(consumer, main.PipelineConfiguration{}, []main.TopicConfiguration{
+		{
+			Name:           "topic",
+			FilterField:    "type",
+			FilterValues:   []string{"result"},
+			RequiredFields: []string{"id", "report.version"},
+		},
+	})
+	assert.NoError(t, err)
+
+	// filtered message is not an error
+	assert.NoError(t, pipeline.Process(decodedMessage("topic", `{"type": "upload"}`)))
+	assert.NoError(t, pipeline.Process(decodedMessage("topic", `{"type": "result", "id": 1, "report": {"version": 2}}`)))
+	err = pipeline.Process(decodedMessage("topic", `{"type": "result"}`))
+	assert.EqualError(t, err, "required fields are missing: id, report.version")
+
+	stats := pipeline.Stats()["topic"]
+	assert.Equal(t, uint64(3), stats[main.StageFilter].Processed)
+	assert.Equal(t, uint64(1), stats[main.StageFilter].Filtered)
+	assert.Equal(t, uint64(2), stats[main.StageValidate].Processed)
+	assert.Equal(t, uint64(1), stats[main.StageValidate].Errors)
+	assert.Equal(t, uint64(1), stats[main.StageLog].Processed)
+}
+
+// TestPipelineValidateNonJSON checks that validate stage refuses payload
+// that is not JSON object
+func TestPipelineValidateNonJSON(t *testing.T) {
+	pipeline, err := main.NewPipeline(NewDummyConsumer(), main.PipelineConfiguration{}, []main.TopicConfiguration{
+		{Name: "topic", RequiredFields: []string{"id"}},
+	})
+	assert.NoError(t, err)
+
+	assert.Error(t, pipeline.Process(decodedMessage("topic", `not a JSON`)))
+	assert.EqualError(t, pipeline.Process(decodedMessage("topic", `[1, 2]`)), "message is not JSON object")
+}
+
+// TestPipelineTopicStages checks that stages can be configured per topic
+// and that custom stages can be registered
+func TestPipelineTopicStages(t *testing.T) {
+	var processed []string
+	main.RegisterProcessor("custom", func(consumer *main.KafkaConsumer, topicConfig main.TopicConfiguration) (main.MessageProcessor, error) {
+		return main.NewProcessorFunc("custom", func(message *main.DecodedMessage) error {
+			processed = append(processed, string(message.Content))
+			if string(message.Content) == "bad" {
+				return errors.New("custom check failed")
+			}
+			return nil
+		}), nil
+	})
+
+	pipeline, err := main.NewPipeline(NewDummyConsumer(), main.PipelineConfiguration{}, []main.TopicConfiguration{
+		{Name: "checked", Stages: []string{"custom"}},
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, pipeline.Process(decodedMessage("checked", "good")))
+	assert.EqualError(t, pipeline.Process(decodedMessage("checked", "bad")), "custom check failed")
+	assert.NoError(t, pipeline.Process(decodedMessage("other", "bad")))
+
+	assert.Equal(t, []string{"good", "bad"}, processed)
+	assert.True(t, pipeline.HasStage("checked", "custom"))
+	assert.False(t, pipeline.HasStage("checked", main.StageLog))
+	assert.False(t, pipeline.HasStage("other", "custom"))
+	assert.Equal(t, uint64(1), pipeline.Stats()["checked"]["custom"].Errors)
+}
+
+// TestHandleMessageArchivesOnce checks that each message is archived just
+// once regardless of processing result
+func TestHandleMessageArchivesOnce(t *testing.T) {
+	directory := t.TempDir()
+	archive, err := main.NewArchive(main.ArchiveConfiguration{Directory: directory}, nil)
+	assert.NoError(t, err)
+
+	decoders, err := main.NewDecoders([]main.TopicConfiguration{
+		{Name: "topic", Decoders: []string{"json"}},
+	})
+	assert.NoError(t, err)
+
+	consumer := NewDummyConsumer()
+	consumer.Decoders = decoders
+	consumer.Archive = archive
+
+	consumer.HandleMessage(consumerMessage(1, []byte(`{"a": 1}`)))
+	consumer.HandleMessage(consumerMessage(2, []byte(`not a JSON`)))
+	assert.NoError(t, archive.Close())
+
+	records := archivedRecords(t, directory)
+	assert.Len(t, records, 2)
+	assert.Empty(t, records[0].Error)
+	assert.NotEmpty(t, records[1].Error)
+}
+
+// TestPipelineAlertStage checks that alert stage raises throttled alerts
+// for matching messages and that it can be reordered
+func TestPipelineAlertStage(t *testing.T) {
+	consumer := NewDummyConsumer()
+	consumer.Alerts = main.NewAlertManager(nil)
+	topicConfig := main.TopicConfiguration{
+		Name:         "topic",
+		FilterField:  "type",
+		FilterValues: []string{"result"},
+		AlertField:   "status",
+		AlertValues:  []string{"error"},
+	}
+	pipeline, err := main.NewPipeline(consumer, main.PipelineConfiguration{}, []main.TopicConfiguration{topicConfig})
+	assert.NoError(t, err)
+	assert.True(t, pipeline.HasStage("topic", main.StageAlert))
+
+	// alert stage is placed after filter stage by default
+	assert.NoError(t, pipeline.Process(decodedMessage("topic", `{"type": "upload", "status": "error"}`)))
+	assert.NoError(t, pipeline.Process(decodedMessage("topic", `{"type": "result", "status": "ok"}`)))
+	assert.Empty(t, consumer.Alerts.Recent())
+
+	assert.NoError(t, pipeline.Process(decodedMessage("topic", `{"type": "result", "status": "error"}`)))
+	assert.NoError(t, pipeline.Process(decodedMessage("topic", `{"type": "result", "status": "error"}`)))
+	recent := consumer.Alerts.Recent()
+	assert.Equal(t, []string{main.AlertMessageMatched}, alertTypes(consumer.Alerts))
+	assert.Equal(t, "error", recent[0].Details["value"])
+
+	// alert stage placed before filter stage sees all messages
+	consumer.Alerts = main.NewAlertManager(nil)
+	topicConfig.Stages = []string{main.StageAlert, main.StageFilter}
+	pipeline, err = main.NewPipeline(consumer, main.PipelineConfiguration{}, []main.TopicConfiguration{topicConfig})
+	assert.NoError(t, err)
+
+	assert.NoError(t, pipeline.Process(decodedMessage("topic", `{"type": "upload", "status": "error"}`)))
+	assert.Equal(t, []string{main.AlertMessageMatched}, alertTypes(consumer.Alerts))
+	assert.Equal(t, uint64(1), pipeline.Stats()["topic"][main.StageFilter].Filtered)
+}
@@ -0,0 +1,280 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains implementation of payload profiler. Profiler
+// tracks, per topic, distribution of top-level JSON keys, type and null rate
+// of each field and cardinality of selected fields. Profile is exposed via
+// REST API and it is also periodically written into log, so it is possible
+// to spot schema drift without reading raw messages.
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// maxProfiledFields is maximum number of distinct fields tracked per topic.
+// It keeps memory consumed by profiler bounded.
+const maxProfiledFields = 1000
+
+// Names of JSON types as reported by profiler
+const (
+	jsonTypeNull    = "null"
+	jsonTypeBoolean = "boolean"
+	jsonTypeNumber  = "number"
+	jsonTypeString  = "string"
+	jsonTypeArray   = "array"
+	jsonTypeObject  = "object"
+)
+
+// FieldProfile contains statistic about one field
+type FieldProfile struct {
+	Present uint64
+	Nulls   uint64
+	Types   map[string]uint64
+}
+
+// TopicProfile contains statistic about payloads consumed from one topic
+type TopicProfile struct {
+	Messages        uint64
+	NonJSONMessages uint64
+	DroppedFields   uint64
+	Fields          map[string]*FieldProfile
+
+	cardinality map[string]*HyperLogLog
+}
+
+// FieldProfileSummary contains summary of field statistic in form suitable
+// to be returned by REST API
+type FieldProfileSummary struct {
+	PresenceRate float64           `json:"presence_rate"`
+	NullRate     float64           `json:"null_rate"`
+	Types        map[string]uint64 `json:"types"`
+}
+
+// TopicProfileSummary contains summary of topic profile in form suitable to
+// be returned by REST API
+type TopicProfileSummary struct {
+	Messages        uint64                         `json:"messages"`
+	NonJSONMessages uint64                         `json:"non_json_messages"`
+	DroppedFields   uint64                         `json:"dropped_fields"`
+	TopLevelKeys    map[string]uint64              `json:"top_level_keys"`
+	Fields          map[string]FieldProfileSummary `json:"fields"`
+	Cardinality     map[string]uint64              `json:"cardinality"`
+}
+
+// Profiler tracks statistic about message payloads for all topics
+type Profiler struct {
+	mutex             sync.Mutex
+	topics            map[string]*TopicProfile
+	cardinalityFields map[string][]string
+}
+
+// NewProfiler constructs new profiler. Topics configuration is used to
+// retrieve fields whose cardinality needs to be estimated.
+func NewProfiler(topicsConfig []TopicConfiguration) *Profiler {
+	cardinalityFields := make(map[string][]string, len(topicsConfig))
+	for _, topicConfig := range topicsConfig {
+		cardinalityFields[topicConfig.Name] = topicConfig.CardinalityFields
+	}
+
+	return &Profiler{
+		topics:            make(map[string]*TopicProfile),
+		cardinalityFields: cardinalityFields,
+	}
+}
+
+// jsonType function returns name of JSON type for value returned by JSON
+// unmarshaller
+func jsonType(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return jsonTypeNull
+	case bool:
+		return jsonTypeBoolean
+	case float64, json.Number:
+		return jsonTypeNumber
+	case string:
+		return jsonTypeString
+	case []interface{}:
+		return jsonTypeArray
+	default:
+		return jsonTypeObject
+	}
+}
+
+// cardinalityValue function converts any JSON value into string that can
+// be added into cardinality sketch
+func cardinalityValue(value interface{}) string {
+	if str, ok := value.(string); ok {
+		return str
+	}
+	// values retrieved from JSON can always be marshalled back
+	serialized, _ := json.Marshal(value)
+	return string(serialized)
+}
+
+// topicProfile method returns profile for given topic. New profile is
+// constructed if needed.
+func (profiler *Profiler) topicProfile(topic string) *TopicProfile {
+	profile, found := profiler.topics[topic]
+	if !found {
+		profile = &TopicProfile{
+			Fields:      make(map[string]*FieldProfile),
+			cardinality: make(map[string]*HyperLogLog),
+		}
+		for _, field := range profiler.cardinalityFields[topic] {
+			profile.cardinality[field] = NewHyperLogLog()
+		}
+		profiler.topics[topic] = profile
+	}
+	return profile
+}
+
+// Profile method updates profile of message topic by message content
+func (profiler *Profiler) Profile(message *DecodedMessage) {
+	profiler.mutex.Lock()
+	defer profiler.mutex.Unlock()
+
+	profile := profiler.topicProfile(message.Message.Topic)
+	profile.Messages++
+
+	_, err := message.JSON()
+	if err != nil {
+		profile.NonJSONMessages++
+		return
+	}
+
+	message.WalkFields(profile.profileField)
+
+	for field, sketch := range profile.cardinality {
+		value, found := message.Field(field)
+		if found && value != nil {
+			sketch.Add(cardinalityValue(value))
+		}
+	}
+}
+
+// profileField method updates statistic of given field
+func (profile *TopicProfile) profileField(path string, value interface{}) {
+	field, found := profile.Fields[path]
+	if !found {
+		if len(profile.Fields) >= maxProfiledFields {
+			profile.DroppedFields++
+			return
+		}
+		field = &FieldProfile{
+			Types: make(map[string]uint64),
+		}
+		profile.Fields[path] = field
+	}
+
+	field.Present++
+	field.Types[jsonType(value)]++
+	if value == nil {
+		field.Nulls++
+	}
+}
+
+// summary method computes summary of topic profile
+func (profile *TopicProfile) summary() TopicProfileSummary {
+	summary := TopicProfileSummary{
+		Messages:        profile.Messages,
+		NonJSONMessages: profile.NonJSONMessages,
+		DroppedFields:   profile.DroppedFields,
+		TopLevelKeys:    make(map[string]uint64),
+		Fields:          make(map[string]FieldProfileSummary, len(profile.Fields)),
+		Cardinality:     make(map[string]uint64, len(profile.cardinality)),
+	}
+
+	for path, field := range profile.Fields {
+		if !strings.Contains(path, ".") {
+			summary.TopLevelKeys[path] = field.Present
+		}
+
+		types := make(map[string]uint64, len(field.Types))
+		for valueType, count := range field.Types {
+			types[valueType] = count
+		}
+
+		fieldSummary := FieldProfileSummary{
+			NullRate: float64(field.Nulls) / float64(field.Present),
+			Types:    types,
+		}
+		if profile.Messages > 0 {
+			fieldSummary.PresenceRate = float64(field.Present) / float64(profile.Messages)
+		}
+		summary.Fields[path] = fieldSummary
+	}
+
+	for field, sketch := range profile.cardinality {
+		summary.Cardinality[field] = sketch.Estimate()
+	}
+
+	return summary
+}
+
+// Summary method returns summary of profiles for all topics
+func (profiler *Profiler) Summary() map[string]TopicProfileSummary {
+	profiler.mutex.Lock()
+	defer profiler.mutex.Unlock()
+
+	summary := make(map[string]TopicProfileSummary, len(profiler.topics))
+	for topic, profile := range profiler.topics {
+		summary[topic] = profile.summary()
+	}
+	return summary
+}
+
+// LogSummary method writes summary of profiles for all topics into log
+func (profiler *Profiler) LogSummary() {
+	summary := profiler.Summary()
+
+	topics := make([]string, 0, len(summary))
+	for topic := range summary {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	for _, topic := range topics {
+		topicSummary := summary[topic]
+		log.Info().
+			Str(topicKey, topic).
+			Uint64("messages", topicSummary.Messages).
+			Uint64("non-JSON messages", topicSummary.NonJSONMessages).
+			Int("fields", len(topicSummary.Fields)).
+			Interface("top-level keys", topicSummary.TopLevelKeys).
+			Interface("cardinality", topicSummary.Cardinality).
+			Msg("Payload profile summary")
+	}
+}
+
+// LogSummaries method periodically writes summary of profiles into log. It
+// blocks current thread.
+func (profiler *Profiler) LogSummaries(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		profiler.LogSummary()
+	}
+}
@@ -0,0 +1,120 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Unit test definitions for functions and methods defined in source file
+// profiler.go
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-kafka-monitor"
+)
+
+// decodedMessage function constructs decoded message with given topic and
+// content
+func decodedMessage(topic, content string) *main.DecodedMessage {
+	return &main.DecodedMessage{
+		Message: &sarama.ConsumerMessage{
+			Topic: topic,
+			Value: []byte(content),
+		},
+		Content: []byte(content),
+	}
+}
+
+// TestProfilerFields checks field statistic computed by profiler
+func TestProfilerFields(t *testing.T) {
+	profiler := main.NewProfiler(nil)
+
+	profiler.Profile(decodedMessage("topic", `{"OrgID": 1, "Report": {"ClusterName": "c1"}}`))
+	profiler.Profile(decodedMessage("topic", `{"OrgID": null, "Report": {"ClusterName": 42}}`))
+	profiler.Profile(decodedMessage("topic", `{"Report": {}}`))
+	profiler.Profile(decodedMessage("topic", `not a JSON`))
+
+	summary := profiler.Summary()
+	assert.Len(t, summary, 1)
+
+	profile := summary["topic"]
+	assert.Equal(t, uint64(4), profile.Messages)
+	assert.Equal(t, uint64(1), profile.NonJSONMessages)
+	assert.Equal(t, map[string]uint64{"OrgID": 2, "Report": 3}, profile.TopLevelKeys)
+
+	orgID := profile.Fields["OrgID"]
+	assert.Equal(t, 0.5, orgID.PresenceRate)
+	assert.Equal(t, 0.5, orgID.NullRate)
+	assert.Equal(t, map[string]uint64{"number": 1, "null": 1}, orgID.Types)
+
+	clusterName := profile.Fields["Report.ClusterName"]
+	assert.Equal(t, map[string]uint64{"string": 1, "number": 1}, clusterName.Types)
+	assert.Equal(t, 0.0, clusterName.NullRate)
+}
+
+// TestProfilerCardinality checks estimation of cardinality of selected
+// fields
+func TestProfilerCardinality(t *testing.T) {
+	profiler := main.NewProfiler([]main.TopicConfiguration{
+		{
+			Name:              "topic",
+			CardinalityFields: []string{"OrgID", "Report.ClusterName"},
+		},
+	})
+
+	profiler.Profile(decodedMessage("topic", `{"OrgID": 1, "Report": {"ClusterName": "c1"}}`))
+	profiler.Profile(decodedMessage("topic", `{"OrgID": 1, "Report": {"ClusterName": "c2"}}`))
+	profiler.Profile(decodedMessage("topic", `{"OrgID": 2, "Report": {"ClusterName": "c3"}}`))
+	profiler.Profile(decodedMessage("topic", `{"OrgID": null}`))
+
+	summary := profiler.Summary()
+	assert.Equal(t, map[string]uint64{"OrgID": 2, "Report.ClusterName": 3}, summary["topic"].Cardinality)
+}
+
+// TestProfilerMultipleTopics checks that profiles are tracked per topic
+func TestProfilerMultipleTopics(t *testing.T) {
+	profiler := main.NewProfiler(nil)
+
+	profiler.Profile(decodedMessage("topic1", `{"foo": 1}`))
+	profiler.Profile(decodedMessage("topic2", `{"bar": 1}`))
+
+	summary := profiler.Summary()
+	assert.Contains(t, summary["topic1"].Fields, "foo")
+	assert.NotContains(t, summary["topic1"].Fields, "bar")
+	assert.Contains(t, summary["topic2"].Fields, "bar")
+
+	// just check that summary can be logged
+	profiler.LogSummary()
+}
+
+// TestProcessMessageWithProfiler checks that messages processed by
+// consumer are profiled
+func TestProcessMessageWithProfiler(t *testing.T) {
+	dummyConsumer := NewDummyConsumer()
+	dummyConsumer.Profiler = main.NewProfiler(nil)
+
+	message := sarama.ConsumerMessage{
+		Topic: "topic",
+		Value: []byte(`{"foo": "bar"}`),
+	}
+
+	err := dummyConsumer.ProcessMessage(&message)
+	assert.NoError(t, err)
+
+	assert.Equal(t, uint64(1), dummyConsumer.Profiler.Summary()["topic"].Messages)
+}
@@ -0,0 +1,128 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains implementation of tracking of consumer
+// progress. Offset of the last consumed message, high water mark, number of
+// consumed messages and number of errors are tracked for each partition
+// claimed by consumer, so lag of consumer can be computed.
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
+// PartitionProgress contains progress of consumer in one partition
+type PartitionProgress struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	// Offset is offset of the last consumed message
+	Offset        int64  `json:"offset"`
+	HighWaterMark int64  `json:"high_water_mark"`
+	Lag           int64  `json:"lag"`
+	Messages      uint64 `json:"messages"`
+	Errors        uint64 `json:"errors"`
+}
+
+// progressTracker tracks progress of consumer in all claimed partitions
+type progressTracker struct {
+	mutex      sync.Mutex
+	partitions map[partitionID]*PartitionProgress
+}
+
+// partition method returns progress of given partition, constructing it
+// when needed
+func (tracker *progressTracker) partition(topic string, partition int32) *PartitionProgress {
+	if tracker.partitions == nil {
+		tracker.partitions = make(map[partitionID]*PartitionProgress)
+	}
+
+	id := partitionID{topic: topic, partition: partition}
+	progress, found := tracker.partitions[id]
+	if !found {
+		progress = &PartitionProgress{Topic: topic, Partition: partition}
+		tracker.partitions[id] = progress
+	}
+	return progress
+}
+
+// record method records message consumed from partition
+func (tracker *progressTracker) record(msg *sarama.ConsumerMessage, failed bool) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	progress := tracker.partition(msg.Topic, msg.Partition)
+	progress.Offset = msg.Offset
+	progress.Messages++
+	if failed {
+		progress.Errors++
+	}
+	progress.updateLag()
+}
+
+// setHighWaterMark method records actual high water mark of partition
+func (tracker *progressTracker) setHighWaterMark(topic string, partition int32, highWaterMark int64) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	progress := tracker.partition(topic, partition)
+	progress.HighWaterMark = highWaterMark
+	progress.updateLag()
+}
+
+// partitionIDs method returns all partitions with tracked progress
+func (tracker *progressTracker) partitionIDs() []partitionID {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	ids := make([]partitionID, 0, len(tracker.partitions))
+	for id := range tracker.partitions {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// updateLag method computes lag from offset of the last consumed message
+// and high water mark, that is offset of the next message to be produced
+func (progress *PartitionProgress) updateLag() {
+	progress.Lag = progress.HighWaterMark - progress.Offset - 1
+	if progress.Lag < 0 || progress.Messages == 0 {
+		progress.Lag = 0
+	}
+}
+
+// snapshot method returns progress in all partitions ordered by topic and
+// partition
+func (tracker *progressTracker) snapshot() []PartitionProgress {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	progress := make([]PartitionProgress, 0, len(tracker.partitions))
+	for _, partitionProgress := range tracker.partitions {
+		progress = append(progress, *partitionProgress)
+	}
+
+	sort.Slice(progress, func(i, j int) bool {
+		if progress[i].Topic != progress[j].Topic {
+			return progress[i].Topic < progress[j].Topic
+		}
+		return progress[i].Partition < progress[j].Partition
+	})
+	return progress
+}
@@ -0,0 +1,178 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains selection of Kafka protocol version used to
+// talk to Kafka cluster. Version can be configured explicitly, or it can
+// be detected automatically: API versions supported by broker are
+// retrieved via ApiVersions request and the highest Kafka version whose
+// APIs are all supported by broker is selected.
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/Shopify/sarama"
+	"github.com/rs/zerolog/log"
+)
+
+// ProtocolVersionAuto is configured protocol version that turns on
+// automatic detection of protocol version
+const ProtocolVersionAuto = "auto"
+
+// defaultProtocolVersion is protocol version used when no version is
+// configured
+var defaultProtocolVersion = sarama.V0_10_2_0
+
+// minimalDetectionProtocolVersion is the oldest Kafka protocol version
+// that supports ApiVersions request
+var minimalDetectionProtocolVersion = sarama.V0_10_0_0
+
+// protocolVersionMarker represents API that has been introduced (or
+// raised to given version) in given Kafka release. Broker that supports
+// the API in at least given version supports the whole Kafka release.
+type protocolVersionMarker struct {
+	version    sarama.KafkaVersion
+	apiKey     int16
+	apiVersion int16
+}
+
+// protocolVersionMarkers contains APIs that identify Kafka releases, the
+// oldest release first
+var protocolVersionMarkers = []protocolVersionMarker{
+	// CreateTopics
+	{sarama.V0_10_1_0, 19, 0},
+	// OffsetFetch v2
+	{sarama.V0_10_2_0, 9, 2},
+	// InitProducerId
+	{sarama.V0_11_0_0, 22, 0},
+	// DescribeLogDirs
+	{sarama.V1_0_0_0, 35, 0},
+	// DeleteGroups
+	{sarama.V1_1_0_0, 42, 0},
+	// Fetch v8
+	{sarama.V2_0_0_0, 1, 8},
+	// Produce v7
+	{sarama.V2_1_0_0, 0, 7},
+	// ElectLeaders
+	{sarama.V2_2_0_0, 43, 0},
+	// IncrementalAlterConfigs
+	{sarama.V2_3_0_0, 44, 0},
+	// OffsetDelete
+	{sarama.V2_4_0_0, 47, 0},
+	// DescribeClientQuotas
+	{sarama.V2_6_0_0, 48, 0},
+	// DescribeUserScramCredentials
+	{sarama.V2_7_0_0, 50, 0},
+	// DescribeCluster
+	{sarama.V2_8_0_0, 60, 0},
+	// DescribeTransactions
+	{sarama.V3_0_0_0, 65, 0},
+	// Fetch v13
+	{sarama.V3_1_0_0, 1, 13},
+}
+
+// detectedProtocolVersions contains protocol versions already detected for
+// broker addresses, so broker is asked just once
+var detectedProtocolVersions = struct {
+	sync.Mutex
+	versions map[string]sarama.KafkaVersion
+}{versions: make(map[string]sarama.KafkaVersion)}
+
+// ProtocolVersion function returns Kafka protocol version to be used to
+// talk to broker. Version is detected when "auto" version is configured.
+func ProtocolVersion(brokerCfg BrokerConfiguration) (sarama.KafkaVersion, error) {
+	switch brokerCfg.Version {
+	case "":
+		return defaultProtocolVersion, nil
+	case ProtocolVersionAuto:
+		return DetectProtocolVersion(brokerCfg.Address)
+	}
+
+	version, err := sarama.ParseKafkaVersion(brokerCfg.Version)
+	if err != nil {
+		return version, fmt.Errorf("improper protocol version '%s': %v", brokerCfg.Version, err)
+	}
+	return version, nil
+}
+
+// DetectProtocolVersion function retrieves API versions supported by
+// broker with given address and selects the highest protocol version
+// supported by both broker and sarama. Detected versions are cached.
+func DetectProtocolVersion(address string) (sarama.KafkaVersion, error) {
+	detectedProtocolVersions.Lock()
+	defer detectedProtocolVersions.Unlock()
+
+	if version, found := detectedProtocolVersions.versions[address]; found {
+		return version, nil
+	}
+
+	config := sarama.NewConfig()
+	config.Version = minimalDetectionProtocolVersion
+
+	broker := sarama.NewBroker(address)
+	err := broker.Open(config)
+	if err != nil {
+		return minimalDetectionProtocolVersion, err
+	}
+	defer func() {
+		err := broker.Close()
+		if err != nil {
+			log.Error().Err(err).Msg(closingBrokerConnectionMessage)
+		}
+	}()
+
+	response, err := broker.ApiVersions(&sarama.ApiVersionsRequest{})
+	if err != nil {
+		return minimalDetectionProtocolVersion, err
+	}
+	if response.ErrorCode != int16(sarama.ErrNoError) {
+		return minimalDetectionProtocolVersion, sarama.KError(response.ErrorCode)
+	}
+
+	version := selectProtocolVersion(response.ApiKeys)
+	log.Info().
+		Str(brokerAddressMessage, address).
+		Str("version", version.String()).
+		Msg("Negotiated Kafka protocol version")
+
+	detectedProtocolVersions.versions[address] = version
+	return version, nil
+}
+
+// selectProtocolVersion function selects the highest Kafka protocol
+// version whose APIs are supported in given versions
+func selectProtocolVersion(apiKeys []sarama.ApiVersionsResponseKey) sarama.KafkaVersion {
+	supported := make(map[int16]int16, len(apiKeys))
+	for _, apiKey := range apiKeys {
+		supported[apiKey.ApiKey] = apiKey.MaxVersion
+	}
+
+	// broker that responds to ApiVersions supports at least 0.10.0
+	version := minimalDetectionProtocolVersion
+	for _, marker := range protocolVersionMarkers {
+		maxVersion, found := supported[marker.apiKey]
+		if !found || maxVersion < marker.apiVersion {
+			break
+		}
+		if marker.version.IsAtLeast(sarama.MaxVersion) {
+			return sarama.MaxVersion
+		}
+		version = marker.version
+	}
+	return version
+}
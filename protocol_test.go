@@ -0,0 +1,133 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Unit test definitions for functions and methods defined in source file
+// protocol.go
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-kafka-monitor"
+)
+
+// apiKeys2_3 contains APIs supported by Kafka 2.3 that are used to detect
+// protocol version
+var apiKeys2_3 = []sarama.ApiVersionsResponseKey{
+	{ApiKey: 0, MaxVersion: 7},
+	{ApiKey: 1, MaxVersion: 11},
+	{ApiKey: 9, MaxVersion: 6},
+	{ApiKey: 19, MaxVersion: 3},
+	{ApiKey: 22, MaxVersion: 1},
+	{ApiKey: 35, MaxVersion: 1},
+	{ApiKey: 42, MaxVersion: 1},
+	{ApiKey: 43, MaxVersion: 1},
+	{ApiKey: 44, MaxVersion: 0},
+}
+
+// TestProtocolVersion checks how configured protocol version is parsed
+func TestProtocolVersion(t *testing.T) {
+	// default version
+	version, err := main.ProtocolVersion(main.BrokerConfiguration{})
+	assert.NoError(t, err)
+	assert.Equal(t, sarama.V0_10_2_0, version)
+
+	version, err = main.ProtocolVersion(main.BrokerConfiguration{Version: "2.8.0"})
+	assert.NoError(t, err)
+	assert.Equal(t, sarama.V2_8_0_0, version)
+
+	_, err = main.ProtocolVersion(main.BrokerConfiguration{Version: "latest"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "improper protocol version 'latest'")
+}
+
+// TestSelectProtocolVersion checks that the highest version supported by
+// broker is selected
+func TestSelectProtocolVersion(t *testing.T) {
+	assert.Equal(t, sarama.V0_10_0_0, main.SelectProtocolVersion(nil))
+	assert.Equal(t, sarama.V2_3_0_0, main.SelectProtocolVersion(apiKeys2_3))
+
+	// API introduced by newer release is not enough when older API is
+	// missing
+	assert.Equal(t, sarama.V0_10_1_0, main.SelectProtocolVersion([]sarama.ApiVersionsResponseKey{
+		{ApiKey: 19, MaxVersion: 0},
+		{ApiKey: 44, MaxVersion: 0},
+	}))
+
+	// version is limited by versions known to sarama
+	all := append([]sarama.ApiVersionsResponseKey{}, apiKeys2_3...)
+	all[1].MaxVersion = 99
+	for _, apiKey := range []int16{47, 48, 50, 60, 65} {
+		all = append(all, sarama.ApiVersionsResponseKey{ApiKey: apiKey})
+	}
+	assert.Equal(t, sarama.MaxVersion, main.SelectProtocolVersion(all))
+}
+
+// TestAutoProtocolVersion checks that protocol version is detected by
+// ApiVersions request
+func TestAutoProtocolVersion(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"ApiVersionsRequest": sarama.NewMockApiVersionsResponse(t).SetApiKeys(apiKeys2_3),
+	})
+
+	version, err := main.ProtocolVersion(main.BrokerConfiguration{
+		Address: broker.Addr(),
+		Version: main.ProtocolVersionAuto,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, sarama.V2_3_0_0, version)
+	broker.Close()
+
+	// detected version is cached, so broker is not asked again
+	version, err = main.DetectProtocolVersion(broker.Addr())
+	assert.NoError(t, err)
+	assert.Equal(t, sarama.V2_3_0_0, version)
+}
+
+// TestTryToConnectToKafkaAutoProtocolVersion checks that protocol version
+// is detected by the deep health check
+func TestTryToConnectToKafkaAutoProtocolVersion(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"ApiVersionsRequest": sarama.NewMockApiVersionsResponse(t).SetApiKeys(apiKeys2_3),
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader(testTopic, 0, broker.BrokerID()),
+	})
+
+	config := configurationForMockBroker(broker, "")
+	config.Broker.Version = main.ProtocolVersionAuto
+
+	code, err := main.TryToConnectToKafka(config)
+	assert.NoError(t, err)
+	assert.Equal(t, main.ExitStatusOK, code)
+}
+
+// TestNewConsumerImproperProtocolVersion checks that consumer can not be
+// constructed with improper protocol version
+func TestNewConsumerImproperProtocolVersion(t *testing.T) {
+	consumer, err := main.NewConsumer(main.BrokerConfiguration{Version: "x.y"}, false)
+	assert.Error(t, err)
+	assert.Nil(t, consumer)
+}
@@ -0,0 +1,275 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains implementation of redaction of sensitive data.
+// Redaction rules are applied everywhere message content leaves the
+// process: log, message archive, REST API (dashboard, hot keys), summary
+// reports, and alerts. Values of configured JSON paths are replaced by
+// mask, parts of text matching configured regular expressions are replaced
+// by mask too, and message keys can be replaced by their hashes, so they
+// can still be correlated without being revealed. Messages captured by
+// dead-letter sink are not redacted, because they need to be processed
+// again.
+//
+// All methods can be called on nil redactor, content is returned unchanged
+// in this case.
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// defaultRedactionMask is used when mask is not configured
+const defaultRedactionMask = "[REDACTED]"
+
+// redactedKeyPrefix is prefix of hashed message keys
+const redactedKeyPrefix = "sha256:"
+
+// redactedKeyLength is number of hexadecimal digits of hash used in place
+// of message key
+const redactedKeyLength = 16
+
+// jsonPathWildcard matches any key of JSON object in JSON path
+const jsonPathWildcard = "*"
+
+// alertKeyDetails are alert details that contain message keys or IDs
+// extracted from messages
+var alertKeyDetails = map[string]bool{
+	"key": true,
+	"id":  true,
+}
+
+// Redactor redacts sensitive data from message content
+type Redactor struct {
+	paths    [][]string
+	patterns []*regexp.Regexp
+	hashKeys bool
+	salt     string
+	mask     string
+}
+
+// NewRedactor constructs new redactor from configured rules
+func NewRedactor(config RedactionConfiguration) (*Redactor, error) {
+	redactor := &Redactor{
+		hashKeys: config.HashKeys,
+		salt:     config.HashSalt,
+		mask:     config.Mask,
+	}
+	if redactor.mask == "" {
+		redactor.mask = defaultRedactionMask
+	}
+
+	for _, path := range config.JSONPaths {
+		if path == "" {
+			return nil, fmt.Errorf("empty JSON path can not be redacted")
+		}
+		redactor.paths = append(redactor.paths, strings.Split(path, "."))
+	}
+
+	for _, pattern := range config.Patterns {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("improper redaction pattern '%s': %v", pattern, err)
+		}
+		redactor.patterns = append(redactor.patterns, compiled)
+	}
+
+	return redactor, nil
+}
+
+// RedactString method replaces all parts of text that match redaction
+// patterns by mask
+func (redactor *Redactor) RedactString(text string) string {
+	if redactor == nil {
+		return text
+	}
+	for _, pattern := range redactor.patterns {
+		text = pattern.ReplaceAllLiteralString(text, redactor.mask)
+	}
+	return text
+}
+
+// RedactError method returns error with message redacted by redaction
+// patterns
+func (redactor *Redactor) RedactError(err error) error {
+	if redactor == nil || err == nil {
+		return err
+	}
+	message := redactor.RedactString(err.Error())
+	if message == err.Error() {
+		return err
+	}
+	return errors.New(message)
+}
+
+// RedactKey method returns hash of message key when key hashing is
+// enabled, otherwise the key is redacted by redaction patterns
+func (redactor *Redactor) RedactKey(key string) string {
+	if redactor == nil || key == "" {
+		return key
+	}
+	if !redactor.hashKeys {
+		return redactor.RedactString(key)
+	}
+	hash := sha256.Sum256([]byte(redactor.salt + key))
+	return redactedKeyPrefix + hex.EncodeToString(hash[:])[:redactedKeyLength]
+}
+
+// RedactPayload method redacts message payload. Values of configured JSON
+// paths are masked and all string values are redacted by redaction
+// patterns when payload is JSON, otherwise patterns are applied to the
+// whole payload.
+func (redactor *Redactor) RedactPayload(payload []byte) []byte {
+	if redactor == nil || (len(redactor.paths) == 0 && len(redactor.patterns) == 0) {
+		return payload
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil || decoder.More() {
+		return []byte(redactor.RedactString(string(payload)))
+	}
+
+	for _, path := range redactor.paths {
+		document = redactor.maskPath(document, path)
+	}
+	document = redactor.redactStrings(document)
+
+	redacted, err := json.Marshal(document)
+	if err != nil {
+		// it should not happen for decoded document, but raw payload must
+		// not be returned anyway
+		return []byte(redactor.mask)
+	}
+	return redacted
+}
+
+// maskPath method replaces value at given JSON path by mask. Arrays are
+// traversed transparently, so path "items.secret" masks secret field of
+// all items.
+func (redactor *Redactor) maskPath(node interface{}, path []string) interface{} {
+	if len(path) == 0 {
+		return redactor.mask
+	}
+
+	switch value := node.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			if path[0] == jsonPathWildcard || path[0] == key {
+				value[key] = redactor.maskPath(child, path[1:])
+			}
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = redactor.maskPath(item, path)
+		}
+	}
+	return node
+}
+
+// redactStrings method redacts all string values in JSON document by
+// redaction patterns
+func (redactor *Redactor) redactStrings(node interface{}) interface{} {
+	if len(redactor.patterns) == 0 {
+		return node
+	}
+
+	switch value := node.(type) {
+	case string:
+		return redactor.RedactString(value)
+	case map[string]interface{}:
+		for key, child := range value {
+			value[key] = redactor.redactStrings(child)
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = redactor.redactStrings(item)
+		}
+	}
+	return node
+}
+
+// RedactRecord method redacts key, headers, payload and error of message
+// record
+func (redactor *Redactor) RedactRecord(record MessageRecord) MessageRecord {
+	if redactor == nil {
+		return record
+	}
+
+	record.Key = redactor.RedactKey(record.Key)
+	if record.Headers != nil {
+		headers := make([]MessageHeader, len(record.Headers))
+		for i, header := range record.Headers {
+			headers[i] = MessageHeader{
+				Key:   header.Key,
+				Value: redactor.RedactString(header.Value),
+			}
+		}
+		record.Headers = headers
+	}
+	if record.Value != "" {
+		record.Value = string(redactor.RedactPayload([]byte(record.Value)))
+	}
+	if record.ValueBase64 != nil {
+		record.ValueBase64 = redactor.RedactPayload(record.ValueBase64)
+	}
+	record.Error = redactor.RedactString(record.Error)
+	return record
+}
+
+// RedactAlert method redacts message and details of alert. Details that
+// contain message keys or IDs are redacted as message keys.
+func (redactor *Redactor) RedactAlert(alert Alert) Alert {
+	if redactor == nil {
+		return alert
+	}
+
+	alert.Message = redactor.RedactString(alert.Message)
+	if alert.Details != nil {
+		details := make(map[string]interface{}, len(alert.Details))
+		for name, value := range alert.Details {
+			if text, ok := value.(string); ok {
+				if alertKeyDetails[name] {
+					value = redactor.RedactKey(text)
+				} else {
+					value = redactor.RedactString(text)
+				}
+			}
+			details[name] = value
+		}
+		alert.Details = details
+	}
+	return alert
+}
+
+// newRedactor function constructs redactor when redaction is enabled in
+// configuration, nil is returned otherwise
+func newRedactor(config ConfigStruct) (*Redactor, error) {
+	redactionConfig := GetRedactionConfiguration(&config)
+	if !redactionConfig.Enabled {
+		return nil, nil
+	}
+	return NewRedactor(redactionConfig)
+}
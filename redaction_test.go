@@ -0,0 +1,255 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Unit test definitions for functions and methods defined in source file
+// redaction.go
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-kafka-monitor"
+)
+
+// Secrets contained in messages used by tests
+const (
+	secretAccount = "7654321"
+	secretEmail   = "john.doe@example.com"
+	secretToken   = "Bearer eyJhbGciOi.secret"
+	secretKey     = "account-7654321"
+)
+
+// secrets contains all secrets that must not leak
+var secrets = []string{secretAccount, secretEmail, secretToken, secretKey}
+
+// secretPayload is JSON payload containing all secrets
+const secretPayload = `{"AccountNumber": "` + secretAccount + `", "Contact": {"email": "` + secretEmail + `"}, ` +
+	`"Items": [{"auth": "` + secretToken + `", "count": 42}], "Status": "ok"}`
+
+// redactionConfiguration contains redaction rules covering all secrets
+var redactionConfiguration = main.RedactionConfiguration{
+	Enabled:   true,
+	JSONPaths: []string{"AccountNumber", "Items.auth"},
+	Patterns:  []string{`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`, `Bearer [A-Za-z0-9._~+/-]+=*`},
+	HashKeys:  true,
+}
+
+// newTestRedactor function constructs redactor with rules covering all
+// secrets
+func newTestRedactor(t *testing.T) *main.Redactor {
+	redactor, err := main.NewRedactor(redactionConfiguration)
+	assert.NoError(t, err)
+	return redactor
+}
+
+// assertNoSecret function checks that no secret is contained in given text
+func assertNoSecret(t *testing.T, text string, where string) {
+	for _, secret := range secrets {
+		assert.NotContains(t, text, secret, where)
+	}
+}
+
+// TestNewRedactorImproperPattern checks that improper pattern is refused
+func TestNewRedactorImproperPattern(t *testing.T) {
+	_, err := main.NewRedactor(main.RedactionConfiguration{Patterns: []string{"("}})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "improper redaction pattern '('")
+}
+
+// TestNilRedactor checks that content is not changed by nil redactor
+func TestNilRedactor(t *testing.T) {
+	var redactor *main.Redactor
+
+	assert.Equal(t, secretPayload, string(redactor.RedactPayload([]byte(secretPayload))))
+	assert.Equal(t, secretKey, redactor.RedactKey(secretKey))
+	assert.Equal(t, secretEmail, redactor.RedactString(secretEmail))
+}
+
+// TestRedactJSONPayload checks that JSON paths and patterns are redacted
+// in JSON payload while the rest of payload is kept
+func TestRedactJSONPayload(t *testing.T) {
+	redacted := string(newTestRedactor(t).RedactPayload([]byte(secretPayload)))
+
+	assertNoSecret(t, redacted, "payload")
+	assert.JSONEq(t, `{
+		"AccountNumber": "[REDACTED]",
+		"Contact": {"email": "[REDACTED]"},
+		"Items": [{"auth": "[REDACTED]", "count": 42}],
+		"Status": "ok"
+	}`, redacted)
+}
+
+// TestRedactJSONPathWildcard checks that wildcard matches all keys
+func TestRedactJSONPathWildcard(t *testing.T) {
+	redactor, err := main.NewRedactor(main.RedactionConfiguration{
+		JSONPaths: []string{"credentials.*"},
+		Mask:      "***",
+	})
+	assert.NoError(t, err)
+
+	redacted := redactor.RedactPayload([]byte(`{"credentials": {"user": "u", "password": "p"}, "id": 1}`))
+	assert.JSONEq(t, `{"credentials": {"user": "***", "password": "***"}, "id": 1}`, string(redacted))
+}
+
+// TestRedactTextPayload checks that patterns are applied to payload that is
+// not JSON
+func TestRedactTextPayload(t *testing.T) {
+	redacted := newTestRedactor(t).RedactPayload([]byte("contact " + secretEmail + " using " + secretToken))
+	assert.Equal(t, "contact [REDACTED] using [REDACTED]", string(redacted))
+}
+
+// TestRedactKey checks that message keys are hashed
+func TestRedactKey(t *testing.T) {
+	redactor := newTestRedactor(t)
+
+	hashed := redactor.RedactKey(secretKey)
+	assert.Regexp(t, `^sha256:[0-9a-f]{16}$`, hashed)
+	// the same key is always hashed the same way, so keys can be correlated
+	assert.Equal(t, hashed, redactor.RedactKey(secretKey))
+	assert.NotEqual(t, hashed, redactor.RedactKey("other"))
+	assert.Equal(t, "", redactor.RedactKey(""))
+}
+
+// TestRedactAlert checks that alert message and details are redacted
+func TestRedactAlert(t *testing.T) {
+	alert := main.Alert{
+		Type:    main.AlertDuplicate,
+		Message: "Duplicate message from " + secretEmail,
+		Details: map[string]interface{}{
+			"key":    secretKey,
+			"offset": int64(42),
+		},
+	}
+
+	redacted := newTestRedactor(t).RedactAlert(alert)
+	assert.Equal(t, "Duplicate message from [REDACTED]", redacted.Message)
+	assert.Regexp(t, `^sha256:`, redacted.Details["key"])
+	assert.Equal(t, int64(42), redacted.Details["offset"])
+	// original alert is not changed
+	assert.Equal(t, secretKey, alert.Details["key"])
+}
+
+// TestNoSecretLeaks checks that no secret leaks from process via log,
+// archive, dashboard, REST API, traffic statistic, reports, and alerts
+func TestNoSecretLeaks(t *testing.T) {
+	globalLog := new(bytes.Buffer)
+	originalLogger := log.Logger
+	log.Logger = zerolog.New(globalLog)
+	defer func() {
+		log.Logger = originalLogger
+	}()
+
+	group := newFakeConsumerGroup(t, "topic")
+	factory := func(addrs []string, id string, config *sarama.Config) (sarama.ConsumerGroup, error) {
+		return group, nil
+	}
+
+	consumerLog := new(bytes.Buffer)
+	consumer, err := main.NewConsumer(main.BrokerConfiguration{Topic: "topic"}, true,
+		main.WithConsumerGroupFactory(factory),
+		main.WithLogger(zerolog.New(consumerLog)))
+	assert.NoError(t, err)
+
+	redactor := newTestRedactor(t)
+	consumer.Redactor = redactor
+	consumer.Alerts = main.NewAlertManager(nil)
+	consumer.Alerts.SetRedactor(redactor)
+
+	archiveDirectory := t.TempDir()
+	consumer.Archive, err = main.NewArchive(main.ArchiveConfiguration{Directory: archiveDirectory}, nil)
+	assert.NoError(t, err)
+	consumer.Archive.SetRedactor(redactor)
+
+	consumer.Dashboard = main.NewDashboard(main.DashboardConfiguration{}, consumer.Alerts, nil)
+	consumer.Dashboard.SetRedactor(redactor)
+
+	consumer.TrafficAnalyzer = main.NewTrafficAnalyzer(main.TrafficConfiguration{}, consumer.Alerts, nil)
+	consumer.TrafficAnalyzer.SetRedactor(redactor)
+
+	reportDirectory := t.TempDir()
+	consumer.Reporter, err = main.NewReporter(main.ReportConfiguration{
+		Directory: reportDirectory,
+		Formats:   []string{main.ReportFormatMarkdown, main.ReportFormatJSON},
+	}, nil, consumer.Alerts, nil)
+	assert.NoError(t, err)
+	consumer.Reporter.SetRedactor(redactor)
+
+	consumer.HandleMessage(&sarama.ConsumerMessage{
+		Topic:     "topic",
+		Offset:    1,
+		Timestamp: time.Now(),
+		Key:       []byte(secretKey),
+		Headers:   []*sarama.RecordHeader{{Key: []byte("authorization"), Value: []byte(secretToken)}},
+		Value:     []byte(secretPayload),
+	})
+	consumer.Reporter.Record(consumerMessage(2, []byte(`{}`)), time.Millisecond, errors.New("unknown user "+secretEmail))
+	consumer.Alerts.Raise(main.Alert{
+		Type:    main.AlertDuplicate,
+		Topic:   "topic",
+		Message: "Duplicate message from " + secretEmail,
+		Details: map[string]interface{}{"key": secretKey},
+	})
+	assert.NoError(t, consumer.Close())
+
+	// message has been processed and logged, but redacted
+	assert.Contains(t, consumerLog.String(), "Message value")
+	assert.Contains(t, consumerLog.String(), "[REDACTED]")
+	assertNoSecret(t, consumerLog.String(), "consumer log")
+	assertNoSecret(t, globalLog.String(), "global log")
+
+	files, err := main.ArchiveFiles(archiveDirectory)
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+	archived, err := ioutil.ReadFile(files[0])
+	assert.NoError(t, err)
+	assert.Contains(t, string(archived), "sha256:")
+	assertNoSecret(t, string(archived), "archive")
+
+	server := main.NewHTTPServer(main.ServerConfiguration{}, consumer)
+	for _, endpoint := range []string{"dashboard/events", "dashboard/message?id=1", "alerts", "traffic", "stats"} {
+		if endpoint == "dashboard/events" {
+			// events are streamed, so state is checked directly
+			state := consumer.Dashboard.State(consumer.Progress())
+			assert.Len(t, state.Messages, 1)
+			assertNoSecret(t, state.Messages[0].Key, endpoint)
+			continue
+		}
+		response := performRequest(server, "/api/v1/"+endpoint)
+		assert.Equal(t, 200, response.Code, endpoint)
+		assertNoSecret(t, response.Body.String(), endpoint)
+	}
+
+	assert.NoError(t, consumer.Reporter.Write(consumer.Reporter.Generate()))
+	reports, err := ioutil.ReadDir(reportDirectory)
+	assert.NoError(t, err)
+	assert.Len(t, reports, 2)
+	for _, report := range reports {
+		content, err := ioutil.ReadFile(reportDirectory + "/" + report.Name())
+		assert.NoError(t, err)
+		assert.Contains(t, string(content), "unknown user [REDACTED]")
+		assertNoSecret(t, string(content), report.Name())
+	}
+}
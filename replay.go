@@ -0,0 +1,258 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains implementation of -replay command. Messages from
+// selected range of offsets or timestamps are read from selected partitions
+// of topic and processed by the same pipeline as messages consumed by the
+// service (decoding, validation, statistic). Messages are read by plain
+// partition consumers, so no consumer group is used or modified.
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/rs/zerolog/log"
+)
+
+// Messages used by replay command
+const (
+	replayMessage = "Replay messages"
+)
+
+// replayBound represents start or end of replayed range. The bound is
+// specified either by offset or by timestamp.
+type replayBound struct {
+	offset      int64
+	timestamp   time.Time
+	isTimestamp bool
+}
+
+// replayRange represents range of offsets replayed from one partition
+type replayRange struct {
+	partition int32
+	start     int64
+	end       int64
+	messages  uint64
+	errors    uint64
+}
+
+// parseReplayBound function parses start or end of replayed range. Offset
+// or timestamp in RFC 3339 format are accepted. Empty string means that
+// default offset is to be used.
+func parseReplayBound(value string, defaultOffset int64) (replayBound, error) {
+	if value == "" {
+		return replayBound{offset: defaultOffset}, nil
+	}
+
+	offset, err := strconv.ParseInt(value, 10, 64)
+	if err == nil {
+		if offset < 0 {
+			return replayBound{}, fmt.Errorf("offset can not be negative: %d", offset)
+		}
+		return replayBound{offset: offset}, nil
+	}
+
+	timestamp, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return replayBound{}, fmt.Errorf("'%s' is neither offset nor RFC 3339 timestamp", value)
+	}
+	return replayBound{timestamp: timestamp, isTimestamp: true}, nil
+}
+
+// parsePartitions function parses comma-separated list of partition IDs.
+// Empty string means all partitions.
+func parsePartitions(value string, all []int32) ([]int32, error) {
+	if value == "" {
+		return all, nil
+	}
+
+	var partitions []int32
+	for _, part := range strings.Split(value, ",") {
+		partition, err := strconv.ParseInt(strings.TrimSpace(part), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid partition '%s'", part)
+		}
+		partitions = append(partitions, int32(partition))
+	}
+	return partitions, nil
+}
+
+// resolveBound function converts bound into offset in given partition.
+// Offsets are clamped to range of offsets available in partition.
+func resolveBound(client sarama.Client, topic string, partition int32, bound replayBound) (int64, error) {
+	oldest, err := client.GetOffset(topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return 0, err
+	}
+	newest, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return 0, err
+	}
+
+	offset := bound.offset
+	if bound.isTimestamp {
+		// the first offset whose timestamp is greater than or equal to
+		// given timestamp is returned
+		offset, err = client.GetOffset(topic, partition, bound.timestamp.UnixNano()/int64(time.Millisecond))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	switch {
+	case offset == sarama.OffsetOldest:
+		return oldest, nil
+	case offset == sarama.OffsetNewest, offset < 0:
+		// no message with given timestamp
+		return newest, nil
+	case offset < oldest:
+		return oldest, nil
+	case offset > newest:
+		return newest, nil
+	default:
+		return offset, nil
+	}
+}
+
+// replay function processes all messages from selected range of selected
+// partitions and displays summary report.
+func replay(config ConfigStruct, cliFlags CliFlags) (int, error) {
+	brokerConfiguration := GetBrokerConfiguration(&config)
+
+	topic := cliFlags.ReplayTopic
+	if topic == "" {
+		topic = brokerConfiguration.Topic
+	}
+
+	start, err := parseReplayBound(cliFlags.ReplayStart, sarama.OffsetOldest)
+	if err != nil {
+		log.Error().Err(err).Msg(replayMessage)
+		return ExitStatusError, err
+	}
+	end, err := parseReplayBound(cliFlags.ReplayEnd, sarama.OffsetNewest)
+	if err != nil {
+		log.Error().Err(err).Msg(replayMessage)
+		return ExitStatusError, err
+	}
+
+	consumer, err := newOfflineConsumer(config)
+	if err != nil {
+		return ExitStatusError, err
+	}
+
+	client, err := newReaderClient(brokerConfiguration)
+	if err != nil {
+		log.Error().Err(err).Msg(replayMessage)
+		return ExitStatusKafkaError, err
+	}
+	defer func() {
+		err := client.Close()
+		if err != nil {
+			log.Error().Err(err).Msg(closingBrokerConnectionMessage)
+		}
+	}()
+
+	allPartitions, err := client.Partitions(topic)
+	if err != nil {
+		log.Error().Err(err).Str(topicKey, topic).Msg(replayMessage)
+		return ExitStatusTopicError, err
+	}
+	partitions, err := parsePartitions(cliFlags.ReplayPartitions, allPartitions)
+	if err != nil {
+		log.Error().Err(err).Msg(replayMessage)
+		return ExitStatusError, err
+	}
+
+	partitionConsumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		log.Error().Err(err).Msg(replayMessage)
+		return ExitStatusKafkaError, err
+	}
+	defer func() {
+		err := partitionConsumer.Close()
+		if err != nil {
+			log.Error().Err(err).Msg(replayMessage)
+		}
+	}()
+
+	ranges := make([]replayRange, 0, len(partitions))
+	for _, partition := range partitions {
+		r := replayRange{partition: partition}
+
+		r.start, err = resolveBound(client, topic, partition, start)
+		if err != nil {
+			log.Error().Err(err).Str(topicKey, topic).Int32(partitionKey, partition).Msg(replayMessage)
+			return ExitStatusKafkaError, err
+		}
+		r.end, err = resolveBound(client, topic, partition, end)
+		if err != nil {
+			log.Error().Err(err).Str(topicKey, topic).Int32(partitionKey, partition).Msg(replayMessage)
+			return ExitStatusKafkaError, err
+		}
+
+		err = readPartition(partitionConsumer, topic, partition, r.start, r.end, func(msg *sarama.ConsumerMessage) bool {
+			errorsBefore := consumer.GetNumberOfErrorsConsumingMessages()
+			consumer.HandleMessage(msg)
+			r.messages++
+			if consumer.GetNumberOfErrorsConsumingMessages() != errorsBefore {
+				r.errors++
+			}
+			return true
+		})
+		if err != nil {
+			log.Error().Err(err).Str(topicKey, topic).Int32(partitionKey, partition).Msg(replayMessage)
+			return ExitStatusKafkaError, err
+		}
+
+		ranges = append(ranges, r)
+	}
+
+	err = printReplaySummary(topic, ranges)
+	if err != nil {
+		return ExitStatusError, err
+	}
+
+	if consumer.Profiler != nil {
+		consumer.Profiler.LogSummary()
+	}
+
+	return ExitStatusOK, nil
+}
+
+// printReplaySummary function displays summary report for all replayed
+// partitions
+func printReplaySummary(topic string, ranges []replayRange) error {
+	var messages, errors uint64
+
+	fmt.Printf("Topic: %s\n\n", topic)
+
+	w := newTableWriter()
+	fmt.Fprintln(w, "PARTITION\tSTART OFFSET\tEND OFFSET\tMESSAGES\tVALID\tERRORS")
+	for _, r := range ranges {
+		fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%d\t%d\n",
+			r.partition, r.start, r.end, r.messages, r.messages-r.errors, r.errors)
+		messages += r.messages
+		errors += r.errors
+	}
+	fmt.Fprintf(w, "TOTAL\t\t\t%d\t%d\t%d\n", messages, messages-errors, errors)
+
+	return w.Flush()
+}
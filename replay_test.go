@@ -0,0 +1,174 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Unit test definitions for functions and methods defined in source file
+// replay.go
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/tisnik/go-capture"
+
+	main "github.com/RedHatInsights/insights-kafka-monitor"
+)
+
+// replayTimestamp is timestamp used to select start of replayed range
+var replayTimestamp = time.Date(2022, 1, 2, 10, 0, 0, 0, time.UTC)
+
+// newReplayMockBroker function constructs mock broker that serves three
+// messages from one partition; the second message is not valid JSON.
+func newReplayMockBroker(t *testing.T) *sarama.MockBroker {
+	broker := sarama.NewMockBroker(t, 1)
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader(testTopic, 0, broker.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset(testTopic, 0, sarama.OffsetOldest, 0).
+			SetOffset(testTopic, 0, sarama.OffsetNewest, 3).
+			SetOffset(testTopic, 0, replayTimestamp.UnixNano()/int64(time.Millisecond), 1),
+		"FetchRequest": sarama.NewMockFetchResponse(t, 1).
+			SetMessage(testTopic, 0, 0, sarama.StringEncoder(`{"a":1}`)).
+			SetMessage(testTopic, 0, 1, sarama.StringEncoder(`not a JSON`)).
+			SetMessage(testTopic, 0, 2, sarama.StringEncoder(`{"a":2}`)).
+			SetHighWaterMark(testTopic, 0, 3),
+	})
+
+	return broker
+}
+
+// replayConfiguration function constructs configuration for replay command
+// with JSON decoder configured for test topic
+func replayConfiguration(broker *sarama.MockBroker) main.ConfigStruct {
+	config := configurationForMockBroker(broker, testGroup)
+	config.Topics = []main.TopicConfiguration{
+		{Name: testTopic, Decoders: []string{"json"}},
+	}
+	return config
+}
+
+// TestReplayWholePartition checks that all messages are replayed by default
+func TestReplayWholePartition(t *testing.T) {
+	broker := newReplayMockBroker(t)
+	defer broker.Close()
+
+	output, err := capture.StandardOutput(func() {
+		code, err := main.Replay(replayConfiguration(broker), main.CliFlags{Replay: true})
+		assert.NoError(t, err)
+		assert.Equal(t, main.ExitStatusOK, code)
+	})
+	checkCapture(t, err)
+
+	assert.Contains(t, output, "Topic: "+testTopic)
+	assert.Regexp(t, `0\s+0\s+3\s+3\s+2\s+1`, output)
+	assert.Regexp(t, `TOTAL\s+3\s+2\s+1`, output)
+}
+
+// TestReplayFetchesHeaders checks that messages are fetched with protocol
+// version that supports record headers
+func TestReplayFetchesHeaders(t *testing.T) {
+	broker := newReplayMockBroker(t)
+	defer broker.Close()
+
+	_, err := capture.StandardOutput(func() {
+		_, err := main.Replay(replayConfiguration(broker), main.CliFlags{Replay: true})
+		assert.NoError(t, err)
+	})
+	checkCapture(t, err)
+
+	fetched := false
+	for _, exchange := range broker.History() {
+		if request, ok := exchange.Request.(*sarama.FetchRequest); ok {
+			fetched = true
+			// version 4 of fetch request is used since Kafka 0.11
+			assert.True(t, request.Version >= 4)
+		}
+	}
+	assert.True(t, fetched)
+}
+
+// TestReplayOffsetRange checks that just messages from selected range of
+// offsets are replayed
+func TestReplayOffsetRange(t *testing.T) {
+	broker := newReplayMockBroker(t)
+	defer broker.Close()
+
+	output, err := capture.StandardOutput(func() {
+		code, err := main.Replay(replayConfiguration(broker), main.CliFlags{
+			Replay:           true,
+			ReplayTopic:      testTopic,
+			ReplayPartitions: "0",
+			ReplayStart:      "2",
+			ReplayEnd:        "3",
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, main.ExitStatusOK, code)
+	})
+	checkCapture(t, err)
+
+	assert.Regexp(t, `TOTAL\s+1\s+1\s+0`, output)
+}
+
+// TestReplayTimestampRange checks that start of replayed range can be
+// specified by timestamp
+func TestReplayTimestampRange(t *testing.T) {
+	broker := newReplayMockBroker(t)
+	defer broker.Close()
+
+	output, err := capture.StandardOutput(func() {
+		code, err := main.Replay(replayConfiguration(broker), main.CliFlags{
+			Replay:      true,
+			ReplayStart: replayTimestamp.Format(time.RFC3339),
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, main.ExitStatusOK, code)
+	})
+	checkCapture(t, err)
+
+	assert.Regexp(t, `0\s+1\s+3\s+2\s+1\s+1`, output)
+}
+
+// TestReplayImproperBounds checks that improper range is refused
+func TestReplayImproperBounds(t *testing.T) {
+	for _, flags := range []main.CliFlags{
+		{Replay: true, ReplayStart: "yesterday"},
+		{Replay: true, ReplayEnd: "-1"},
+	} {
+		code, err := main.Replay(main.ConfigStruct{}, flags)
+		assert.Error(t, err)
+		assert.Equal(t, main.ExitStatusError, code)
+	}
+}
+
+// TestReplayImproperPartitions checks that improper list of partitions is
+// refused
+func TestReplayImproperPartitions(t *testing.T) {
+	broker := newReplayMockBroker(t)
+	defer broker.Close()
+
+	code, err := main.Replay(replayConfiguration(broker), main.CliFlags{
+		Replay:           true,
+		ReplayPartitions: "0,x",
+	})
+	assert.EqualError(t, err, "invalid partition 'x'")
+	assert.Equal(t, main.ExitStatusError, code)
+}
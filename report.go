@@ -0,0 +1,539 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains implementation of periodic summary reports.
+// Message counts, error counts and the most frequent error reasons,
+// processing latency percentiles, the largest messages, quiet periods, and
+// schema drift events are collected for each topic during report period.
+// When the period ends, report is written into configured directory in
+// Markdown and/or JSON format and optionally sent to webhook. Periods are
+// aligned to multiples of configured interval, so daily reports cover whole
+// days (in UTC).
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/rs/zerolog/log"
+)
+
+// Report formats
+const (
+	ReportFormatMarkdown = "markdown"
+	ReportFormatJSON     = "json"
+)
+
+// Default report settings
+const (
+	defaultReportInterval       = 24 * time.Hour
+	defaultReportTopMessages    = 5
+	defaultReportTopErrors      = 5
+	defaultReportQuietPeriod    = 10 * time.Minute
+	defaultReportLatencySamples = 10000
+	defaultReportWebhookTimeout = 10 * time.Second
+)
+
+// maxReportErrorReasons is the maximum number of distinct error reasons
+// tracked for one topic. Errors with other reasons are counted together.
+const maxReportErrorReasons = 1000
+
+// otherErrorReasons is reason used for errors that are not tracked
+// separately
+const otherErrorReasons = "(other)"
+
+// Report file naming
+const (
+	reportFilePrefix     = "report-"
+	reportFileTimeFormat = "20060102T150405"
+)
+
+// Messages used by report generator
+const (
+	reportWriteMessage   = "Unable to write report"
+	reportWebhookMessage = "Unable to send report to webhook"
+	reportWrittenMessage = "Report written"
+)
+
+// ErrorCount contains number of errors with given reason
+type ErrorCount struct {
+	Reason string `json:"reason"`
+	Count  uint64 `json:"count"`
+}
+
+// LatencyPercentiles contains percentiles of message processing latency
+type LatencyPercentiles struct {
+	P50 time.Duration `json:"p50"`
+	P90 time.Duration `json:"p90"`
+	P99 time.Duration `json:"p99"`
+	Max time.Duration `json:"max"`
+}
+
+// MessageSize identifies message together with its size
+type MessageSize struct {
+	Partition int32     `json:"partition"`
+	Offset    int64     `json:"offset"`
+	Timestamp time.Time `json:"timestamp"`
+	Size      int       `json:"size"`
+}
+
+// QuietPeriod represents time interval without any message consumed from
+// topic
+type QuietPeriod struct {
+	Start    time.Time     `json:"start"`
+	End      time.Time     `json:"end"`
+	Duration time.Duration `json:"duration"`
+}
+
+// TopicReport contains summary of one topic for report period
+type TopicReport struct {
+	Topic           string             `json:"topic"`
+	Messages        uint64             `json:"messages"`
+	Errors          uint64             `json:"errors"`
+	TopErrors       []ErrorCount       `json:"top_errors"`
+	Latency         LatencyPercentiles `json:"latency"`
+	LargestMessages []MessageSize      `json:"largest_messages"`
+	QuietPeriods    []QuietPeriod      `json:"quiet_periods"`
+	DriftEvents     []Alert            `json:"drift_events"`
+}
+
+// Report contains summary of all topics for report period
+type Report struct {
+	Start  time.Time     `json:"start"`
+	End    time.Time     `json:"end"`
+	Topics []TopicReport `json:"topics"`
+}
+
+// topicReportData contains data collected for one topic during actual
+// report period
+type topicReportData struct {
+	messages     uint64
+	errors       uint64
+	errorReasons map[string]uint64
+	latencies    []time.Duration
+	maxLatency   time.Duration
+	largest      []MessageSize
+	lastSeen     time.Time
+	quietPeriods []QuietPeriod
+	driftEvents  []Alert
+}
+
+// Reporter collects data about consumed messages and generates periodic
+// reports
+type Reporter struct {
+	mutex       sync.Mutex
+	config      ReportConfiguration
+	topics      map[string]*topicReportData
+	periodStart time.Time
+	clock       Clock
+	randomInt   func(int) int
+	client      *http.Client
+	redactor    *Redactor
+}
+
+// NewReporter constructs new report generator. Given topics are included
+// in each report, even when no message is consumed from them. Schema drift
+// events are recorded as they are raised by given alert manager.
+func NewReporter(config ReportConfiguration, topics []string, alerts *AlertManager, clock Clock) (*Reporter, error) {
+	if config.Interval <= 0 {
+		config.Interval = defaultReportInterval
+	}
+	if len(config.Formats) == 0 {
+		config.Formats = []string{ReportFormatMarkdown}
+	}
+	for _, format := range config.Formats {
+		if format != ReportFormatMarkdown && format != ReportFormatJSON {
+			return nil, fmt.Errorf("unknown report format '%s'", format)
+		}
+	}
+	if config.Directory == "" && config.Webhook == "" {
+		return nil, fmt.Errorf("report directory or webhook needs to be configured")
+	}
+	if config.TopMessages <= 0 {
+		config.TopMessages = defaultReportTopMessages
+	}
+	if config.TopErrors <= 0 {
+		config.TopErrors = defaultReportTopErrors
+	}
+	if config.QuietPeriod <= 0 {
+		config.QuietPeriod = defaultReportQuietPeriod
+	}
+	if config.LatencySamples <= 0 {
+		config.LatencySamples = defaultReportLatencySamples
+	}
+
+	if config.Directory != "" {
+		err := os.MkdirAll(config.Directory, 0750)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	reporter := &Reporter{
+		config:    config,
+		topics:    make(map[string]*topicReportData),
+		clock:     clockOrDefault(clock),
+		randomInt: rand.Intn, // #nosec G404
+		client:    &http.Client{Timeout: defaultReportWebhookTimeout},
+	}
+	reporter.periodStart = reporter.clock.Now().Truncate(config.Interval)
+	for _, topic := range topics {
+		reporter.topic(topic)
+	}
+	if alerts != nil {
+		alerts.AddListener(reporter.RecordAlert)
+	}
+	return reporter, nil
+}
+
+// SetRedactor method sets redactor used to redact error reasons
+func (reporter *Reporter) SetRedactor(redactor *Redactor) {
+	reporter.redactor = redactor
+}
+
+// topic method returns data collected for given topic, constructing them
+// when needed
+func (reporter *Reporter) topic(topic string) *topicReportData {
+	data, found := reporter.topics[topic]
+	if !found {
+		data = &topicReportData{
+			errorReasons: make(map[string]uint64),
+			lastSeen:     reporter.periodStart,
+		}
+		reporter.topics[topic] = data
+	}
+	return data
+}
+
+// Record method records message consumed from topic together with time
+// needed to process it and processing error
+func (reporter *Reporter) Record(msg *sarama.ConsumerMessage, duration time.Duration, processingError error) {
+	reporter.mutex.Lock()
+	defer reporter.mutex.Unlock()
+
+	now := reporter.clock.Now()
+	data := reporter.topic(msg.Topic)
+
+	data.messages++
+	if processingError != nil {
+		data.errors++
+		reason := reporter.redactor.RedactString(processingError.Error())
+		if _, found := data.errorReasons[reason]; !found && len(data.errorReasons) >= maxReportErrorReasons {
+			reason = otherErrorReasons
+		}
+		data.errorReasons[reason]++
+	}
+
+	// reservoir sampling keeps uniform sample of latencies of all messages
+	// consumed during report period
+	if len(data.latencies) < reporter.config.LatencySamples {
+		data.latencies = append(data.latencies, duration)
+	} else if i := reporter.randomInt(int(data.messages)); i < reporter.config.LatencySamples {
+		data.latencies[i] = duration
+	}
+	if duration > data.maxLatency {
+		data.maxLatency = duration
+	}
+
+	data.addLargest(MessageSize{
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Timestamp: msg.Timestamp,
+		Size:      len(msg.Value),
+	}, reporter.config.TopMessages)
+
+	if now.Sub(data.lastSeen) >= reporter.config.QuietPeriod {
+		data.addQuietPeriod(data.lastSeen, now)
+	}
+	data.lastSeen = now
+}
+
+// RecordAlert method records alert raised for topic when it is schema
+// drift event. Other alerts are ignored.
+func (reporter *Reporter) RecordAlert(alert Alert) {
+	if !driftAlert(alert) {
+		return
+	}
+
+	reporter.mutex.Lock()
+	defer reporter.mutex.Unlock()
+
+	data := reporter.topic(alert.Topic)
+	data.driftEvents = append(data.driftEvents, alert)
+}
+
+// addLargest method stores message into list of the largest messages when
+// it is larger than the smallest one in the list
+func (data *topicReportData) addLargest(message MessageSize, limit int) {
+	if len(data.largest) == limit && message.Size <= data.largest[limit-1].Size {
+		return
+	}
+	data.largest = append(data.largest, message)
+	sort.SliceStable(data.largest, func(i, j int) bool {
+		return data.largest[i].Size > data.largest[j].Size
+	})
+	if len(data.largest) > limit {
+		data.largest = data.largest[:limit]
+	}
+}
+
+// addQuietPeriod method stores quiet period
+func (data *topicReportData) addQuietPeriod(start, end time.Time) {
+	data.quietPeriods = append(data.quietPeriods, QuietPeriod{
+		Start:    start,
+		End:      end,
+		Duration: end.Sub(start),
+	})
+}
+
+// latencyPercentile function returns latency at given percentile from
+// sorted latencies
+func latencyPercentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	index := int(float64(len(sorted))*p/100+0.5) - 1
+	if index < 0 {
+		index = 0
+	}
+	if index >= len(sorted) {
+		index = len(sorted) - 1
+	}
+	return sorted[index]
+}
+
+// driftAlert function checks if alert was raised by schema drift detector
+func driftAlert(alert Alert) bool {
+	switch alert.Type {
+	case AlertSchemaNewField, AlertSchemaMissingField, AlertSchemaTypeChanged:
+		return true
+	}
+	return false
+}
+
+// Generate method finishes actual report period, returns report for it, and
+// starts new period
+func (reporter *Reporter) Generate() Report {
+	reporter.mutex.Lock()
+	defer reporter.mutex.Unlock()
+
+	end := reporter.clock.Now()
+	report := Report{
+		Start:  reporter.periodStart,
+		End:    end,
+		Topics: make([]TopicReport, 0, len(reporter.topics)),
+	}
+
+	for name, data := range reporter.topics {
+		if end.Sub(data.lastSeen) >= reporter.config.QuietPeriod {
+			data.addQuietPeriod(data.lastSeen, end)
+		}
+
+		topicReport := TopicReport{
+			Topic:           name,
+			Messages:        data.messages,
+			Errors:          data.errors,
+			TopErrors:       make([]ErrorCount, 0, len(data.errorReasons)),
+			LargestMessages: data.largest,
+			QuietPeriods:    data.quietPeriods,
+			DriftEvents:     data.driftEvents,
+		}
+		for reason, count := range data.errorReasons {
+			topicReport.TopErrors = append(topicReport.TopErrors, ErrorCount{Reason: reason, Count: count})
+		}
+		sort.Slice(topicReport.TopErrors, func(i, j int) bool {
+			if topicReport.TopErrors[i].Count != topicReport.TopErrors[j].Count {
+				return topicReport.TopErrors[i].Count > topicReport.TopErrors[j].Count
+			}
+			return topicReport.TopErrors[i].Reason < topicReport.TopErrors[j].Reason
+		})
+		if len(topicReport.TopErrors) > reporter.config.TopErrors {
+			topicReport.TopErrors = topicReport.TopErrors[:reporter.config.TopErrors]
+		}
+
+		sort.Slice(data.latencies, func(i, j int) bool {
+			return data.latencies[i] < data.latencies[j]
+		})
+		topicReport.Latency = LatencyPercentiles{
+			P50: latencyPercentile(data.latencies, 50),
+			P90: latencyPercentile(data.latencies, 90),
+			P99: latencyPercentile(data.latencies, 99),
+			Max: data.maxLatency,
+		}
+
+		if topicReport.DriftEvents == nil {
+			topicReport.DriftEvents = []Alert{}
+		}
+		if topicReport.LargestMessages == nil {
+			topicReport.LargestMessages = []MessageSize{}
+		}
+		if topicReport.QuietPeriods == nil {
+			topicReport.QuietPeriods = []QuietPeriod{}
+		}
+
+		report.Topics = append(report.Topics, topicReport)
+	}
+
+	sort.Slice(report.Topics, func(i, j int) bool {
+		return report.Topics[i].Topic < report.Topics[j].Topic
+	})
+
+	// topics are kept, so they are included in the next report too
+	reporter.periodStart = end
+	for name := range reporter.topics {
+		delete(reporter.topics, name)
+		reporter.topic(name)
+	}
+
+	return report
+}
+
+// Markdown method renders report in Markdown format
+func (report *Report) Markdown() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# Kafka monitor report\n\n")
+	fmt.Fprintf(&b, "Period: %s - %s\n", report.Start.UTC().Format(time.RFC3339), report.End.UTC().Format(time.RFC3339))
+
+	for _, topic := range report.Topics {
+		fmt.Fprintf(&b, "\n## Topic %s\n\n", topic.Topic)
+		fmt.Fprintf(&b, "| Messages | Errors | Latency p50 | Latency p90 | Latency p99 | Latency max |\n")
+		fmt.Fprintf(&b, "|---------:|-------:|------------:|------------:|------------:|------------:|\n")
+		fmt.Fprintf(&b, "| %d | %d | %v | %v | %v | %v |\n",
+			topic.Messages, topic.Errors,
+			topic.Latency.P50, topic.Latency.P90, topic.Latency.P99, topic.Latency.Max)
+
+		if len(topic.TopErrors) > 0 {
+			fmt.Fprintf(&b, "\n### Top error reasons\n\n")
+			for _, reason := range topic.TopErrors {
+				fmt.Fprintf(&b, "* %d× `%s`\n", reason.Count, reason.Reason)
+			}
+		}
+
+		if len(topic.LargestMessages) > 0 {
+			fmt.Fprintf(&b, "\n### Largest messages\n\n")
+			fmt.Fprintf(&b, "| Partition | Offset | Size | Timestamp |\n")
+			fmt.Fprintf(&b, "|----------:|-------:|-----:|-----------|\n")
+			for _, message := range topic.LargestMessages {
+				fmt.Fprintf(&b, "| %d | %d | %d | %s |\n",
+					message.Partition, message.Offset, message.Size, message.Timestamp.UTC().Format(time.RFC3339))
+			}
+		}
+
+		if len(topic.QuietPeriods) > 0 {
+			fmt.Fprintf(&b, "\n### Quiet periods\n\n")
+			for _, period := range topic.QuietPeriods {
+				fmt.Fprintf(&b, "* %s - %s (%v)\n",
+					period.Start.UTC().Format(time.RFC3339), period.End.UTC().Format(time.RFC3339),
+					period.Duration.Round(time.Second))
+			}
+		}
+
+		if len(topic.DriftEvents) > 0 {
+			fmt.Fprintf(&b, "\n### Schema drift events\n\n")
+			for _, alert := range topic.DriftEvents {
+				fmt.Fprintf(&b, "* %s %s: %s\n", alert.Time.UTC().Format(time.RFC3339), alert.Type, alert.Message)
+			}
+		}
+	}
+
+	return b.String()
+}
+
+// Write method writes report into configured directory in all configured
+// formats and sends it to webhook when it is configured
+func (reporter *Reporter) Write(report Report) error {
+	if reporter.config.Directory != "" {
+		name := filepath.Join(reporter.config.Directory, reportFilePrefix+report.Start.UTC().Format(reportFileTimeFormat))
+		for _, format := range reporter.config.Formats {
+			var content []byte
+			var fileName string
+			switch format {
+			case ReportFormatJSON:
+				var err error
+				content, err = json.MarshalIndent(report, "", "  ")
+				if err != nil {
+					return err
+				}
+				fileName = name + ".json"
+			default:
+				content = []byte(report.Markdown())
+				fileName = name + ".md"
+			}
+			err := ioutil.WriteFile(fileName, content, 0600)
+			if err != nil {
+				return err
+			}
+			log.Info().Str(filenameAttribute, fileName).Msg(reportWrittenMessage)
+		}
+	}
+
+	if reporter.config.Webhook != "" {
+		return reporter.send(report)
+	}
+	return nil
+}
+
+// send method sends report to webhook as JSON
+func (reporter *Reporter) send(report Report) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	response, err := reporter.client.Post(reporter.config.Webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := response.Body.Close()
+		if err != nil {
+			log.Error().Err(err).Msg(reportWebhookMessage)
+		}
+	}()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d", response.StatusCode)
+	}
+	return nil
+}
+
+// Run method generates and writes report at the end of each report period.
+// It blocks current thread.
+func (reporter *Reporter) Run() {
+	for {
+		now := reporter.clock.Now()
+		next := now.Truncate(reporter.config.Interval).Add(reporter.config.Interval)
+		time.Sleep(next.Sub(now))
+
+		err := reporter.Write(reporter.Generate())
+		if err != nil {
+			log.Error().Err(err).Msg(reportWriteMessage)
+		}
+	}
+}
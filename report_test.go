@@ -0,0 +1,202 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Unit test definitions for functions and methods defined in source file
+// report.go
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-kafka-monitor"
+)
+
+// reportStart is start of report period used by tests
+var reportStart = time.Date(2022, 3, 4, 0, 0, 0, 0, time.UTC)
+
+// newReporter function constructs report generator that writes reports
+// into temporary directory when no destination is configured
+func newReporter(t *testing.T, config main.ReportConfiguration, topics []string, alerts *main.AlertManager, clock main.Clock) *main.Reporter {
+	if config.Directory == "" && config.Webhook == "" {
+		config.Directory = t.TempDir()
+	}
+	reporter, err := main.NewReporter(config, topics, alerts, clock)
+	assert.NoError(t, err)
+	return reporter
+}
+
+// TestNewReporterImproperConfiguration checks that improper report
+// configuration is refused
+func TestNewReporterImproperConfiguration(t *testing.T) {
+	_, err := main.NewReporter(main.ReportConfiguration{}, nil, nil, nil)
+	assert.EqualError(t, err, "report directory or webhook needs to be configured")
+
+	_, err = main.NewReporter(main.ReportConfiguration{
+		Directory: t.TempDir(),
+		Formats:   []string{"pdf"},
+	}, nil, nil, nil)
+	assert.EqualError(t, err, "unknown report format 'pdf'")
+}
+
+// TestReporterGenerate checks content of generated report
+func TestReporterGenerate(t *testing.T) {
+	clock := newManualClock(reportStart)
+	alerts := main.NewAlertManager(nil)
+	reporter := newReporter(t, main.ReportConfiguration{
+		TopMessages: 2,
+		TopErrors:   1,
+		QuietPeriod: time.Hour,
+	}, []string{"topic", "silent"}, alerts, clock)
+
+	clock.Advance(2 * time.Hour)
+	reporter.Record(consumerMessage(1, make([]byte, 10)), 10*time.Millisecond, nil)
+	reporter.Record(consumerMessage(2, make([]byte, 30)), 20*time.Millisecond, errors.New("bad JSON"))
+	reporter.Record(consumerMessage(3, make([]byte, 20)), 30*time.Millisecond, errors.New("bad JSON"))
+	reporter.Record(consumerMessage(4, make([]byte, 5)), 40*time.Millisecond, errors.New("missing field"))
+
+	alerts.Raise(main.Alert{Time: clock.Now(), Type: main.AlertSchemaNewField, Topic: "topic", Message: "new field"})
+	alerts.Raise(main.Alert{Time: clock.Now(), Type: main.AlertCanaryLost, Topic: "topic", Message: "canary lost"})
+
+	clock.Advance(30 * time.Minute)
+	report := reporter.Generate()
+
+	assert.Equal(t, reportStart, report.Start)
+	assert.Equal(t, clock.Now(), report.End)
+	assert.Len(t, report.Topics, 2)
+
+	silent := report.Topics[0]
+	assert.Equal(t, "silent", silent.Topic)
+	assert.Equal(t, uint64(0), silent.Messages)
+	assert.Equal(t, []main.QuietPeriod{{Start: reportStart, End: clock.Now(), Duration: 150 * time.Minute}}, silent.QuietPeriods)
+
+	topic := report.Topics[1]
+	assert.Equal(t, "topic", topic.Topic)
+	assert.Equal(t, uint64(4), topic.Messages)
+	assert.Equal(t, uint64(3), topic.Errors)
+	assert.Equal(t, []main.ErrorCount{{Reason: "bad JSON", Count: 2}}, topic.TopErrors)
+	assert.Equal(t, 20*time.Millisecond, topic.Latency.P50)
+	assert.Equal(t, 40*time.Millisecond, topic.Latency.P99)
+	assert.Equal(t, 40*time.Millisecond, topic.Latency.Max)
+	assert.Len(t, topic.LargestMessages, 2)
+	assert.Equal(t, int64(2), topic.LargestMessages[0].Offset)
+	assert.Equal(t, 30, topic.LargestMessages[0].Size)
+	assert.Equal(t, int64(3), topic.LargestMessages[1].Offset)
+	// the last 30 minutes are shorter than quiet period
+	assert.Equal(t, []main.QuietPeriod{{Start: reportStart, End: reportStart.Add(2 * time.Hour), Duration: 2 * time.Hour}}, topic.QuietPeriods)
+	assert.Len(t, topic.DriftEvents, 1)
+	assert.Equal(t, "new field", topic.DriftEvents[0].Message)
+
+	// new period is started and all topics are still reported
+	clock.Advance(10 * time.Minute)
+	report = reporter.Generate()
+	assert.Len(t, report.Topics, 2)
+	assert.Equal(t, uint64(0), report.Topics[1].Messages)
+	assert.Empty(t, report.Topics[1].DriftEvents)
+}
+
+// TestReporterDriftEvents checks that drift events are recorded when they
+// are raised, so they are reported even when many other alerts are raised
+// later
+func TestReporterDriftEvents(t *testing.T) {
+	clock := newManualClock(reportStart)
+	alerts := main.NewAlertManager(nil)
+	reporter := newReporter(t, main.ReportConfiguration{}, []string{"topic"}, alerts, clock)
+
+	alerts.Raise(main.Alert{Type: main.AlertSchemaNewField, Topic: "topic", Message: "new field"})
+	for i := 0; i < 2000; i++ {
+		alerts.Raise(main.Alert{Type: main.AlertOffsetGap, Topic: "topic", Message: "gap"})
+	}
+
+	clock.Advance(time.Minute)
+	report := reporter.Generate()
+	assert.Len(t, report.Topics[0].DriftEvents, 1)
+	assert.Equal(t, "new field", report.Topics[0].DriftEvents[0].Message)
+}
+
+// TestReporterWrite checks that report is written in all configured
+// formats
+func TestReporterWrite(t *testing.T) {
+	directory := t.TempDir()
+	clock := newManualClock(reportStart)
+	reporter := newReporter(t, main.ReportConfiguration{
+		Directory: directory,
+		Formats:   []string{main.ReportFormatMarkdown, main.ReportFormatJSON},
+	}, []string{"topic"}, nil, clock)
+
+	reporter.Record(consumerMessage(1, []byte(`{}`)), time.Millisecond, errors.New("bad JSON"))
+	clock.Advance(time.Hour)
+	assert.NoError(t, reporter.Write(reporter.Generate()))
+
+	markdown, err := ioutil.ReadFile(filepath.Join(directory, "report-20220304T000000.md"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(markdown), "## Topic topic")
+	assert.Contains(t, string(markdown), "### Top error reasons")
+	assert.Contains(t, string(markdown), "`bad JSON`")
+	assert.Contains(t, string(markdown), "### Largest messages")
+
+	content, err := ioutil.ReadFile(filepath.Join(directory, "report-20220304T000000.json"))
+	assert.NoError(t, err)
+	var report main.Report
+	assert.NoError(t, json.Unmarshal(content, &report))
+	assert.Equal(t, uint64(1), report.Topics[0].Errors)
+}
+
+// TestReporterWebhook checks that report is sent to webhook
+func TestReporterWebhook(t *testing.T) {
+	var received main.Report
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		assert.Equal(t, http.MethodPost, request.Method)
+		assert.Equal(t, "application/json", request.Header.Get("Content-Type"))
+		assert.NoError(t, json.NewDecoder(request.Body).Decode(&received))
+		writer.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	clock := newManualClock(reportStart)
+	reporter := newReporter(t, main.ReportConfiguration{Webhook: server.URL}, []string{"topic"}, nil, clock)
+
+	reporter.Record(consumerMessage(1, []byte(`{}`)), time.Millisecond, nil)
+	assert.NoError(t, reporter.Write(reporter.Generate()))
+	assert.Equal(t, uint64(1), received.Topics[0].Messages)
+
+	status = http.StatusBadGateway
+	assert.EqualError(t, reporter.Write(reporter.Generate()), "webhook responded with status 502")
+}
+
+// TestReporterRecordsConsumedMessages checks that messages handled by
+// consumer are recorded by report generator
+func TestReporterRecordsConsumedMessages(t *testing.T) {
+	clock := newManualClock(reportStart)
+	consumer := NewDummyConsumer()
+	consumer.Reporter = newReporter(t, main.ReportConfiguration{}, nil, nil, clock)
+
+	consumer.HandleMessage(consumerMessage(1, []byte(`{}`)))
+
+	report := consumer.Reporter.Generate()
+	assert.Len(t, report.Topics, 1)
+	assert.Equal(t, uint64(1), report.Topics[0].Messages)
+}
@@ -0,0 +1,405 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains implementation of sequence checker. Offset of
+// each consumed message is compared with offset of previous message
+// consumed from the same partition, so gaps (skipped offsets) and
+// regressions (offsets consumed again, for example after rebalance or
+// offset reset) are detected. Message timestamps are checked the same way
+// to detect out-of-order messages. Offsets normally skip in compacted and
+// transactional topics, so gaps are not alerted for them. State of
+// partitions that are no longer claimed by consumer is forgotten.
+// Optionally, duplicate payloads are detected for topics with dedup_key
+// rule configured in [[topics]] table. Deduplication keys are kept in
+// bounded in-memory store for configured time window measured from the
+// time the key was last seen by the monitor.
+
+import (
+	"container/list"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/rs/zerolog/log"
+)
+
+// Alert types raised by sequence checker
+const (
+	AlertOffsetGap        = "offset_gap"
+	AlertOffsetRegression = "offset_regression"
+	AlertOutOfOrder       = "out_of_order_timestamp"
+	AlertDuplicate        = "duplicate_message"
+)
+
+// Default sequence checker settings
+const (
+	defaultDedupWindow  = time.Hour
+	defaultDedupMaxKeys = 100000
+)
+
+// PartitionSequenceStats contains statistic about sequence of messages
+// consumed from one partition
+type PartitionSequenceStats struct {
+	Topic         string    `json:"topic"`
+	Partition     int32     `json:"partition"`
+	Messages      uint64    `json:"messages"`
+	LastOffset    int64     `json:"last_offset"`
+	LastTimestamp time.Time `json:"last_timestamp"`
+	// Gaps is number of detected gaps in offsets
+	Gaps uint64 `json:"gaps"`
+	// MissingOffsets is total number of offsets skipped by all gaps
+	MissingOffsets int64 `json:"missing_offsets"`
+	// Regressions is number of detected offsets lower than or equal to
+	// previous offset
+	Regressions uint64 `json:"regressions"`
+	// OutOfOrder is number of messages with timestamp older than
+	// timestamp of previous message
+	OutOfOrder uint64 `json:"out_of_order"`
+}
+
+// SequenceStats contains statistic about all consumed partitions and about
+// duplicates detected in all topics
+type SequenceStats struct {
+	Partitions []PartitionSequenceStats `json:"partitions"`
+	Duplicates map[string]uint64        `json:"duplicates"`
+	DedupKeys  int                      `json:"dedup_keys"`
+}
+
+// partitionID identifies one partition of one topic
+type partitionID struct {
+	topic     string
+	partition int32
+}
+
+// dedupEntry represents one deduplication key seen recently
+type dedupEntry struct {
+	key       string
+	seen      time.Time
+	partition int32
+	offset    int64
+	element   *list.Element
+}
+
+// SequenceChecker detects offset gaps and regressions, out-of-order
+// timestamps, and duplicate payloads
+type SequenceChecker struct {
+	mutex      sync.Mutex
+	config     SequenceConfiguration
+	alerts     *AlertManager
+	dedupRules map[string]idRule
+	ignoreGaps map[string]bool
+	partitions map[partitionID]*PartitionSequenceStats
+	dedupKeys  map[string]*dedupEntry
+	dedupOrder *list.List
+	duplicates map[string]uint64
+	clock      Clock
+}
+
+// NewSequenceChecker constructs new sequence checker. Rules used to extract
+// deduplication keys and topics with ignored offset gaps are taken from
+// topics configuration.
+func NewSequenceChecker(config SequenceConfiguration, topicsConfig []TopicConfiguration, alerts *AlertManager, clock Clock) (*SequenceChecker, error) {
+	if config.DedupWindow <= 0 {
+		config.DedupWindow = defaultDedupWindow
+	}
+	if config.DedupMaxKeys <= 0 {
+		config.DedupMaxKeys = defaultDedupMaxKeys
+	}
+
+	dedupRules := make(map[string]idRule)
+	ignoreGaps := make(map[string]bool)
+	for _, topicConfig := range topicsConfig {
+		if topicConfig.IgnoreOffsetGaps {
+			ignoreGaps[topicConfig.Name] = true
+		}
+		if topicConfig.DedupKey == "" {
+			continue
+		}
+		rule, err := parseIDRule(topicConfig.DedupKey)
+		if err != nil {
+			return nil, fmt.Errorf("topic '%s': %v", topicConfig.Name, err)
+		}
+		dedupRules[topicConfig.Name] = rule
+	}
+
+	return &SequenceChecker{
+		config:     config,
+		alerts:     alerts,
+		dedupRules: dedupRules,
+		ignoreGaps: ignoreGaps,
+		partitions: make(map[partitionID]*PartitionSequenceStats),
+		dedupKeys:  make(map[string]*dedupEntry),
+		dedupOrder: list.New(),
+		duplicates: make(map[string]uint64),
+		clock:      clockOrDefault(clock),
+	}, nil
+}
+
+// IgnoreOffsetGaps method turns off alerts for offset gaps in given topic.
+// Gaps are still counted.
+func (checker *SequenceChecker) IgnoreOffsetGaps(topic string) {
+	checker.mutex.Lock()
+	defer checker.mutex.Unlock()
+
+	checker.ignoreGaps[topic] = true
+}
+
+// SetClaims method sets partitions claimed by consumer in actual session.
+// Sequence state of partitions that are no longer claimed is forgotten, so
+// no gap or regression is reported when the partition is claimed again.
+func (checker *SequenceChecker) SetClaims(claims map[string][]int32) {
+	checker.mutex.Lock()
+	defer checker.mutex.Unlock()
+
+	claimed := make(map[partitionID]bool)
+	for topic, partitions := range claims {
+		for _, partition := range partitions {
+			claimed[partitionID{topic: topic, partition: partition}] = true
+		}
+	}
+	for id := range checker.partitions {
+		if !claimed[id] {
+			delete(checker.partitions, id)
+		}
+	}
+}
+
+// Check method compares offset and timestamp of message with previous
+// message consumed from the same partition
+func (checker *SequenceChecker) Check(msg *sarama.ConsumerMessage) {
+	checker.mutex.Lock()
+	defer checker.mutex.Unlock()
+
+	id := partitionID{topic: msg.Topic, partition: msg.Partition}
+	stats, found := checker.partitions[id]
+	if !found {
+		checker.partitions[id] = &PartitionSequenceStats{
+			Topic:         msg.Topic,
+			Partition:     msg.Partition,
+			Messages:      1,
+			LastOffset:    msg.Offset,
+			LastTimestamp: msg.Timestamp,
+		}
+		return
+	}
+	stats.Messages++
+
+	details := map[string]interface{}{
+		"partition":       msg.Partition,
+		"offset":          msg.Offset,
+		"previous_offset": stats.LastOffset,
+	}
+
+	switch {
+	case msg.Offset > stats.LastOffset+1:
+		missing := msg.Offset - stats.LastOffset - 1
+		stats.Gaps++
+		stats.MissingOffsets += missing
+		if checker.ignoreGaps[msg.Topic] {
+			break
+		}
+		details["missing"] = missing
+		checker.alerts.Raise(Alert{
+			Type:     AlertOffsetGap,
+			Severity: SeverityWarning,
+			Topic:    msg.Topic,
+			Message:  fmt.Sprintf("%d offsets skipped in partition %d", missing, msg.Partition),
+			Details:  details,
+		})
+	case msg.Offset <= stats.LastOffset:
+		stats.Regressions++
+		checker.alerts.Raise(Alert{
+			Type:     AlertOffsetRegression,
+			Severity: SeverityWarning,
+			Topic:    msg.Topic,
+			Message:  fmt.Sprintf("Offset in partition %d went back from %d to %d", msg.Partition, stats.LastOffset, msg.Offset),
+			Details:  details,
+		})
+	}
+
+	if !msg.Timestamp.IsZero() && msg.Timestamp.Before(stats.LastTimestamp) {
+		stats.OutOfOrder++
+		checker.alerts.Raise(Alert{
+			Type:     AlertOutOfOrder,
+			Severity: SeverityInfo,
+			Topic:    msg.Topic,
+			Message:  fmt.Sprintf("Message timestamp in partition %d is older than timestamp of previous message", msg.Partition),
+			Details: map[string]interface{}{
+				"partition":          msg.Partition,
+				"offset":             msg.Offset,
+				"timestamp":          msg.Timestamp,
+				"previous_timestamp": stats.LastTimestamp,
+			},
+		})
+	}
+
+	stats.LastOffset = msg.Offset
+	if msg.Timestamp.After(stats.LastTimestamp) {
+		stats.LastTimestamp = msg.Timestamp
+	}
+}
+
+// HasDedupRule method checks if duplicates are to be detected for given
+// topic
+func (checker *SequenceChecker) HasDedupRule(topic string) bool {
+	_, found := checker.dedupRules[topic]
+	return found
+}
+
+// CheckDuplicate method checks if message with the same deduplication key
+// has been consumed from the same topic in configured time window. Message
+// consumed again from the same offset (for example after rebalance) is not
+// considered duplicate as it is reported as offset regression already.
+func (checker *SequenceChecker) CheckDuplicate(message *DecodedMessage) bool {
+	rule, found := checker.dedupRules[message.Message.Topic]
+	if !found {
+		return false
+	}
+	id := rule.extract(message)
+	if id == "" {
+		return false
+	}
+
+	msg := message.Message
+	key := msg.Topic + "\x00" + id
+
+	checker.mutex.Lock()
+	defer checker.mutex.Unlock()
+
+	// message timestamps are not ordered across partitions, so time of
+	// consumption is used for time window
+	seen := checker.clock.Now()
+	checker.expireDedupKeys(seen)
+
+	entry, found := checker.dedupKeys[key]
+	if !found {
+		entry = &dedupEntry{key: key}
+		entry.element = checker.dedupOrder.PushBack(entry)
+		checker.dedupKeys[key] = entry
+		checker.evictDedupKeys()
+	} else {
+		checker.dedupOrder.MoveToBack(entry.element)
+	}
+
+	duplicate := found && (entry.partition != msg.Partition || entry.offset != msg.Offset)
+	if duplicate {
+		checker.duplicates[msg.Topic]++
+		checker.alerts.Raise(Alert{
+			Type:     AlertDuplicate,
+			Severity: SeverityWarning,
+			Topic:    msg.Topic,
+			Message:  fmt.Sprintf("Duplicate message consumed in %v", seen.Sub(entry.seen)),
+			Details: map[string]interface{}{
+				"key":                id,
+				"partition":          msg.Partition,
+				"offset":             msg.Offset,
+				"original_partition": entry.partition,
+				"original_offset":    entry.offset,
+			},
+		})
+	}
+
+	entry.seen = seen
+	entry.partition = msg.Partition
+	entry.offset = msg.Offset
+	return duplicate
+}
+
+// expireDedupKeys method removes deduplication keys seen before time
+// window. Keys are ordered by the time they were last seen by the monitor,
+// so just the oldest keys need to be checked.
+func (checker *SequenceChecker) expireDedupKeys(now time.Time) {
+	deadline := now.Add(-checker.config.DedupWindow)
+	for element := checker.dedupOrder.Front(); element != nil; element = checker.dedupOrder.Front() {
+		entry := element.Value.(*dedupEntry)
+		if entry.seen.After(deadline) {
+			return
+		}
+		checker.dedupOrder.Remove(element)
+		delete(checker.dedupKeys, entry.key)
+	}
+}
+
+// evictDedupKeys method removes the least recently seen deduplication keys
+// when the store is full
+func (checker *SequenceChecker) evictDedupKeys() {
+	for len(checker.dedupKeys) > checker.config.DedupMaxKeys {
+		entry := checker.dedupOrder.Front().Value.(*dedupEntry)
+		checker.dedupOrder.Remove(entry.element)
+		delete(checker.dedupKeys, entry.key)
+	}
+}
+
+// Stats method returns actual sequence statistic ordered by topic and
+// partition
+func (checker *SequenceChecker) Stats() SequenceStats {
+	checker.mutex.Lock()
+	defer checker.mutex.Unlock()
+
+	stats := SequenceStats{
+		Partitions: make([]PartitionSequenceStats, 0, len(checker.partitions)),
+		Duplicates: make(map[string]uint64, len(checker.duplicates)),
+		DedupKeys:  len(checker.dedupKeys),
+	}
+	for _, partitionStats := range checker.partitions {
+		stats.Partitions = append(stats.Partitions, *partitionStats)
+	}
+	for topic, count := range checker.duplicates {
+		stats.Duplicates[topic] = count
+	}
+
+	sort.Slice(stats.Partitions, func(i, j int) bool {
+		if stats.Partitions[i].Topic != stats.Partitions[j].Topic {
+			return stats.Partitions[i].Topic < stats.Partitions[j].Topic
+		}
+		return stats.Partitions[i].Partition < stats.Partitions[j].Partition
+	})
+	return stats
+}
+
+// fetchCompactedTopics function returns topics with compact cleanup policy
+// from given topics
+func fetchCompactedTopics(brokerCfg BrokerConfiguration, topics []string) ([]string, error) {
+	inventory, err := newInventory(brokerCfg)
+	if err != nil {
+		return nil, err
+	}
+	defer inventory.close()
+
+	var compacted []string
+	for _, topic := range topics {
+		entries, err := inventory.admin.DescribeConfig(sarama.ConfigResource{
+			Type:        sarama.TopicResource,
+			Name:        topic,
+			ConfigNames: []string{cleanupPolicyConfig},
+		})
+		if err != nil {
+			log.Warn().Err(err).Str(topicKey, topic).Msg("Unable to retrieve topic configuration")
+			continue
+		}
+		for _, entry := range entries {
+			if entry.Name == cleanupPolicyConfig && strings.Contains(entry.Value, "compact") {
+				compacted = append(compacted, topic)
+			}
+		}
+	}
+	return compacted, nil
+}
@@ -0,0 +1,292 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Unit test definitions for functions and methods defined in source file
+// sequence.go
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-kafka-monitor"
+)
+
+// newTestSequenceChecker function constructs sequence checker that detects
+// duplicate payloads in topic "topic"
+func newTestSequenceChecker(t *testing.T, config main.SequenceConfiguration, alerts *main.AlertManager, clock main.Clock) *main.SequenceChecker {
+	checker, err := main.NewSequenceChecker(config, []main.TopicConfiguration{
+		{Name: "topic", DedupKey: "payload"},
+		{Name: "other", DedupKey: "json:id"},
+	}, alerts, clock)
+	assert.NoError(t, err)
+	return checker
+}
+
+// decoded function wraps consumed message into decoded message
+func decoded(msg *sarama.ConsumerMessage) *main.DecodedMessage {
+	return &main.DecodedMessage{Message: msg, Content: msg.Value}
+}
+
+// TestSequenceCheckerGapsAndRegressions checks that gaps and regressions in
+// offsets are detected
+func TestSequenceCheckerGapsAndRegressions(t *testing.T) {
+	alerts := main.NewAlertManager(nil)
+	checker := newTestSequenceChecker(t, main.SequenceConfiguration{}, alerts, nil)
+
+	for _, offset := range []int64{10, 11, 15, 16, 12} {
+		checker.Check(consumerMessage(offset, nil))
+	}
+
+	stats := checker.Stats()
+	assert.Len(t, stats.Partitions, 1)
+
+	partition := stats.Partitions[0]
+	assert.Equal(t, int32(1), partition.Partition)
+	assert.Equal(t, uint64(5), partition.Messages)
+	assert.Equal(t, int64(12), partition.LastOffset)
+	assert.Equal(t, uint64(1), partition.Gaps)
+	assert.Equal(t, int64(3), partition.MissingOffsets)
+	assert.Equal(t, uint64(1), partition.Regressions)
+
+	assert.Equal(t, []string{main.AlertOffsetGap, main.AlertOffsetRegression}, alertTypes(alerts))
+}
+
+// TestSequenceCheckerIgnoredGaps checks that gaps in topics configured to
+// ignore them are counted, but not alerted
+func TestSequenceCheckerIgnoredGaps(t *testing.T) {
+	alerts := main.NewAlertManager(nil)
+	checker, err := main.NewSequenceChecker(main.SequenceConfiguration{}, []main.TopicConfiguration{
+		{Name: "topic", IgnoreOffsetGaps: true},
+	}, alerts, nil)
+	assert.NoError(t, err)
+
+	for _, offset := range []int64{10, 12, 15} {
+		checker.Check(consumerMessage(offset, nil))
+	}
+
+	stats := checker.Stats()
+	assert.Equal(t, uint64(2), stats.Partitions[0].Gaps)
+	assert.Equal(t, int64(3), stats.Partitions[0].MissingOffsets)
+	assert.Empty(t, alertTypes(alerts))
+}
+
+// TestSequenceCheckerIgnoreOffsetGaps checks that alerts for offset gaps
+// can be turned off for given topic at runtime
+func TestSequenceCheckerIgnoreOffsetGaps(t *testing.T) {
+	alerts := main.NewAlertManager(nil)
+	checker := newTestSequenceChecker(t, main.SequenceConfiguration{}, alerts, nil)
+
+	checker.Check(consumerMessage(10, nil))
+	checker.Check(consumerMessage(12, nil))
+	checker.IgnoreOffsetGaps("topic")
+	checker.Check(consumerMessage(14, nil))
+
+	assert.Equal(t, uint64(2), checker.Stats().Partitions[0].Gaps)
+	assert.Equal(t, []string{main.AlertOffsetGap}, alertTypes(alerts))
+}
+
+// TestFetchCompactedTopics checks that topics with compact cleanup policy
+// are retrieved from broker
+func TestFetchCompactedTopics(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetController(broker.BrokerID()).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader(testTopic, 0, broker.BrokerID()),
+		"DescribeConfigsRequest": sarama.NewMockWrapper(&sarama.DescribeConfigsResponse{
+			Resources: []*sarama.ResourceResponse{{
+				Type:    sarama.TopicResource,
+				Name:    testTopic,
+				Configs: []*sarama.ConfigEntry{{Name: "cleanup.policy", Value: "compact"}},
+			}},
+		}),
+	})
+
+	config := configurationForMockBroker(broker, testGroup)
+	compacted, err := main.FetchCompactedTopics(main.GetBrokerConfiguration(&config), []string{testTopic})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{testTopic}, compacted)
+}
+
+// TestSequenceCheckerOutOfOrder checks that messages with timestamp older
+// than timestamp of previous message are detected
+func TestSequenceCheckerOutOfOrder(t *testing.T) {
+	alerts := main.NewAlertManager(nil)
+	checker := newTestSequenceChecker(t, main.SequenceConfiguration{}, alerts, nil)
+
+	first := consumerMessage(0, nil)
+	second := consumerMessage(1, nil)
+	second.Timestamp = first.Timestamp.Add(-time.Second)
+	third := consumerMessage(2, nil)
+
+	checker.Check(first)
+	checker.Check(second)
+	checker.Check(third)
+
+	stats := checker.Stats()
+	assert.Equal(t, uint64(1), stats.Partitions[0].OutOfOrder)
+	assert.Equal(t, first.Timestamp, stats.Partitions[0].LastTimestamp)
+	assert.Equal(t, []string{main.AlertOutOfOrder}, alertTypes(alerts))
+}
+
+// TestSequenceCheckerDuplicates checks that duplicate payloads are detected
+// in time window
+func TestSequenceCheckerDuplicates(t *testing.T) {
+	alerts := main.NewAlertManager(nil)
+	clock := newManualClock(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	checker := newTestSequenceChecker(t, main.SequenceConfiguration{DedupWindow: time.Hour}, alerts, clock)
+
+	first := consumerMessage(0, []byte(`{"id": 1}`))
+	assert.False(t, checker.CheckDuplicate(decoded(first)))
+
+	// the same offset consumed again is not duplicate
+	assert.False(t, checker.CheckDuplicate(decoded(first)))
+
+	second := consumerMessage(1, []byte(`{"id": 1}`))
+	assert.True(t, checker.CheckDuplicate(decoded(second)))
+
+	third := consumerMessage(2, []byte(`{"id": 2}`))
+	assert.False(t, checker.CheckDuplicate(decoded(third)))
+
+	// message timestamps do not affect time window
+	fourth := consumerMessage(3, []byte(`{"id": 2}`))
+	fourth.Timestamp = fourth.Timestamp.Add(-24 * time.Hour)
+	clock.Advance(30 * time.Minute)
+	assert.True(t, checker.CheckDuplicate(decoded(fourth)))
+
+	// the same payload after time window is not duplicate
+	clock.Advance(time.Hour)
+	fifth := consumerMessage(4, []byte(`{"id": 1}`))
+	assert.False(t, checker.CheckDuplicate(decoded(fifth)))
+
+	stats := checker.Stats()
+	assert.Equal(t, uint64(2), stats.Duplicates["topic"])
+	assert.Equal(t, []string{main.AlertDuplicate, main.AlertDuplicate}, alertTypes(alerts))
+	assert.Equal(t, "Duplicate message consumed in 30m0s", alerts.Recent()[1].Message)
+}
+
+// TestSequenceCheckerDedupKey checks that duplicates are detected by
+// configured key and just for topics with the key configured
+func TestSequenceCheckerDedupKey(t *testing.T) {
+	checker := newTestSequenceChecker(t, main.SequenceConfiguration{DedupMaxKeys: 1}, nil, nil)
+
+	assert.True(t, checker.HasDedupRule("other"))
+	assert.False(t, checker.HasDedupRule("third"))
+
+	first := decodedMessage("other", `{"id": 1, "value": "a"}`)
+	second := decodedMessage("other", `{"id": 1, "value": "b"}`)
+	second.Message.Offset = 1
+	assert.False(t, checker.CheckDuplicate(first))
+	assert.True(t, checker.CheckDuplicate(second))
+
+	// the only key kept in store is evicted
+	third := decodedMessage("other", `{"id": 2}`)
+	third.Message.Offset = 2
+	fourth := decodedMessage("other", `{"id": 1}`)
+	fourth.Message.Offset = 3
+	assert.False(t, checker.CheckDuplicate(third))
+	assert.False(t, checker.CheckDuplicate(fourth))
+	assert.Equal(t, 1, checker.Stats().DedupKeys)
+
+	assert.False(t, checker.CheckDuplicate(decodedMessage("third", `{"id": 1}`)))
+}
+
+// TestNewSequenceCheckerImproperDedupKey checks that improper dedup key
+// rule is refused
+func TestNewSequenceCheckerImproperDedupKey(t *testing.T) {
+	_, err := main.NewSequenceChecker(main.SequenceConfiguration{}, []main.TopicConfiguration{
+		{Name: "topic", DedupKey: "json"},
+	}, nil, nil)
+	assert.EqualError(t, err, "topic 'topic': name needs to be specified in ID rule 'json'")
+}
+
+// TestHandleMessageChecksSequence checks that sequence of all handled
+// messages is checked and duplicates are detected by dedup stage
+func TestHandleMessageChecksSequence(t *testing.T) {
+	consumer := NewDummyConsumer()
+	consumer.SequenceChecker = newTestSequenceChecker(t, main.SequenceConfiguration{}, nil, nil)
+
+	consumer.HandleMessage(consumerMessage(0, []byte(`{}`)))
+	consumer.HandleMessage(consumerMessage(2, []byte(`{}`)))
+
+	stats := consumer.SequenceChecker.Stats()
+	assert.Equal(t, uint64(1), stats.Partitions[0].Gaps)
+	assert.Equal(t, uint64(1), stats.Duplicates["topic"])
+}
+
+// TestSequenceEndpoint checks the sequence endpoint
+func TestSequenceEndpoint(t *testing.T) {
+	consumer := NewDummyConsumer()
+	server := main.NewHTTPServer(main.ServerConfiguration{}, consumer)
+
+	response := performRequest(server, "/api/v1/sequence")
+	assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+
+	consumer.SequenceChecker = newTestSequenceChecker(t, main.SequenceConfiguration{}, nil, nil)
+	consumer.SequenceChecker.Check(consumerMessage(0, nil))
+
+	response = performRequest(server, "/api/v1/sequence")
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Body.String(), `"last_offset":0`)
+}
+
+// TestSequenceCheckerRevokedPartitions checks that sequence state of
+// partitions that are no longer claimed is forgotten
+func TestSequenceCheckerRevokedPartitions(t *testing.T) {
+	group := newFakeConsumerGroup(t, "topic")
+	alerts := main.NewAlertManager(nil)
+
+	consumer := NewDummyConsumer()
+	consumer.ConsumerGroup = group
+	consumer.SequenceChecker = newTestSequenceChecker(t, main.SequenceConfiguration{}, alerts, nil)
+	done := serveInBackground(consumer)
+
+	session := group.Rebalance(0, 1)
+	session.Send(0, []byte(`{"id": 1}`))
+	session.Send(1, []byte(`{"id": 2}`))
+	session.Send(1, []byte(`{"id": 3}`))
+	session.End()
+	session.Wait()
+	assert.Len(t, consumer.SequenceChecker.Stats().Partitions, 2)
+
+	// partition 1 is revoked and claimed again later, consumed from the
+	// beginning by new claim
+	session = group.Rebalance(0)
+	session.End()
+	session.Wait()
+	stats := consumer.SequenceChecker.Stats()
+	assert.Len(t, stats.Partitions, 1)
+	assert.Equal(t, int32(0), stats.Partitions[0].Partition)
+
+	session = group.Rebalance(0, 1)
+	session.Send(1, []byte(`{"id": 4}`))
+	session.End()
+	session.Wait()
+
+	assert.Empty(t, alerts.Recent())
+
+	assert.NoError(t, consumer.Close())
+	waitForServe(t, done)
+}
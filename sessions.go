@@ -0,0 +1,221 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains implementation of consumer group session
+// tracker. New session is created by consumer group after each rebalance,
+// so generation ID, member ID, claimed partitions, start and end time of
+// all sessions are recorded to be able to find out how often the consumer
+// group is rebalanced. Alert is raised when too many rebalances happen
+// within configured time window (rebalance storm).
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// AlertRebalanceStorm is type of alert raised when consumer group is
+// rebalanced too often
+const AlertRebalanceStorm = "rebalance_storm"
+
+// Default session tracker settings
+const (
+	defaultSessionHistory     = 100
+	defaultSessionStormWindow = 10 * time.Minute
+)
+
+// Session contains lifecycle of one consumer group session
+type Session struct {
+	GenerationID int32              `json:"generation_id"`
+	MemberID     string             `json:"member_id"`
+	Claims       map[string][]int32 `json:"claims"`
+	Start        time.Time          `json:"start"`
+	// End and Duration are zero for active session
+	End      time.Time     `json:"end"`
+	Duration time.Duration `json:"duration"`
+}
+
+// SessionStats contains statistic about consumer group sessions
+type SessionStats struct {
+	// Current is the active session, nil when there is none
+	Current *Session `json:"current"`
+	// Sessions contains the most recent finished sessions, the newest first
+	Sessions []Session `json:"sessions"`
+	// Total is number of sessions started so far
+	Total uint64 `json:"total"`
+	// Rebalances is number of sessions started after the first one
+	Rebalances uint64 `json:"rebalances"`
+	// RecentRebalances is number of rebalances within storm window
+	RecentRebalances int           `json:"recent_rebalances"`
+	StormWindow      time.Duration `json:"storm_window"`
+	// RebalancesPerHour is rebalance frequency computed from rebalances
+	// within storm window
+	RebalancesPerHour float64 `json:"rebalances_per_hour"`
+}
+
+// SessionTracker records lifecycle of consumer group sessions
+type SessionTracker struct {
+	mutex      sync.Mutex
+	config     SessionConfiguration
+	alerts     *AlertManager
+	clock      Clock
+	current    *Session
+	sessions   []Session
+	rebalances []time.Time
+	total      uint64
+	storm      bool
+}
+
+// NewSessionTracker constructs new session tracker
+func NewSessionTracker(config SessionConfiguration, alerts *AlertManager, clock Clock) *SessionTracker {
+	if config.History <= 0 {
+		config.History = defaultSessionHistory
+	}
+	if config.StormWindow <= 0 {
+		config.StormWindow = defaultSessionStormWindow
+	}
+
+	return &SessionTracker{
+		config:   config,
+		alerts:   alerts,
+		clock:    clockOrDefault(clock),
+		sessions: make([]Session, 0, config.History),
+	}
+}
+
+// sessionClaims function returns copy of partitions claimed by session,
+// sorted by partition number
+func sessionClaims(session sarama.ConsumerGroupSession) map[string][]int32 {
+	claims := make(map[string][]int32)
+	for topic, partitions := range session.Claims() {
+		sorted := append([]int32{}, partitions...)
+		sort.Slice(sorted, func(i, j int) bool {
+			return sorted[i] < sorted[j]
+		})
+		claims[topic] = sorted
+	}
+	return claims
+}
+
+// Start method records start of new session. Every session except the
+// first one is started by rebalance.
+func (tracker *SessionTracker) Start(session sarama.ConsumerGroupSession) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	now := tracker.clock.Now()
+	tracker.current = &Session{
+		GenerationID: session.GenerationID(),
+		MemberID:     session.MemberID(),
+		Claims:       sessionClaims(session),
+		Start:        now,
+	}
+	tracker.total++
+	if tracker.total == 1 {
+		return
+	}
+
+	tracker.rebalances = append(tracker.rebalances, now)
+	recent := tracker.recentRebalances(now)
+	if tracker.config.StormThreshold <= 0 || recent < tracker.config.StormThreshold {
+		tracker.storm = false
+		return
+	}
+
+	// alert is raised just once per storm
+	if tracker.storm {
+		return
+	}
+	tracker.storm = true
+	tracker.alerts.Raise(Alert{
+		Type:     AlertRebalanceStorm,
+		Severity: SeverityWarning,
+		Message: fmt.Sprintf("Consumer group has been rebalanced %d times within %v",
+			recent, tracker.config.StormWindow),
+		Details: map[string]interface{}{
+			"rebalances":    recent,
+			"window":        tracker.config.StormWindow.String(),
+			"generation_id": session.GenerationID(),
+			"member_id":     session.MemberID(),
+		},
+	})
+}
+
+// End method records end of session
+func (tracker *SessionTracker) End(session sarama.ConsumerGroupSession) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	if tracker.current == nil || tracker.current.GenerationID != session.GenerationID() {
+		// session has not been started via tracker
+		return
+	}
+
+	finished := *tracker.current
+	finished.End = tracker.clock.Now()
+	finished.Duration = finished.End.Sub(finished.Start)
+	tracker.current = nil
+
+	if len(tracker.sessions) == tracker.config.History {
+		// drop the oldest session
+		copy(tracker.sessions, tracker.sessions[1:])
+		tracker.sessions = tracker.sessions[:tracker.config.History-1]
+	}
+	tracker.sessions = append(tracker.sessions, finished)
+}
+
+// recentRebalances method drops rebalances older than storm window and
+// returns number of remaining ones
+func (tracker *SessionTracker) recentRebalances(now time.Time) int {
+	deadline := now.Add(-tracker.config.StormWindow)
+	i := 0
+	for i < len(tracker.rebalances) && !tracker.rebalances[i].After(deadline) {
+		i++
+	}
+	tracker.rebalances = tracker.rebalances[i:]
+	return len(tracker.rebalances)
+}
+
+// Stats method returns statistic about consumer group sessions
+func (tracker *SessionTracker) Stats() SessionStats {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	recent := tracker.recentRebalances(tracker.clock.Now())
+	stats := SessionStats{
+		Sessions:          make([]Session, 0, len(tracker.sessions)),
+		Total:             tracker.total,
+		RecentRebalances:  recent,
+		StormWindow:       tracker.config.StormWindow,
+		RebalancesPerHour: float64(recent) / tracker.config.StormWindow.Hours(),
+	}
+	if tracker.total > 0 {
+		stats.Rebalances = tracker.total - 1
+	}
+	if tracker.current != nil {
+		current := *tracker.current
+		stats.Current = &current
+	}
+	for i := len(tracker.sessions) - 1; i >= 0; i-- {
+		stats.Sessions = append(stats.Sessions, tracker.sessions[i])
+	}
+	return stats
+}
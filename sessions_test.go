@@ -0,0 +1,171 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Unit test definitions for functions and methods defined in source file
+// sessions.go
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-kafka-monitor"
+)
+
+// TestSessionLifecycle checks that sessions created by rebalances are
+// recorded by consumer
+func TestSessionLifecycle(t *testing.T) {
+	clock := &steppingClock{
+		now:  time.Date(2022, 3, 4, 5, 6, 0, 0, time.UTC),
+		step: time.Minute,
+	}
+	group := newFakeConsumerGroup(t, "topic")
+
+	consumer := NewDummyConsumer()
+	consumer.ConsumerGroup = group
+	consumer.Sessions = main.NewSessionTracker(main.SessionConfiguration{StormWindow: time.Hour}, nil, clock)
+	done := serveInBackground(consumer)
+
+	session := group.Rebalance(1, 0)
+	session.End()
+	session.Wait()
+
+	session = group.Rebalance(1)
+	session.End()
+	session.Wait()
+
+	stats := consumer.Sessions.Stats()
+	assert.Equal(t, uint64(2), stats.Total)
+	assert.Equal(t, uint64(1), stats.Rebalances)
+	assert.Equal(t, 1, stats.RecentRebalances)
+	assert.Equal(t, 1.0, stats.RebalancesPerHour)
+	assert.Nil(t, stats.Current)
+
+	// the newest session first
+	assert.Len(t, stats.Sessions, 2)
+	assert.Equal(t, int32(2), stats.Sessions[0].GenerationID)
+	assert.Equal(t, map[string][]int32{"topic": {1}}, stats.Sessions[0].Claims)
+	assert.Equal(t, int32(1), stats.Sessions[1].GenerationID)
+	assert.Equal(t, "fake-member", stats.Sessions[1].MemberID)
+	assert.Equal(t, map[string][]int32{"topic": {0, 1}}, stats.Sessions[1].Claims)
+	assert.Equal(t, time.Date(2022, 3, 4, 5, 6, 0, 0, time.UTC), stats.Sessions[1].Start)
+	assert.Equal(t, time.Minute, stats.Sessions[1].Duration)
+
+	// rebalance frequency is exposed in stats
+	response := performRequest(main.NewHTTPServer(main.ServerConfiguration{}, consumer), "/api/v1/stats")
+	assert.Equal(t, http.StatusOK, response.Code)
+	var body struct {
+		Sessions main.SessionStats `json:"sessions"`
+	}
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &body))
+	assert.Equal(t, uint64(1), body.Sessions.Rebalances)
+
+	assert.NoError(t, consumer.Close())
+	waitForServe(t, done)
+}
+
+// TestActiveSession checks that active session is part of statistic
+func TestActiveSession(t *testing.T) {
+	tracker := main.NewSessionTracker(main.SessionConfiguration{}, nil, nil)
+	assert.Nil(t, tracker.Stats().Current)
+
+	session := &fakeSession{
+		topic:      "topic",
+		generation: 3,
+		claims:     map[int32]*fakeClaim{2: nil},
+	}
+	tracker.Start(session)
+
+	current := tracker.Stats().Current
+	assert.NotNil(t, current)
+	assert.Equal(t, int32(3), current.GenerationID)
+	assert.Equal(t, map[string][]int32{"topic": {2}}, current.Claims)
+	assert.True(t, current.End.IsZero())
+
+	// session not started via tracker is ignored
+	tracker.End(&fakeSession{generation: 2})
+	assert.NotNil(t, tracker.Stats().Current)
+
+	tracker.End(session)
+	assert.Nil(t, tracker.Stats().Current)
+	assert.Len(t, tracker.Stats().Sessions, 1)
+}
+
+// TestRebalanceStorm checks that alert is raised once per rebalance storm
+func TestRebalanceStorm(t *testing.T) {
+	clock := newManualClock(time.Date(2022, 3, 4, 5, 6, 0, 0, time.UTC))
+	alerts := main.NewAlertManager(nil)
+	tracker := main.NewSessionTracker(main.SessionConfiguration{
+		StormWindow:    time.Minute,
+		StormThreshold: 3,
+	}, alerts, clock)
+
+	rebalance := func(generation int32) {
+		session := &fakeSession{generation: generation}
+		tracker.Start(session)
+		clock.Advance(10 * time.Second)
+		tracker.End(session)
+	}
+
+	// the first session is not started by rebalance
+	for generation := int32(1); generation <= 3; generation++ {
+		rebalance(generation)
+	}
+	assert.Empty(t, alerts.Recent())
+
+	// the third rebalance within window starts storm, the next one
+	// belongs to the same storm
+	rebalance(4)
+	rebalance(5)
+	recent := alerts.Recent()
+	assert.Len(t, recent, 1)
+	assert.Equal(t, main.AlertRebalanceStorm, recent[0].Type)
+	assert.Equal(t, 3, recent[0].Details["rebalances"])
+	assert.Equal(t, int32(4), recent[0].Details["generation_id"])
+
+	// storm is over when rebalances are rare enough
+	clock.Advance(time.Hour)
+	rebalance(6)
+	assert.Equal(t, 1, tracker.Stats().RecentRebalances)
+	rebalance(7)
+	rebalance(8)
+	assert.Len(t, alerts.Recent(), 2)
+}
+
+// TestSessionHistoryLimit checks that just configured number of finished
+// sessions is kept
+func TestSessionHistoryLimit(t *testing.T) {
+	tracker := main.NewSessionTracker(main.SessionConfiguration{History: 2}, nil, nil)
+
+	for generation := int32(1); generation <= 5; generation++ {
+		session := &fakeSession{generation: generation}
+		tracker.Start(session)
+		tracker.End(session)
+	}
+
+	stats := tracker.Stats()
+	assert.Equal(t, uint64(5), stats.Total)
+	assert.Equal(t, uint64(4), stats.Rebalances)
+	assert.Len(t, stats.Sessions, 2)
+	// the newest session first
+	assert.Equal(t, int32(5), stats.Sessions[0].GenerationID)
+	assert.Equal(t, int32(4), stats.Sessions[1].GenerationID)
+}
@@ -0,0 +1,349 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains implementation of message size monitor.
+// Rolling statistic (mean, standard deviation, percentiles, maximum) is
+// computed for sizes of the most recent messages consumed from each topic.
+// Size of message is size of its key, value, and headers. Alerts are raised
+// when message exceeds configured size limit, when its size deviates from
+// baseline by more than N standard deviations, and when message size
+// approaches the max.message.bytes limit of the topic, as configured on
+// broker. Alerts of each type are raised at most once per alert interval
+// for each topic.
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/rs/zerolog/log"
+)
+
+// Alert types raised by size monitor
+const (
+	AlertSizeLimit     = "message_size_limit"
+	AlertSizeAnomaly   = "message_size_anomaly"
+	AlertSizeNearLimit = "message_size_near_broker_limit"
+)
+
+// Default size monitor settings
+const (
+	defaultSizeWindow           = 1000
+	defaultSizeMinSamples       = 100
+	defaultSizeBrokerLimitRatio = 0.9
+	defaultSizeAlertInterval    = time.Minute
+)
+
+// maxMessageBytesConfig is name of topic configuration option that limits
+// size of messages accepted by broker
+const maxMessageBytesConfig = "max.message.bytes"
+
+// SizeStats contains rolling statistic about sizes of messages consumed
+// from one topic
+type SizeStats struct {
+	Topic   string  `json:"topic"`
+	Samples int     `json:"samples"`
+	Mean    float64 `json:"mean"`
+	StdDev  float64 `json:"stddev"`
+	P50     int     `json:"p50"`
+	P99     int     `json:"p99"`
+	Max     int     `json:"max"`
+	// BrokerLimit is max.message.bytes configured for the topic, zero when
+	// it is not known
+	BrokerLimit int64  `json:"broker_limit"`
+	Oversized   uint64 `json:"oversized"`
+	Anomalies   uint64 `json:"anomalies"`
+	NearLimit   uint64 `json:"near_limit"`
+}
+
+// sizeWindow contains sizes of the most recent messages consumed from one
+// topic stored in ring buffer
+type sizeWindow struct {
+	sizes       []int
+	next        int
+	sum         float64
+	sumSquares  float64
+	brokerLimit int64
+	oversized   uint64
+	anomalies   uint64
+	nearLimit   uint64
+}
+
+// add method stores new size into window, replacing the oldest one when
+// the window is full
+func (window *sizeWindow) add(size int, capacity int) {
+	value := float64(size)
+	if len(window.sizes) < capacity {
+		window.sizes = append(window.sizes, size)
+	} else {
+		oldest := float64(window.sizes[window.next])
+		window.sum -= oldest
+		window.sumSquares -= oldest * oldest
+		window.sizes[window.next] = size
+		window.next = (window.next + 1) % capacity
+	}
+	window.sum += value
+	window.sumSquares += value * value
+}
+
+// meanAndStdDev method computes mean and standard deviation of sizes in
+// window
+func (window *sizeWindow) meanAndStdDev() (float64, float64) {
+	if len(window.sizes) == 0 {
+		return 0, 0
+	}
+	count := float64(len(window.sizes))
+	mean := window.sum / count
+	// rounding errors can make variance slightly negative
+	variance := math.Max(window.sumSquares/count-mean*mean, 0)
+	return mean, math.Sqrt(variance)
+}
+
+// SizeMonitor computes message size statistic and raises size alerts
+type SizeMonitor struct {
+	mutex    sync.Mutex
+	config   SizeConfiguration
+	alerts   *AlertManager
+	throttle *alertThrottle
+	windows  map[string]*sizeWindow
+}
+
+// NewSizeMonitor constructs new message size monitor
+func NewSizeMonitor(config SizeConfiguration, alerts *AlertManager, clock Clock) *SizeMonitor {
+	if config.Window <= 0 {
+		config.Window = defaultSizeWindow
+	}
+	if config.MinSamples <= 0 {
+		config.MinSamples = defaultSizeMinSamples
+	}
+	if config.BrokerLimitRatio <= 0 || config.BrokerLimitRatio > 1 {
+		config.BrokerLimitRatio = defaultSizeBrokerLimitRatio
+	}
+	if config.AlertInterval <= 0 {
+		config.AlertInterval = defaultSizeAlertInterval
+	}
+
+	return &SizeMonitor{
+		config:   config,
+		alerts:   alerts,
+		throttle: newAlertThrottle(config.AlertInterval, clock),
+		windows:  make(map[string]*sizeWindow),
+	}
+}
+
+// window method returns size window for given topic, constructing it when
+// needed
+func (monitor *SizeMonitor) window(topic string) *sizeWindow {
+	window, found := monitor.windows[topic]
+	if !found {
+		window = &sizeWindow{}
+		monitor.windows[topic] = window
+	}
+	return window
+}
+
+// SetBrokerLimit method sets max.message.bytes configured on broker for
+// given topic
+func (monitor *SizeMonitor) SetBrokerLimit(topic string, limit int64) {
+	monitor.mutex.Lock()
+	defer monitor.mutex.Unlock()
+
+	monitor.window(topic).brokerLimit = limit
+}
+
+// messageSize function returns size of message key, value, and headers.
+// It is just an approximation of size checked against max.message.bytes
+// by broker: the limit applies to the whole record batch after
+// compression, including record and batch overhead, so compressed batch
+// can be smaller and batch with many records can be larger than the sum
+// of its messages.
+func messageSize(msg *sarama.ConsumerMessage) int {
+	size := len(msg.Key) + len(msg.Value)
+	for _, header := range msg.Headers {
+		if header != nil {
+			size += len(header.Key) + len(header.Value)
+		}
+	}
+	return size
+}
+
+// raise method raises alert unless alert of the same type has been raised
+// for the same topic in alert interval. Number of suppressed alerts is
+// added into alert details.
+func (monitor *SizeMonitor) raise(alert Alert) {
+	allowed, suppressed := monitor.throttle.allow(alert.Topic + "/" + alert.Type)
+	if !allowed {
+		return
+	}
+	details := make(map[string]interface{}, len(alert.Details)+1)
+	for key, value := range alert.Details {
+		details[key] = value
+	}
+	details["suppressed"] = suppressed
+	alert.Details = details
+	monitor.alerts.Raise(alert)
+}
+
+// Check method updates size statistic by consumed message and raises
+// alerts for oversized and anomalous messages
+func (monitor *SizeMonitor) Check(msg *sarama.ConsumerMessage) {
+	monitor.mutex.Lock()
+	defer monitor.mutex.Unlock()
+
+	size := messageSize(msg)
+	window := monitor.window(msg.Topic)
+	details := map[string]interface{}{
+		"partition": msg.Partition,
+		"offset":    msg.Offset,
+		"size":      size,
+	}
+
+	if monitor.config.MaxBytes > 0 && size > monitor.config.MaxBytes {
+		window.oversized++
+		details["limit"] = monitor.config.MaxBytes
+		monitor.raise(Alert{
+			Type:     AlertSizeLimit,
+			Severity: SeverityCritical,
+			Topic:    msg.Topic,
+			Message:  fmt.Sprintf("Message size %d bytes exceeds limit %d bytes", size, monitor.config.MaxBytes),
+			Details:  details,
+		})
+	}
+
+	if window.brokerLimit > 0 && float64(size) >= monitor.config.BrokerLimitRatio*float64(window.brokerLimit) {
+		window.nearLimit++
+		details[maxMessageBytesConfig] = window.brokerLimit
+		monitor.raise(Alert{
+			Type:     AlertSizeNearLimit,
+			Severity: SeverityWarning,
+			Topic:    msg.Topic,
+			Message:  fmt.Sprintf("Message size %d bytes is close to broker limit %d bytes", size, window.brokerLimit),
+			Details:  details,
+		})
+	}
+
+	// size is compared with baseline computed before the message is added
+	// into window
+	if monitor.config.Sigma > 0 && len(window.sizes) >= monitor.config.MinSamples {
+		mean, stddev := window.meanAndStdDev()
+		deviation := math.Abs(float64(size) - mean)
+		if deviation > monitor.config.Sigma*stddev && deviation > 0 {
+			window.anomalies++
+			details["mean"] = mean
+			details["stddev"] = stddev
+			monitor.raise(Alert{
+				Type:     AlertSizeAnomaly,
+				Severity: SeverityWarning,
+				Topic:    msg.Topic,
+				Message:  fmt.Sprintf("Message size %d bytes deviates from mean %.0f bytes by more than %.1f sigma", size, mean, monitor.config.Sigma),
+				Details:  details,
+			})
+		}
+	}
+
+	window.add(size, monitor.config.Window)
+}
+
+// percentile function returns value at given percentile from sorted
+// values
+func percentile(sorted []int, p float64) int {
+	if len(sorted) == 0 {
+		return 0
+	}
+	index := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if index < 0 {
+		index = 0
+	}
+	return sorted[index]
+}
+
+// Stats method returns rolling size statistic for all topics
+func (monitor *SizeMonitor) Stats() []SizeStats {
+	monitor.mutex.Lock()
+	defer monitor.mutex.Unlock()
+
+	stats := make([]SizeStats, 0, len(monitor.windows))
+	for topic, window := range monitor.windows {
+		sorted := make([]int, len(window.sizes))
+		copy(sorted, window.sizes)
+		sort.Ints(sorted)
+
+		mean, stddev := window.meanAndStdDev()
+		topicStats := SizeStats{
+			Topic:       topic,
+			Samples:     len(sorted),
+			Mean:        mean,
+			StdDev:      stddev,
+			P50:         percentile(sorted, 50),
+			P99:         percentile(sorted, 99),
+			BrokerLimit: window.brokerLimit,
+			Oversized:   window.oversized,
+			Anomalies:   window.anomalies,
+			NearLimit:   window.nearLimit,
+		}
+		if len(sorted) > 0 {
+			topicStats.Max = sorted[len(sorted)-1]
+		}
+		stats = append(stats, topicStats)
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Topic < stats[j].Topic
+	})
+	return stats
+}
+
+// fetchBrokerLimits function retrieves max.message.bytes configured on
+// broker for given topics. Topics with limit that can not be retrieved are
+// not included in result.
+func fetchBrokerLimits(brokerCfg BrokerConfiguration, topics []string) (map[string]int64, error) {
+	inventory, err := newInventory(brokerCfg)
+	if err != nil {
+		return nil, err
+	}
+	defer inventory.close()
+
+	limits := make(map[string]int64, len(topics))
+	for _, topic := range topics {
+		entries, err := inventory.admin.DescribeConfig(sarama.ConfigResource{
+			Type:        sarama.TopicResource,
+			Name:        topic,
+			ConfigNames: []string{maxMessageBytesConfig},
+		})
+		if err != nil {
+			log.Warn().Err(err).Str(topicKey, topic).Msg("Unable to retrieve topic configuration")
+			continue
+		}
+		for _, entry := range entries {
+			if entry.Name != maxMessageBytesConfig {
+				continue
+			}
+			limit, err := strconv.ParseInt(entry.Value, 10, 64)
+			if err != nil {
+				log.Warn().Err(err).Str(topicKey, topic).Msg("Improper max.message.bytes value")
+				continue
+			}
+			limits[topic] = limit
+		}
+	}
+	return limits, nil
+}
@@ -0,0 +1,167 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Unit test definitions for functions and methods defined in source file
+// size.go
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-kafka-monitor"
+)
+
+// checkSizes function checks messages without key and headers with values
+// of given sizes by size monitor
+func checkSizes(monitor *main.SizeMonitor, sizes ...int) {
+	for i, size := range sizes {
+		monitor.Check(&sarama.ConsumerMessage{
+			Topic:  "topic",
+			Offset: int64(i),
+			Value:  make([]byte, size),
+		})
+	}
+}
+
+// TestSizeMonitorStats checks rolling size statistic
+func TestSizeMonitorStats(t *testing.T) {
+	monitor := main.NewSizeMonitor(main.SizeConfiguration{Window: 4}, nil, nil)
+
+	// the first size falls out of window
+	checkSizes(monitor, 1000, 10, 20, 30, 40)
+
+	stats := monitor.Stats()
+	assert.Len(t, stats, 1)
+	assert.Equal(t, "topic", stats[0].Topic)
+	assert.Equal(t, 4, stats[0].Samples)
+	assert.InDelta(t, 25.0, stats[0].Mean, 0.001)
+	assert.InDelta(t, 11.180, stats[0].StdDev, 0.001)
+	assert.Equal(t, 20, stats[0].P50)
+	assert.Equal(t, 40, stats[0].P99)
+	assert.Equal(t, 40, stats[0].Max)
+}
+
+// TestSizeMonitorLimit checks that alert is raised for messages exceeding
+// configured size limit
+func TestSizeMonitorLimit(t *testing.T) {
+	alerts := main.NewAlertManager(nil)
+	monitor := main.NewSizeMonitor(main.SizeConfiguration{MaxBytes: 100}, alerts, nil)
+
+	checkSizes(monitor, 50, 100, 101)
+
+	assert.Equal(t, uint64(1), monitor.Stats()[0].Oversized)
+	assert.Equal(t, []string{main.AlertSizeLimit}, alertTypes(alerts))
+}
+
+// TestSizeMonitorAlertInterval checks that alerts of the same type are
+// raised at most once per alert interval for each topic
+func TestSizeMonitorAlertInterval(t *testing.T) {
+	clock := newManualClock(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	alerts := main.NewAlertManager(clock)
+	monitor := main.NewSizeMonitor(main.SizeConfiguration{MaxBytes: 100, AlertInterval: time.Minute}, alerts, clock)
+
+	checkSizes(monitor, 101, 102, 103)
+	assert.Equal(t, uint64(3), monitor.Stats()[0].Oversized)
+	assert.Equal(t, []string{main.AlertSizeLimit}, alertTypes(alerts))
+
+	// other topic is not affected
+	monitor.Check(&sarama.ConsumerMessage{Topic: "other", Value: make([]byte, 101)})
+	assert.Len(t, alerts.Recent(), 2)
+
+	clock.Advance(time.Minute)
+	checkSizes(monitor, 104)
+	recent := alerts.Recent()
+	assert.Len(t, recent, 3)
+	assert.Equal(t, uint64(2), recent[2].Details["suppressed"])
+}
+
+// TestSizeMonitorAnomaly checks that alert is raised for messages with size
+// deviating from baseline, but just after enough samples are collected
+func TestSizeMonitorAnomaly(t *testing.T) {
+	alerts := main.NewAlertManager(nil)
+	monitor := main.NewSizeMonitor(main.SizeConfiguration{Sigma: 3, MinSamples: 10}, alerts, nil)
+
+	checkSizes(monitor, 100, 5000)
+	assert.Empty(t, alertTypes(alerts))
+
+	checkSizes(monitor, 90, 110, 100, 95, 105, 100, 98, 102, 100)
+	assert.Empty(t, alertTypes(alerts))
+
+	checkSizes(monitor, 120000)
+	assert.Equal(t, uint64(1), monitor.Stats()[0].Anomalies)
+	assert.Equal(t, []string{main.AlertSizeAnomaly}, alertTypes(alerts))
+}
+
+// TestSizeMonitorBrokerLimit checks that warning is raised for messages
+// approaching broker limit
+func TestSizeMonitorBrokerLimit(t *testing.T) {
+	alerts := main.NewAlertManager(nil)
+	monitor := main.NewSizeMonitor(main.SizeConfiguration{BrokerLimitRatio: 0.8}, alerts, nil)
+	monitor.SetBrokerLimit("topic", 1000)
+
+	checkSizes(monitor, 799, 800)
+
+	stats := monitor.Stats()
+	assert.Equal(t, int64(1000), stats[0].BrokerLimit)
+	assert.Equal(t, uint64(1), stats[0].NearLimit)
+	assert.Equal(t, []string{main.AlertSizeNearLimit}, alertTypes(alerts))
+}
+
+// TestFetchBrokerLimits checks that max.message.bytes is retrieved from
+// topic configuration
+func TestFetchBrokerLimits(t *testing.T) {
+	broker := newInventoryMockBroker(t)
+	defer broker.Close()
+
+	config := configurationForMockBroker(broker, testGroup)
+	limits, err := main.FetchBrokerLimits(main.GetBrokerConfiguration(&config), []string{testTopic})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int64{testTopic: 1000000}, limits)
+}
+
+// TestSizeStage checks that size stage of pipeline monitors sizes of
+// processed messages
+func TestSizeStage(t *testing.T) {
+	consumer := NewDummyConsumer()
+	consumer.SizeMonitor = main.NewSizeMonitor(main.SizeConfiguration{}, nil, nil)
+
+	assert.NoError(t, consumer.ProcessMessage(consumerMessage(0, []byte(`{"a": 1}`))))
+
+	// key "key", header "header: value", and 8 bytes of value
+	assert.Equal(t, 22, consumer.SizeMonitor.Stats()[0].Max)
+}
+
+// TestSizesEndpoint checks the sizes endpoint
+func TestSizesEndpoint(t *testing.T) {
+	consumer := NewDummyConsumer()
+	server := main.NewHTTPServer(main.ServerConfiguration{}, consumer)
+
+	response := performRequest(server, "/api/v1/sizes")
+	assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+
+	consumer.SizeMonitor = main.NewSizeMonitor(main.SizeConfiguration{}, nil, nil)
+	checkSizes(consumer.SizeMonitor, 42)
+
+	response = performRequest(server, "/api/v1/sizes")
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Body.String(), `"max":42`)
+}
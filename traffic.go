@@ -0,0 +1,418 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This source file contains implementation of traffic analyzer. Message
+// and byte rates are computed for each partition in fixed time windows and
+// partitions with rate significantly higher than average rate of the topic
+// are flagged as skewed. The most frequent message keys (hot keys) are
+// tracked for each topic by Space-Saving heavy-hitters sketch that needs
+// just bounded amount of memory regardless of number of distinct keys.
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/rs/zerolog/log"
+)
+
+// AlertPartitionSkew is type of alert raised when traffic of topic
+// partitions becomes skewed
+const AlertPartitionSkew = "partition_skew"
+
+// Default traffic analyzer settings
+const (
+	defaultTrafficInterval   = time.Minute
+	defaultTrafficSkewRatio  = 2.0
+	defaultTrafficTopKeys    = 10
+	defaultTrafficSketchSize = 100
+)
+
+// PartitionTrafficStats contains statistic about traffic of one partition
+type PartitionTrafficStats struct {
+	Partition      int32   `json:"partition"`
+	Messages       uint64  `json:"messages"`
+	Bytes          uint64  `json:"bytes"`
+	MessagesPerSec float64 `json:"messages_per_sec"`
+	BytesPerSec    float64 `json:"bytes_per_sec"`
+	// Skewed is set when message rate of the partition exceeds average
+	// rate of the topic by configured ratio
+	Skewed bool `json:"skewed"`
+}
+
+// KeyCount represents estimated number of messages with given key. Actual
+// number of messages is between Count-Error and Count.
+type KeyCount struct {
+	Key   string `json:"key"`
+	Count uint64 `json:"count"`
+	Error uint64 `json:"error"`
+}
+
+// TopicTrafficStats contains statistic about traffic of one topic
+type TopicTrafficStats struct {
+	Topic      string                  `json:"topic"`
+	Partitions []PartitionTrafficStats `json:"partitions"`
+	// SkewRatio is ratio between the highest and the average message rate
+	// of partitions
+	SkewRatio float64    `json:"skew_ratio"`
+	Skewed    bool       `json:"skewed"`
+	TopKeys   []KeyCount `json:"top_keys"`
+	NoKey     uint64     `json:"no_key"`
+}
+
+// partitionTraffic contains counters for one partition
+type partitionTraffic struct {
+	messages       uint64
+	bytes          uint64
+	windowMessages uint64
+	windowBytes    uint64
+	messagesRate   float64
+	bytesRate      float64
+}
+
+// topicTraffic contains counters and hot keys sketch for one topic
+type topicTraffic struct {
+	partitions map[int32]*partitionTraffic
+	keys       *SpaceSaving
+	noKey      uint64
+	skewed     bool
+}
+
+// TrafficAnalyzer computes per-partition rates and tracks hot keys
+type TrafficAnalyzer struct {
+	mutex       sync.Mutex
+	config      TrafficConfiguration
+	alerts      *AlertManager
+	clock       Clock
+	windowStart time.Time
+	topics      map[string]*topicTraffic
+	redactor    *Redactor
+}
+
+// NewTrafficAnalyzer constructs new traffic analyzer
+func NewTrafficAnalyzer(config TrafficConfiguration, alerts *AlertManager, clock Clock) *TrafficAnalyzer {
+	if config.Interval <= 0 {
+		config.Interval = defaultTrafficInterval
+	}
+	if config.SkewRatio <= 1 {
+		config.SkewRatio = defaultTrafficSkewRatio
+	}
+	if config.TopKeys <= 0 {
+		config.TopKeys = defaultTrafficTopKeys
+	}
+	if config.SketchSize <= 0 {
+		config.SketchSize = defaultTrafficSketchSize
+	}
+	// sketch needs to have at least as many counters as number of
+	// reported keys
+	if config.SketchSize < config.TopKeys {
+		config.SketchSize = config.TopKeys
+	}
+
+	clock = clockOrDefault(clock)
+	return &TrafficAnalyzer{
+		config:      config,
+		alerts:      alerts,
+		clock:       clock,
+		windowStart: clock.Now(),
+		topics:      make(map[string]*topicTraffic),
+	}
+}
+
+// SetRedactor method sets redactor used to redact tracked message keys
+func (analyzer *TrafficAnalyzer) SetRedactor(redactor *Redactor) {
+	analyzer.redactor = redactor
+}
+
+// topic method returns counters for given topic, new counters are created
+// when needed. Mutex needs to be locked by caller.
+func (analyzer *TrafficAnalyzer) topic(name string) *topicTraffic {
+	topic, found := analyzer.topics[name]
+	if !found {
+		topic = &topicTraffic{
+			partitions: make(map[int32]*partitionTraffic),
+			keys:       NewSpaceSaving(analyzer.config.SketchSize),
+		}
+		analyzer.topics[name] = topic
+	}
+	return topic
+}
+
+// partition method returns counters for given partition, new counters are
+// created when needed
+func (topic *topicTraffic) partition(id int32) *partitionTraffic {
+	partition, found := topic.partitions[id]
+	if !found {
+		partition = &partitionTraffic{}
+		topic.partitions[id] = partition
+	}
+	return partition
+}
+
+// SetClaims method sets partitions claimed by consumer in actual session.
+// Claimed partitions that do not receive any messages are counted with
+// zero rate into the average rate of topic. Topics and partitions that are
+// no longer claimed are forgotten.
+func (analyzer *TrafficAnalyzer) SetClaims(claims map[string][]int32) {
+	analyzer.mutex.Lock()
+	defer analyzer.mutex.Unlock()
+
+	for name, partitions := range claims {
+		topic := analyzer.topic(name)
+		claimed := make(map[int32]bool, len(partitions))
+		for _, id := range partitions {
+			claimed[id] = true
+			topic.partition(id)
+		}
+		for id := range topic.partitions {
+			if !claimed[id] {
+				delete(topic.partitions, id)
+			}
+		}
+	}
+	for name := range analyzer.topics {
+		if _, found := claims[name]; !found {
+			delete(analyzer.topics, name)
+		}
+	}
+}
+
+// Record method updates traffic statistic by consumed message
+func (analyzer *TrafficAnalyzer) Record(msg *sarama.ConsumerMessage) {
+	analyzer.mutex.Lock()
+	defer analyzer.mutex.Unlock()
+
+	topic := analyzer.topic(msg.Topic)
+	partition := topic.partition(msg.Partition)
+
+	size := uint64(len(msg.Value))
+	partition.messages++
+	partition.bytes += size
+	partition.windowMessages++
+	partition.windowBytes += size
+
+	if msg.Key == nil {
+		topic.noKey++
+	} else {
+		topic.keys.Add(analyzer.redactor.RedactKey(string(msg.Key)))
+	}
+}
+
+// Roll method finishes actual time window, computes rates for all
+// partitions, and raises alert for topics that became skewed
+func (analyzer *TrafficAnalyzer) Roll() {
+	analyzer.mutex.Lock()
+	defer analyzer.mutex.Unlock()
+
+	now := analyzer.clock.Now()
+	elapsed := now.Sub(analyzer.windowStart).Seconds()
+	analyzer.windowStart = now
+
+	for name, topic := range analyzer.topics {
+		for _, partition := range topic.partitions {
+			partition.messagesRate, partition.bytesRate = 0, 0
+			if elapsed > 0 {
+				partition.messagesRate = float64(partition.windowMessages) / elapsed
+				partition.bytesRate = float64(partition.windowBytes) / elapsed
+			}
+			partition.windowMessages, partition.windowBytes = 0, 0
+		}
+
+		ratio := analyzer.skewRatio(topic)
+		skewed := ratio > analyzer.config.SkewRatio
+		if skewed && !topic.skewed {
+			analyzer.alerts.Raise(Alert{
+				Type:     AlertPartitionSkew,
+				Severity: SeverityWarning,
+				Topic:    name,
+				Message:  fmt.Sprintf("Partition skew ratio %.2f exceeds %.2f", ratio, analyzer.config.SkewRatio),
+				Details: map[string]interface{}{
+					"ratio":     ratio,
+					"threshold": analyzer.config.SkewRatio,
+				},
+			})
+		}
+		topic.skewed = skewed
+	}
+}
+
+// skewRatio method computes ratio between the highest and the average
+// message rate of all partitions of topic, including claimed partitions
+// without any messages
+func (analyzer *TrafficAnalyzer) skewRatio(topic *topicTraffic) float64 {
+	var total, highest float64
+	for _, partition := range topic.partitions {
+		total += partition.messagesRate
+		if partition.messagesRate > highest {
+			highest = partition.messagesRate
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return highest / (total / float64(len(topic.partitions)))
+}
+
+// Stats method returns traffic statistic for all topics. Rates are
+// computed for the last finished time window.
+func (analyzer *TrafficAnalyzer) Stats() []TopicTrafficStats {
+	analyzer.mutex.Lock()
+	defer analyzer.mutex.Unlock()
+
+	stats := make([]TopicTrafficStats, 0, len(analyzer.topics))
+	for name, topic := range analyzer.topics {
+		ratio := analyzer.skewRatio(topic)
+		average := 0.0
+		for _, partition := range topic.partitions {
+			average += partition.messagesRate
+		}
+		// topic can be claimed without any partition
+		if len(topic.partitions) > 0 {
+			average /= float64(len(topic.partitions))
+		}
+
+		topicStats := TopicTrafficStats{
+			Topic:      name,
+			Partitions: make([]PartitionTrafficStats, 0, len(topic.partitions)),
+			SkewRatio:  ratio,
+			Skewed:     ratio > analyzer.config.SkewRatio,
+			TopKeys:    topic.keys.Top(analyzer.config.TopKeys),
+			NoKey:      topic.noKey,
+		}
+		for id, partition := range topic.partitions {
+			topicStats.Partitions = append(topicStats.Partitions, PartitionTrafficStats{
+				Partition:      id,
+				Messages:       partition.messages,
+				Bytes:          partition.bytes,
+				MessagesPerSec: partition.messagesRate,
+				BytesPerSec:    partition.bytesRate,
+				Skewed:         average > 0 && partition.messagesRate > average*analyzer.config.SkewRatio,
+			})
+		}
+		sort.Slice(topicStats.Partitions, func(i, j int) bool {
+			return topicStats.Partitions[i].Partition < topicStats.Partitions[j].Partition
+		})
+		stats = append(stats, topicStats)
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Topic < stats[j].Topic
+	})
+	return stats
+}
+
+// LogSummary method writes summary of traffic of all topics into log
+func (analyzer *TrafficAnalyzer) LogSummary() {
+	for _, topic := range analyzer.Stats() {
+		keys := make([]string, len(topic.TopKeys))
+		for i, key := range topic.TopKeys {
+			keys[i] = fmt.Sprintf("%s=%d", key.Key, key.Count)
+		}
+
+		var skewed []int32
+		for _, partition := range topic.Partitions {
+			if partition.Skewed {
+				skewed = append(skewed, partition.Partition)
+			}
+		}
+
+		log.Info().
+			Str(topicKey, topic.Topic).
+			Int("partitions", len(topic.Partitions)).
+			Float64("skew ratio", topic.SkewRatio).
+			Bool("skewed", topic.Skewed).
+			Ints32("skewed partitions", skewed).
+			Strs("top keys", keys).
+			Msg("Traffic summary")
+	}
+}
+
+// Run method periodically finishes time window and writes traffic summary
+// into log. It blocks current thread.
+func (analyzer *TrafficAnalyzer) Run() {
+	ticker := time.NewTicker(analyzer.config.Interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		analyzer.Roll()
+		analyzer.LogSummary()
+	}
+}
+
+// SpaceSaving is implementation of Space-Saving algorithm that finds the
+// most frequent items in stream using fixed number of counters. When all
+// counters are used, the counter with the lowest count is reassigned to
+// new item and its count is taken as error of the new item.
+type SpaceSaving struct {
+	capacity int
+	counters map[string]*KeyCount
+}
+
+// NewSpaceSaving constructs new sketch with given number of counters
+func NewSpaceSaving(capacity int) *SpaceSaving {
+	return &SpaceSaving{
+		capacity: capacity,
+		counters: make(map[string]*KeyCount, capacity),
+	}
+}
+
+// Add method counts one occurrence of item
+func (sketch *SpaceSaving) Add(item string) {
+	if counter, found := sketch.counters[item]; found {
+		counter.Count++
+		return
+	}
+
+	if len(sketch.counters) < sketch.capacity {
+		sketch.counters[item] = &KeyCount{Key: item, Count: 1}
+		return
+	}
+
+	var minimum *KeyCount
+	for _, counter := range sketch.counters {
+		if minimum == nil || counter.Count < minimum.Count {
+			minimum = counter
+		}
+	}
+	delete(sketch.counters, minimum.Key)
+	sketch.counters[item] = &KeyCount{
+		Key:   item,
+		Count: minimum.Count + 1,
+		Error: minimum.Count,
+	}
+}
+
+// Top method returns n most frequent items, the most frequent first
+func (sketch *SpaceSaving) Top(n int) []KeyCount {
+	top := make([]KeyCount, 0, len(sketch.counters))
+	for _, counter := range sketch.counters {
+		top = append(top, *counter)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Key < top[j].Key
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
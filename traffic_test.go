@@ -0,0 +1,251 @@
+/*
+Copyright © 2022 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main_test
+
+// Unit test definitions for functions and methods defined in source file
+// traffic.go
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	main "github.com/RedHatInsights/insights-kafka-monitor"
+)
+
+// recordMessages function records given number of messages of given size
+// consumed from one partition
+func recordMessages(analyzer *main.TrafficAnalyzer, partition int32, count, size int) {
+	for i := 0; i < count; i++ {
+		msg := consumerMessage(int64(i), make([]byte, size))
+		msg.Partition = partition
+		analyzer.Record(msg)
+	}
+}
+
+// TestTrafficAnalyzerRates checks that message and byte rates are computed
+// for each partition in time window
+func TestTrafficAnalyzerRates(t *testing.T) {
+	clock := newManualClock(time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC))
+	analyzer := main.NewTrafficAnalyzer(main.TrafficConfiguration{}, nil, clock)
+
+	recordMessages(analyzer, 0, 20, 100)
+	recordMessages(analyzer, 1, 10, 10)
+
+	clock.Advance(10 * time.Second)
+	analyzer.Roll()
+
+	stats := analyzer.Stats()
+	assert.Len(t, stats, 1)
+	assert.Equal(t, "topic", stats[0].Topic)
+
+	partitions := stats[0].Partitions
+	assert.Len(t, partitions, 2)
+	assert.Equal(t, uint64(20), partitions[0].Messages)
+	assert.Equal(t, uint64(2000), partitions[0].Bytes)
+	assert.InDelta(t, 2.0, partitions[0].MessagesPerSec, 0.001)
+	assert.InDelta(t, 200.0, partitions[0].BytesPerSec, 0.001)
+	assert.InDelta(t, 1.0, partitions[1].MessagesPerSec, 0.001)
+	assert.InDelta(t, 10.0, partitions[1].BytesPerSec, 0.001)
+
+	// rates are computed for the last time window only
+	clock.Advance(10 * time.Second)
+	analyzer.Roll()
+	partitions = analyzer.Stats()[0].Partitions
+	assert.Equal(t, 0.0, partitions[0].MessagesPerSec)
+	assert.Equal(t, uint64(20), partitions[0].Messages)
+}
+
+// TestTrafficAnalyzerSkew checks that skewed partitions are flagged and
+// that alert is raised when topic becomes skewed
+func TestTrafficAnalyzerSkew(t *testing.T) {
+	clock := newManualClock(time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC))
+	alerts := main.NewAlertManager(nil)
+	analyzer := main.NewTrafficAnalyzer(main.TrafficConfiguration{SkewRatio: 2}, alerts, clock)
+
+	recordMessages(analyzer, 0, 10, 1)
+	recordMessages(analyzer, 1, 10, 1)
+	recordMessages(analyzer, 2, 10, 1)
+	clock.Advance(time.Second)
+	analyzer.Roll()
+
+	stats := analyzer.Stats()
+	assert.InDelta(t, 1.0, stats[0].SkewRatio, 0.001)
+	assert.False(t, stats[0].Skewed)
+	assert.Empty(t, alertTypes(alerts))
+
+	recordMessages(analyzer, 0, 100, 1)
+	recordMessages(analyzer, 1, 10, 1)
+	recordMessages(analyzer, 2, 10, 1)
+	clock.Advance(time.Second)
+	analyzer.Roll()
+
+	stats = analyzer.Stats()
+	assert.InDelta(t, 2.5, stats[0].SkewRatio, 0.001)
+	assert.True(t, stats[0].Skewed)
+	assert.True(t, stats[0].Partitions[0].Skewed)
+	assert.False(t, stats[0].Partitions[1].Skewed)
+
+	// alert is raised just once while the topic stays skewed
+	recordMessages(analyzer, 0, 100, 1)
+	clock.Advance(time.Second)
+	analyzer.Roll()
+	assert.Equal(t, []string{main.AlertPartitionSkew}, alertTypes(alerts))
+}
+
+// TestTrafficAnalyzerSkewIdlePartitions checks that claimed partitions
+// without any messages are counted into the average rate of topic
+func TestTrafficAnalyzerSkewIdlePartitions(t *testing.T) {
+	clock := newManualClock(time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC))
+	alerts := main.NewAlertManager(nil)
+	analyzer := main.NewTrafficAnalyzer(main.TrafficConfiguration{SkewRatio: 2}, alerts, clock)
+	analyzer.SetClaims(map[string][]int32{"topic": {0, 1, 2, 3}})
+
+	// all messages are sent to one of four partitions
+	recordMessages(analyzer, 2, 40, 1)
+	clock.Advance(time.Second)
+	analyzer.Roll()
+
+	stats := analyzer.Stats()
+	assert.Len(t, stats[0].Partitions, 4)
+	assert.InDelta(t, 4.0, stats[0].SkewRatio, 0.001)
+	assert.True(t, stats[0].Skewed)
+	assert.Equal(t, []string{main.AlertPartitionSkew}, alertTypes(alerts))
+
+	// partitions that are no longer claimed are forgotten
+	analyzer.SetClaims(map[string][]int32{"topic": {2}})
+	recordMessages(analyzer, 2, 40, 1)
+	clock.Advance(time.Second)
+	analyzer.Roll()
+
+	stats = analyzer.Stats()
+	assert.Len(t, stats[0].Partitions, 1)
+	assert.InDelta(t, 1.0, stats[0].SkewRatio, 0.001)
+	assert.False(t, stats[0].Skewed)
+}
+
+// TestTrafficAnalyzerClaimedTopics checks that topics that are no longer
+// claimed are forgotten and that topic claimed without partitions has valid
+// statistic
+func TestTrafficAnalyzerClaimedTopics(t *testing.T) {
+	analyzer := main.NewTrafficAnalyzer(main.TrafficConfiguration{}, nil, nil)
+	analyzer.SetClaims(map[string][]int32{"topic": {0}, "other": {0}})
+	assert.Len(t, analyzer.Stats(), 2)
+
+	analyzer.SetClaims(map[string][]int32{"topic": {}})
+	stats := analyzer.Stats()
+	assert.Len(t, stats, 1)
+	assert.Equal(t, "topic", stats[0].Topic)
+	assert.Empty(t, stats[0].Partitions)
+	assert.Equal(t, 0.0, stats[0].SkewRatio)
+}
+
+// TestTrafficAnalyzerTopKeys checks that the most frequent message keys are
+// reported
+func TestTrafficAnalyzerTopKeys(t *testing.T) {
+	clock := newManualClock(time.Now())
+	analyzer := main.NewTrafficAnalyzer(main.TrafficConfiguration{TopKeys: 2}, nil, clock)
+
+	for key, count := range map[string]int{"a": 5, "b": 3, "c": 1} {
+		for i := 0; i < count; i++ {
+			msg := consumerMessage(0, nil)
+			msg.Key = []byte(key)
+			analyzer.Record(msg)
+		}
+	}
+	msg := consumerMessage(0, nil)
+	msg.Key = nil
+	analyzer.Record(msg)
+
+	stats := analyzer.Stats()
+	assert.Equal(t, []main.KeyCount{
+		{Key: "a", Count: 5},
+		{Key: "b", Count: 3},
+	}, stats[0].TopKeys)
+	assert.Equal(t, uint64(1), stats[0].NoKey)
+}
+
+// TestSpaceSavingBounded checks that heavy hitters are found with bounded
+// number of counters
+func TestSpaceSavingBounded(t *testing.T) {
+	sketch := main.NewSpaceSaving(3)
+
+	for i := 0; i < 1000; i++ {
+		sketch.Add("hot")
+		sketch.Add(fmt.Sprintf("cold-%d", i))
+	}
+
+	top := sketch.Top(10)
+	assert.Len(t, top, 3)
+	assert.Equal(t, "hot", top[0].Key)
+	assert.Equal(t, uint64(1000), top[0].Count)
+	assert.Equal(t, uint64(0), top[0].Error)
+}
+
+// TestHandleMessageRecordsTraffic checks that traffic of all handled
+// messages is recorded
+func TestHandleMessageRecordsTraffic(t *testing.T) {
+	consumer := NewDummyConsumer()
+	consumer.TrafficAnalyzer = main.NewTrafficAnalyzer(main.TrafficConfiguration{}, nil, nil)
+
+	consumer.HandleMessage(consumerMessage(0, []byte(`{}`)))
+
+	stats := consumer.TrafficAnalyzer.Stats()
+	assert.Equal(t, uint64(1), stats[0].Partitions[0].Messages)
+	assert.Equal(t, "key", stats[0].TopKeys[0].Key)
+}
+
+// TestServeSetsTrafficClaims checks that partitions claimed in consumer
+// group session are passed to traffic analyzer
+func TestServeSetsTrafficClaims(t *testing.T) {
+	group := newFakeConsumerGroup(t, "topic")
+
+	consumer := NewDummyConsumer()
+	consumer.ConsumerGroup = group
+	consumer.TrafficAnalyzer = main.NewTrafficAnalyzer(main.TrafficConfiguration{}, nil, nil)
+	done := serveInBackground(consumer)
+
+	session := group.Rebalance(0, 1, 2)
+	session.Send(1, []byte(`{}`))
+	session.End()
+	session.Wait()
+
+	stats := consumer.TrafficAnalyzer.Stats()
+	assert.Len(t, stats[0].Partitions, 3)
+
+	assert.NoError(t, consumer.Close())
+	waitForServe(t, done)
+}
+
+// TestTrafficEndpoint checks the traffic endpoint
+func TestTrafficEndpoint(t *testing.T) {
+	consumer := NewDummyConsumer()
+	server := main.NewHTTPServer(main.ServerConfiguration{}, consumer)
+
+	response := performRequest(server, "/api/v1/traffic")
+	assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+
+	consumer.TrafficAnalyzer = main.NewTrafficAnalyzer(main.TrafficConfiguration{}, nil, nil)
+	consumer.TrafficAnalyzer.Record(consumerMessage(0, nil))
+
+	response = performRequest(server, "/api/v1/traffic")
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Body.String(), `"top_keys":[{"key":"key","count":1,"error":0}]`)
+}
@@ -24,4 +24,20 @@ type CliFlags struct {
 	ShowVersion            bool
 	ShowAuthors            bool
 	ShowConfiguration      bool
+	ListTopics             bool
+	DescribeTopic          string
+	ListGroups             bool
+	DescribeGroup          string
+	ListDeadLetters        bool
+	RecheckDeadLetters     bool
+	Replay                 bool
+	ReplayTopic            string
+	ReplayPartitions       string
+	ReplayStart            string
+	ReplayEnd              string
+	History                bool
+	HistoryTopic           string
+	HistoryStart           string
+	HistoryEnd             string
+	AuditTopics            bool
 }